@@ -6,8 +6,12 @@ import (
 	"os"
 	"path"
 	"runtime/debug"
+	"strings"
 	"time"
 
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/apikeys"
+	"github.com/ansonallard/deployment-service/cmd/internal/audit"
 	backgroundprocessor "github.com/ansonallard/deployment-service/cmd/internal/background_processor"
 	"github.com/ansonallard/deployment-service/cmd/internal/background_processor/dockerbuild"
 	"github.com/ansonallard/deployment-service/cmd/internal/background_processor/dockercompose"
@@ -15,27 +19,36 @@ import (
 	"github.com/ansonallard/deployment-service/cmd/internal/background_processor/npm"
 	openapiBp "github.com/ansonallard/deployment-service/cmd/internal/background_processor/openapi"
 	"github.com/ansonallard/deployment-service/cmd/internal/background_processor/utils"
+	"github.com/ansonallard/deployment-service/cmd/internal/commitstatus"
 	"github.com/ansonallard/deployment-service/cmd/internal/compose"
 	"github.com/ansonallard/deployment-service/cmd/internal/controllers"
+	"github.com/ansonallard/deployment-service/cmd/internal/credentials"
 	"github.com/ansonallard/deployment-service/cmd/internal/env"
+	"github.com/ansonallard/deployment-service/cmd/internal/events"
+	"github.com/ansonallard/deployment-service/cmd/internal/gitea"
 	"github.com/ansonallard/deployment-service/cmd/internal/github"
+	"github.com/ansonallard/deployment-service/cmd/internal/health"
+	"github.com/ansonallard/deployment-service/cmd/internal/knownhosts"
+	"github.com/ansonallard/deployment-service/cmd/internal/metrics"
 	"github.com/ansonallard/deployment-service/cmd/internal/middleware"
 	"github.com/ansonallard/deployment-service/cmd/internal/middleware/authz"
 	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/oidc"
+	"github.com/ansonallard/deployment-service/cmd/internal/pullrequest"
 	"github.com/ansonallard/deployment-service/cmd/internal/releaser"
 	"github.com/ansonallard/deployment-service/cmd/internal/repo"
+	"github.com/ansonallard/deployment-service/cmd/internal/runlog"
 	"github.com/ansonallard/deployment-service/cmd/internal/service"
+	"github.com/ansonallard/deployment-service/cmd/internal/signing"
+	"github.com/ansonallard/deployment-service/cmd/internal/validation"
 	"github.com/ansonallard/deployment-service/cmd/internal/version"
 	"github.com/ansonallard/deployment-service/cmd/service_version"
 	"github.com/ansonallard/deployment_service_go_client/lib/deployment_service_go_client"
 	"github.com/ansonallard/go_utils/logging"
-	"github.com/ansonallard/go_utils/openapi/ierr"
 	"github.com/ansonallard/go_utils/tracing"
-	"github.com/getkin/kin-openapi/openapi3filter"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/moby/moby/client"
-	ginmiddleware "github.com/oapi-codegen/gin-middleware"
 	"github.com/rs/zerolog"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.opentelemetry.io/otel"
@@ -50,6 +63,7 @@ const (
 )
 
 func main() {
+	startedAt := time.Now().UTC()
 	var logFile *os.File
 	if env.IsDevMode() {
 		if err := godotenv.Load(); err != nil {
@@ -90,32 +104,82 @@ func main() {
 		}
 	}()
 
-	authZMiddleware := authz.NewAuthZ(env.GetAPIKey(ctx))
+	metricsConfig := metrics.ProviderConfig{
+		ServiceName:    serviceName,
+		ServiceVersion: strings.TrimSpace(service_version.ServiceVersion),
+	}
+	if env.IsMetricsOTLPEnabled() {
+		metricsConfig.OTLPEndpoint = env.GetTempoURI(ctx)
+	}
+	metricsHandler, shutdownMetrics, err := metrics.InitMeterProvider(ctx, metricsConfig)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize meter provider")
+	}
+	defer func() {
+		if err := shutdownMetrics(ctx); err != nil {
+			log.Error().Err(err).Msg("Error shutting down meter provider")
+		}
+	}()
 
-	deploymentServiceRepo, err := repo.NewDeploymentService(repo.DeploymentServieConfig{
-		ServiceFilPath: env.GetSerivceFilePath(ctx),
-		SSHKeyPath:     env.GetSSHKeyPath(ctx),
-		GitClient:      repo.NewGitClient(),
-		GitRepoOrigin:  env.GetGitRepoOirign(ctx),
+	apiKeys, err := apikeys.NewStore(apikeys.StoreConfig{
+		FilePath:     env.GetAPIKeysFilePath(ctx),
+		BootstrapKey: env.GetAPIKey(ctx),
 	})
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to instantiate deployment service")
+		log.Fatal().Err(err).Msg("Failed to load API keys")
+	}
+	authZConfig := authz.AuthZConfig{
+		Keys: apiKeys,
+	}
+	if issuerURL := env.GetOIDCIssuerURL(); issuerURL != "" {
+		scopeMapping, err := oidc.ParseScopeMapping(env.GetOIDCScopeMapping(ctx))
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid OIDC_SCOPE_MAPPING")
+		}
+		authZConfig.Tokens, err = oidc.NewVerifier(oidc.Config{
+			IssuerURL:    issuerURL,
+			Audience:     env.GetOIDCAudience(ctx),
+			JWKSURL:      env.GetOIDCJWKSURL(),
+			GroupsClaim:  env.GetOIDCGroupsClaim(),
+			ScopeMapping: scopeMapping,
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to instantiate OIDC verifier")
+		}
+	}
+	authZMiddleware, err := authz.NewAuthZ(authZConfig)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to instantiate authz middleware")
 	}
 
-	serviceChannel := make(service.ServiceChannel, 100)
+	knownHosts, err := knownhosts.New(knownhosts.Config{
+		FilePath: env.GetKnownHostsPath(ctx),
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load known_hosts")
+	}
 
-	deploymentService, err := service.NewDeploymentService(service.DeploymentServiceConfig{
-		Repo:                 deploymentServiceRepo,
-		BackgroundJobChannel: serviceChannel,
+	gitCredentials, err := credentials.NewStore(credentials.StoreConfig{
+		DefaultSSHKeyPath:   env.GetSSHKeyPath(ctx),
+		CredentialsFilePath: env.GetGitCredentialsFilePath(),
+		HostKeyCallback:     knownHosts.HostKeyCallback(),
 	})
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to instantiate deployment service")
+		log.Fatal().Err(err).Msg("Failed to load git credentials")
 	}
-	deploymentServiceController, err := controllers.NewDeploymentServiceController(controllers.DeploymentServiceControllerConfig{
-		Service: deploymentService,
+
+	gitClient := repo.NewGitClient()
+	serviceLocks := repo.NewServiceLocks()
+
+	deploymentServiceRepo, err := repo.NewDeploymentService(repo.DeploymentServieConfig{
+		ServiceFilPath: env.GetSerivceFilePath(ctx),
+		Credentials:    gitCredentials,
+		GitClient:      gitClient,
+		ServiceLocks:   serviceLocks,
+		GitRepoOrigin:  env.GetGitRepoOirign(ctx),
 	})
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to instantiate deployment service controller")
+		log.Fatal().Err(err).Msg("Failed to instantiate deployment service")
 	}
 
 	dockerClient, err := client.New(
@@ -127,6 +191,17 @@ func main() {
 	}
 	defer dockerClient.Close()
 
+	// Only used to check on the deploy daemon, deploys go through the docker
+	// compose CLI
+	deployDockerClient, err := client.New(
+		client.FromEnv,
+		client.WithHost(env.GetDockerDeployHost(ctx)),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not instantiate docker deploy client")
+	}
+	defer deployDockerClient.Close()
+
 	dockerReleaser, err := releaser.NewDockerReleaser(releaser.DockerReleaserConfig{
 		DockerClient:   dockerClient,
 		ArtifactPrefix: env.GetArtifactPrefix(ctx),
@@ -147,6 +222,152 @@ func main() {
 		DockerHome: env.GetDockerDeployHost(ctx),
 	})
 
+	serviceChannel := make(service.ServiceChannel, 100)
+	eventBus := events.NewBus()
+
+	runLogMaxBytes, err := env.GetRunLogMaxBytes()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not parse run log max bytes")
+	}
+	runLogRetention, err := env.GetRunLogRetention()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not parse run log retention")
+	}
+	auditLog, err := audit.NewLog(audit.Config{
+		FilePath: env.GetAuditLogPath(ctx),
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to instantiate audit log")
+	}
+
+	runLogs, err := runlog.NewStore(runlog.StoreConfig{
+		ServiceFilePath: env.GetSerivceFilePath(ctx),
+		MaxBytes:        runLogMaxBytes,
+		Retention:       runLogRetention,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to instantiate run log store")
+	}
+
+	versioner, err := version.NewVersioner(version.VersionerConfig{
+		GitClient: gitClient,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to instantiate versioner")
+	}
+
+	validator, err := validation.NewValidator(validation.Config{
+		GitClient:   gitClient,
+		Credentials: gitCredentials,
+		Versioner:   versioner,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to instantiate validator")
+	}
+
+	deploymentService, err := service.NewDeploymentService(service.DeploymentServiceConfig{
+		Repo:                 deploymentServiceRepo,
+		BackgroundJobChannel: serviceChannel,
+		Validator:            validator,
+		Compose:              dockerCompose,
+		DockerReleaser:       dockerReleaser,
+		SelfServiceName:      env.GetSelfServiceApplication(),
+		Context:              ctx,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to instantiate deployment service")
+	}
+	if err := metrics.RegisterServiceMetrics(ctx, deploymentService); err != nil {
+		log.Fatal().Err(err).Msg("Failed to register service metrics")
+	}
+	deploymentServiceController, err := controllers.NewDeploymentServiceController(controllers.DeploymentServiceControllerConfig{
+		Service: deploymentService,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to instantiate deployment service controller")
+	}
+	serviceGitController, err := controllers.NewServiceGitController(controllers.ServiceGitControllerConfig{
+		Service: deploymentService,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to instantiate service git controller")
+	}
+	serviceRevisionsController, err := controllers.NewServiceRevisionsController(controllers.ServiceRevisionsControllerConfig{
+		Service: deploymentService,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to instantiate service revisions controller")
+	}
+	serviceValidationController, err := controllers.NewServiceValidationController(controllers.ServiceValidationControllerConfig{
+		Service: deploymentService,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to instantiate service validation controller")
+	}
+	exportController, err := controllers.NewExportController(controllers.ExportControllerConfig{
+		Service: deploymentService,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to instantiate export controller")
+	}
+	eventsController, err := controllers.NewEventsController(controllers.EventsControllerConfig{
+		Service: deploymentService,
+		Events:  eventBus,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to instantiate events controller")
+	}
+	runsController, err := controllers.NewRunsController(controllers.RunsControllerConfig{
+		Service: deploymentService,
+		RunLogs: runLogs,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to instantiate runs controller")
+	}
+	minFreeDiskBytes, err := env.GetMinFreeDiskBytes()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not parse min free disk bytes")
+	}
+	healthChecker, err := health.NewChecker(health.Config{
+		Checks: []health.Check{
+			health.DockerPing("dockerBuildDaemon", dockerClient),
+			health.DockerPing("dockerDeployDaemon", deployDockerClient),
+			health.WritableDir("serviceFilePath", env.GetSerivceFilePath(ctx), minFreeDiskBytes),
+			health.SSHKey("sshKey", env.GetSSHKeyPath(ctx)),
+			health.Executable("dockerCLI", env.GetPathToDockerCLI()),
+		},
+		Service:   deploymentService,
+		Version:   strings.TrimSpace(service_version.ServiceVersion),
+		StartedAt: startedAt,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to instantiate health checker")
+	}
+	healthController, err := controllers.NewHealthController(controllers.HealthControllerConfig{
+		Checker: healthChecker,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to instantiate health controller")
+	}
+	auditController, err := controllers.NewAuditController(controllers.AuditControllerConfig{
+		Audit: auditLog,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to instantiate audit controller")
+	}
+	apiKeysController, err := controllers.NewAPIKeysController(controllers.APIKeysControllerConfig{
+		Keys: apiKeys,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to instantiate API keys controller")
+	}
+	knownHostsController, err := controllers.NewKnownHostsController(controllers.KnownHostsControllerConfig{
+		KnownHosts: knownHosts,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to instantiate known hosts controller")
+	}
+
 	envWriter := service.NewEnvFileWriter()
 
 	npmrcPath := env.GetNPMRCPath(ctx)
@@ -170,6 +391,42 @@ func main() {
 		Email: env.GetCICommitAuthorEmail(ctx),
 	}
 
+	var gitSigner signing.Signer
+	if signingFormat := env.GetGitSigningFormat(); signingFormat != "" {
+		gitSigner, err = signing.NewSigner(signing.Config{
+			Format:     signing.Format(signingFormat),
+			KeyPath:    env.GetGitSigningKeyPath(ctx),
+			Passphrase: env.GetGitSigningKeyPassphrase(),
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load git signing key")
+		}
+		log.Info().Str("format", signingFormat).Msg("Signing CI commits and tags")
+	}
+
+	githubClient := github.NewGithubClient(ctx, env.GetGitHubPAT(ctx), env.GetGitHubOwner(ctx))
+
+	pullRequestResolverConfig := pullrequest.ResolverConfig{
+		GitHub: githubClient,
+	}
+	commitStatusConfig := commitstatus.ReporterConfig{
+		GitHub:            githubClient,
+		TargetURLTemplate: env.GetCommitStatusTargetURL(),
+	}
+	if giteaURL := env.GetGiteaURL(); giteaURL != "" {
+		giteaClient, err := gitea.NewGiteaClient(gitea.Config{
+			URL:   giteaURL,
+			Token: env.GetGiteaPAT(ctx),
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to instantiate gitea client")
+		}
+		pullRequestResolverConfig.Gitea = giteaClient
+		pullRequestResolverConfig.GiteaHost = giteaClient.Host()
+		commitStatusConfig.Gitea = giteaClient
+		commitStatusConfig.GiteaHost = giteaClient.Host()
+	}
+
 	openAPIProcessor, err := openapiBp.NewOpenAPIProcessor(openapiBp.OpenAPIProcessorConfig{
 		DockerReleaser: dockerReleaser,
 		RegistryUrl:    env.GetArtifactRegistryURL(ctx),
@@ -182,7 +439,8 @@ func main() {
 			Token:          env.GetArtifactRegistryPAT(ctx),
 		},
 		CiCommitAuthor: &ciCommitAuthor,
-		GithubClient:   github.NewGithubClient(ctx, env.GetGitHubPAT(ctx), env.GetGitHubOwner(ctx)),
+		Signer:         gitSigner,
+		GithubClient:   githubClient,
 	})
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to instantiate openapi processor")
@@ -214,10 +472,19 @@ func main() {
 	}
 
 	backgroundProcessor, err := backgroundprocessor.NewBackgroundProcessor(backgroundprocessor.BackgroundProcessorConfig{
-		Versioner:              version.NewVersioner(),
-		SSHKeyPath:             env.GetSSHKeyPath(ctx),
+		Versioner:              versioner,
+		GitClient:              gitClient,
+		ServiceLocks:           serviceLocks,
+		ServiceRepo:            deploymentServiceRepo,
+		PullRequests:           pullrequest.NewResolver(pullRequestResolverConfig),
+		CommitStatuses:         commitstatus.NewReporter(commitStatusConfig),
+		Events:                 eventBus,
+		RunLogs:                runLogs,
+		Audit:                  auditLog,
+		Credentials:            gitCredentials,
 		GitRepoOrigin:          env.GetGitRepoOirign(ctx),
 		CiCommitAuthor:         &ciCommitAuthor,
+		Signer:                 gitSigner,
 		NpmServiceProcessor:    npmServiceProcessor,
 		OpenAPIProcessor:       openAPIProcessor,
 		GoServiceProcessor:     goServiceProcessor,
@@ -272,25 +539,38 @@ func main() {
 	router.Use(tracing.ZerologTraceMiddleware())
 	router.Use(logging.RecoveryMiddleware(log))
 	router.Use(logging.LoggingMiddleware())
+	router.Use(middleware.AuditMiddleware(auditLog))
 	router.Use(middleware.ErrorHandlerMiddleware())
-	router.Use(authZMiddleware.AuthMiddleware())
 
-	router.Use(ginmiddleware.OapiRequestValidatorWithOptions(spec, &ginmiddleware.Options{
-		ErrorHandler: func(c *gin.Context, message string, statusCode int) {
-			_ = c.Error(ierr.NewBadRequestError(message))
-			c.Abort()
-		},
-		Options: openapi3filter.Options{
-			AuthenticationFunc: func(ctx context.Context, input *openapi3filter.AuthenticationInput) error {
-				return nil // auth is handled by authZMiddleware.AuthMiddleware()
-			},
-		},
-	}))
+	// Probes and metrics are left unauthenticated for uptime checks and orchestrators
+	controllers.RegisterHealthRoutes(router, healthController)
+	router.GET("/metrics", gin.WrapH(metricsHandler))
+
+	// Routes that aren't in the generated OpenAPI spec live on their own group,
+	// since the request validator rejects any route it doesn't know about.
+	v1 := router.Group("/v1", authZMiddleware.AuthMiddleware())
+	controllers.RegisterKnownHostsRoutes(v1, knownHostsController)
+	controllers.RegisterServiceGitRoutes(v1, serviceGitController)
+	controllers.RegisterServiceRevisionsRoutes(v1, serviceRevisionsController)
+	controllers.RegisterServiceValidationRoutes(v1, serviceValidationController)
+	controllers.RegisterExportRoutes(v1, exportController)
+	controllers.RegisterEventsRoutes(v1, eventsController)
+	controllers.RegisterRunsRoutes(v1, runsController)
+	controllers.RegisterAPIKeysRoutes(v1, apiKeysController)
+	controllers.RegisterAuditRoutes(v1, auditController)
+	controllers.RegisterDiagnosticsRoutes(v1, healthController)
+
+	requestValidator, err := middleware.RequestValidator(spec)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create request validator")
+	}
+	specRoutes := router.Group("", authZMiddleware.AuthMiddleware(), middleware.QueryParameters())
+	specRoutes.Use(requestValidator)
 
 	strictHandler := deployment_service_go_client.NewStrictHandler(deploymentServiceController, nil)
 
 	// Register OpenAPI handlers (generated by oapi-codegen)
-	deployment_service_go_client.RegisterHandlersWithOptions(router, strictHandler, deployment_service_go_client.GinServerOptions{
+	deployment_service_go_client.RegisterHandlersWithOptions(specRoutes, strictHandler, deployment_service_go_client.GinServerOptions{
 		BaseURL: "/v1",
 	})
 
@@ -346,6 +626,7 @@ func runTick(
 		trace.WithAttributes(attribute.String("service", serviceName)),
 	)
 	defer tickSpan.End()
+	start := time.Now()
 
 	sc := tickSpan.SpanContext()
 	enrichedLog := zerolog.Ctx(tickCtx).With().
@@ -357,7 +638,7 @@ func runTick(
 
 	service, err := getService(tickCtx, serviceName)
 	if err != nil {
-		if _, ok := err.(*ierr.NotFoundError); ok {
+		if apierr.HasCode(err, apierr.CodeServiceNotFound) {
 			log.Info().Str("service", serviceName).
 				Msg("Service deleted, stopping background processing")
 			return true
@@ -381,7 +662,9 @@ func runTick(
 		}
 	}()
 
-	if err := backgroundProcessor.ProcessService(tickCtx, service); err != nil {
+	err = backgroundProcessor.ProcessService(tickCtx, service)
+	metrics.RecordTick(tickCtx, serviceName, start, err)
+	if err != nil {
 		log.Error().Err(err).Str("service", serviceName).
 			Msg("Error when processing service")
 		tickSpan.RecordError(err)
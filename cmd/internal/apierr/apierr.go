@@ -0,0 +1,168 @@
+package apierr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ansonallard/go_utils/openapi/ierr"
+)
+
+// Code identifies a kind of failure. Codes are part of the API, so existing
+// ones must keep their meaning.
+type Code string
+
+const (
+	// CodeInvalidArgument is a parameter or body that is malformed or out of
+	// range. Details lists the fields at fault when they are known.
+	CodeInvalidArgument   Code = "invalidArgument"
+	CodeInvalidCredential Code = "invalidCredential"
+	CodeBranchNotFound    Code = "branchNotFound"
+	CodeUnauthenticated   Code = "unauthenticated"
+	CodePermissionDenied  Code = "permissionDenied"
+	CodeNotFound          Code = "notFound"
+	CodeServiceNotFound   Code = "serviceNotFound"
+	CodeRevisionNotFound  Code = "revisionNotFound"
+	CodeAlreadyExists     Code = "alreadyExists"
+	// CodeServiceBusy means another operation holds the service, e.g. a
+	// release or its first clone. Retrying later succeeds.
+	CodeServiceBusy Code = "serviceBusy"
+	CodeConflict    Code = "conflict"
+	// CodeVersionMismatch means If-Match isn't the service's current version
+	CodeVersionMismatch Code = "versionMismatch"
+	CodeRateLimited     Code = "rateLimited"
+	// CodeCloneFailed means the service's git remote couldn't be cloned
+	CodeCloneFailed Code = "cloneFailed"
+	CodeInternal    Code = "internal"
+)
+
+var statuses = map[Code]int{
+	CodeInvalidArgument:   http.StatusBadRequest,
+	CodeInvalidCredential: http.StatusBadRequest,
+	CodeBranchNotFound:    http.StatusBadRequest,
+	CodeUnauthenticated:   http.StatusUnauthorized,
+	CodePermissionDenied:  http.StatusForbidden,
+	CodeNotFound:          http.StatusNotFound,
+	CodeServiceNotFound:   http.StatusNotFound,
+	CodeRevisionNotFound:  http.StatusNotFound,
+	CodeAlreadyExists:     http.StatusConflict,
+	CodeServiceBusy:       http.StatusConflict,
+	CodeConflict:          http.StatusConflict,
+	CodeVersionMismatch:   http.StatusPreconditionFailed,
+	CodeRateLimited:       http.StatusTooManyRequests,
+	CodeCloneFailed:       http.StatusBadGateway,
+	CodeInternal:          http.StatusInternalServerError,
+}
+
+// Status is the HTTP status errors with the code are returned with.
+func (c Code) Status() int {
+	if status, ok := statuses[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// FieldError is a problem with one field of a request.
+type FieldError struct {
+	// Field is the parameter name, or the dotted path of a body field
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is a failure to return to API clients. Message is shown to them, so
+// it must not include internal details; those belong in the cause, which is
+// only logged.
+type Error struct {
+	Code    Code
+	Message string
+	Details []FieldError
+	cause   error
+}
+
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func Newf(code Code, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap returns an error with code and message, keeping err as its cause.
+func Wrap(code Code, message string, err error) *Error {
+	return &Error{Code: code, Message: message, cause: err}
+}
+
+// InvalidField is a CodeInvalidArgument error for a single field.
+func InvalidField(field string, message string) *Error {
+	return &Error{
+		Code:    CodeInvalidArgument,
+		Message: message,
+		Details: []FieldError{{Field: field, Message: message}},
+	}
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %s", e.Message, e.cause)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// HasCode is whether err is, or wraps, an Error with code.
+func HasCode(err error, code Code) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// From maps err onto an Error. Errors from dependencies that still return
+// ierr types keep their message; anything else is internal, and its message
+// is replaced so it can't leak paths or other internals.
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	for _, mapping := range ierrCodes {
+		if e := mapping.as(err); e != nil {
+			return New(mapping.code, e.Error())
+		}
+	}
+	return Wrap(CodeInternal, "internal error", err)
+}
+
+// ierrCodes maps the ierr types onto codes. They are matched with errors.As,
+// so they keep their code when wrapped with context.
+var ierrCodes = []struct {
+	as   func(err error) error
+	code Code
+}{
+	{as[*ierr.BadRequestError], CodeInvalidArgument},
+	{as[*ierr.UnAuthorizedError], CodeUnauthenticated},
+	{as[*ierr.ForbiddenError], CodePermissionDenied},
+	{as[*ierr.NotFoundError], CodeNotFound},
+	{as[*ierr.ConflictError], CodeConflict},
+	{as[*ierr.PreConditionFailed], CodeVersionMismatch},
+	{as[*ierr.TooManyRequestsError], CodeRateLimited},
+}
+
+// as returns the error of type T in err's chain, or nil.
+func as[T error](err error) error {
+	var target T
+	if errors.As(err, &target) {
+		return target
+	}
+	return nil
+}
+
+// Response is the body of every error response.
+type Response struct {
+	Code    Code         `json:"code"`
+	Message string       `json:"message"`
+	Details []FieldError `json:"details,omitempty"`
+	// TraceID finds the request in the traces and logs
+	TraceID string `json:"traceId,omitempty"`
+}
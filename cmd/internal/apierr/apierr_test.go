@@ -0,0 +1,118 @@
+package apierr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/ansonallard/go_utils/openapi/ierr"
+)
+
+func TestFrom(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantCode    Code
+		wantMessage string
+		wantStatus  int
+	}{
+		{
+			name:     "Error is returned as is",
+			err:      Newf(CodeServiceNotFound, "service %s not found", "a"),
+			wantCode: CodeServiceNotFound, wantMessage: "service a not found", wantStatus: http.StatusNotFound,
+		},
+		{
+			name:     "wrapped Error",
+			err:      fmt.Errorf("failed to get service: %w", New(CodeServiceBusy, "busy")),
+			wantCode: CodeServiceBusy, wantMessage: "busy", wantStatus: http.StatusConflict,
+		},
+		{
+			name:     "joined Error",
+			err:      errors.Join(errors.New("cleanup failed"), New(CodeAlreadyExists, "exists")),
+			wantCode: CodeAlreadyExists, wantMessage: "exists", wantStatus: http.StatusConflict,
+		},
+		{
+			name:     "Error wins over a wrapped ierr",
+			err:      Wrap(CodeRevisionNotFound, "revision not found", ierr.NewNotFoundError("missing")),
+			wantCode: CodeRevisionNotFound, wantMessage: "revision not found", wantStatus: http.StatusNotFound,
+		},
+		{
+			name:     "bad request",
+			err:      ierr.NewBadRequestError("bad"),
+			wantCode: CodeInvalidArgument, wantMessage: "bad", wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:     "wrapped bad request",
+			err:      fmt.Errorf("decoding body: %w", ierr.NewBadRequestError("bad")),
+			wantCode: CodeInvalidArgument, wantMessage: "bad", wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:     "wrapped unauthorized",
+			err:      fmt.Errorf("auth: %w", ierr.NewUnAuthorizedError("no key")),
+			wantCode: CodeUnauthenticated, wantMessage: "no key", wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:     "wrapped forbidden",
+			err:      fmt.Errorf("auth: %w", ierr.NewForbiddenError("read only")),
+			wantCode: CodePermissionDenied, wantMessage: "read only", wantStatus: http.StatusForbidden,
+		},
+		{
+			name:     "wrapped not found",
+			err:      fmt.Errorf("lookup: %w", ierr.NewNotFoundError("gone")),
+			wantCode: CodeNotFound, wantMessage: "gone", wantStatus: http.StatusNotFound,
+		},
+		{
+			name:     "twice wrapped conflict",
+			err:      fmt.Errorf("outer: %w", fmt.Errorf("inner: %w", ierr.NewConflictError("taken"))),
+			wantCode: CodeConflict, wantMessage: "taken", wantStatus: http.StatusConflict,
+		},
+		{
+			name:     "wrapped precondition failed",
+			err:      fmt.Errorf("update: %w", ierr.NewPreConditionFailed("stale")),
+			wantCode: CodeVersionMismatch, wantMessage: "stale", wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:     "joined too many requests",
+			err:      errors.Join(errors.New("other"), ierr.NewTooManyRequestsError("slow down")),
+			wantCode: CodeRateLimited, wantMessage: "slow down", wantStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:     "anything else is internal",
+			err:      fmt.Errorf("open /srv/services/a: %w", errors.New("permission denied")),
+			wantCode: CodeInternal, wantMessage: "internal error", wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := From(tt.err)
+			if got.Code != tt.wantCode || got.Message != tt.wantMessage {
+				t.Fatalf("expected %s %q, got %s %q", tt.wantCode, tt.wantMessage, got.Code, got.Message)
+			}
+			if status := got.Code.Status(); status != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, status)
+			}
+		})
+	}
+}
+
+func TestInternalErrorKeepsCause(t *testing.T) {
+	cause := errors.New("disk full")
+	got := From(fmt.Errorf("write: %w", cause))
+	if !errors.Is(got, cause) {
+		t.Fatal("expected the cause to be kept for logging")
+	}
+}
+
+func TestHasCode(t *testing.T) {
+	err := fmt.Errorf("context: %w", New(CodeServiceBusy, "busy"))
+	if !HasCode(err, CodeServiceBusy) {
+		t.Fatal("expected a wrapped Error to have its code")
+	}
+	if HasCode(err, CodeConflict) {
+		t.Fatal("expected a wrapped Error not to have another code")
+	}
+	if HasCode(errors.New("plain"), CodeInternal) {
+		t.Fatal("expected a plain error to have no code")
+	}
+}
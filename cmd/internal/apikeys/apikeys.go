@@ -0,0 +1,386 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("deployment-service.apikeys")
+
+const (
+	// Prefix of generated keys, so they are recognisable in secret scanners
+	keyPrefix = "dsk_"
+	keyBytes  = 32
+	// How much of a key is kept in the clear to tell keys apart
+	hintLength = len(keyPrefix) + 4
+)
+
+// Scope is what a key may do. Each scope includes the ones before it.
+type Scope string
+
+const (
+	// ScopeRead may only read.
+	ScopeRead Scope = "read"
+	// ScopeTrigger may also trigger actions on services, e.g. a repair.
+	ScopeTrigger Scope = "trigger"
+	// ScopeWrite may also create, change and delete services.
+	ScopeWrite Scope = "write"
+	// ScopeAdmin may also manage keys and known hosts, read the audit log and
+	// export and import.
+	ScopeAdmin Scope = "admin"
+)
+
+var scopeOrder = []Scope{ScopeRead, ScopeTrigger, ScopeWrite, ScopeAdmin}
+
+func ScopeFromExternal(scope string) (Scope, error) {
+	if !slices.Contains(scopeOrder, Scope(scope)) {
+		return "", apierr.Newf(apierr.CodeInvalidArgument, "invalid scope %q, expected one of: %s, %s, %s, %s",
+			scope, ScopeRead, ScopeTrigger, ScopeWrite, ScopeAdmin)
+	}
+	return Scope(scope), nil
+}
+
+// Includes reports whether s grants required.
+func (s Scope) Includes(required Scope) bool {
+	return slices.Index(scopeOrder, s) >= slices.Index(scopeOrder, required)
+}
+
+// Key is a stored API key. Only the SHA-256 of the key is kept; the key
+// itself is returned once, when it is created.
+type Key struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Scope Scope  `json:"scope"`
+	// Services restricts the key to these services. Empty means every service.
+	Services  []string   `json:"services,omitempty"`
+	Hint      string     `json:"hint"`
+	Hash      string     `json:"hash"`
+	CreatedAt time.Time  `json:"created_at"`
+	CreatedBy string     `json:"created_by"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func (k *Key) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+type CreateOptions struct {
+	Name      string
+	Scope     Scope
+	Services  []string
+	ExpiresAt *time.Time
+	CreatedBy string
+}
+
+// ErrInvalidKey is returned by Authenticate for unknown, expired and revoked
+// keys alike.
+var ErrInvalidKey = errors.New("invalid API key")
+
+type Store interface {
+	// Authenticate returns the active key matching presented.
+	Authenticate(presented string) (*Key, error)
+	List(ctx context.Context) ([]Key, error)
+	// Create returns the new key and its secret, which can't be retrieved
+	// again.
+	Create(ctx context.Context, opts CreateOptions) (*Key, string, error)
+	Revoke(ctx context.Context, id string) (*Key, error)
+	// Rotate creates a key like id, with the same expiry period, and revokes
+	// id, in a single write.
+	Rotate(ctx context.Context, id string, rotatedBy string) (*Key, string, error)
+}
+
+type StoreConfig struct {
+	FilePath string
+	// BootstrapKey is the API_KEY. It is always an admin key, so there is a
+	// way in before any key is created. Optional.
+	BootstrapKey string
+}
+
+// BootstrapKeyName is the name of the key configured with API_KEY.
+const BootstrapKeyName = "api-key"
+
+func NewStore(config StoreConfig) (Store, error) {
+	if config.FilePath == "" {
+		return nil, fmt.Errorf("filePath not provided")
+	}
+	s := &store{filePath: config.FilePath, keys: make([]Key, 0)}
+	if config.BootstrapKey != "" {
+		s.bootstrap = &Key{
+			ID:    BootstrapKeyName,
+			Name:  BootstrapKeyName,
+			Scope: ScopeAdmin,
+			Hash:  hashKey(config.BootstrapKey),
+		}
+	}
+	data, err := os.ReadFile(config.FilePath)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read API keys: %w", err)
+	default:
+		if err := json.Unmarshal(data, &s.keys); err != nil {
+			return nil, fmt.Errorf("failed to decode API keys: %w", err)
+		}
+	}
+	return s, nil
+}
+
+type store struct {
+	filePath  string
+	bootstrap *Key
+	mu        sync.RWMutex
+	keys      []Key
+}
+
+func (s *store) Authenticate(presented string) (*Key, error) {
+	presentedHash := []byte(hashKey(presented))
+	now := time.Now()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Every key is compared, so the time taken doesn't depend on which matched
+	var match *Key
+	candidates := s.keys
+	if s.bootstrap != nil {
+		candidates = append([]Key{*s.bootstrap}, candidates...)
+	}
+	for i := range candidates {
+		if subtle.ConstantTimeCompare(presentedHash, []byte(candidates[i].Hash)) == 1 {
+			match = &candidates[i]
+		}
+	}
+	if match == nil || !match.Active(now) {
+		return nil, ErrInvalidKey
+	}
+	key := *match
+	return &key, nil
+}
+
+func (s *store) List(ctx context.Context) ([]Key, error) {
+	_, span := tracer.Start(ctx, "apikeys.list")
+	defer span.End()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := slices.Clone(s.keys)
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (s *store) Create(ctx context.Context, opts CreateOptions) (*Key, string, error) {
+	_, span := tracer.Start(ctx, "apikeys.create",
+		trace.WithAttributes(
+			attribute.String("api_key.name", opts.Name),
+			attribute.String("api_key.scope", string(opts.Scope)),
+		),
+	)
+	defer span.End()
+
+	if err := validateCreateOptions(opts); err != nil {
+		return nil, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.create(opts)
+}
+
+// create adds a key. Callers must hold the write lock.
+func (s *store) create(opts CreateOptions) (*Key, string, error) {
+	key, secret, err := newKey(opts)
+	if err != nil {
+		return nil, "", err
+	}
+	keys := append(slices.Clone(s.keys), key)
+	if err := s.save(keys); err != nil {
+		return nil, "", err
+	}
+	s.keys = keys
+	return &key, secret, nil
+}
+
+// newKey generates a key and its secret, without storing them.
+func newKey(opts CreateOptions) (Key, string, error) {
+	secret, err := generateKey()
+	if err != nil {
+		return Key{}, "", err
+	}
+	return Key{
+		ID:        utils.GenerateUlidString(),
+		Name:      opts.Name,
+		Scope:     opts.Scope,
+		Services:  opts.Services,
+		Hint:      secret[:hintLength],
+		Hash:      hashKey(secret),
+		CreatedAt: time.Now().UTC(),
+		CreatedBy: opts.CreatedBy,
+		ExpiresAt: opts.ExpiresAt,
+	}, secret, nil
+}
+
+func (s *store) Revoke(ctx context.Context, id string) (*Key, error) {
+	_, span := tracer.Start(ctx, "apikeys.revoke",
+		trace.WithAttributes(attribute.String("api_key.id", id)),
+	)
+	defer span.End()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.revoke(id)
+}
+
+// revoke marks a key as revoked. Callers must hold the write lock.
+func (s *store) revoke(id string) (*Key, error) {
+	index := slices.IndexFunc(s.keys, func(k Key) bool { return k.ID == id })
+	if index < 0 {
+		return nil, apierr.Newf(apierr.CodeNotFound, "API key %q not found", id)
+	}
+	keys := slices.Clone(s.keys)
+	if keys[index].RevokedAt == nil {
+		now := time.Now().UTC()
+		keys[index].RevokedAt = &now
+		if err := s.save(keys); err != nil {
+			return nil, err
+		}
+		s.keys = keys
+	}
+	key := keys[index]
+	return &key, nil
+}
+
+func (s *store) Rotate(ctx context.Context, id string, rotatedBy string) (*Key, string, error) {
+	_, span := tracer.Start(ctx, "apikeys.rotate",
+		trace.WithAttributes(attribute.String("api_key.id", id)),
+	)
+	defer span.End()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := slices.IndexFunc(s.keys, func(k Key) bool { return k.ID == id })
+	if index < 0 {
+		return nil, "", apierr.Newf(apierr.CodeNotFound, "API key %q not found", id)
+	}
+	old := s.keys[index]
+	if !old.Active(time.Now()) {
+		return nil, "", apierr.Newf(apierr.CodeConflict, "API key %s is revoked or expired", id)
+	}
+	opts := CreateOptions{
+		Name:      old.Name,
+		Scope:     old.Scope,
+		Services:  old.Services,
+		CreatedBy: rotatedBy,
+	}
+	if old.ExpiresAt != nil {
+		expiresAt := time.Now().UTC().Add(old.ExpiresAt.Sub(old.CreatedAt))
+		opts.ExpiresAt = &expiresAt
+	}
+	key, secret, err := newKey(opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The new key and the revocation are saved together, so a failed write
+	// can't leave a new key nobody has the secret of, or both keys active
+	keys := slices.Clone(s.keys)
+	now := time.Now().UTC()
+	keys[index].RevokedAt = &now
+	keys = append(keys, key)
+	if err := s.save(keys); err != nil {
+		return nil, "", err
+	}
+	s.keys = keys
+	return &key, secret, nil
+}
+
+// save writes keys to a temporary file and renames it into place. Callers
+// must hold the write lock.
+func (s *store) save(keys []Key) error {
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.filePath), ".tmp-api-keys-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.filePath)
+}
+
+func validateCreateOptions(opts CreateOptions) error {
+	if strings.TrimSpace(opts.Name) == "" {
+		return apierr.New(apierr.CodeInvalidArgument, "name is required")
+	}
+	if opts.Name == BootstrapKeyName {
+		return apierr.Newf(apierr.CodeInvalidArgument, "name %q is reserved for API_KEY", BootstrapKeyName)
+	}
+	if _, err := ScopeFromExternal(string(opts.Scope)); err != nil {
+		return err
+	}
+	if opts.Scope == ScopeAdmin && len(opts.Services) > 0 {
+		return apierr.New(apierr.CodeInvalidArgument, "admin keys can't be restricted to services")
+	}
+	for _, service := range opts.Services {
+		if err := model.ValidateServiceName(service); err != nil {
+			return err
+		}
+	}
+	if opts.ExpiresAt != nil && !opts.ExpiresAt.After(time.Now()) {
+		return apierr.New(apierr.CodeInvalidArgument, "expiresAt must be in the future")
+	}
+	return nil
+}
+
+func generateKey() (string, error) {
+	secret := make([]byte, keyBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return keyPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// Keys are random, so a plain SHA-256 is enough to keep them from being
+// recovered from the file.
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
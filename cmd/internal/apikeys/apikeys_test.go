@@ -0,0 +1,82 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) (Store, string) {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), "api_keys.json")
+	s, err := NewStore(StoreConfig{FilePath: filePath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, filePath
+}
+
+func TestRotateRevokesOldKeyInOneWrite(t *testing.T) {
+	ctx := context.Background()
+	s, filePath := newTestStore(t)
+	expiresAt := time.Now().Add(time.Hour)
+	old, oldSecret, err := s.Create(ctx, CreateOptions{Name: "ci", Scope: ScopeTrigger, ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, secret, err := s.Rotate(ctx, old.ID, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.Name != old.Name || rotated.Scope != old.Scope || rotated.ExpiresAt == nil {
+		t.Fatalf("expected a key like the old one, got %+v", rotated)
+	}
+	if _, err := s.Authenticate(oldSecret); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected the old key to be revoked, got %v", err)
+	}
+	if _, err := s.Authenticate(secret); err != nil {
+		t.Fatalf("expected the new key to authenticate, got %v", err)
+	}
+
+	// Both changes are on disk
+	reloaded, err := NewStore(StoreConfig{FilePath: filePath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloaded.Authenticate(oldSecret); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected the old key to stay revoked, got %v", err)
+	}
+	if _, err := reloaded.Authenticate(secret); err != nil {
+		t.Fatalf("expected the new key to be stored, got %v", err)
+	}
+}
+
+func TestRotateChangesNothingWhenSaveFails(t *testing.T) {
+	ctx := context.Background()
+	s, filePath := newTestStore(t)
+	old, oldSecret, err := s.Create(ctx, CreateOptions{Name: "ci", Scope: ScopeTrigger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Dir(filePath)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := s.Rotate(ctx, old.ID, "admin"); err == nil {
+		t.Fatal("expected rotating to fail when the keys can't be saved")
+	}
+	if _, err := s.Authenticate(oldSecret); err != nil {
+		t.Fatalf("expected the old key to stay active, got %v", err)
+	}
+	keys, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("expected no new key, got %d keys", len(keys))
+	}
+}
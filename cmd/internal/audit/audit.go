@@ -0,0 +1,223 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/identity"
+	"github.com/ansonallard/deployment-service/cmd/internal/utils"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("deployment-service.audit")
+
+// Longest entry Query reads. Entries are small, so only a corrupt file has
+// longer lines.
+const maxEntryBytes = 1 << 20
+
+// Actions recorded by the background processor. API requests are recorded
+// as "<method> <route>", e.g. "DELETE /v1/services/:name".
+const (
+	ActionReleasePullRequestOpened = "release_pull_request_opened"
+	ActionReleased                 = "released"
+	ActionDeployed                 = "deployed"
+	ActionReleaseFailed            = "release_failed"
+)
+
+type Entry struct {
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	Actor   string    `json:"actor"`
+	Action  string    `json:"action"`
+	Service string    `json:"service,omitempty"`
+	TraceID string    `json:"traceId,omitempty"`
+	// Set for API requests
+	Method string `json:"method,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Status int    `json:"status,omitempty"`
+	// Set for pipeline actions
+	Version string `json:"version,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type Filter struct {
+	Service string
+	// Since and Until bound the entry's time, inclusively. Optional.
+	Since      *time.Time
+	Until      *time.Time
+	MaxResults int
+}
+
+// Log is an append-only record of changes made through the API and of what
+// the background processor released.
+type Log interface {
+	// Record appends entry, filling in its ID, time, actor and trace ID from
+	// ctx when they aren't set. Failing to record doesn't fail the caller, so
+	// it is only logged.
+	Record(ctx context.Context, entry Entry)
+	// Query returns the entries matching filter, newest first.
+	Query(ctx context.Context, filter Filter) ([]Entry, error)
+}
+
+type Config struct {
+	// FilePath is the JSON lines file entries are appended to
+	FilePath string
+}
+
+func NewLog(config Config) (Log, error) {
+	if config.FilePath == "" {
+		return nil, fmt.Errorf("filePath not provided")
+	}
+	return &fileLog{filePath: config.FilePath}, nil
+}
+
+type fileLog struct {
+	filePath string
+	mu       sync.Mutex
+}
+
+func (l *fileLog) Record(ctx context.Context, entry Entry) {
+	if entry.ID == "" {
+		entry.ID = utils.GenerateUlidString()
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	if entry.Actor == "" {
+		entry.Actor = identity.CallerFromContext(ctx)
+	}
+	if sc := trace.SpanContextFromContext(ctx); entry.TraceID == "" && sc.HasTraceID() {
+		entry.TraceID = sc.TraceID().String()
+	}
+
+	if err := l.append(entry); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("action", entry.Action).Str("actor", entry.Actor).
+			Str("service", entry.Service).Msg("Failed to write audit log entry")
+	}
+}
+
+func (l *fileLog) append(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	file, err := os.OpenFile(l.filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	// One write per entry, so a crash can at most cut off the last line
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (l *fileLog) Query(ctx context.Context, filter Filter) ([]Entry, error) {
+	_, span := tracer.Start(ctx, "audit.query",
+		trace.WithAttributes(
+			attribute.String("service.name", filter.Service),
+			attribute.Int("max_results", filter.MaxResults),
+		),
+	)
+	defer span.End()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	file, err := os.Open(l.filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Entry{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make([]Entry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxEntryBytes)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// e.g. the last line of an entry cut off by a crash
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("Skipping unreadable audit log entry")
+			continue
+		}
+		if filter.matches(&entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.Reverse(entries)
+	if filter.MaxResults > 0 && len(entries) > filter.MaxResults {
+		entries = entries[:filter.MaxResults]
+	}
+	return entries, nil
+}
+
+func (f Filter) matches(entry *Entry) bool {
+	if f.Service != "" && entry.Service != f.Service {
+		return false
+	}
+	if f.Since != nil && entry.Time.Before(*f.Since) {
+		return false
+	}
+	if f.Until != nil && entry.Time.After(*f.Until) {
+		return false
+	}
+	return true
+}
+
+// Request holds what handlers tell the audit log about an API request. A nil
+// Request is valid and ignores everything.
+type Request struct {
+	mu      sync.Mutex
+	service string
+}
+
+type requestKey struct{}
+
+// WithRequest returns a ctx carrying a Request for SetService.
+func WithRequest(ctx context.Context) (context.Context, *Request) {
+	request := &Request{}
+	return context.WithValue(ctx, requestKey{}, request), request
+}
+
+// SetService names the service the request in ctx acts on, for routes that
+// don't have it in their path, e.g. creating a service.
+func SetService(ctx context.Context, serviceName string) {
+	request, _ := ctx.Value(requestKey{}).(*Request)
+	if request == nil {
+		return
+	}
+	request.mu.Lock()
+	defer request.mu.Unlock()
+	request.service = serviceName
+}
+
+// Service returns the service set with SetService.
+func (r *Request) Service() string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.service
+}
@@ -0,0 +1,144 @@
+package audit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/identity"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestLog(t *testing.T) (Log, string) {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := NewLog(Config{FilePath: filePath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, filePath
+}
+
+func ids(entries []Entry) []string {
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+	return ids
+}
+
+func TestRecordFillsInFromContext(t *testing.T) {
+	l, _ := newTestLog(t)
+	traceID := trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+	}))
+	ctx = identity.WithCaller(ctx, "key:ci")
+
+	before := time.Now().UTC()
+	l.Record(ctx, Entry{Action: ActionReleased, Service: "my-service", Version: "1.2.3"})
+	entries, err := l.Query(context.Background(), Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %+v", entries)
+	}
+	entry := entries[0]
+	if entry.ID == "" || entry.Time.Before(before) || entry.Actor != "key:ci" || entry.TraceID != traceID.String() {
+		t.Fatalf("expected the ID, time, actor and trace ID to be filled in, got %+v", entry)
+	}
+	if entry.Action != ActionReleased || entry.Service != "my-service" || entry.Version != "1.2.3" {
+		t.Fatalf("expected the recorded fields to be kept, got %+v", entry)
+	}
+
+	// Set fields aren't replaced
+	l.Record(ctx, Entry{ID: "id", Actor: "background-processor", Action: ActionDeployed})
+	entries, err = l.Query(context.Background(), Filter{MaxResults: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[0].ID != "id" || entries[0].Actor != "background-processor" {
+		t.Fatalf("expected the set ID and actor to be kept, got %+v", entries[0])
+	}
+}
+
+func TestQueryFiltersNewestFirst(t *testing.T) {
+	l, _ := newTestLog(t)
+	ctx := context.Background()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, service := range []string{"a", "b", "a", "a", "b"} {
+		l.Record(ctx, Entry{
+			ID:      string(rune('0' + i)),
+			Time:    start.Add(time.Duration(i) * time.Hour),
+			Action:  ActionDeployed,
+			Service: service,
+		})
+	}
+	since := start.Add(time.Hour)
+	until := start.Add(3 * time.Hour)
+
+	for _, tt := range []struct {
+		name     string
+		filter   Filter
+		expected []string
+	}{
+		{name: "everything", filter: Filter{}, expected: []string{"4", "3", "2", "1", "0"}},
+		{name: "service", filter: Filter{Service: "a"}, expected: []string{"3", "2", "0"}},
+		{name: "since and until are inclusive", filter: Filter{Since: &since, Until: &until}, expected: []string{"3", "2", "1"}},
+		{name: "service and time", filter: Filter{Service: "b", Since: &since}, expected: []string{"4", "1"}},
+		{name: "max results keeps the newest", filter: Filter{MaxResults: 2}, expected: []string{"4", "3"}},
+		{name: "max results after filtering", filter: Filter{Service: "a", MaxResults: 2}, expected: []string{"3", "2"}},
+		{name: "max results beyond the entries", filter: Filter{Service: "b", MaxResults: 10}, expected: []string{"4", "1"}},
+		{name: "nothing matches", filter: Filter{Service: "c"}, expected: []string{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := l.Query(ctx, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(entries); !slices.Equal(got, tt.expected) {
+				t.Fatalf("expected entries %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestQuerySkipsUnreadableEntries(t *testing.T) {
+	l, filePath := newTestLog(t)
+	ctx := context.Background()
+	l.Record(ctx, Entry{ID: "first", Action: ActionDeployed})
+	// As left by a crash in the middle of a write
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteString(`{"id":"cut off","act` + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	l.Record(ctx, Entry{ID: "second", Action: ActionDeployed})
+
+	entries, err := l.Query(ctx, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(entries); !slices.Equal(got, []string{"second", "first"}) {
+		t.Fatalf("expected the readable entries, got %v", got)
+	}
+}
+
+func TestQueryWithoutEntries(t *testing.T) {
+	l, _ := newTestLog(t)
+	entries, err := l.Query(context.Background(), Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries == nil || len(entries) != 0 {
+		t.Fatalf("expected no entries, got %#v", entries)
+	}
+}
@@ -2,25 +2,35 @@ package backgroundprocessor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/Masterminds/semver/v3"
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/audit"
 	"github.com/ansonallard/deployment-service/cmd/internal/background_processor/dockerbuild"
 	"github.com/ansonallard/deployment-service/cmd/internal/background_processor/dockercompose"
 	"github.com/ansonallard/deployment-service/cmd/internal/background_processor/goservice"
 	"github.com/ansonallard/deployment-service/cmd/internal/background_processor/npm"
 	"github.com/ansonallard/deployment-service/cmd/internal/background_processor/openapi"
 	"github.com/ansonallard/deployment-service/cmd/internal/background_processor/utils"
+	"github.com/ansonallard/deployment-service/cmd/internal/commitstatus"
+	"github.com/ansonallard/deployment-service/cmd/internal/credentials"
+	"github.com/ansonallard/deployment-service/cmd/internal/events"
+	"github.com/ansonallard/deployment-service/cmd/internal/metrics"
 	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/pullrequest"
+	"github.com/ansonallard/deployment-service/cmd/internal/repo"
+	"github.com/ansonallard/deployment-service/cmd/internal/runlog"
+	"github.com/ansonallard/deployment-service/cmd/internal/signing"
 	"github.com/ansonallard/deployment-service/cmd/internal/version"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/plumbing/storer"
-	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/rs/zerolog"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -31,8 +41,10 @@ import (
 var tracer = otel.Tracer("deployment-service.background")
 
 const (
-	ciCommitMsgFormat = "ci: Release version %s"
-	defaultOrigin     = "origin"
+	ciCommitMsgFormat   = "ci: Release version %s"
+	defaultOrigin       = "origin"
+	releaseBranchFormat = "release/%s"
+	releasePullBodyFmt  = "Automated release of version %s. The release is tagged and built once this is merged."
 )
 
 type BackgroundProcesseror interface {
@@ -41,9 +53,18 @@ type BackgroundProcesseror interface {
 
 type BackgroundProcessorConfig struct {
 	Versioner              version.Versioner
-	SSHKeyPath             string
+	GitClient              repo.GitClient
+	ServiceLocks           repo.ServiceLocks
+	ServiceRepo            repo.DeploymentService
+	PullRequests           pullrequest.Resolver
+	CommitStatuses         commitstatus.Reporter
+	Events                 events.Bus
+	RunLogs                runlog.Store
+	Audit                  audit.Log
+	Credentials            credentials.Store
 	GitRepoOrigin          string
 	CiCommitAuthor         *utils.CiCommitAuthor
+	Signer                 signing.Signer // Optional, commits and tags are unsigned when nil
 	NpmServiceProcessor    npm.NPMServiceProcessor
 	OpenAPIProcessor       openapi.OpenAPIProcessor
 	GoServiceProcessor     goservice.GoServiceProcessor
@@ -57,12 +78,32 @@ func NewBackgroundProcessor(config BackgroundProcessorConfig) (BackgroundProcess
 	if config.Versioner == nil {
 		return nil, fmt.Errorf("versioner not provided")
 	}
-	sshAuth, err := ssh.NewPublicKeysFromFile("git", config.SSHKeyPath, "")
-	if err != nil {
-		return nil, fmt.Errorf("failed to load ssh key: %w", err)
+	if config.GitClient == nil {
+		return nil, fmt.Errorf("gitClient not provided")
+	}
+	if config.ServiceLocks == nil {
+		return nil, fmt.Errorf("serviceLocks not provided")
+	}
+	if config.ServiceRepo == nil {
+		return nil, fmt.Errorf("serviceRepo not provided")
+	}
+	if config.PullRequests == nil {
+		return nil, fmt.Errorf("pullRequests not provided")
+	}
+	if config.CommitStatuses == nil {
+		return nil, fmt.Errorf("commitStatuses not provided")
+	}
+	if config.Events == nil {
+		return nil, fmt.Errorf("events not provided")
 	}
-	if config.SSHKeyPath == "" {
-		return nil, fmt.Errorf("sshKeyPath not provided")
+	if config.RunLogs == nil {
+		return nil, fmt.Errorf("runLogs not provided")
+	}
+	if config.Audit == nil {
+		return nil, fmt.Errorf("audit not provided")
+	}
+	if config.Credentials == nil {
+		return nil, fmt.Errorf("credentials not provided")
 	}
 	if config.GitRepoOrigin == "" {
 		return nil, fmt.Errorf("gitRepoOrigin not provided")
@@ -88,9 +129,18 @@ func NewBackgroundProcessor(config BackgroundProcessorConfig) (BackgroundProcess
 
 	return &backgroundProcessor{
 			versioner:              config.Versioner,
+			gitClient:              config.GitClient,
+			serviceLocks:           config.ServiceLocks,
+			serviceRepo:            config.ServiceRepo,
+			pullRequests:           config.PullRequests,
+			commitStatuses:         config.CommitStatuses,
+			events:                 config.Events,
+			runLogs:                config.RunLogs,
+			audit:                  config.Audit,
 			gitRepoOrigin:          config.GitRepoOrigin,
-			sshAuth:                sshAuth,
+			credentials:            config.Credentials,
 			ciCommmitAuthor:        config.CiCommitAuthor,
+			signer:                 config.Signer,
 			npmServiceProcessor:    config.NpmServiceProcessor,
 			openAPIProcessor:       config.OpenAPIProcessor,
 			goServiceProcessor:     config.GoServiceProcessor,
@@ -104,9 +154,18 @@ func NewBackgroundProcessor(config BackgroundProcessorConfig) (BackgroundProcess
 
 type backgroundProcessor struct {
 	versioner              version.Versioner
-	sshAuth                *ssh.PublicKeys
+	gitClient              repo.GitClient
+	serviceLocks           repo.ServiceLocks
+	serviceRepo            repo.DeploymentService
+	pullRequests           pullrequest.Resolver
+	commitStatuses         commitstatus.Reporter
+	events                 events.Bus
+	runLogs                runlog.Store
+	audit                  audit.Log
+	credentials            credentials.Store
 	gitRepoOrigin          string
 	ciCommmitAuthor        *utils.CiCommitAuthor
+	signer                 signing.Signer
 	npmServiceProcessor    npm.NPMServiceProcessor
 	openAPIProcessor       openapi.OpenAPIProcessor
 	goServiceProcessor     goservice.GoServiceProcessor
@@ -117,16 +176,109 @@ type backgroundProcessor struct {
 	selfServiceName        string
 }
 
-func (bp *backgroundProcessor) ProcessService(ctx context.Context, service *model.Service) error {
+func (bp *backgroundProcessor) ProcessService(ctx context.Context, service *model.Service) (retErr error) {
 	log := zerolog.Ctx(ctx)
+	if provisioned, err := bp.provisioned(ctx, service); err != nil || !provisioned {
+		return err
+	}
 
 	unlock := bp.acquireDeployLock(ctx, service)
 	defer unlock()
 
+	// Held for the whole tick so the worktree can't be re-cloned mid-release,
+	// e.g. while the build reads it. API operations wait for it to be released.
+	unlockService, ok := bp.serviceLocks.TryLock(service.Name.Name)
+	if !ok {
+		bp.updateDeploymentStatus(ctx, service, func(deployment *model.DeploymentStatus) {
+			deployment.State = model.PipelineStateQueued
+		})
+		dequeue := metrics.Queued(ctx)
+		unlockService = bp.serviceLocks.Lock(service.Name.Name)
+		dequeue()
+	}
+	defer unlockService()
+
+	// The service may have been changed, re-provisioned or deleted by the API
+	// while the tick waited, so it releases from the stored definition
+	current, err := bp.serviceRepo.Get(ctx, service.Name.Name)
+	if err != nil {
+		if apierr.HasCode(err, apierr.CodeServiceNotFound) {
+			log.Info().Str("service", service.Name.Name).Msg("Service was deleted, skipping tick")
+			return nil
+		}
+		return err
+	}
+	service = current
+	if provisioned, err := bp.provisioned(ctx, service); err != nil || !provisioned {
+		if !ok {
+			bp.finishDeploymentStatus(ctx, service, nil)
+		}
+		return err
+	}
+
+	ctx = bp.events.WithService(ctx, service.Name.Name)
+	events.Emit(ctx, events.Event{Type: events.TypeTickStarted})
+	defer func() {
+		if retErr != nil {
+			events.Emit(ctx, events.Event{Type: events.TypeReleaseFailed, Message: retErr.Error()})
+		}
+	}()
+
+	// Reports the outcome of whichever stage the release stopped at
+	var status *commitstatus.Tracker
+	defer func() { status.Finish(ctx, retErr) }()
+	// Closes the log of the release, once one has started
+	var run *runlog.Run
+	defer func() { run.Finish(retErr) }()
+	defer func() {
+		// Ticks that fail before a release starts, e.g. while the remote is
+		// unreachable, would flood the audit log
+		if retErr != nil && run != nil {
+			bp.audit.Record(ctx, audit.Entry{Action: audit.ActionReleaseFailed, Service: service.Name.Name, Message: retErr.Error()})
+		}
+	}()
+	defer func() { bp.finishDeploymentStatus(ctx, service, retErr) }()
+	// The stage the tick is in, to tell which one failed
+	stage := metrics.StageCheck
+	defer func() {
+		if retErr != nil {
+			metrics.RecordFailure(ctx, service.Name.Name, stage)
+		}
+	}()
+
+	gitAuth, err := bp.credentials.AuthMethod(service.GitCredential, service.GitSSHUrl)
+	if err != nil {
+		return fmt.Errorf("failed to resolve git credential: %w", err)
+	}
+
+	usePullRequest := !bp.isDevMode && service.GitReleaseStrategy == model.ReleaseStrategyPullRequest
+	if pending := service.ReleasePullRequest; usePullRequest && pending != nil && !pending.Declined {
+		stage = metrics.StageRelease
+		releasedVersion, mergeCommit, err := bp.completeReleasePullRequest(ctx, service, gitAuth)
+		if err != nil || releasedVersion == nil {
+			return err
+		}
+		events.Emit(ctx, events.Event{Type: events.TypeTagPushed, Version: releasedVersion.String(), Commit: mergeCommit.String()})
+		ctx, run = bp.beginRun(ctx, service)
+		bp.audit.Record(ctx, audit.Entry{
+			Action:  audit.ActionReleased,
+			Service: service.Name.Name,
+			Version: releasedVersion.String(),
+			Commit:  mergeCommit.String(),
+			Message: fmt.Sprintf("merged %s", pending.URL),
+		})
+		bp.updateDeploymentStatus(ctx, service, func(deployment *model.DeploymentStatus) {
+			deployment.Released(releasedVersion.String(), mergeCommit.String())
+		})
+		ctx, status = bp.commitStatuses.Begin(ctx, service, mergeCommit.String(), commitstatus.StageBuild)
+		stage = buildStage(service)
+		return bp.build(ctx, service, releasedVersion)
+	}
+
 	_, checkSpan := tracer.Start(ctx, "background.has_new_commit",
 		trace.WithAttributes(attribute.String("service.name", service.Name.Name)),
 	)
-	hasNewCommit, err := bp.hasNewCommit(ctx, service)
+	head, hasNewCommit, err := bp.hasNewCommit(ctx, service, gitAuth)
 	if err != nil {
 		checkSpan.RecordError(err)
 		checkSpan.SetStatus(codes.Error, err.Error())
@@ -134,17 +286,39 @@ func (bp *backgroundProcessor) ProcessService(ctx context.Context, service *mode
 		return err
 	}
 	checkSpan.End()
+	bp.updateDeploymentStatus(ctx, service, func(deployment *model.DeploymentStatus) {
+		deployment.HeadCommit = head.String()
+	})
 	if !hasNewCommit {
 		if service.Configuration.DockerCompose != nil && service.Configuration.DockerCompose.RefreshImages {
+			stage = metrics.StageDeploy
 			return bp.dockerComposeProcessor.RefreshDockerComposeApplication(ctx, service)
 		}
 		return nil
 	}
 
+	if pending := service.ReleasePullRequest; pending != nil && pending.Declined {
+		if head.String() == pending.BaseCommit {
+			log.Debug().Str("service", service.Name.Name).Str("pullRequest", pending.URL).
+				Msg("Release pull request was declined, waiting for new commits")
+			return nil
+		}
+		if err := bp.serviceRepo.SetReleasePullRequest(ctx, service.Name.Name, nil); err != nil {
+			return err
+		}
+	}
+
+	ctx, run = bp.beginRun(ctx, service)
+	stage = metrics.StageVersion
+	events.Emit(ctx, events.Event{Type: events.TypeNewCommit, Commit: head.String()})
+	ctx, status = bp.commitStatuses.Begin(ctx, service, head.String(), commitstatus.StageVersion)
+
 	calcCtx, calcSpan := tracer.Start(ctx, "background.calculate_next_version",
 		trace.WithAttributes(attribute.String("service.name", service.Name.Name)),
 	)
+	calcStart := time.Now()
 	nextVersion, err := bp.versioner.CalculateNextVersion(calcCtx, service.GitRepoFilePath)
+	metrics.RecordVersionCalculation(ctx, service.Name.Name, calcStart, err)
 	if err != nil {
 		calcSpan.RecordError(err)
 		calcSpan.SetStatus(codes.Error, err.Error())
@@ -153,6 +327,7 @@ func (bp *backgroundProcessor) ProcessService(ctx context.Context, service *mode
 	}
 	calcSpan.End()
 	log.Info().Interface("semver", nextVersion).Str("nextVersion", nextVersion.String()).Msg("Next version")
+	events.Emit(ctx, events.Event{Type: events.TypeVersionComputed, Version: nextVersion.String(), Commit: head.String()})
 
 	serviceConfiguration := service.Configuration
 	_, setVerSpan := tracer.Start(ctx, "background.set_version",
@@ -191,24 +366,65 @@ func (bp *backgroundProcessor) ProcessService(ctx context.Context, service *mode
 	setVerSpan.End()
 
 	if !bp.isDevMode {
+		stage = metrics.StageRelease
 		var skipStaging bool
 		if serviceConfiguration.DockerCompose != nil || serviceConfiguration.DockerBuild != nil {
 			skipStaging = true
 		}
+		if usePullRequest {
+			log.Info().Str("service", service.Name.Name).Str("nextVersion", nextVersion.String()).Msg("Opening release pull request")
+			// Tagging and building wait for the pull request to be merged
+			return bp.openReleasePullRequest(ctx, service, gitAuth, nextVersion, skipStaging)
+		}
+
 		log.Info().Str("service", service.Name.Name).Str("nextVersion", nextVersion.String()).Msg("Commiting changes")
-		if err := bp.commitChanges(ctx, service.GitRepoFilePath, nextVersion, skipStaging); err != nil {
+		if err := bp.commitChanges(ctx, service.GitRepoFilePath, gitAuth, nextVersion, skipStaging); err != nil {
 			return err
 		}
 
 		log.Info().Str("service", service.Name.Name).Str("nextVersion", nextVersion.String()).Msg("Tagging and pushing changes")
-		if err := bp.tagAndPushChanges(ctx, service.GitRepoFilePath, *nextVersion); err != nil {
+		releaseCommit, err := bp.tagAndPushChanges(ctx, service.GitRepoFilePath, gitAuth, *nextVersion, plumbing.ZeroHash)
+		if err != nil {
 			return err
 		}
+		events.Emit(ctx, events.Event{Type: events.TypeTagPushed, Version: nextVersion.String(), Commit: releaseCommit.String()})
+		bp.audit.Record(ctx, audit.Entry{
+			Action:  audit.ActionReleased,
+			Service: service.Name.Name,
+			Version: nextVersion.String(),
+			Commit:  releaseCommit.String(),
+		})
+		bp.updateDeploymentStatus(ctx, service, func(deployment *model.DeploymentStatus) {
+			deployment.Released(nextVersion.String(), releaseCommit.String())
+		})
+	}
+
+	stage = buildStage(service)
+	return bp.build(ctx, service, nextVersion)
+}
+
+// buildStage is the stage build runs service in.
+func buildStage(service *model.Service) metrics.Stage {
+	if service.Configuration.DockerCompose != nil {
+		return metrics.StageDeploy
 	}
+	return metrics.StageBuild
+}
+
+func (bp *backgroundProcessor) build(ctx context.Context, service *model.Service, nextVersion *semver.Version) (retErr error) {
+	log := zerolog.Ctx(ctx)
+	serviceConfiguration := service.Configuration
+	start := time.Now()
+	defer func() { metrics.RecordBuild(ctx, service.Name.Name, service.ConfigurationType(), start, retErr) }()
 
 	buildCtx, buildSpan := tracer.Start(ctx, "background.build",
 		trace.WithAttributes(attribute.String("service.name", service.Name.Name)),
 	)
+	if serviceConfiguration.DockerCompose != nil {
+		commitstatus.Enter(buildCtx, commitstatus.StageDeploy)
+	} else {
+		commitstatus.Enter(buildCtx, commitstatus.StageBuild)
+	}
 	switch {
 	case serviceConfiguration.Npm != nil && serviceConfiguration.Npm.Service != nil:
 		if err := bp.npmServiceProcessor.BuildNpmService(buildCtx, service, nextVersion); err != nil {
@@ -273,88 +489,116 @@ func (bp *backgroundProcessor) ProcessService(ctx context.Context, service *mode
 	}
 	buildSpan.End()
 
+	events.Emit(ctx, events.Event{Type: events.TypeDeployFinished, Version: nextVersion.String()})
+	bp.audit.Record(ctx, audit.Entry{Action: audit.ActionDeployed, Service: service.Name.Name, Version: nextVersion.String()})
+	bp.updateDeploymentStatus(ctx, service, func(deployment *model.DeploymentStatus) {
+		deployment.Deployed(nextVersion.String())
+	})
 	return nil
 }
 
-func (bp *backgroundProcessor) hasNewCommit(ctx context.Context, service *model.Service) (bool, error) {
+// provisioned reports whether the service's first clone has finished, since
+// until then there is no worktree to release from.
+func (bp *backgroundProcessor) provisioned(ctx context.Context, service *model.Service) (bool, error) {
+	deploymentStatus, err := bp.serviceRepo.GetDeploymentStatus(ctx, service.Name.Name)
+	if err != nil {
+		return false, err
+	}
+	if !deploymentStatus.Provisioned() {
+		zerolog.Ctx(ctx).Debug().Str("service", service.Name.Name).Str("provisioning", string(deploymentStatus.EffectiveProvisioning())).
+			Msg("Service isn't provisioned, skipping tick")
+		return false, nil
+	}
+	return true, nil
+}
+
+// beginRun starts the log of a release and reports the service as running.
+// The release goes ahead without a log if it can't be started.
+func (bp *backgroundProcessor) beginRun(ctx context.Context, service *model.Service) (context.Context, *runlog.Run) {
+	ctx, run, err := bp.runLogs.Start(ctx, service.Name.Name)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("service", service.Name.Name).Msg("Failed to start run log")
+	}
+	bp.updateDeploymentStatus(ctx, service, func(deployment *model.DeploymentStatus) {
+		deployment.State = model.PipelineStateRunning
+		if run != nil {
+			deployment.LastRunID = run.ID
+		}
+	})
+	return ctx, run
+}
+
+// updateDeploymentStatus records progress in the service's deployment status.
+// Failing to record it doesn't fail the release.
+func (bp *backgroundProcessor) updateDeploymentStatus(ctx context.Context, service *model.Service, update func(deployment *model.DeploymentStatus)) {
+	if err := bp.serviceRepo.UpdateDeploymentStatus(ctx, service.Name.Name, update); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("service", service.Name.Name).Msg("Failed to update deployment status")
+	}
+}
+
+// finishDeploymentStatus records the outcome of a tick. A failure is kept
+// until the next run starts, since ticks that find nothing to release don't
+// retry it.
+func (bp *backgroundProcessor) finishDeploymentStatus(ctx context.Context, service *model.Service, err error) {
+	bp.updateDeploymentStatus(ctx, service, func(deployment *model.DeploymentStatus) {
+		switch {
+		case err != nil:
+			deployment.Failed(err)
+		case deployment.State == model.PipelineStateQueued || deployment.State == model.PipelineStateRunning:
+			deployment.State = model.PipelineStateIdle
+		}
+	})
+}
+
+// hasNewCommit pulls the tracked branch and reports its HEAD and whether HEAD
+// is not yet tagged with a release.
+func (bp *backgroundProcessor) hasNewCommit(ctx context.Context, service *model.Service, gitAuth transport.AuthMethod) (plumbing.Hash, bool, error) {
 	ctx, span := tracer.Start(ctx, "background.pull_and_check",
 		trace.WithAttributes(attribute.String("service.name", service.Name.Name)),
 	)
 	defer span.End()
 
-	repo, err := git.PlainOpen(service.GitRepoFilePath)
+	gitRepo, err := bp.gitClient.Open(ctx, service.GitRepoFilePath)
 	if err != nil {
-		return false, fmt.Errorf("failed to open repo: %w", err)
+		return plumbing.ZeroHash, false, fmt.Errorf("failed to open repo: %w", err)
 	}
 
-	// Pull from remote
-	wt, err := repo.Worktree()
-	if err != nil {
-		return false, fmt.Errorf("failed to get worktree: %w", err)
-	}
-
-	err = wt.Pull(&git.PullOptions{
+	if err := gitRepo.Pull(ctx, &git.PullOptions{
 		RemoteName: defaultOrigin,
 		Progress:   nil,
 		Force:      true,
-		Auth:       bp.sshAuth,
-	})
-	if err != nil && err != git.NoErrAlreadyUpToDate {
-		return false, fmt.Errorf("failed to pull: %w", err)
+		Auth:       gitAuth,
+	}); err != nil {
+		return plumbing.ZeroHash, false, fmt.Errorf("failed to pull: %w", err)
 	}
 
 	// Get current HEAD
-	ref, err := repo.Head()
+	head, err := gitRepo.Head(ctx)
 	if err != nil {
-		return false, fmt.Errorf("failed to get HEAD: %w", err)
+		return plumbing.ZeroHash, false, fmt.Errorf("failed to get HEAD: %w", err)
 	}
 
-	c, err := repo.CommitObject(ref.Hash())
+	tags, err := gitRepo.Tags(ctx)
 	if err != nil {
-		return false, fmt.Errorf("failed to get commit object: %w", err)
-	}
-
-	tags, err := repo.Tags()
-	if err != nil {
-		return false, fmt.Errorf("failed to get tags: %w", err)
+		return plumbing.ZeroHash, false, fmt.Errorf("failed to get tags: %w", err)
 	}
 
 	foundSemver := false
-	err = tags.ForEach(func(ref *plumbing.Reference) error {
-		// Try to resolve annotated tag objects
-		tagObj, err := repo.TagObject(ref.Hash())
-		var targetHash plumbing.Hash
-		if err == nil {
-			// Annotated tag -> resolve to its target
-			targetHash = tagObj.Target
-		} else {
-			// Lightweight tag -> points directly to commit
-			targetHash = ref.Hash()
-		}
-
+	for _, tag := range tags {
 		// Compare tag's target to current commit
-		if targetHash != c.Hash {
-			return nil
+		if tag.Target != head.Hash() {
+			continue
 		}
-
-		tagName := ref.Name().Short()
-
-		// Attempt to parse as semver
-		if _, err := semver.NewVersion(tagName); err == nil {
+		if _, err := semver.NewVersion(tag.Name); err == nil {
 			foundSemver = true
-			return storer.ErrStop // stop iteration early
+			break
 		}
-
-		return nil
-	})
-	if err != nil && err != storer.ErrStop {
-		return false, fmt.Errorf("error iterating tags: %w", err)
 	}
 
-	return !foundSemver, nil
+	return head.Hash(), !foundSemver, nil
 }
 
-func (bp *backgroundProcessor) commitChanges(ctx context.Context, repoPath string, version *semver.Version, skipStaging bool) error {
+func (bp *backgroundProcessor) commitChanges(ctx context.Context, repoPath string, gitAuth transport.AuthMethod, version *semver.Version, skipStaging bool) error {
 	ctx, span := tracer.Start(ctx, "background.commit",
 		trace.WithAttributes(
 			attribute.String("repo_path", repoPath),
@@ -363,43 +607,41 @@ func (bp *backgroundProcessor) commitChanges(ctx context.Context, repoPath strin
 	)
 	defer span.End()
 
-	repo, err := git.PlainOpen(repoPath)
+	gitRepo, err := bp.gitClient.Open(ctx, repoPath)
 	if err != nil {
 		return fmt.Errorf("failed to open repo: %w", err)
 	}
 
-	workTree, err := repo.Worktree()
-	if err != nil {
+	if _, err = gitRepo.Commit(ctx, bp.releaseCommitOptions(version, skipStaging)); err != nil {
 		return err
 	}
 
-	if !skipStaging {
-		if err = workTree.AddGlob("*"); err != nil {
-			return err
-		}
+	if err := gitRepo.Push(ctx, &git.PushOptions{
+		Auth:       gitAuth,
+		RemoteName: bp.gitRepoOrigin,
+	}); err != nil {
+		return fmt.Errorf("failed to push commit: %w", err)
 	}
-	_, err = workTree.Commit(fmt.Sprintf(ciCommitMsgFormat, version.String()), &git.CommitOptions{
+	return nil
+}
+
+func (bp *backgroundProcessor) releaseCommitOptions(version *semver.Version, skipStaging bool) repo.CommitOptions {
+	return repo.CommitOptions{
+		Message: fmt.Sprintf(ciCommitMsgFormat, version.String()),
 		Author: &object.Signature{
 			Name:  bp.ciCommmitAuthor.Name,
 			Email: bp.ciCommmitAuthor.Email,
 			When:  time.Now(),
 		},
-		AllowEmptyCommits: true,
-	})
-	if err != nil {
-		return err
+		StageAll:   !skipStaging,
+		AllowEmpty: true,
+		Signer:     bp.signer,
 	}
-
-	if err := repo.Push(&git.PushOptions{
-		Auth:       bp.sshAuth,
-		RemoteName: bp.gitRepoOrigin,
-	}); err != nil && err != git.NoErrAlreadyUpToDate {
-		return fmt.Errorf("failed to push commit: %w", err)
-	}
-	return nil
 }
 
-func (bp *backgroundProcessor) tagAndPushChanges(ctx context.Context, repoPath string, version semver.Version) error {
+// tagAndPushChanges tags target with version, or HEAD when target is the zero
+// hash, and pushes the tag. It returns the tagged commit.
+func (bp *backgroundProcessor) tagAndPushChanges(ctx context.Context, repoPath string, gitAuth transport.AuthMethod, version semver.Version, target plumbing.Hash) (plumbing.Hash, error) {
 	ctx, span := tracer.Start(ctx, "background.tag",
 		trace.WithAttributes(
 			attribute.String("repo_path", repoPath),
@@ -408,36 +650,218 @@ func (bp *backgroundProcessor) tagAndPushChanges(ctx context.Context, repoPath s
 	)
 	defer span.End()
 
-	repo, err := git.PlainOpen(repoPath)
+	gitRepo, err := bp.gitClient.Open(ctx, repoPath)
 	if err != nil {
-		return fmt.Errorf("failed to open repo: %w", err)
+		return plumbing.ZeroHash, fmt.Errorf("failed to open repo: %w", err)
 	}
 
-	head, err := repo.Head()
-	if err != nil {
-		return fmt.Errorf("failed to get HEAD: %w", err)
+	if target.IsZero() {
+		head, err := gitRepo.Head(ctx)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("failed to get HEAD: %w", err)
+		}
+		target = head.Hash()
 	}
 
-	_, err = repo.CreateTag(version.String(), head.Hash(), &git.CreateTagOptions{
+	_, err = gitRepo.CreateTag(ctx, version.String(), target, repo.TagOptions{
 		Tagger: &object.Signature{
 			Name:  bp.ciCommmitAuthor.Name,
 			Email: bp.ciCommmitAuthor.Email,
 			When:  time.Now(),
 		},
 		Message: fmt.Sprintf("Release %s", version.String()),
+		Signer:  bp.signer,
 	})
+	if errors.Is(err, git.ErrTagExists) {
+		// An earlier attempt created the tag but may have failed to push it
+		if err := checkExistingTag(ctx, gitRepo, version.String(), target); err != nil {
+			return plumbing.ZeroHash, err
+		}
+		zerolog.Ctx(ctx).Info().Str("version", version.String()).Msg("Tag already exists, pushing it again")
+	} else if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to create tag: %w", err)
+	}
+
+	tagRef := plumbing.NewTagReferenceName(version.String())
+	if err := gitRepo.Push(ctx, &git.PushOptions{
+		Auth:       gitAuth,
+		RemoteName: bp.gitRepoOrigin,
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:%s", tagRef, tagRef))},
+	}); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to push tag: %w", err)
+	}
+	return target, nil
+}
+
+// checkExistingTag fails unless the tag name points at target.
+func checkExistingTag(ctx context.Context, gitRepo repo.Repository, name string, target plumbing.Hash) error {
+	tags, err := gitRepo.Tags(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get tags: %w", err)
+	}
+	for _, tag := range tags {
+		if tag.Name != name {
+			continue
+		}
+		if tag.Target != target {
+			return fmt.Errorf("tag %s already exists on %s, not %s", name, tag.Target, target)
+		}
+		return nil
+	}
+	return fmt.Errorf("tag %s not found", name)
+}
+
+// openReleasePullRequest commits the version bump to a release branch instead
+// of the tracked branch and opens a pull request for it. The local branch is
+// reset afterwards, so the release commit only reaches it through the merge.
+func (bp *backgroundProcessor) openReleasePullRequest(
+	ctx context.Context,
+	service *model.Service,
+	gitAuth transport.AuthMethod,
+	version *semver.Version,
+	skipStaging bool,
+) error {
+	ctx, span := tracer.Start(ctx, "background.open_release_pull_request",
+		trace.WithAttributes(
+			attribute.String("service.name", service.Name.Name),
+			attribute.String("version", version.String()),
+		),
+	)
+	defer span.End()
+
+	provider, prRepo, err := bp.pullRequests.Resolve(service.GitSSHUrl)
 	if err != nil {
-		return fmt.Errorf("failed to create tag: %w", err)
+		return err
 	}
 
-	if err := repo.Push(&git.PushOptions{
-		Auth:       bp.sshAuth,
+	gitRepo, err := bp.gitClient.Open(ctx, service.GitRepoFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open repo: %w", err)
+	}
+	head, err := gitRepo.Head(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get HEAD: %w", err)
+	}
+
+	if _, err := gitRepo.Commit(ctx, bp.releaseCommitOptions(version, skipStaging)); err != nil {
+		return err
+	}
+
+	releaseBranch := fmt.Sprintf(releaseBranchFormat, version.String())
+	pushErr := gitRepo.Push(ctx, &git.PushOptions{
+		Auth:       gitAuth,
 		RemoteName: bp.gitRepoOrigin,
-		RefSpecs:   []config.RefSpec{"refs/tags/*:refs/tags/*"},
-	}); err != nil && err != git.NoErrAlreadyUpToDate {
-		return fmt.Errorf("failed to push tags: %w", err)
+		// Forced, since a declined release of the same version leaves the branch behind
+		RefSpecs: []config.RefSpec{
+			config.RefSpec(fmt.Sprintf("+%s:%s", head.Name(), plumbing.NewBranchReferenceName(releaseBranch))),
+		},
+	})
+	if err := gitRepo.Reset(ctx, &git.ResetOptions{Commit: head.Hash(), Mode: git.HardReset}); err != nil {
+		return fmt.Errorf("failed to reset to %s: %w", head.Hash(), err)
 	}
-	return nil
+	if pushErr != nil {
+		return fmt.Errorf("failed to push release branch: %w", pushErr)
+	}
+
+	pr, err := provider.CreatePullRequest(ctx, prRepo, pullrequest.CreateOptions{
+		Head:  releaseBranch,
+		Base:  head.Name().Short(),
+		Title: fmt.Sprintf(ciCommitMsgFormat, version.String()),
+		Body:  fmt.Sprintf(releasePullBodyFmt, version.String()),
+	})
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(attribute.String("pull_request.url", pr.URL))
+	zerolog.Ctx(ctx).Info().Str("service", service.Name.Name).Str("pullRequest", pr.URL).Msg("Opened release pull request")
+	bp.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionReleasePullRequestOpened,
+		Service: service.Name.Name,
+		Version: version.String(),
+		Commit:  head.Hash().String(),
+		Message: pr.URL,
+	})
+
+	return bp.serviceRepo.SetReleasePullRequest(ctx, service.Name.Name, &model.ReleasePullRequest{
+		Version:    version.String(),
+		Branch:     releaseBranch,
+		Number:     pr.Number,
+		URL:        pr.URL,
+		BaseCommit: head.Hash().String(),
+	})
+}
+
+// completeReleasePullRequest checks on the pending release pull request. Once
+// it is merged the merge commit is tagged and checked out, and the released
+// version and merge commit are returned so the pipeline can build it. It
+// returns a nil version while the pull request is still open or was declined.
+func (bp *backgroundProcessor) completeReleasePullRequest(ctx context.Context, service *model.Service, gitAuth transport.AuthMethod) (*semver.Version, plumbing.Hash, error) {
+	pending := service.ReleasePullRequest
+	ctx, span := tracer.Start(ctx, "background.complete_release_pull_request",
+		trace.WithAttributes(
+			attribute.String("service.name", service.Name.Name),
+			attribute.String("version", pending.Version),
+			attribute.String("pull_request.url", pending.URL),
+		),
+	)
+	defer span.End()
+	log := zerolog.Ctx(ctx)
+
+	provider, prRepo, err := bp.pullRequests.Resolve(service.GitSSHUrl)
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+	pr, err := provider.GetPullRequest(ctx, prRepo, pending.Number)
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+
+	switch pr.State {
+	case pullrequest.StateOpen:
+		log.Debug().Str("service", service.Name.Name).Str("pullRequest", pending.URL).Msg("Waiting for release pull request to be merged")
+		return nil, plumbing.ZeroHash, nil
+	case pullrequest.StateClosed:
+		log.Warn().Str("service", service.Name.Name).Str("pullRequest", pending.URL).Msg("Release pull request was closed without merging")
+		pending.Declined = true
+		return nil, plumbing.ZeroHash, bp.serviceRepo.SetReleasePullRequest(ctx, service.Name.Name, pending)
+	}
+
+	version, err := semver.NewVersion(pending.Version)
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+	if pr.MergeCommitSHA == "" {
+		return nil, plumbing.ZeroHash, fmt.Errorf("release pull request %s has no merge commit", pending.URL)
+	}
+	mergeCommit := plumbing.NewHash(pr.MergeCommitSHA)
+
+	gitRepo, err := bp.gitClient.Open(ctx, service.GitRepoFilePath)
+	if err != nil {
+		return nil, plumbing.ZeroHash, fmt.Errorf("failed to open repo: %w", err)
+	}
+	if err := gitRepo.Pull(ctx, &git.PullOptions{
+		RemoteName: defaultOrigin,
+		Force:      true,
+		Auth:       gitAuth,
+	}); err != nil {
+		return nil, plumbing.ZeroHash, fmt.Errorf("failed to pull: %w", err)
+	}
+	// Build exactly what was merged, even if the branch has moved on since
+	if err := gitRepo.Reset(ctx, &git.ResetOptions{Commit: mergeCommit, Mode: git.HardReset}); err != nil {
+		return nil, plumbing.ZeroHash, fmt.Errorf("failed to check out merge commit %s: %w", mergeCommit, err)
+	}
+
+	log.Info().Str("service", service.Name.Name).Str("version", version.String()).Msg("Release pull request merged, tagging merge commit")
+	// The pull request stays pending until the tag is pushed, so a failed
+	// push is retried on the next tick
+	if _, err := bp.tagAndPushChanges(ctx, service.GitRepoFilePath, gitAuth, *version, mergeCommit); err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+
+	if err := bp.serviceRepo.SetReleasePullRequest(ctx, service.Name.Name, nil); err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+	return version, mergeCommit, nil
 }
 
 func (bp *backgroundProcessor) acquireDeployLock(ctx context.Context, service *model.Service) func() {
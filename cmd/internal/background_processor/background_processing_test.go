@@ -0,0 +1,608 @@
+package backgroundprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/ansonallard/deployment-service/cmd/internal/audit"
+	"github.com/ansonallard/deployment-service/cmd/internal/background_processor/goservice"
+	"github.com/ansonallard/deployment-service/cmd/internal/background_processor/utils"
+	"github.com/ansonallard/deployment-service/cmd/internal/commitstatus"
+	"github.com/ansonallard/deployment-service/cmd/internal/events"
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/pullrequest"
+	"github.com/ansonallard/deployment-service/cmd/internal/repo"
+	"github.com/ansonallard/deployment-service/cmd/internal/runlog"
+	internalutils "github.com/ansonallard/deployment-service/cmd/internal/utils"
+	"github.com/ansonallard/deployment-service/cmd/internal/version"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+const (
+	testServiceName = "service"
+	testBranch      = "main"
+)
+
+// harness runs the background processor for a Go service against an
+// in-memory remote. Only the Docker build is faked.
+type harness struct {
+	t            *testing.T
+	client       *repo.InMemoryGitClient
+	url          string
+	serviceRepo  repo.DeploymentService
+	serviceLocks repo.ServiceLocks
+	releaser     *fakeReleaser
+	pullRequests *fakePullRequests
+	processor    BackgroundProcesseror
+}
+
+type harnessOptions struct {
+	strategy model.ReleaseStrategy
+	// gitClient wraps the in-memory client the processor uses, when set
+	gitClient func(repo.GitClient) repo.GitClient
+}
+
+func newHarness(t *testing.T, opts harnessOptions) *harness {
+	t.Helper()
+	ctx := context.Background()
+	h := &harness{
+		t:            t,
+		client:       repo.NewInMemoryGitClient(),
+		serviceLocks: repo.NewServiceLocks(),
+		releaser:     &fakeReleaser{},
+		pullRequests: &fakePullRequests{},
+	}
+	var err error
+	if h.url, err = h.client.CreateRemote(testServiceName); err != nil {
+		t.Fatal(err)
+	}
+	h.push("feat: initial commit", map[string]string{"version.txt": "0.0.0"})
+
+	serviceFilePath := t.TempDir()
+	h.serviceRepo, err = repo.NewDeploymentService(repo.DeploymentServieConfig{
+		ServiceFilPath: serviceFilePath,
+		Credentials:    noCredentials{},
+		GitClient:      h.client,
+		ServiceLocks:   h.serviceLocks,
+		GitRepoOrigin:  git.DefaultRemoteName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &model.Service{
+		Name:               model.Name{Name: testServiceName},
+		ID:                 internalutils.GenerateUlidString(),
+		Version:            internalutils.GenerateUlidString(),
+		GitSSHUrl:          h.url,
+		GitBranchName:      testBranch,
+		GitReleaseStrategy: opts.strategy,
+		Configuration:      model.ServiceConfiguration{Go: &model.GoConfiguration{Service: &model.GoServiceConfiguration{}}},
+	}
+	if err := h.serviceRepo.Create(ctx, service); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.serviceRepo.Provision(ctx, testServiceName, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.serviceRepo.UpdateDeploymentStatus(ctx, testServiceName, func(status *model.DeploymentStatus) {
+		status.ProvisioningFinished(nil)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var gitClient repo.GitClient = h.client
+	if opts.gitClient != nil {
+		gitClient = opts.gitClient(gitClient)
+	}
+	versioner, err := version.NewVersioner(version.VersionerConfig{GitClient: gitClient})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runLogs, err := runlog.NewStore(runlog.StoreConfig{ServiceFilePath: serviceFilePath, MaxBytes: 1 << 20, Retention: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog, err := audit.NewLog(audit.Config{FilePath: filepath.Join(t.TempDir(), "audit.jsonl")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	goProcessor, err := goservice.NewGoServiceProcessor(goservice.GoServiceProcessorConfig{
+		DockerReleaser: h.releaser,
+		GoUser:         "user",
+		GoPAT:          "pat",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.processor, err = NewBackgroundProcessor(BackgroundProcessorConfig{
+		Versioner:              versioner,
+		GitClient:              gitClient,
+		ServiceLocks:           h.serviceLocks,
+		ServiceRepo:            h.serviceRepo,
+		PullRequests:           h.pullRequests,
+		CommitStatuses:         commitstatus.NewReporter(commitstatus.ReporterConfig{}),
+		Events:                 events.NewBus(),
+		RunLogs:                runLogs,
+		Audit:                  auditLog,
+		Credentials:            noCredentials{},
+		GitRepoOrigin:          git.DefaultRemoteName,
+		CiCommitAuthor:         &utils.CiCommitAuthor{Name: "CI", Email: "ci@example.com"},
+		NpmServiceProcessor:    unexpectedProcessor{},
+		OpenAPIProcessor:       unexpectedProcessor{},
+		GoServiceProcessor:     goProcessor,
+		DockerComposeProcessor: unexpectedProcessor{},
+		DockerBuildProcessor:   unexpectedProcessor{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// push commits files to the tracked branch of the remote, as a developer.
+func (h *harness) push(message string, files map[string]string) plumbing.Hash {
+	h.t.Helper()
+	hash, err := h.client.PushCommit(context.Background(), h.url, testBranch, message, files)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return hash
+}
+
+// tick runs the background processor once, as the scheduler does with the
+// service freshly loaded.
+func (h *harness) tick() error {
+	h.t.Helper()
+	ctx := context.Background()
+	service, err := h.serviceRepo.Get(ctx, testServiceName)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return h.processor.ProcessService(ctx, service)
+}
+
+func (h *harness) deploymentStatus() *model.DeploymentStatus {
+	h.t.Helper()
+	status, err := h.serviceRepo.GetDeploymentStatus(context.Background(), testServiceName)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return status
+}
+
+// remote is a fresh clone of the remote with its tags.
+func (h *harness) remote() (repo.Repository, string) {
+	h.t.Helper()
+	path := filepath.Join(h.t.TempDir(), "remote")
+	cloned, err := h.client.Clone(context.Background(), path, &git.CloneOptions{URL: h.url, Tags: git.AllTags})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return cloned, path
+}
+
+// remoteTag returns the commit the release tag name points at on the remote.
+func (h *harness) remoteTag(name string) (plumbing.Hash, bool) {
+	h.t.Helper()
+	remote, _ := h.remote()
+	tags, err := remote.Tags(context.Background())
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	for _, tag := range tags {
+		if tag.Name == name {
+			return tag.Target, true
+		}
+	}
+	return plumbing.ZeroHash, false
+}
+
+func TestProcessServiceReleasesNewCommits(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+
+	if err := h.tick(); err != nil {
+		t.Fatal(err)
+	}
+	remote, path := h.remote()
+	head, err := remote.Head(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, ok := h.remoteTag("0.0.1")
+	if !ok || target != head.Hash() {
+		t.Fatalf("expected tag 0.0.1 on the remote's HEAD %s, got %s (found %t)", head.Hash(), target, ok)
+	}
+	commit := headCommit(t, remote)
+	if commit.Message != "ci: Release version 0.0.1" {
+		t.Fatalf("unexpected release commit message %q", commit.Message)
+	}
+	// The version file written by the Go processor is part of the release commit
+	if contents := readFile(t, filepath.Join(path, "version.txt")); contents != "0.0.1" {
+		t.Fatalf("expected version.txt to be 0.0.1 on the remote, got %q", contents)
+	}
+	if want := []string{"service:0.0.1", "service:latest"}; !slices.Equal(h.releaser.pushedTags(), want) {
+		t.Fatalf("expected %v to be pushed, got %v", want, h.releaser.pushedTags())
+	}
+	status := h.deploymentStatus()
+	if status.LastReleasedVersion != "0.0.1" || status.LastDeployedVersion != "0.0.1" || status.State != model.PipelineStateIdle {
+		t.Fatalf("unexpected deployment status %+v", status)
+	}
+
+	// Nothing new to release
+	if err := h.tick(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(h.releaser.pushedTags()); got != 2 {
+		t.Fatalf("expected no new build without new commits, got %d pushes", got)
+	}
+
+	h.push("feat: add endpoint", nil)
+	if err := h.tick(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := h.remoteTag("0.1.0"); !ok {
+		t.Fatal("expected the feature to be released as 0.1.0")
+	}
+	if status := h.deploymentStatus(); status.LastDeployedVersion != "0.1.0" {
+		t.Fatalf("expected 0.1.0 to be deployed, got %+v", status)
+	}
+}
+
+func TestProcessServiceRecordsBuildFailure(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	h.releaser.buildErr = errors.New("docker daemon unavailable")
+
+	if err := h.tick(); err == nil {
+		t.Fatal("expected the tick to fail")
+	}
+	status := h.deploymentStatus()
+	if status.State != model.PipelineStateFailed || status.LastError == "" {
+		t.Fatalf("expected a failed deployment status, got %+v", status)
+	}
+	// The release was tagged before the build failed
+	if status.LastReleasedVersion != "0.0.1" || status.LastDeployedVersion != "" {
+		t.Fatalf("unexpected deployment status %+v", status)
+	}
+}
+
+func TestProcessServiceSkipsUnprovisionedService(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	if err := h.serviceRepo.UpdateDeploymentStatus(context.Background(), testServiceName, func(status *model.DeploymentStatus) {
+		status.ProvisioningStarted(1)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.tick(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := h.remoteTag("0.0.1"); ok {
+		t.Fatal("expected a service that is still provisioning not to be released")
+	}
+}
+
+func TestProcessServiceReleasesFromCurrentGitSettings(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	ctx := context.Background()
+	stale, err := h.serviceRepo.Get(ctx, testServiceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The branch becomes protected after the scheduler loaded the service
+	strategy := model.ReleaseStrategyPullRequest
+	if _, err := h.serviceRepo.UpdateGit(ctx, testServiceName, stale.Version, model.GitSettingsUpdate{ReleaseStrategy: &strategy}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.processor.ProcessService(ctx, stale); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := h.remoteTag("0.0.1"); ok {
+		t.Fatal("expected the release not to be pushed to the branch directly")
+	}
+	if len(h.pullRequests.created) != 1 {
+		t.Fatalf("expected a release pull request, got %+v", h.pullRequests.created)
+	}
+}
+
+func TestProcessServiceSkipsServiceDeletedWhileQueued(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	ctx := context.Background()
+	service, err := h.serviceRepo.Get(ctx, testServiceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An API operation holds the service, so the tick queues behind it
+	unlock := h.serviceLocks.Lock(testServiceName)
+	done := make(chan error)
+	go func() { done <- h.processor.ProcessService(ctx, service) }()
+	for h.deploymentStatus().State != model.PipelineStateQueued {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := h.serviceRepo.Delete(ctx, testServiceName, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+
+	if err := <-done; err != nil {
+		t.Fatalf("expected the tick to be skipped, got %v", err)
+	}
+	if _, ok := h.remoteTag("0.0.1"); ok {
+		t.Fatal("expected a deleted service not to be released")
+	}
+	if len(h.releaser.pushedTags()) != 0 {
+		t.Fatal("expected a deleted service not to be built")
+	}
+}
+
+func TestReleasePullRequestRetriesFailedTagPush(t *testing.T) {
+	var failingClient *failingTagPushClient
+	h := newHarness(t, harnessOptions{
+		strategy: model.ReleaseStrategyPullRequest,
+		gitClient: func(client repo.GitClient) repo.GitClient {
+			failingClient = &failingTagPushClient{GitClient: client}
+			return failingClient
+		},
+	})
+
+	// The first tick opens the release pull request
+	if err := h.tick(); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.pullRequests.created) != 1 || h.pullRequests.created[0].Head != "release/0.0.1" {
+		t.Fatalf("expected a pull request from release/0.0.1, got %+v", h.pullRequests.created)
+	}
+	mergeCommit := h.mergeBranch("release/0.0.1")
+	h.pullRequests.merge(mergeCommit)
+
+	// The tag is created, but pushing it fails
+	failingClient.failures.Store(1)
+	if err := h.tick(); err == nil {
+		t.Fatal("expected the tick to fail pushing the tag")
+	}
+	if _, ok := h.remoteTag("0.0.1"); ok {
+		t.Fatal("expected the tag not to reach the remote")
+	}
+	service, err := h.serviceRepo.Get(context.Background(), testServiceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.ReleasePullRequest == nil {
+		t.Fatal("expected the release pull request to stay pending after the failed push")
+	}
+	if len(h.releaser.pushedTags()) != 0 {
+		t.Fatal("expected nothing to be built before the tag is pushed")
+	}
+
+	// The retry pushes the tag that already exists locally
+	if err := h.tick(); err != nil {
+		t.Fatal(err)
+	}
+	target, ok := h.remoteTag("0.0.1")
+	if !ok || target != mergeCommit {
+		t.Fatalf("expected tag 0.0.1 on the merge commit %s, got %s (found %t)", mergeCommit, target, ok)
+	}
+	service, err = h.serviceRepo.Get(context.Background(), testServiceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.ReleasePullRequest != nil {
+		t.Fatalf("expected the release pull request to be cleared, got %+v", service.ReleasePullRequest)
+	}
+	if want := []string{"service:0.0.1", "service:latest"}; !slices.Equal(h.releaser.pushedTags(), want) {
+		t.Fatalf("expected %v to be pushed, got %v", want, h.releaser.pushedTags())
+	}
+}
+
+// mergeBranch fast-forwards the tracked branch of the remote to branch, as
+// merging its pull request would, and returns the merged commit.
+func (h *harness) mergeBranch(branch string) plumbing.Hash {
+	h.t.Helper()
+	ctx := context.Background()
+	cloned, err := h.client.Clone(ctx, filepath.Join(h.t.TempDir(), "merge"), &git.CloneOptions{URL: h.url})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	source := plumbing.NewRemoteReferenceName(git.DefaultRemoteName, branch)
+	if err := cloned.Push(ctx, &git.PushOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:%s", source, plumbing.NewBranchReferenceName(testBranch)))},
+	}); err != nil {
+		h.t.Fatal(err)
+	}
+	head, _ := h.remote()
+	return headCommit(h.t, head).Hash
+}
+
+// failingTagPushClient fails the next failures pushes of tags.
+type failingTagPushClient struct {
+	repo.GitClient
+	failures atomic.Int32
+}
+
+func (c *failingTagPushClient) Open(ctx context.Context, path string) (repo.Repository, error) {
+	r, err := c.GitClient.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &failingTagPushRepository{Repository: r, client: c}, nil
+}
+
+type failingTagPushRepository struct {
+	repo.Repository
+	client *failingTagPushClient
+}
+
+func (r *failingTagPushRepository) Push(ctx context.Context, opts *git.PushOptions) error {
+	for _, refSpec := range opts.RefSpecs {
+		if strings.Contains(string(refSpec), "refs/tags/") && r.client.failures.Add(-1) >= 0 {
+			return errors.New("connection reset by peer")
+		}
+	}
+	return r.Repository.Push(ctx, opts)
+}
+
+func headCommit(t *testing.T, r repo.Repository) *object.Commit {
+	t.Helper()
+	ctx := context.Background()
+	head, err := r.Head(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iter, err := r.Log(ctx, head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+	commit, err := iter.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commit
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(contents)
+}
+
+type noCredentials struct{}
+
+func (noCredentials) AuthMethod(credentialName, gitURL string) (transport.AuthMethod, error) {
+	return nil, nil
+}
+
+// fakeReleaser records the images it is asked to push instead of talking to
+// Docker.
+type fakeReleaser struct {
+	mu       sync.Mutex
+	pushed   []string
+	buildErr error
+}
+
+func (r *fakeReleaser) BuildImage(ctx context.Context, repositoryPath, dockerfilePath string, tags []string) error {
+	return r.buildErr
+}
+
+func (r *fakeReleaser) BuildImageWithSecrets(ctx context.Context, repositoryPath, dockerfilePath string, tags []string, secrets map[string][]byte) error {
+	return r.buildErr
+}
+
+func (r *fakeReleaser) PushImage(ctx context.Context, serviceName string, tag string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pushed = append(r.pushed, tag)
+	return nil
+}
+
+func (r *fakeReleaser) RemoveImage(ctx context.Context, tag string) error {
+	return nil
+}
+
+func (r *fakeReleaser) ListImages(ctx context.Context, serviceName string) ([]string, error) {
+	return nil, nil
+}
+
+func (r *fakeReleaser) CreateArtifactTag(serviceName string, version *semver.Version) string {
+	return serviceName + ":" + version.String()
+}
+
+func (r *fakeReleaser) CreateLatestArtifactTag(serviceName string) string {
+	return serviceName + ":latest"
+}
+
+func (r *fakeReleaser) pushedTags() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.pushed)
+}
+
+// fakePullRequests is a forge holding at most one pull request.
+type fakePullRequests struct {
+	mu      sync.Mutex
+	created []pullrequest.CreateOptions
+	current *pullrequest.PullRequest
+}
+
+func (f *fakePullRequests) Resolve(gitURL string) (pullrequest.Provider, pullrequest.Repository, error) {
+	return f, pullrequest.Repository{Owner: "owner", Name: testServiceName}, nil
+}
+
+func (f *fakePullRequests) CreatePullRequest(ctx context.Context, r pullrequest.Repository, opts pullrequest.CreateOptions) (*pullrequest.PullRequest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created = append(f.created, opts)
+	f.current = &pullrequest.PullRequest{Number: len(f.created), URL: "https://forge.example.com/pulls/1", State: pullrequest.StateOpen}
+	return f.current, nil
+}
+
+func (f *fakePullRequests) GetPullRequest(ctx context.Context, r pullrequest.Repository, number int) (*pullrequest.PullRequest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.current == nil || f.current.Number != number {
+		return nil, errors.New("pull request not found")
+	}
+	pr := *f.current
+	return &pr, nil
+}
+
+// merge marks the open pull request as merged as commit.
+func (f *fakePullRequests) merge(commit plumbing.Hash) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.current.State = pullrequest.StateMerged
+	f.current.MergeCommitSHA = commit.String()
+}
+
+// unexpectedProcessor stands in for the processors of service types the
+// tests don't use.
+type unexpectedProcessor struct{}
+
+var errUnexpectedProcessor = errors.New("unexpected processor call")
+
+func (unexpectedProcessor) SetPackageJsonVersion(service *model.Service, version *semver.Version) error {
+	return errUnexpectedProcessor
+}
+
+func (unexpectedProcessor) BuildNpmService(ctx context.Context, service *model.Service, nextVersion *semver.Version) error {
+	return errUnexpectedProcessor
+}
+
+func (unexpectedProcessor) SetOpenApiYamlVersion(service *model.Service, version *semver.Version) error {
+	return errUnexpectedProcessor
+}
+
+func (unexpectedProcessor) BuildAndDeployOpenAPIClient(ctx context.Context, service *model.Service, nextVersion *semver.Version) error {
+	return errUnexpectedProcessor
+}
+
+func (unexpectedProcessor) DeployDockerComposeApplication(ctx context.Context, service *model.Service, nextVersion *semver.Version) error {
+	return errUnexpectedProcessor
+}
+
+func (unexpectedProcessor) RefreshDockerComposeApplication(ctx context.Context, service *model.Service) error {
+	return errUnexpectedProcessor
+}
+
+func (unexpectedProcessor) BuildAndPushDockerImage(ctx context.Context, service *model.Service, nextVersion *semver.Version) error {
+	return errUnexpectedProcessor
+}
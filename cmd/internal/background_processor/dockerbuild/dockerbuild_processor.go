@@ -5,8 +5,10 @@ import (
 	"fmt"
 
 	"github.com/Masterminds/semver/v3"
+	"github.com/ansonallard/deployment-service/cmd/internal/commitstatus"
 	"github.com/ansonallard/deployment-service/cmd/internal/model"
 	"github.com/ansonallard/deployment-service/cmd/internal/releaser"
+	"github.com/ansonallard/deployment-service/cmd/internal/securepath"
 	"github.com/rs/zerolog"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -56,6 +58,9 @@ func (dbp *dockerBuildProcessor) BuildAndPushDockerImage(
 	if dockerfilePath == "" {
 		dockerfilePath = "Dockerfile"
 	}
+	if _, err := securepath.Join(service.GitRepoFilePath, dockerfilePath); err != nil {
+		return err
+	}
 
 	tags := []string{
 		dbp.dockerReleaser.CreateArtifactTag(service.Name.Name, nextVersion),
@@ -71,6 +76,7 @@ func (dbp *dockerBuildProcessor) BuildAndPushDockerImage(
 		return err
 	}
 
+	commitstatus.Enter(ctx, commitstatus.StagePublish)
 	for _, tag := range tags {
 		if err := dbp.dockerReleaser.PushImage(ctx, service.Name.Name, tag); err != nil {
 			return err
@@ -5,13 +5,14 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
-	"path"
 	"path/filepath"
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/ansonallard/deployment-service/cmd/internal/background_processor/utils"
+	"github.com/ansonallard/deployment-service/cmd/internal/commitstatus"
 	"github.com/ansonallard/deployment-service/cmd/internal/model"
 	"github.com/ansonallard/deployment-service/cmd/internal/releaser"
+	"github.com/ansonallard/deployment-service/cmd/internal/securepath"
 	goservicetemplate "github.com/ansonallard/deployment-service/cmd/internal/templates/go_service"
 	"github.com/rs/zerolog"
 	"go.opentelemetry.io/otel"
@@ -93,6 +94,14 @@ func (gsp *goServiceProcessor) SetVersionFile(service *model.Service, version *s
 	if versionFilePath == "" {
 		return fmt.Errorf("version file %q not found in %q", versionFileName, service.GitRepoFilePath)
 	}
+	// The version file may be a symlink committed to the repo
+	relativePath, err := filepath.Rel(service.GitRepoFilePath, versionFilePath)
+	if err != nil {
+		return err
+	}
+	if versionFilePath, err = securepath.Join(service.GitRepoFilePath, relativePath); err != nil {
+		return err
+	}
 
 	if err := os.WriteFile(versionFilePath, []byte(version.String()), 0644); err != nil {
 		return fmt.Errorf("failed to write version file: %w", err)
@@ -107,11 +116,17 @@ type dockerfileTemplateData struct {
 }
 
 func (gsp *goServiceProcessor) writeDockerfile(service *model.Service) error {
-	dockerfilePath := path.Join(service.GitRepoFilePath, dockerfileName)
+	dockerfilePath, err := securepath.Join(service.GitRepoFilePath, dockerfileName)
+	if err != nil {
+		return err
+	}
 	serviceBindaryDir := "."
 	if service.Configuration.Go.Service.BinaryDirectory != "" {
 		serviceBindaryDir = service.Configuration.Go.Service.BinaryDirectory
 	}
+	if _, err := securepath.Join(service.GitRepoFilePath, serviceBindaryDir); err != nil {
+		return err
+	}
 
 	finalBaseImage := defaultBaseImage
 	includeDockerCompose := false
@@ -166,6 +181,7 @@ func (gsp *goServiceProcessor) BuildGoService(
 		return err
 	}
 
+	commitstatus.Enter(ctx, commitstatus.StagePublish)
 	for _, tag := range tags {
 		if err := gsp.dockerReleaser.PushImage(ctx, service.Name.Name, tag); err != nil {
 			return err
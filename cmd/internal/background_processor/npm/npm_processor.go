@@ -4,12 +4,13 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"path"
 
 	"github.com/Masterminds/semver/v3"
+	"github.com/ansonallard/deployment-service/cmd/internal/commitstatus"
 	"github.com/ansonallard/deployment-service/cmd/internal/compose"
 	"github.com/ansonallard/deployment-service/cmd/internal/model"
 	"github.com/ansonallard/deployment-service/cmd/internal/releaser"
+	"github.com/ansonallard/deployment-service/cmd/internal/securepath"
 	"github.com/ansonallard/deployment-service/cmd/internal/service"
 	npmservice "github.com/ansonallard/deployment-service/cmd/internal/templates/npm_service"
 	"github.com/rs/zerolog/log"
@@ -83,7 +84,10 @@ func (nsp *npmServiceProcessor) SetPackageJsonVersion(service *model.Service, ve
 		return err
 	}
 
-	packageJsonFilePath := nsp.getPackageJsonPath(service.GitRepoFilePath)
+	packageJsonFilePath, err := nsp.getPackageJsonPath(service.GitRepoFilePath)
+	if err != nil {
+		return err
+	}
 
 	fileBytes, err := os.ReadFile(packageJsonFilePath)
 	if err != nil {
@@ -101,8 +105,8 @@ func (nsp *npmServiceProcessor) SetPackageJsonVersion(service *model.Service, ve
 	return nil
 }
 
-func (nsp *npmServiceProcessor) getPackageJsonPath(gitRepoFilePath string) string {
-	return path.Join(gitRepoFilePath, packageJSONFilePath)
+func (nsp *npmServiceProcessor) getPackageJsonPath(gitRepoFilePath string) (string, error) {
+	return securepath.Join(gitRepoFilePath, packageJSONFilePath)
 }
 
 func (nsp *npmServiceProcessor) BuildNpmService(
@@ -142,6 +146,7 @@ func (nsp *npmServiceProcessor) BuildNpmService(
 		return err
 	}
 
+	commitstatus.Enter(ctx, commitstatus.StagePublish)
 	for _, tag := range tags {
 		if err := nsp.dockerReleaser.PushImage(ctx, service.Name.Name, tag); err != nil {
 			return err
@@ -151,7 +156,10 @@ func (nsp *npmServiceProcessor) BuildNpmService(
 }
 
 func (nsp *npmServiceProcessor) writeDockerfile(service *model.Service) error {
-	dockerfilePath := path.Join(service.GitRepoFilePath, dockerfileName)
+	dockerfilePath, err := securepath.Join(service.GitRepoFilePath, dockerfileName)
+	if err != nil {
+		return err
+	}
 
 	var dockerfileContents string
 	switch service.Configuration.Npm.Service.ServiceType {
@@ -167,7 +175,10 @@ func (nsp *npmServiceProcessor) writeDockerfile(service *model.Service) error {
 }
 
 func (nsp *npmServiceProcessor) writeFrontendNginxConfig(service *model.Service) error {
-	filePath := path.Join(service.GitRepoFilePath, nginxConf)
+	filePath, err := securepath.Join(service.GitRepoFilePath, nginxConf)
+	if err != nil {
+		return err
+	}
 	if err := os.WriteFile(filePath, []byte(npmservice.FrontendNginxConfig), 0644); err != nil {
 		return fmt.Errorf("failed to write file: %w", err)
 	}
@@ -188,12 +199,18 @@ func (nsp *npmServiceProcessor) writeArtifacts(service *model.Service) error {
 }
 
 func (nsp *npmServiceProcessor) removeArtifacts(service *model.Service) error {
-	dockerfilePath := path.Join(service.GitRepoFilePath, dockerfileName)
+	dockerfilePath, err := securepath.Join(service.GitRepoFilePath, dockerfileName)
+	if err != nil {
+		return err
+	}
 	if err := os.Remove(dockerfilePath); err != nil {
 		return err
 	}
 	if service.Configuration.Npm.Service.ServiceType == model.NpmServiceTypeFrontend {
-		filePath := path.Join(service.GitRepoFilePath, nginxConf)
+		filePath, err := securepath.Join(service.GitRepoFilePath, nginxConf)
+		if err != nil {
+			return err
+		}
 		if err := os.Remove(filePath); err != nil {
 			return err
 		}
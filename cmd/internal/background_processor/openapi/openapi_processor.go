@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -15,6 +14,8 @@ import (
 	"github.com/ansonallard/deployment-service/cmd/internal/github"
 	"github.com/ansonallard/deployment-service/cmd/internal/model"
 	"github.com/ansonallard/deployment-service/cmd/internal/releaser"
+	"github.com/ansonallard/deployment-service/cmd/internal/securepath"
+	"github.com/ansonallard/deployment-service/cmd/internal/signing"
 	goclient "github.com/ansonallard/deployment-service/cmd/internal/templates/go_client"
 	typescriptclient "github.com/ansonallard/deployment-service/cmd/internal/templates/typescript_client"
 	yaml "github.com/oasdiff/yaml3"
@@ -55,6 +56,7 @@ type goClientTemplateData struct {
 	GithubOwner     string
 	CiCommitEmail   string
 	CiCommitName    string
+	SigningFormat   signing.Format
 }
 
 type TypescriptClientConfig struct {
@@ -74,6 +76,7 @@ type OpenAPIProcessorConfig struct {
 	RegistryUrl            string
 	GithubClient           github.GitHubClient
 	CiCommitAuthor         *utils.CiCommitAuthor
+	Signer                 signing.Signer // Optional, GitHub client commits and tags are unsigned when nil
 }
 
 func NewOpenAPIProcessor(config OpenAPIProcessorConfig) (OpenAPIProcessor, error) {
@@ -111,6 +114,7 @@ func NewOpenAPIProcessor(config OpenAPIProcessorConfig) (OpenAPIProcessor, error
 		registryUrl:            config.RegistryUrl,
 		githubClient:           config.GithubClient,
 		ciCommitAuthor:         config.CiCommitAuthor,
+		signer:                 config.Signer,
 	}, nil
 }
 
@@ -121,6 +125,7 @@ type openAPIProcessor struct {
 	registryUrl            string
 	githubClient           github.GitHubClient
 	ciCommitAuthor         *utils.CiCommitAuthor
+	signer                 signing.Signer
 }
 
 func (op *openAPIProcessor) SetOpenApiYamlVersion(service *model.Service, version *semver.Version) error {
@@ -136,7 +141,10 @@ func (op *openAPIProcessor) SetOpenApiYamlVersion(service *model.Service, versio
 		return err
 	}
 
-	openApiYamlFilePath := path.Join(service.GitRepoFilePath, service.Configuration.OpenAPI.OpenAPI.YamlFile)
+	openApiYamlFilePath, err := securepath.Join(service.GitRepoFilePath, service.Configuration.OpenAPI.OpenAPI.YamlFile)
+	if err != nil {
+		return err
+	}
 	fileBytes, err := os.ReadFile(openApiYamlFilePath)
 	if err != nil {
 		return err
@@ -404,12 +412,21 @@ func (op *openAPIProcessor) buildAndDeployGoClientToGithub(
 		return fmt.Errorf("failed to copy OpenAPI spec: %w", err)
 	}
 
+	secrets := map[string][]byte{
+		releaser.GithubPATKey: []byte(op.githubClient.GetToken()),
+	}
+	if op.signer != nil {
+		signingKey, err := op.signer.ExportPrivateKey()
+		if err != nil {
+			return fmt.Errorf("failed to export signing key: %w", err)
+		}
+		secrets[releaser.GitSigningKey] = signingKey
+	}
+
 	if err := op.dockerReleaser.BuildImageWithSecrets(
 		ctx, buildDir, "Dockerfile",
 		[]string{op.generateOpenAPIDockerFullyQualifiedImageName(imageName, nextVersion)},
-		map[string][]byte{
-			releaser.GithubPATKey: []byte(op.githubClient.GetToken()),
-		},
+		secrets,
 	); err != nil {
 		return fmt.Errorf("failed to build Go client: %w", err)
 	}
@@ -601,10 +618,13 @@ func (op *openAPIProcessor) generateGoClientVersion(version *semver.Version) str
 }
 
 func (op *openAPIProcessor) copyOpenAPISpec(service *model.Service, buildDir string) error {
-	sourcePath := filepath.Join(
+	sourcePath, err := securepath.Join(
 		service.GitRepoFilePath,
 		service.Configuration.OpenAPI.OpenAPI.YamlFile,
 	)
+	if err != nil {
+		return err
+	}
 
 	destPath := filepath.Join(
 		buildDir,
@@ -696,6 +716,9 @@ func (op *openAPIProcessor) generateGoClientConfigFilesForGithub(
 		CiCommitName:    op.ciCommitAuthor.Name,
 		CiCommitEmail:   op.ciCommitAuthor.Email,
 	}
+	if op.signer != nil {
+		templateData.SigningFormat = op.signer.Format()
+	}
 
 	if err := utils.GenerateFileFromTemplate(
 		filepath.Join(buildDir, "config.yaml"), goclient.OapiConfigTemplate, templateData,
@@ -0,0 +1,190 @@
+package commitstatus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/pullrequest"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	contextPrefix = "deployment-service"
+	// GitHub rejects descriptions longer than this
+	maxDescriptionLength = 140
+)
+
+// Stage is one step of a release, reported as its own status context.
+type Stage string
+
+const (
+	StageVersion Stage = "version"
+	StageBuild   Stage = "build"
+	StagePublish Stage = "publish"
+	StageDeploy  Stage = "deploy"
+)
+
+type State string
+
+const (
+	StatePending State = "pending"
+	StateSuccess State = "success"
+	StateFailure State = "failure"
+)
+
+type Status struct {
+	State       State
+	Context     string
+	Description string
+	TargetURL   string
+}
+
+// Poster posts a status against a commit. Implemented by the forge clients.
+type Poster interface {
+	CreateCommitStatus(ctx context.Context, repo pullrequest.Repository, sha string, status Status) error
+}
+
+// Reporter starts status reporting for a single release of a service.
+type Reporter interface {
+	// Begin reports stage as pending against sha and returns a ctx carrying
+	// the Tracker, so processors further down can call Enter. The Tracker is
+	// nil when no forge is configured for the service's remote.
+	Begin(ctx context.Context, service *model.Service, sha string, stage Stage) (context.Context, *Tracker)
+}
+
+type ReporterConfig struct {
+	GitHub Poster // Optional
+	Gitea  Poster // Optional
+	// GiteaHost is the host remotes on the Gitea instance are cloned from.
+	GiteaHost string
+	// TargetURLTemplate links each status to the run. `{service}` and
+	// `{traceId}` are substituted. Optional.
+	TargetURLTemplate string
+}
+
+func NewReporter(config ReporterConfig) Reporter {
+	return &reporter{
+		github:            config.GitHub,
+		gitea:             config.Gitea,
+		giteaHost:         config.GiteaHost,
+		targetURLTemplate: config.TargetURLTemplate,
+	}
+}
+
+type reporter struct {
+	github            Poster
+	gitea             Poster
+	giteaHost         string
+	targetURLTemplate string
+}
+
+func (r *reporter) Begin(ctx context.Context, service *model.Service, sha string, stage Stage) (context.Context, *Tracker) {
+	log := zerolog.Ctx(ctx)
+
+	host, repo, err := pullrequest.ParseRemote(service.GitSSHUrl)
+	if err != nil {
+		log.Debug().Err(err).Str("service", service.Name.Name).Msg("Not reporting commit statuses")
+		return ctx, nil
+	}
+	var poster Poster
+	switch {
+	case host == "github.com" && r.github != nil:
+		poster = r.github
+	case host == r.giteaHost && r.gitea != nil:
+		poster = r.gitea
+	default:
+		log.Debug().Str("service", service.Name.Name).Str("host", host).Msg("No forge configured, not reporting commit statuses")
+		return ctx, nil
+	}
+
+	tracker := &Tracker{
+		poster:    poster,
+		repo:      repo,
+		sha:       sha,
+		targetURL: r.targetURL(ctx, service),
+	}
+	tracker.Enter(ctx, stage)
+	return context.WithValue(ctx, trackerKey{}, tracker), tracker
+}
+
+func (r *reporter) targetURL(ctx context.Context, service *model.Service) string {
+	if r.targetURLTemplate == "" {
+		return ""
+	}
+	return strings.NewReplacer(
+		"{service}", service.Name.Name,
+		"{traceId}", trace.SpanContextFromContext(ctx).TraceID().String(),
+	).Replace(r.targetURLTemplate)
+}
+
+type trackerKey struct{}
+
+// Tracker reports the stages of one release against one commit. A nil
+// Tracker is valid and reports nothing.
+type Tracker struct {
+	poster    Poster
+	repo      pullrequest.Repository
+	sha       string
+	targetURL string
+	current   Stage
+}
+
+// Enter marks the current stage as succeeded and stage as pending.
+func (t *Tracker) Enter(ctx context.Context, stage Stage) {
+	if t == nil || t.current == stage {
+		return
+	}
+	if t.current != "" {
+		t.post(ctx, t.current, StateSuccess, fmt.Sprintf("%s succeeded", t.current))
+	}
+	t.current = stage
+	t.post(ctx, stage, StatePending, fmt.Sprintf("%s in progress", stage))
+}
+
+// Finish marks the current stage as succeeded, or failed when err is set.
+// Statuses are public, so only the stage is named; err itself is kept in the
+// run log.
+func (t *Tracker) Finish(ctx context.Context, err error) {
+	if t == nil || t.current == "" {
+		return
+	}
+	if err != nil {
+		t.post(ctx, t.current, StateFailure, fmt.Sprintf("%s failed", t.current))
+	} else {
+		t.post(ctx, t.current, StateSuccess, fmt.Sprintf("%s succeeded", t.current))
+	}
+	t.current = ""
+}
+
+// Posting is best effort; a forge outage must never fail a release.
+func (t *Tracker) post(ctx context.Context, stage Stage, state State, description string) {
+	description = truncate(description, maxDescriptionLength)
+	err := t.poster.CreateCommitStatus(ctx, t.repo, t.sha, Status{
+		State:       state,
+		Context:     fmt.Sprintf("%s/%s", contextPrefix, stage),
+		Description: description,
+		TargetURL:   t.targetURL,
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("sha", t.sha).Str("stage", string(stage)).Msg("Failed to post commit status")
+	}
+}
+
+// truncate shortens s to at most max runes, marking the cut with an ellipsis.
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+// Enter moves the release tracked in ctx on to stage. It is a no-op when ctx
+// carries no Tracker.
+func Enter(ctx context.Context, stage Stage) {
+	tracker, _ := ctx.Value(trackerKey{}).(*Tracker)
+	tracker.Enter(ctx, stage)
+}
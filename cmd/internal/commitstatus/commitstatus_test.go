@@ -0,0 +1,80 @@
+package commitstatus
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/pullrequest"
+)
+
+type recordingPoster struct {
+	statuses []Status
+}
+
+func (p *recordingPoster) CreateCommitStatus(ctx context.Context, repo pullrequest.Repository, sha string, status Status) error {
+	p.statuses = append(p.statuses, status)
+	return nil
+}
+
+func begin(t *testing.T, poster Poster) (context.Context, *Tracker) {
+	t.Helper()
+	reporter := NewReporter(ReporterConfig{GitHub: poster})
+	service := &model.Service{Name: model.Name{Name: "service"}, GitSSHUrl: "git@github.com:owner/service.git"}
+	ctx, tracker := reporter.Begin(context.Background(), service, "abc123", StageVersion)
+	if tracker == nil {
+		t.Fatal("expected a tracker for a GitHub remote")
+	}
+	return ctx, tracker
+}
+
+func TestTrackerReportsStages(t *testing.T) {
+	poster := &recordingPoster{}
+	ctx, tracker := begin(t, poster)
+	Enter(ctx, StageBuild)
+	tracker.Finish(ctx, nil)
+
+	want := []Status{
+		{State: StatePending, Context: "deployment-service/version", Description: "version in progress"},
+		{State: StateSuccess, Context: "deployment-service/version", Description: "version succeeded"},
+		{State: StatePending, Context: "deployment-service/build", Description: "build in progress"},
+		{State: StateSuccess, Context: "deployment-service/build", Description: "build succeeded"},
+	}
+	if len(poster.statuses) != len(want) {
+		t.Fatalf("expected %d statuses, got %+v", len(want), poster.statuses)
+	}
+	for i := range want {
+		if poster.statuses[i] != want[i] {
+			t.Errorf("status %d: expected %+v, got %+v", i, want[i], poster.statuses[i])
+		}
+	}
+}
+
+func TestTrackerFailureHidesError(t *testing.T) {
+	poster := &recordingPoster{}
+	ctx, tracker := begin(t, poster)
+	Enter(ctx, StageBuild)
+	tracker.Finish(ctx, errors.New("go build: /home/ci/.netrc: token ghp_secret rejected"))
+
+	last := poster.statuses[len(poster.statuses)-1]
+	if last.State != StateFailure || last.Description != "build failed" {
+		t.Fatalf("expected a failed build status, got %+v", last)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("short", maxDescriptionLength); got != "short" {
+		t.Fatalf("expected a short description to be kept, got %q", got)
+	}
+	long := strings.Repeat("é", maxDescriptionLength+10)
+	got := truncate(long, maxDescriptionLength)
+	if n := utf8.RuneCountInString(got); n != maxDescriptionLength {
+		t.Fatalf("expected %d characters, got %d", maxDescriptionLength, n)
+	}
+	if !utf8.ValidString(got) || !strings.HasSuffix(got, "…") {
+		t.Fatalf("expected a valid string ending in an ellipsis, got %q", got)
+	}
+}
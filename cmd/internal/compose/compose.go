@@ -3,12 +3,15 @@ package compose
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
 
 	"github.com/Masterminds/semver/v3"
+	"github.com/ansonallard/deployment-service/cmd/internal/events"
 	logwriter "github.com/ansonallard/deployment-service/cmd/internal/log_writer"
+	"github.com/ansonallard/deployment-service/cmd/internal/runlog"
 	"github.com/rs/zerolog"
 )
 
@@ -25,6 +28,7 @@ const (
 type ComposeRunner interface {
 	Up(ctx context.Context, composeDir string, version *semver.Version) error
 	Down(ctx context.Context, composeDir string) error
+	DownRemovingImages(ctx context.Context, composeDir string) error
 	Pull(ctx context.Context, composeDir string) error
 }
 
@@ -64,6 +68,12 @@ func (r *runner) Down(ctx context.Context, composeDir string) error {
 	return r.runComposeCommand(ctx, nil, composeDir, "down")
 }
 
+// DownRemovingImages runs `down --rmi all`, also removing every image the
+// stack uses. Volumes are kept.
+func (r *runner) DownRemovingImages(ctx context.Context, composeDir string) error {
+	return r.runComposeCommand(ctx, nil, composeDir, "down", "--rmi", "all")
+}
+
 // Pull runs `docker-compose pull` or `docker compose pull`.
 func (r *runner) Pull(ctx context.Context, composeDir string) error {
 	return r.runComposeCommand(ctx, nil, composeDir, "pull")
@@ -106,9 +116,16 @@ func (r *runner) runComposeCommand(ctx context.Context, version *semver.Version,
 		Str("PATH", os.Getenv("PATH")).
 		Msg("Docker environment variables")
 
-	// Pipe stdout and stderr to zerolog
-	cmd.Stdout = logwriter.NewZerologWriter(*log, zerolog.DebugLevel)
-	cmd.Stderr = logwriter.NewZerologWriter(*log, zerolog.DebugLevel)
+	// Pipe stdout and stderr to zerolog, event subscribers and the run log
+	deployLog := events.NewLineWriter(ctx, events.TypeDeployLog)
+	defer deployLog.Close()
+	output := io.MultiWriter(
+		logwriter.NewZerologWriter(*log, zerolog.DebugLevel),
+		deployLog,
+		runlog.Writer(ctx),
+	)
+	cmd.Stdout = output
+	cmd.Stderr = output
 
 	log.Debug().Str("command path", cmd.Path).Interface("args", cmd.Args).Msg(fmt.Sprintf("Running command: %s, %+v", cmd.Path, cmd.Args))
 	log.Debug().Str("Working directory:", cmd.Dir).Msg("Working dir")
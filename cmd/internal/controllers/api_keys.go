@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/apikeys"
+	"github.com/ansonallard/deployment-service/cmd/internal/identity"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scope     string     `json:"scope"`
+	Services  []string   `json:"services"`
+	Hint      string     `json:"hint"`
+	CreatedAt time.Time  `json:"createdAt"`
+	CreatedBy string     `json:"createdBy"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+	Active    bool       `json:"active"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name  string `json:"name"`
+	Scope string `json:"scope"`
+	// Services restricts the key to these services
+	Services  []string   `json:"services,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// CreateAPIKeyResponse carries the key itself, which is only ever returned
+// here.
+type CreateAPIKeyResponse struct {
+	APIKey APIKey `json:"apiKey"`
+	Key    string `json:"key"`
+}
+
+type ListAPIKeysResponse struct {
+	APIKeys []APIKey `json:"apiKeys"`
+}
+
+type APIKeysController interface {
+	// (GET /api-keys)
+	ListAPIKeys(c *gin.Context)
+
+	// (POST /api-keys)
+	CreateAPIKey(c *gin.Context)
+
+	// (POST /api-keys/{id}/rotate)
+	RotateAPIKey(c *gin.Context)
+
+	// (DELETE /api-keys/{id})
+	RevokeAPIKey(c *gin.Context)
+}
+
+type APIKeysControllerConfig struct {
+	Keys apikeys.Store
+}
+
+type apiKeysController struct {
+	keys apikeys.Store
+}
+
+func NewAPIKeysController(config APIKeysControllerConfig) (APIKeysController, error) {
+	if config.Keys == nil {
+		return nil, fmt.Errorf("keys not set")
+	}
+	return &apiKeysController{
+		keys: config.Keys,
+	}, nil
+}
+
+// RegisterAPIKeysRoutes registers the API key management routes, which
+// aren't part of the generated OpenAPI spec.
+func RegisterAPIKeysRoutes(router gin.IRouter, controller APIKeysController) {
+	router.GET("/api-keys", controller.ListAPIKeys)
+	router.POST("/api-keys", controller.CreateAPIKey)
+	router.POST("/api-keys/:id/rotate", controller.RotateAPIKey)
+	router.DELETE("/api-keys/:id", controller.RevokeAPIKey)
+}
+
+func (ac *apiKeysController) ListAPIKeys(c *gin.Context) {
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.api_keys.list")
+	defer span.End()
+
+	keys, err := ac.keys.List(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	response := ListAPIKeysResponse{APIKeys: make([]APIKey, 0, len(keys))}
+	for i := range keys {
+		response.APIKeys = append(response.APIKeys, toAPIKeyExternal(&keys[i]))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (ac *apiKeysController) CreateAPIKey(c *gin.Context) {
+	var request CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		_ = c.Error(apierr.New(apierr.CodeInvalidArgument, err.Error()))
+		return
+	}
+
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.api_keys.create",
+		trace.WithAttributes(attribute.String("api_key.name", request.Name)),
+	)
+	defer span.End()
+
+	scope, err := apikeys.ScopeFromExternal(request.Scope)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	key, secret, err := ac.keys.Create(ctx, apikeys.CreateOptions{
+		Name:      request.Name,
+		Scope:     scope,
+		Services:  request.Services,
+		ExpiresAt: request.ExpiresAt,
+		CreatedBy: identity.CallerFromContext(ctx),
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, CreateAPIKeyResponse{APIKey: toAPIKeyExternal(key), Key: secret})
+}
+
+func (ac *apiKeysController) RotateAPIKey(c *gin.Context) {
+	id := c.Param("id")
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.api_keys.rotate",
+		trace.WithAttributes(attribute.String("api_key.id", id)),
+	)
+	defer span.End()
+
+	key, secret, err := ac.keys.Rotate(ctx, id, identity.CallerFromContext(ctx))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, CreateAPIKeyResponse{APIKey: toAPIKeyExternal(key), Key: secret})
+}
+
+func (ac *apiKeysController) RevokeAPIKey(c *gin.Context) {
+	id := c.Param("id")
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.api_keys.revoke",
+		trace.WithAttributes(attribute.String("api_key.id", id)),
+	)
+	defer span.End()
+
+	if _, err := ac.keys.Revoke(ctx, id); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func toAPIKeyExternal(key *apikeys.Key) APIKey {
+	services := key.Services
+	if services == nil {
+		services = []string{}
+	}
+	return APIKey{
+		ID:        key.ID,
+		Name:      key.Name,
+		Scope:     string(key.Scope),
+		Services:  services,
+		Hint:      key.Hint,
+		CreatedAt: key.CreatedAt,
+		CreatedBy: key.CreatedBy,
+		ExpiresAt: key.ExpiresAt,
+		RevokedAt: key.RevokedAt,
+		Active:    key.Active(time.Now()),
+	}
+}
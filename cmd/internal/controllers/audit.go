@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/audit"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultAuditMaxResults = 100
+
+type ListAuditEntriesResponse struct {
+	Entries []audit.Entry `json:"entries"`
+}
+
+type AuditController interface {
+	// (GET /audit)
+	ListAuditEntries(c *gin.Context)
+}
+
+type AuditControllerConfig struct {
+	Audit audit.Log
+}
+
+type auditController struct {
+	audit audit.Log
+}
+
+func NewAuditController(config AuditControllerConfig) (AuditController, error) {
+	if config.Audit == nil {
+		return nil, fmt.Errorf("audit not set")
+	}
+	return &auditController{
+		audit: config.Audit,
+	}, nil
+}
+
+// RegisterAuditRoutes registers the audit log route, which isn't part of the
+// generated OpenAPI spec.
+func RegisterAuditRoutes(router gin.IRouter, controller AuditController) {
+	router.GET("/audit", controller.ListAuditEntries)
+}
+
+func (ac *auditController) ListAuditEntries(c *gin.Context) {
+	filter := audit.Filter{
+		Service:    c.Query("service"),
+		MaxResults: defaultAuditMaxResults,
+	}
+	var err error
+	if filter.Since, err = timeQuery(c, "since"); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if filter.Until, err = timeQuery(c, "until"); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if value := c.Query("maxResults"); value != "" {
+		maxResults, err := strconv.Atoi(value)
+		if err != nil || maxResults < 1 {
+			_ = c.Error(apierr.InvalidField("maxResults", "maxResults must be at least 1"))
+			return
+		}
+		filter.MaxResults = maxResults
+	}
+
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.list_audit_entries",
+		trace.WithAttributes(attribute.String("service.name", filter.Service)),
+	)
+	defer span.End()
+
+	entries, err := ac.audit.Query(ctx, filter)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, ListAuditEntriesResponse{Entries: entries})
+}
+
+// timeQuery parses the optional RFC 3339 query parameter param.
+func timeQuery(c *gin.Context, param string) (*time.Time, error) {
+	value := c.Query(param)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, apierr.InvalidField(param, fmt.Sprintf("invalid %s %q, expected an RFC 3339 time", param, value))
+	}
+	return &parsed, nil
+}
@@ -2,8 +2,11 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
 
+	"github.com/ansonallard/deployment-service/cmd/internal/middleware"
 	"github.com/ansonallard/deployment-service/cmd/internal/model"
 	"github.com/ansonallard/deployment-service/cmd/internal/service"
 	"github.com/ansonallard/deployment_service_go_client/lib/deployment_service_go_client"
@@ -61,18 +64,13 @@ func (ds *deploymentServiceController) CreateService(ctx context.Context, reques
 	if err := ds.service.Create(ctx, service); err != nil {
 		return nil, err
 	}
-	serviceDto := new(deployment_service_go_client.Service)
-	if err := service.ToExternal(serviceDto); err != nil {
+	serviceJSON, err := service.ToExternalJSON()
+	if err != nil {
 		return nil, err
 	}
-	version := deployment_service_go_client.Version(service.Version)
-	return deployment_service_go_client.CreateService200JSONResponse{
-		Body: deployment_service_go_client.CreateServiceResponse{
-			Service: *serviceDto,
-		},
-		Headers: deployment_service_go_client.CreateService200ResponseHeaders{
-			ETag: &version,
-		},
+	return createService202JSONResponse{
+		Body:    getServiceResponse{Service: serviceJSON},
+		Version: service.Version,
 	}, nil
 }
 
@@ -87,18 +85,13 @@ func (ds *deploymentServiceController) GetService(ctx context.Context, request d
 		return nil, err
 	}
 
-	serviceDto := new(deployment_service_go_client.Service)
-	if err := service.ToExternal(serviceDto); err != nil {
+	serviceJSON, err := service.ToExternalJSON()
+	if err != nil {
 		return nil, err
 	}
-	version := deployment_service_go_client.Version(service.Version)
-	return deployment_service_go_client.GetService200JSONResponse{
-		Body: deployment_service_go_client.GetServiceResponse{
-			Service: *serviceDto,
-		},
-		Headers: deployment_service_go_client.GetService200ResponseHeaders{
-			ETag: &version,
-		},
+	return getService200JSONResponse{
+		Body:    getServiceResponse{Service: serviceJSON},
+		Version: service.Version,
 	}, nil
 }
 
@@ -106,27 +99,32 @@ func (ds *deploymentServiceController) ListServices(ctx context.Context, request
 	ctx, span := tracer.Start(ctx, "controllers.list")
 	defer span.End()
 
-	maxResults, nextToken := model.FromListRequest(request.Params)
-
-	services, err := ds.service.List(ctx, maxResults, nextToken)
+	opts, err := model.FromListRequest(request.Params, middleware.QueryFromContext(ctx))
 	if err != nil {
 		return nil, err
 	}
 
-	servicesDto := make(deployment_service_go_client.Services, 0)
+	services, nextToken, err := ds.service.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
 
+	servicesJSON := make([]json.RawMessage, 0, len(services))
 	for _, service := range services {
-		serviceDto := new(deployment_service_go_client.Service)
-		if err := service.ToExternal(serviceDto); err != nil {
+		serviceJSON, err := service.ToExternalJSON()
+		if err != nil {
 			return nil, err
 		}
-		servicesDto = append(servicesDto, *serviceDto)
+		servicesJSON = append(servicesJSON, serviceJSON)
 	}
 
-	return deployment_service_go_client.ListServices200JSONResponse{
-		Services:  servicesDto,
-		NextToken: nil,
-	}, nil
+	response := listServices200JSONResponse{
+		Services: servicesJSON,
+	}
+	if nextToken != "" {
+		response.NextToken = &nextToken
+	}
+	return response, nil
 }
 
 func (ds *deploymentServiceController) UpdateService(ctx context.Context, request deployment_service_go_client.UpdateServiceRequestObject) (deployment_service_go_client.UpdateServiceResponseObject, error) {
@@ -145,19 +143,13 @@ func (ds *deploymentServiceController) UpdateService(ctx context.Context, reques
 		return nil, err
 	}
 
-	serviceDto := new(deployment_service_go_client.Service)
-	if err := updated.ToExternal(serviceDto); err != nil {
+	serviceJSON, err := updated.ToExternalJSON()
+	if err != nil {
 		return nil, err
 	}
-
-	version := deployment_service_go_client.Version(updated.Version)
-	return deployment_service_go_client.UpdateService200JSONResponse{
-		Body: deployment_service_go_client.UpdateServiceResponse{
-			Service: *serviceDto,
-		},
-		Headers: deployment_service_go_client.UpdateService200ResponseHeaders{
-			ETag: &version,
-		},
+	return updateService200JSONResponse{
+		Body:    getServiceResponse{Service: serviceJSON},
+		Version: updated.Version,
 	}, nil
 }
 
@@ -167,8 +159,83 @@ func (ds *deploymentServiceController) DeleteService(ctx context.Context, reques
 	)
 	defer span.End()
 
-	if err := ds.service.Delete(ctx, request.Name); err != nil {
+	opts, err := model.DeleteOptionsFromQuery(middleware.QueryFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	report, err := ds.service.Delete(ctx, request.Name, opts)
+	if err != nil {
 		return nil, err
 	}
-	return deployment_service_go_client.DeleteService204Response{}, nil
+	if !opts.Report {
+		return deployment_service_go_client.DeleteService204Response{}, nil
+	}
+	return deleteService200JSONResponse(*report), nil
+}
+
+// deleteService200JSONResponse reports what a delete tore down. The generated
+// client only knows the 204 response, which is still returned when no delete
+// mode is requested.
+type deleteService200JSONResponse model.DeleteReport
+
+func (response deleteService200JSONResponse) VisitDeleteServiceResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(response)
+}
+
+// createService202JSONResponse returns the service while it is cloned in the
+// background, with deployment.provisioning to poll until it is ready.
+type createService202JSONResponse struct {
+	Body    getServiceResponse
+	Version string
+}
+
+func (response createService202JSONResponse) VisitCreateServiceResponse(w http.ResponseWriter) error {
+	w.Header().Set("ETag", response.Version)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	return json.NewEncoder(w).Encode(response.Body)
+}
+
+// getService200JSONResponse, updateService200JSONResponse and
+// listServices200JSONResponse return services with their deployment status,
+// which the generated client's Service doesn't have a field for.
+type getService200JSONResponse struct {
+	Body    getServiceResponse
+	Version string
+}
+
+type getServiceResponse struct {
+	Service json.RawMessage `json:"service"`
+}
+
+func (response getService200JSONResponse) VisitGetServiceResponse(w http.ResponseWriter) error {
+	w.Header().Set("ETag", response.Version)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(response.Body)
+}
+
+type updateService200JSONResponse struct {
+	Body    getServiceResponse
+	Version string
+}
+
+func (response updateService200JSONResponse) VisitUpdateServiceResponse(w http.ResponseWriter) error {
+	w.Header().Set("ETag", response.Version)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(response.Body)
+}
+
+type listServices200JSONResponse struct {
+	Services  []json.RawMessage `json:"services"`
+	NextToken *string           `json:"nextToken,omitempty"`
+}
+
+func (response listServices200JSONResponse) VisitListServicesResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(response)
 }
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/events"
+	"github.com/ansonallard/deployment-service/cmd/internal/service"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Comments are sent this often so proxies don't close idle streams
+const eventsKeepAliveInterval = 15 * time.Second
+
+type EventsController interface {
+	// (GET /events)
+	StreamEvents(c *gin.Context)
+
+	// (GET /services/{name}/events)
+	StreamServiceEvents(c *gin.Context)
+}
+
+type EventsControllerConfig struct {
+	Service service.DeploymentService
+	Events  events.Bus
+}
+
+type eventsController struct {
+	service service.DeploymentService
+	events  events.Bus
+}
+
+func NewEventsController(config EventsControllerConfig) (EventsController, error) {
+	if config.Service == nil {
+		return nil, fmt.Errorf("service not set")
+	}
+	if config.Events == nil {
+		return nil, fmt.Errorf("events not set")
+	}
+	return &eventsController{
+		service: config.Service,
+		events:  config.Events,
+	}, nil
+}
+
+// RegisterEventsRoutes registers the Server-Sent Events streams of pipeline
+// events, which aren't part of the generated OpenAPI spec.
+func RegisterEventsRoutes(router gin.IRouter, controller EventsController) {
+	router.GET("/events", controller.StreamEvents)
+	router.GET("/services/:name/events", controller.StreamServiceEvents)
+}
+
+func (ec *eventsController) StreamEvents(c *gin.Context) {
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.stream_events")
+	defer span.End()
+
+	c.Request = c.Request.WithContext(ctx)
+	ec.stream(c, "")
+}
+
+func (ec *eventsController) StreamServiceEvents(c *gin.Context) {
+	name := c.Param("name")
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.stream_service_events",
+		trace.WithAttributes(attribute.String("service.name", name)),
+	)
+	defer span.End()
+
+	if _, err := ec.service.Get(ctx, name); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Request = c.Request.WithContext(ctx)
+	ec.stream(c, name)
+}
+
+// stream writes events until the client disconnects. Events published while
+// the client isn't connected are not replayed.
+func (ec *eventsController) stream(c *gin.Context, serviceName string) {
+	subscription, unsubscribe := ec.events.Subscribe(serviceName)
+	defer unsubscribe()
+
+	keepAlive := time.NewTicker(eventsKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Stops nginx from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		case event, ok := <-subscription:
+			if !ok {
+				return false
+			}
+			c.Render(-1, sseEvent{event})
+			return true
+		}
+	})
+}
+
+// sseEvent renders an event with its ID, so clients can tell when they missed
+// some.
+type sseEvent struct {
+	events.Event
+}
+
+func (e sseEvent) Render(w http.ResponseWriter) error {
+	data, err := json.Marshal(e.Event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", strconv.FormatUint(e.ID, 10), e.Type, data)
+	return err
+}
+
+func (e sseEvent) WriteContentType(w http.ResponseWriter) {}
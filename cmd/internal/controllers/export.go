@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/export"
+	"github.com/ansonallard/deployment-service/cmd/internal/service"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The passphrase is sent as a header so it doesn't end up in access logs
+const exportPassphraseHeader = "X-Export-Passphrase"
+
+type ExportController interface {
+	// (GET /export)
+	Export(c *gin.Context)
+
+	// (POST /import)
+	Import(c *gin.Context)
+}
+
+type ExportControllerConfig struct {
+	Service service.DeploymentService
+}
+
+type exportController struct {
+	service service.DeploymentService
+}
+
+func NewExportController(config ExportControllerConfig) (ExportController, error) {
+	if config.Service == nil {
+		return nil, fmt.Errorf("service not set")
+	}
+	return &exportController{
+		service: config.Service,
+	}, nil
+}
+
+// RegisterExportRoutes registers the export and import routes, which aren't
+// part of the generated OpenAPI spec.
+func RegisterExportRoutes(router gin.IRouter, controller ExportController) {
+	router.GET("/export", controller.Export)
+	router.POST("/import", controller.Import)
+}
+
+func (ec *exportController) Export(c *gin.Context) {
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.export")
+	defer span.End()
+
+	bundle, err := ec.service.Export(ctx, c.GetHeader(exportPassphraseHeader))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="deployment-service-export-%s.json"`,
+		bundle.ExportedAt.Format("20060102T150405Z")))
+	c.JSON(http.StatusOK, bundle)
+}
+
+func (ec *exportController) Import(c *gin.Context) {
+	conflict := c.Query("conflict")
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.import",
+		trace.WithAttributes(attribute.String("import.conflict_policy", conflict)),
+	)
+	defer span.End()
+
+	policy, err := export.ConflictPolicyFromExternal(conflict)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	bundle := new(export.Bundle)
+	if err := c.ShouldBindJSON(bundle); err != nil {
+		_ = c.Error(apierr.New(apierr.CodeInvalidArgument, err.Error()))
+		return
+	}
+
+	result, err := ec.service.Import(ctx, bundle, c.GetHeader(exportPassphraseHeader), policy)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
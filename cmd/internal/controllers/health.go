@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/health"
+	"github.com/gin-gonic/gin"
+)
+
+type HealthResponse struct {
+	Status health.Status `json:"status"`
+}
+
+// ReadyCheck is a readiness check without its details, which would tell
+// anyone who can reach the service about its host.
+type ReadyCheck struct {
+	Name   string        `json:"name"`
+	Status health.Status `json:"status"`
+}
+
+type ReadyResponse struct {
+	Status health.Status `json:"status"`
+	Checks []ReadyCheck  `json:"checks"`
+}
+
+type HealthController interface {
+	// (GET /healthz)
+	Healthz(c *gin.Context)
+
+	// (GET /readyz)
+	Readyz(c *gin.Context)
+
+	// (GET /v1/diagnostics)
+	Diagnostics(c *gin.Context)
+}
+
+type HealthControllerConfig struct {
+	Checker health.Checker
+}
+
+type healthController struct {
+	checker health.Checker
+}
+
+func NewHealthController(config HealthControllerConfig) (HealthController, error) {
+	if config.Checker == nil {
+		return nil, fmt.Errorf("checker not set")
+	}
+	return &healthController{
+		checker: config.Checker,
+	}, nil
+}
+
+// RegisterHealthRoutes registers the liveness and readiness probes. They are
+// meant for the router itself, outside of authz.
+func RegisterHealthRoutes(router gin.IRouter, controller HealthController) {
+	router.GET("/healthz", controller.Healthz)
+	router.GET("/readyz", controller.Readyz)
+}
+
+// RegisterDiagnosticsRoutes registers the detailed dependency report, which
+// isn't part of the generated OpenAPI spec.
+func RegisterDiagnosticsRoutes(router gin.IRouter, controller HealthController) {
+	router.GET("/diagnostics", controller.Diagnostics)
+}
+
+// Healthz only reports that the process is serving requests, so a failing
+// dependency doesn't get it restarted.
+func (hc *healthController) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{Status: health.StatusOK})
+}
+
+func (hc *healthController) Readyz(c *gin.Context) {
+	report := hc.checker.Ready(c.Request.Context())
+	response := ReadyResponse{Status: report.Status, Checks: make([]ReadyCheck, 0, len(report.Checks))}
+	for _, check := range report.Checks {
+		response.Checks = append(response.Checks, ReadyCheck{Name: check.Name, Status: check.Status})
+	}
+	c.JSON(readyStatusCode(report.Status), response)
+}
+
+func (hc *healthController) Diagnostics(c *gin.Context) {
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.diagnostics")
+	defer span.End()
+
+	diagnostics, err := hc.checker.Diagnostics(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(readyStatusCode(diagnostics.Status), diagnostics)
+}
+
+func readyStatusCode(status health.Status) int {
+	if status != health.StatusOK {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
+}
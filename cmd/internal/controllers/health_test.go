@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/health"
+	"github.com/gin-gonic/gin"
+)
+
+func serveReadyz(t *testing.T, diskErr error) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	checker, err := health.NewChecker(health.Config{
+		Checks: []health.Check{
+			{Name: "docker", Run: func(ctx context.Context) (string, error) {
+				return "unix:///var/run/docker.sock, API version 1.47", nil
+			}},
+			{Name: "disk", Run: func(ctx context.Context) (string, error) {
+				return "", diskErr
+			}},
+		},
+		Service:   newFakeService(),
+		StartedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	controller, err := NewHealthController(HealthControllerConfig{Checker: checker})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	RegisterHealthRoutes(router, controller)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	return response
+}
+
+func TestReadyz(t *testing.T) {
+	response := serveReadyz(t, nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", response.Code, response.Body)
+	}
+
+	response = serveReadyz(t, errors.New("/var/lib/deployment-service isn't writable"))
+	if response.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 for a failing check, got %d: %s", response.Code, response.Body)
+	}
+	var ready ReadyResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &ready); err != nil {
+		t.Fatal(err)
+	}
+	if ready.Status != health.StatusFailed || len(ready.Checks) != 2 ||
+		ready.Checks[0] != (ReadyCheck{Name: "docker", Status: health.StatusOK}) ||
+		ready.Checks[1] != (ReadyCheck{Name: "disk", Status: health.StatusFailed}) {
+		t.Fatalf("expected each check's status, got %+v", ready)
+	}
+	// Neither details nor errors are served without authentication
+	for _, leak := range []string{"docker.sock", "/var/lib", "details", "error"} {
+		if strings.Contains(response.Body.String(), leak) {
+			t.Fatalf("expected %q not to be in the response, got %s", leak, response.Body)
+		}
+	}
+}
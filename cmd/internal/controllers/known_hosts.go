@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/knownhosts"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type KnownHost struct {
+	Hosts       []string `json:"hosts"`
+	KeyType     string   `json:"keyType"`
+	Fingerprint string   `json:"fingerprint"`
+	PublicKey   string   `json:"publicKey"`
+}
+
+type ListKnownHostsResponse struct {
+	KnownHosts []KnownHost `json:"knownHosts"`
+}
+
+type AddKnownHostRequest struct {
+	// Host is `hostname` or `hostname:port`; port defaults to 22.
+	Host string `json:"host" binding:"required"`
+	// PublicKey is an authorized_keys style line, e.g. `ssh-ed25519 AAAA...`.
+	// When omitted the keys the host presents are trusted on first use.
+	PublicKey *string `json:"publicKey,omitempty"`
+}
+
+type AddKnownHostResponse struct {
+	KnownHosts []KnownHost `json:"knownHosts"`
+}
+
+type KnownHostsController interface {
+	// (GET /known-hosts)
+	ListKnownHosts(c *gin.Context)
+
+	// (POST /known-hosts)
+	AddKnownHost(c *gin.Context)
+
+	// (DELETE /known-hosts/{host})
+	RemoveKnownHost(c *gin.Context)
+}
+
+type KnownHostsControllerConfig struct {
+	KnownHosts knownhosts.KnownHosts
+}
+
+type knownHostsController struct {
+	knownHosts knownhosts.KnownHosts
+}
+
+func NewKnownHostsController(config KnownHostsControllerConfig) (KnownHostsController, error) {
+	if config.KnownHosts == nil {
+		return nil, fmt.Errorf("knownHosts not set")
+	}
+	return &knownHostsController{
+		knownHosts: config.KnownHosts,
+	}, nil
+}
+
+// RegisterKnownHostsRoutes registers the known_hosts management routes, which
+// aren't part of the generated OpenAPI spec.
+func RegisterKnownHostsRoutes(router gin.IRouter, controller KnownHostsController) {
+	router.GET("/known-hosts", controller.ListKnownHosts)
+	router.POST("/known-hosts", controller.AddKnownHost)
+	router.DELETE("/known-hosts/:host", controller.RemoveKnownHost)
+}
+
+func (kc *knownHostsController) ListKnownHosts(c *gin.Context) {
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.known_hosts.list")
+	defer span.End()
+
+	hostKeys, err := kc.knownHosts.List(ctx)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, ListKnownHostsResponse{KnownHosts: toKnownHostsExternal(hostKeys)})
+}
+
+func (kc *knownHostsController) AddKnownHost(c *gin.Context) {
+	var request AddKnownHostRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		_ = c.Error(apierr.New(apierr.CodeInvalidArgument, err.Error()))
+		return
+	}
+
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.known_hosts.add",
+		trace.WithAttributes(attribute.String("host", request.Host)),
+	)
+	defer span.End()
+
+	publicKey := ""
+	if request.PublicKey != nil {
+		publicKey = *request.PublicKey
+	}
+	hostKeys, err := kc.knownHosts.Add(ctx, request.Host, publicKey)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, AddKnownHostResponse{KnownHosts: toKnownHostsExternal(hostKeys)})
+}
+
+func (kc *knownHostsController) RemoveKnownHost(c *gin.Context) {
+	host := c.Param("host")
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.known_hosts.remove",
+		trace.WithAttributes(attribute.String("host", host)),
+	)
+	defer span.End()
+
+	if err := kc.knownHosts.Remove(ctx, host, c.Query("keyType")); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func toKnownHostsExternal(hostKeys []knownhosts.HostKey) []KnownHost {
+	knownHosts := make([]KnownHost, 0, len(hostKeys))
+	for _, hostKey := range hostKeys {
+		knownHosts = append(knownHosts, KnownHost{
+			Hosts:       hostKey.Hosts,
+			KeyType:     hostKey.KeyType,
+			Fingerprint: hostKey.Fingerprint,
+			PublicKey:   hostKey.PublicKey,
+		})
+	}
+	return knownHosts
+}
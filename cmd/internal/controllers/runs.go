@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/runlog"
+	"github.com/ansonallard/deployment-service/cmd/internal/service"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// How often a followed log is checked for new output
+const followPollInterval = 500 * time.Millisecond
+
+type ListRunsResponse struct {
+	Runs []runlog.RunInfo `json:"runs"`
+}
+
+type RunsController interface {
+	// (GET /services/{name}/runs)
+	ListRuns(c *gin.Context)
+
+	// (GET /services/{name}/runs/{runId}/logs)
+	GetRunLogs(c *gin.Context)
+}
+
+type RunsControllerConfig struct {
+	Service service.DeploymentService
+	RunLogs runlog.Store
+}
+
+type runsController struct {
+	service service.DeploymentService
+	runLogs runlog.Store
+}
+
+func NewRunsController(config RunsControllerConfig) (RunsController, error) {
+	if config.Service == nil {
+		return nil, fmt.Errorf("service not set")
+	}
+	if config.RunLogs == nil {
+		return nil, fmt.Errorf("runLogs not set")
+	}
+	return &runsController{
+		service: config.Service,
+		runLogs: config.RunLogs,
+	}, nil
+}
+
+// RegisterRunsRoutes registers the routes for release run logs, which aren't
+// part of the generated OpenAPI spec.
+func RegisterRunsRoutes(router gin.IRouter, controller RunsController) {
+	router.GET("/services/:name/runs", controller.ListRuns)
+	router.GET("/services/:name/runs/:runId/logs", controller.GetRunLogs)
+}
+
+func (rc *runsController) ListRuns(c *gin.Context) {
+	name := c.Param("name")
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.list_runs",
+		trace.WithAttributes(attribute.String("service.name", name)),
+	)
+	defer span.End()
+
+	if _, err := rc.service.Get(ctx, name); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	runs, err := rc.runLogs.List(ctx, name)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, ListRunsResponse{Runs: runs})
+}
+
+func (rc *runsController) GetRunLogs(c *gin.Context) {
+	name := c.Param("name")
+	runID := c.Param("runId")
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.get_run_logs",
+		trace.WithAttributes(
+			attribute.String("service.name", name),
+			attribute.String("run.id", runID),
+		),
+	)
+	defer span.End()
+
+	follow := false
+	if c.Query("follow") != "" {
+		var err error
+		if follow, err = strconv.ParseBool(c.Query("follow")); err != nil {
+			_ = c.Error(apierr.InvalidField("follow", fmt.Sprintf("invalid follow %q, expected true or false", c.Query("follow"))))
+			return
+		}
+	}
+
+	if _, err := rc.service.Get(ctx, name); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	logFile, done, err := rc.runLogs.Open(ctx, name, runID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	defer logFile.Close()
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Status(http.StatusOK)
+	if !follow {
+		_, _ = io.Copy(c.Writer, logFile)
+		return
+	}
+
+	// Follow the log until the run finishes or the client goes away
+	c.Header("X-Accel-Buffering", "no")
+	poll := time.NewTicker(followPollInterval)
+	defer poll.Stop()
+	for {
+		if _, err := io.Copy(c.Writer, logFile); err != nil && !errors.Is(err, io.EOF) {
+			return
+		}
+		c.Writer.Flush()
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			// Whatever was written before the run finished
+			_, _ = io.Copy(c.Writer, logFile)
+			return
+		case <-poll.C:
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/runlog"
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetRunLogsFollowsUntilRunFinishes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	serviceFilePath := t.TempDir()
+	if err := os.Mkdir(filepath.Join(serviceFilePath, "my-service"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	runLogs, err := runlog.NewStore(runlog.StoreConfig{ServiceFilePath: serviceFilePath, MaxBytes: 1 << 20, Retention: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	controller, err := NewRunsController(RunsControllerConfig{Service: newFakeService(), RunLogs: runLogs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	RegisterRunsRoutes(router, controller)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	runCtx, run, err := runLogs.Start(context.Background(), "my-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := runlog.Writer(runCtx)
+	if _, err := writer.Write([]byte("step 1\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := http.Get(server.URL + "/services/my-service/runs/" + run.ID + "/logs?follow=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", response.StatusCode)
+	}
+	body := bufio.NewReader(response.Body)
+	readLine := func() string {
+		t.Helper()
+		line, err := body.ReadString('\n')
+		if err != nil {
+			t.Fatalf("expected another line, got %v", err)
+		}
+		return line
+	}
+	if line := readLine(); !strings.HasPrefix(line, "Run "+run.ID+" of my-service started") {
+		t.Fatalf("expected the run header, got %q", line)
+	}
+	if line := readLine(); line != "step 1\n" {
+		t.Fatalf("expected output written before following, got %q", line)
+	}
+
+	// Output written while following arrives before the run finishes
+	if _, err := writer.Write([]byte("step 2\n")); err != nil {
+		t.Fatal(err)
+	}
+	if line := readLine(); line != "step 2\n" {
+		t.Fatalf("expected output written while following, got %q", line)
+	}
+
+	run.Finish(nil)
+	rest, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(rest), "Run succeeded at ") {
+		t.Fatalf("expected the response to end with the outcome, got %q", rest)
+	}
+}
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/service"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ifMatchHeader = "If-Match"
+	eTagHeader    = "ETag"
+)
+
+type UpdateServiceGitRequest struct {
+	SshUrl     *string `json:"sshUrl,omitempty"`
+	BranchName *string `json:"branchName,omitempty"`
+	// Credential names an entry in the git credential store. An empty string
+	// switches the service back to the default SSH key.
+	Credential *string `json:"credential,omitempty"`
+	// ReleaseStrategy is "push" or "pullRequest".
+	ReleaseStrategy *string `json:"releaseStrategy,omitempty"`
+}
+
+type ServiceGitController interface {
+	// (PUT /services/{name}/git)
+	UpdateServiceGit(c *gin.Context)
+
+	// (POST /services/{name}/repair)
+	RepairService(c *gin.Context)
+}
+
+type ServiceGitControllerConfig struct {
+	Service service.DeploymentService
+}
+
+type serviceGitController struct {
+	service service.DeploymentService
+}
+
+func NewServiceGitController(config ServiceGitControllerConfig) (ServiceGitController, error) {
+	if config.Service == nil {
+		return nil, fmt.Errorf("service not set")
+	}
+	return &serviceGitController{
+		service: config.Service,
+	}, nil
+}
+
+// RegisterServiceGitRoutes registers the routes for changing a service's git
+// settings, which aren't part of the generated OpenAPI spec.
+func RegisterServiceGitRoutes(router gin.IRouter, controller ServiceGitController) {
+	router.PUT("/services/:name/git", controller.UpdateServiceGit)
+	router.POST("/services/:name/repair", controller.RepairService)
+}
+
+func (sc *serviceGitController) UpdateServiceGit(c *gin.Context) {
+	name := c.Param("name")
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.update_git",
+		trace.WithAttributes(attribute.String("service.name", name)),
+	)
+	defer span.End()
+
+	ifMatch := c.GetHeader(ifMatchHeader)
+	if ifMatch == "" {
+		_ = c.Error(apierr.InvalidField(ifMatchHeader, fmt.Sprintf("%s header is required", ifMatchHeader)))
+		return
+	}
+	var request UpdateServiceGitRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		_ = c.Error(apierr.New(apierr.CodeInvalidArgument, err.Error()))
+		return
+	}
+	if request.SshUrl != nil && *request.SshUrl == "" {
+		_ = c.Error(apierr.InvalidField("sshUrl", "sshUrl must not be empty"))
+		return
+	}
+	if request.BranchName != nil && *request.BranchName == "" {
+		_ = c.Error(apierr.InvalidField("branchName", "branchName must not be empty"))
+		return
+	}
+
+	update := model.GitSettingsUpdate{
+		SSHUrl:     request.SshUrl,
+		BranchName: request.BranchName,
+		Credential: request.Credential,
+	}
+	if request.ReleaseStrategy != nil {
+		releaseStrategy, err := model.ReleaseStrategyFromExternal(*request.ReleaseStrategy)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		update.ReleaseStrategy = &releaseStrategy
+	}
+
+	updated, err := sc.service.UpdateGit(ctx, name, ifMatch, update)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	writeService(c, updated)
+}
+
+func (sc *serviceGitController) RepairService(c *gin.Context) {
+	name := c.Param("name")
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.repair",
+		trace.WithAttributes(attribute.String("service.name", name)),
+	)
+	defer span.End()
+
+	repaired, err := sc.service.Repair(ctx, name)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	writeService(c, repaired)
+}
+
+// writeService responds with svc and its deployment status.
+func writeService(c *gin.Context, svc *model.Service) {
+	serviceJSON, err := svc.ToExternalJSON()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Header(eTagHeader, svc.Version)
+	c.JSON(http.StatusOK, getServiceResponse{Service: serviceJSON})
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// fakeService returns the same service, with its deployment status
+// attached, from every call the tests make.
+type fakeService struct {
+	service.DeploymentService
+	svc *model.Service
+}
+
+func (f *fakeService) Get(ctx context.Context, name string) (*model.Service, error) {
+	return f.svc, nil
+}
+
+func (f *fakeService) UpdateGit(ctx context.Context, name string, ifMatch string, update model.GitSettingsUpdate) (*model.Service, error) {
+	return f.svc, nil
+}
+
+func (f *fakeService) Repair(ctx context.Context, name string) (*model.Service, error) {
+	return f.svc, nil
+}
+
+func (f *fakeService) GetRevision(ctx context.Context, serviceName string, revisionID string) (*model.Revision, error) {
+	return &model.Revision{ID: f.svc.Version, Service: *f.svc}, nil
+}
+
+func (f *fakeService) Revert(ctx context.Context, serviceName string, ifMatch string, revisionID string) (*model.Service, error) {
+	return f.svc, nil
+}
+
+func newFakeService() *fakeService {
+	return &fakeService{svc: &model.Service{
+		Name:          model.Name{Name: "my-service"},
+		ID:            "id",
+		Version:       "version",
+		GitSSHUrl:     "git@github.com:org/repo.git",
+		GitBranchName: "refs/heads/main",
+		Configuration: model.ServiceConfiguration{Go: &model.GoConfiguration{Service: &model.GoServiceConfiguration{}}},
+		DeploymentStatus: &model.DeploymentStatus{
+			State:               model.PipelineStateIdle,
+			LastDeployedVersion: "1.2.3",
+		},
+	}}
+}
+
+// deployedVersion returns the deployment status's last deployed version from
+// the service at path in body.
+func deployedVersion(t *testing.T, body []byte, path ...string) string {
+	t.Helper()
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range path {
+		raw := response[key]
+		response = nil
+		if err := json.Unmarshal(raw, &response); err != nil {
+			t.Fatalf("expected %s in %s: %v", key, body, err)
+		}
+	}
+	var deployment struct {
+		LastDeployedVersion string `json:"lastDeployedVersion"`
+	}
+	if err := json.Unmarshal(response["deployment"], &deployment); err != nil {
+		t.Fatalf("expected a deployment status in %s: %v", body, err)
+	}
+	return deployment.LastDeployedVersion
+}
+
+func TestServiceResponsesIncludeDeploymentStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	fake := newFakeService()
+	gitController, err := NewServiceGitController(ServiceGitControllerConfig{Service: fake})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revisionsController, err := NewServiceRevisionsController(ServiceRevisionsControllerConfig{Service: fake})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	RegisterServiceGitRoutes(router, gitController)
+	RegisterServiceRevisionsRoutes(router, revisionsController)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		// servicePath leads to the service in the response
+		servicePath []string
+	}{
+		{name: "update git", method: http.MethodPut, path: "/services/my-service/git", body: `{"branchName": "refs/heads/release"}`, servicePath: []string{"service"}},
+		{name: "repair", method: http.MethodPost, path: "/services/my-service/repair", servicePath: []string{"service"}},
+		{name: "get revision", method: http.MethodGet, path: "/services/my-service/revisions/version", servicePath: []string{"revision", "service"}},
+		{name: "revert", method: http.MethodPost, path: "/services/my-service/revisions/version/revert", servicePath: []string{"service"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			request.Header.Set(ifMatchHeader, "version")
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+			if response.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", response.Code, response.Body)
+			}
+			if got := deployedVersion(t, response.Body.Bytes(), test.servicePath...); got != "1.2.3" {
+				t.Fatalf("expected the deployment status with 1.2.3 deployed, got %q", got)
+			}
+		})
+	}
+}
+
+func TestUpdateServiceResponseIncludesDeploymentStatus(t *testing.T) {
+	svc := newFakeService().svc
+	serviceJSON, err := svc.ToExternalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := httptest.NewRecorder()
+	if err := (updateService200JSONResponse{Body: getServiceResponse{Service: serviceJSON}, Version: svc.Version}).VisitUpdateServiceResponse(response); err != nil {
+		t.Fatal(err)
+	}
+	if got := response.Header().Get(eTagHeader); got != svc.Version {
+		t.Fatalf("expected ETag %s, got %q", svc.Version, got)
+	}
+	if got := deployedVersion(t, response.Body.Bytes(), "service"); got != "1.2.3" {
+		t.Fatalf("expected the deployment status with 1.2.3 deployed, got %q", got)
+	}
+}
@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/service"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type RevisionSummary struct {
+	Id           string    `json:"id"`
+	CreatedAt    time.Time `json:"createdAt"`
+	Caller       string    `json:"caller"`
+	Action       string    `json:"action"`
+	RevertedFrom *string   `json:"revertedFrom,omitempty"`
+}
+
+type Revision struct {
+	RevisionSummary
+	Service json.RawMessage `json:"service"`
+}
+
+type ListRevisionsResponse struct {
+	Revisions []RevisionSummary `json:"revisions"`
+}
+
+type GetRevisionResponse struct {
+	Revision Revision `json:"revision"`
+}
+
+type DiffRevisionsResponse struct {
+	From    string                 `json:"from"`
+	To      string                 `json:"to"`
+	Changes []model.RevisionChange `json:"changes"`
+}
+
+type ServiceRevisionsController interface {
+	// (GET /services/{name}/revisions)
+	ListRevisions(c *gin.Context)
+
+	// (GET /services/{name}/revisions/{revision})
+	GetRevision(c *gin.Context)
+
+	// (GET /services/{name}/revisions/{revision}/diff)
+	DiffRevisions(c *gin.Context)
+
+	// (POST /services/{name}/revisions/{revision}/revert)
+	RevertRevision(c *gin.Context)
+}
+
+type ServiceRevisionsControllerConfig struct {
+	Service service.DeploymentService
+}
+
+type serviceRevisionsController struct {
+	service service.DeploymentService
+}
+
+func NewServiceRevisionsController(config ServiceRevisionsControllerConfig) (ServiceRevisionsController, error) {
+	if config.Service == nil {
+		return nil, fmt.Errorf("service not set")
+	}
+	return &serviceRevisionsController{
+		service: config.Service,
+	}, nil
+}
+
+// RegisterServiceRevisionsRoutes registers the configuration history routes,
+// which aren't part of the generated OpenAPI spec.
+func RegisterServiceRevisionsRoutes(router gin.IRouter, controller ServiceRevisionsController) {
+	router.GET("/services/:name/revisions", controller.ListRevisions)
+	router.GET("/services/:name/revisions/:revision", controller.GetRevision)
+	router.GET("/services/:name/revisions/:revision/diff", controller.DiffRevisions)
+	router.POST("/services/:name/revisions/:revision/revert", controller.RevertRevision)
+}
+
+func (rc *serviceRevisionsController) ListRevisions(c *gin.Context) {
+	name := c.Param("name")
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.list_revisions",
+		trace.WithAttributes(attribute.String("service.name", name)),
+	)
+	defer span.End()
+
+	revisions, err := rc.service.ListRevisions(ctx, name)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	response := ListRevisionsResponse{Revisions: make([]RevisionSummary, 0, len(revisions))}
+	for _, revision := range revisions {
+		response.Revisions = append(response.Revisions, toRevisionSummary(revision))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (rc *serviceRevisionsController) GetRevision(c *gin.Context) {
+	name := c.Param("name")
+	revisionID := c.Param("revision")
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.get_revision",
+		trace.WithAttributes(
+			attribute.String("service.name", name),
+			attribute.String("revision.id", revisionID),
+		),
+	)
+	defer span.End()
+
+	revision, err := rc.service.GetRevision(ctx, name, revisionID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	serviceJSON, err := revision.Service.ToExternalJSON()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, GetRevisionResponse{
+		Revision: Revision{
+			RevisionSummary: toRevisionSummary(revision),
+			Service:         serviceJSON,
+		},
+	})
+}
+
+func (rc *serviceRevisionsController) DiffRevisions(c *gin.Context) {
+	name := c.Param("name")
+	revisionID := c.Param("revision")
+	againstID := c.Query("against")
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.diff_revisions",
+		trace.WithAttributes(
+			attribute.String("service.name", name),
+			attribute.String("revision.id", revisionID),
+			attribute.String("revision.against", againstID),
+		),
+	)
+	defer span.End()
+
+	against, changes, err := rc.service.DiffRevisions(ctx, name, revisionID, againstID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, DiffRevisionsResponse{
+		From:    against.ID,
+		To:      revisionID,
+		Changes: changes,
+	})
+}
+
+func (rc *serviceRevisionsController) RevertRevision(c *gin.Context) {
+	name := c.Param("name")
+	revisionID := c.Param("revision")
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.revert_revision",
+		trace.WithAttributes(
+			attribute.String("service.name", name),
+			attribute.String("revision.id", revisionID),
+		),
+	)
+	defer span.End()
+
+	ifMatch := c.GetHeader(ifMatchHeader)
+	if ifMatch == "" {
+		_ = c.Error(apierr.InvalidField(ifMatchHeader, fmt.Sprintf("%s header is required", ifMatchHeader)))
+		return
+	}
+
+	reverted, err := rc.service.Revert(ctx, name, ifMatch, revisionID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	writeService(c, reverted)
+}
+
+func toRevisionSummary(revision *model.Revision) RevisionSummary {
+	summary := RevisionSummary{
+		Id:        revision.ID,
+		CreatedAt: revision.CreatedAt,
+		Caller:    revision.Caller,
+		Action:    string(revision.Action),
+	}
+	if revision.RevertedFrom != "" {
+		summary.RevertedFrom = &revision.RevertedFrom
+	}
+	return summary
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/service"
+	"github.com/ansonallard/deployment_service_go_client/lib/deployment_service_go_client"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type ServiceValidationController interface {
+	// (POST /services:validate)
+	ValidateService(c *gin.Context)
+}
+
+type ServiceValidationControllerConfig struct {
+	Service service.DeploymentService
+}
+
+type serviceValidationController struct {
+	service service.DeploymentService
+}
+
+func NewServiceValidationController(config ServiceValidationControllerConfig) (ServiceValidationController, error) {
+	if config.Service == nil {
+		return nil, fmt.Errorf("service not set")
+	}
+	return &serviceValidationController{
+		service: config.Service,
+	}, nil
+}
+
+// RegisterServiceValidationRoutes registers the route for validating a service
+// without creating it, which isn't part of the generated OpenAPI spec. The
+// colon is escaped so gin doesn't read it as a path parameter.
+func RegisterServiceValidationRoutes(router gin.IRouter, controller ServiceValidationController) {
+	router.POST("/services\\:validate", controller.ValidateService)
+}
+
+// ValidateService takes the same body as CreateService. The problems found
+// are the response, so an invalid service is still a 200.
+func (vc *serviceValidationController) ValidateService(c *gin.Context) {
+	var request deployment_service_go_client.CreateServiceRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		_ = c.Error(apierr.New(apierr.CodeInvalidArgument, err.Error()))
+		return
+	}
+	ctx, span := tracer.Start(c.Request.Context(), "controllers.validate",
+		trace.WithAttributes(attribute.String("service.name", request.Service.Name)),
+	)
+	defer span.End()
+
+	service := new(model.Service)
+	if err := service.FromCreateRequest(&request); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	report, err := vc.service.Validate(ctx, service)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
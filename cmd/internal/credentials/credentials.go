@@ -0,0 +1,206 @@
+package credentials
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Type is the git transport a credential authenticates.
+type Type string
+
+const (
+	TypeSSH        Type = "ssh"
+	TypeHTTPSToken Type = "https_token"
+)
+
+const (
+	sshUser = "git"
+	// Gitea and GitHub ignore the username when the password is a token, but
+	// go-git requires a non-empty one for basic auth.
+	defaultTokenUsername = "git"
+)
+
+// Credential is a single named entry in the credential store file. Secrets,
+// including key passphrases, are referenced by path so the store file itself
+// holds no key material.
+type Credential struct {
+	Name                 string `json:"name"`
+	Type                 Type   `json:"type"`
+	SSHKeyPath           string `json:"ssh_key_path,omitempty"`
+	SSHKeyPassphrasePath string `json:"ssh_key_passphrase_path,omitempty"`
+	Username             string `json:"username,omitempty"`
+	TokenPath            string `json:"token_path,omitempty"`
+}
+
+type storeFile struct {
+	Credentials []Credential `json:"credentials"`
+}
+
+// Store resolves the git auth method for a service from the name of its
+// credential. Services without a credential use the default SSH key.
+type Store interface {
+	AuthMethod(credentialName, gitURL string) (transport.AuthMethod, error)
+}
+
+type StoreConfig struct {
+	// DefaultSSHKeyPath is used for SSH remotes when a service does not
+	// reference a credential.
+	DefaultSSHKeyPath string
+	// CredentialsFilePath is optional. When empty only the default SSH key
+	// is available.
+	CredentialsFilePath string
+	// HostKeyCallback verifies the host key of every SSH remote.
+	HostKeyCallback gossh.HostKeyCallback
+}
+
+type entry struct {
+	credentialType Type
+	auth           transport.AuthMethod
+}
+
+type store struct {
+	defaultSSHAuth *ssh.PublicKeys
+	entries        map[string]entry
+}
+
+// NewStore loads every key and token referenced by the store file up front,
+// so a bad credential fails startup rather than the next background tick.
+func NewStore(config StoreConfig) (Store, error) {
+	if config.DefaultSSHKeyPath == "" {
+		return nil, fmt.Errorf("defaultSSHKeyPath not provided")
+	}
+	if config.HostKeyCallback == nil {
+		return nil, fmt.Errorf("hostKeyCallback not provided")
+	}
+	defaultSSHAuth, err := ssh.NewPublicKeysFromFile(sshUser, config.DefaultSSHKeyPath, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ssh key: %w", err)
+	}
+	defaultSSHAuth.HostKeyCallback = config.HostKeyCallback
+
+	s := &store{
+		defaultSSHAuth: defaultSSHAuth,
+		entries:        make(map[string]entry),
+	}
+	if config.CredentialsFilePath == "" {
+		return s, nil
+	}
+
+	fileBytes, err := os.ReadFile(config.CredentialsFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	var file storeFile
+	if err := json.Unmarshal(fileBytes, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials file: %w", err)
+	}
+
+	for _, credential := range file.Credentials {
+		if credential.Name == "" {
+			return nil, fmt.Errorf("credential name not set")
+		}
+		if _, ok := s.entries[credential.Name]; ok {
+			return nil, fmt.Errorf("duplicate credential %q", credential.Name)
+		}
+		auth, err := loadAuthMethod(credential, config.HostKeyCallback)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load credential %q: %w", credential.Name, err)
+		}
+		s.entries[credential.Name] = entry{credentialType: credential.Type, auth: auth}
+	}
+	return s, nil
+}
+
+func loadAuthMethod(credential Credential, hostKeyCallback gossh.HostKeyCallback) (transport.AuthMethod, error) {
+	switch credential.Type {
+	case TypeSSH:
+		if credential.SSHKeyPath == "" {
+			return nil, fmt.Errorf("ssh_key_path not set")
+		}
+		var passphrase string
+		if credential.SSHKeyPassphrasePath != "" {
+			passphraseBytes, err := os.ReadFile(credential.SSHKeyPassphrasePath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read ssh key passphrase: %w", err)
+			}
+			passphrase = strings.TrimSpace(string(passphraseBytes))
+		}
+		auth, err := ssh.NewPublicKeysFromFile(sshUser, credential.SSHKeyPath, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		auth.HostKeyCallback = hostKeyCallback
+		return auth, nil
+	case TypeHTTPSToken:
+		if credential.TokenPath == "" {
+			return nil, fmt.Errorf("token_path not set")
+		}
+		tokenBytes, err := os.ReadFile(credential.TokenPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read token: %w", err)
+		}
+		token := strings.TrimSpace(string(tokenBytes))
+		if token == "" {
+			return nil, fmt.Errorf("token file is empty")
+		}
+		username := credential.Username
+		if username == "" {
+			username = defaultTokenUsername
+		}
+		return &http.BasicAuth{Username: username, Password: token}, nil
+	default:
+		return nil, fmt.Errorf("unsupported credential type %q", credential.Type)
+	}
+}
+
+// AuthMethod returns the auth for gitURL. The credential's type must match
+// the URL's transport, e.g. a token can't be used against an SSH remote.
+func (s *store) AuthMethod(credentialName, gitURL string) (transport.AuthMethod, error) {
+	urlType, err := TransportType(gitURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if credentialName == "" {
+		if urlType != TypeSSH {
+			return nil, apierr.Newf(apierr.CodeInvalidCredential, "a git credential is required for %s", gitURL)
+		}
+		return s.defaultSSHAuth, nil
+	}
+
+	e, ok := s.entries[credentialName]
+	if !ok {
+		return nil, apierr.Newf(apierr.CodeInvalidCredential, "unknown git credential %q", credentialName)
+	}
+	if e.credentialType != urlType {
+		return nil, apierr.Newf(apierr.CodeInvalidCredential,
+			"git credential %q is of type %s, but %s requires %s", credentialName, e.credentialType, gitURL, urlType,
+		)
+	}
+	return e.auth, nil
+}
+
+// TransportType infers the transport from the remote URL. Anything that is
+// not an https URL, including scp-style `git@host:org/repo`, is SSH. Plain
+// http remotes are rejected, since a token would be sent in cleartext.
+func TransportType(gitURL string) (Type, error) {
+	u, err := url.Parse(gitURL)
+	if err == nil {
+		switch strings.ToLower(u.Scheme) {
+		case "https":
+			return TypeHTTPSToken, nil
+		case "http":
+			return "", apierr.Newf(apierr.CodeInvalidCredential, "%s isn't supported, git credentials are only sent over https", gitURL)
+		}
+	}
+	return TypeSSH, nil
+}
@@ -0,0 +1,217 @@
+package credentials
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// writeFile writes contents to name in dir and returns its path.
+func writeFile(t *testing.T, dir, name string, contents []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, contents, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeSSHKey writes a new ed25519 private key, encrypted when passphrase is
+// set, and returns its path.
+func writeSSHKey(t *testing.T, dir, name, passphrase string) string {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = gossh.MarshalPrivateKey(key, name)
+	} else {
+		block, err = gossh.MarshalPrivateKeyWithPassphrase(key, name, []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeFile(t, dir, name, pem.EncodeToMemory(block))
+}
+
+// newTestStore writes credentials to a store file and loads it.
+func newTestStore(t *testing.T, dir string, credentials ...Credential) (Store, error) {
+	t.Helper()
+	fileBytes, err := json.Marshal(storeFile{Credentials: credentials})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewStore(StoreConfig{
+		DefaultSSHKeyPath:   writeSSHKey(t, dir, "default", ""),
+		CredentialsFilePath: writeFile(t, dir, "credentials.json", fileBytes),
+		HostKeyCallback:     gossh.InsecureIgnoreHostKey(),
+	})
+}
+
+func TestTransportType(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected Type
+		err      bool
+	}{
+		{url: "https://github.com/org/repo.git", expected: TypeHTTPSToken},
+		{url: "HTTPS://github.com/org/repo.git", expected: TypeHTTPSToken},
+		{url: "git@github.com:org/repo.git", expected: TypeSSH},
+		{url: "ssh://git@github.com/org/repo.git", expected: TypeSSH},
+		{url: "http://gitea.local/org/repo.git", err: true},
+		{url: "HTTP://gitea.local/org/repo.git", err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			got, err := TransportType(test.url)
+			if test.err {
+				if !apierr.HasCode(err, apierr.CodeInvalidCredential) {
+					t.Fatalf("expected %s, got %q, %v", apierr.CodeInvalidCredential, got, err)
+				}
+				return
+			}
+			if err != nil || got != test.expected {
+				t.Fatalf("expected %s, got %q, %v", test.expected, got, err)
+			}
+		})
+	}
+}
+
+func TestAuthMethod(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newTestStore(t, dir,
+		Credential{Name: "deploy-key", Type: TypeSSH, SSHKeyPath: writeSSHKey(t, dir, "deploy", "")},
+		Credential{Name: "token", Type: TypeHTTPSToken, Username: "ci-bot", TokenPath: writeFile(t, dir, "token", []byte("secret\n"))},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth, err := s.AuthMethod("", "git@github.com:org/repo.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys, ok := auth.(*ssh.PublicKeys); !ok || keys.User != sshUser || keys.HostKeyCallback == nil {
+		t.Fatalf("expected the default key with a host key callback, got %#v", auth)
+	}
+
+	auth, err = s.AuthMethod("token", "https://github.com/org/repo.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if basic, ok := auth.(*http.BasicAuth); !ok || basic.Username != "ci-bot" || basic.Password != "secret" {
+		t.Fatalf("expected basic auth with the trimmed token, got %#v", auth)
+	}
+
+	failures := []struct {
+		name       string
+		credential string
+		url        string
+	}{
+		{name: "no credential for https", credential: "", url: "https://github.com/org/repo.git"},
+		{name: "unknown credential", credential: "missing", url: "git@github.com:org/repo.git"},
+		{name: "token over ssh", credential: "token", url: "git@github.com:org/repo.git"},
+		{name: "key over https", credential: "deploy-key", url: "https://github.com/org/repo.git"},
+		{name: "token over http", credential: "token", url: "http://github.com/org/repo.git"},
+		{name: "no credential for http", credential: "", url: "http://github.com/org/repo.git"},
+	}
+	for _, failure := range failures {
+		t.Run(failure.name, func(t *testing.T) {
+			auth, err := s.AuthMethod(failure.credential, failure.url)
+			if !apierr.HasCode(err, apierr.CodeInvalidCredential) {
+				t.Fatalf("expected %s, got %#v, %v", apierr.CodeInvalidCredential, auth, err)
+			}
+		})
+	}
+}
+
+func TestStoreLoadsEncryptedKey(t *testing.T) {
+	dir := t.TempDir()
+	credential := Credential{
+		Name:                 "deploy-key",
+		Type:                 TypeSSH,
+		SSHKeyPath:           writeSSHKey(t, dir, "deploy", "hunter2"),
+		SSHKeyPassphrasePath: writeFile(t, dir, "passphrase", []byte("hunter2\n")),
+	}
+	s, err := newTestStore(t, dir, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AuthMethod("deploy-key", "git@github.com:org/repo.git"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The store file references the passphrase instead of holding it
+	fileBytes, err := os.ReadFile(filepath.Join(dir, "credentials.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(fileBytes), "hunter2") {
+		t.Fatalf("expected the store file not to hold the passphrase, got %s", fileBytes)
+	}
+
+	credential.SSHKeyPassphrasePath = writeFile(t, dir, "wrong", []byte("wrong"))
+	if _, err := newTestStore(t, dir, credential); err == nil {
+		t.Fatal("expected a wrong passphrase to fail loading the store")
+	}
+}
+
+func TestNewStoreRejectsBadCredentials(t *testing.T) {
+	dir := t.TempDir()
+	key := writeSSHKey(t, dir, "deploy", "")
+	tests := []struct {
+		name        string
+		credentials []Credential
+	}{
+		{name: "missing name", credentials: []Credential{{Type: TypeSSH, SSHKeyPath: key}}},
+		{name: "duplicate name", credentials: []Credential{
+			{Name: "key", Type: TypeSSH, SSHKeyPath: key},
+			{Name: "key", Type: TypeSSH, SSHKeyPath: key},
+		}},
+		{name: "missing key path", credentials: []Credential{{Name: "key", Type: TypeSSH}}},
+		{name: "missing key", credentials: []Credential{{Name: "key", Type: TypeSSH, SSHKeyPath: filepath.Join(dir, "missing")}}},
+		{name: "missing passphrase", credentials: []Credential{
+			{Name: "key", Type: TypeSSH, SSHKeyPath: key, SSHKeyPassphrasePath: filepath.Join(dir, "missing")},
+		}},
+		{name: "missing token path", credentials: []Credential{{Name: "token", Type: TypeHTTPSToken}}},
+		{name: "empty token", credentials: []Credential{
+			{Name: "token", Type: TypeHTTPSToken, TokenPath: writeFile(t, dir, "empty", []byte("\n"))},
+		}},
+		{name: "unsupported type", credentials: []Credential{{Name: "password", Type: "password"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := newTestStore(t, t.TempDir(), test.credentials...); err == nil {
+				t.Fatal("expected loading the store to fail")
+			}
+		})
+	}
+}
+
+func TestNewStoreWithoutCredentialsFile(t *testing.T) {
+	s, err := NewStore(StoreConfig{
+		DefaultSSHKeyPath: writeSSHKey(t, t.TempDir(), "default", ""),
+		HostKeyCallback:   gossh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AuthMethod("", "git@github.com:org/repo.git"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AuthMethod("deploy-key", "git@github.com:org/repo.git"); !apierr.HasCode(err, apierr.CodeInvalidCredential) {
+		t.Fatalf("expected %s, got %v", apierr.CodeInvalidCredential, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -25,6 +26,18 @@ func GetAPIKey(ctx context.Context) string {
 	return getRequiredEnvVar(ctx, "API_KEY")
 }
 
+// The JSON file API keys created through the API are stored in, hashed.
+// Defaults to `api_keys.json` under SERVICE_FILE_PATH.
+func GetAPIKeysFilePath(ctx context.Context) string {
+	return getOptionalEnvVar("API_KEYS_FILE", path.Join(GetSerivceFilePath(ctx), "api_keys.json"))
+}
+
+// The JSON lines file the audit log is appended to. Defaults to `audit.jsonl`
+// under SERVICE_FILE_PATH.
+func GetAuditLogPath(ctx context.Context) string {
+	return getOptionalEnvVar("AUDIT_LOG_FILE", path.Join(GetSerivceFilePath(ctx), "audit.jsonl"))
+}
+
 func GetSerivceFilePath(ctx context.Context) string {
 	return getRequiredEnvVar(ctx, "SERVICE_FILE_PATH")
 }
@@ -33,10 +46,36 @@ func GetSSHKeyPath(ctx context.Context) string {
 	return getRequiredEnvVar(ctx, "SSH_KEY_PATH")
 }
 
+// Optional JSON file of named git credentials that services can reference
+// instead of the default SSH key.
+func GetGitCredentialsFilePath() string {
+	return getOptionalEnvVar("GIT_CREDENTIALS_FILE", "")
+}
+
+// The known_hosts file every SSH remote is verified against. Defaults to
+// `known_hosts` under SERVICE_FILE_PATH.
+func GetKnownHostsPath(ctx context.Context) string {
+	return getOptionalEnvVar("KNOWN_HOSTS_PATH", path.Join(GetSerivceFilePath(ctx), "known_hosts"))
+}
+
 func GetGitRepoOirign(ctx context.Context) string {
 	return getRequiredEnvVar(ctx, "GIT_REPO_ORIGIN")
 }
 
+// The key format used to sign CI commits and tags: "openpgp" or "ssh".
+// Signing is disabled when unset.
+func GetGitSigningFormat() string {
+	return strings.ToLower(getOptionalEnvVar("GIT_SIGNING_FORMAT", ""))
+}
+
+func GetGitSigningKeyPath(ctx context.Context) string {
+	return getRequiredEnvVar(ctx, "GIT_SIGNING_KEY_PATH")
+}
+
+func GetGitSigningKeyPassphrase() string {
+	return getOptionalEnvVar("GIT_SIGNING_KEY_PASSPHRASE", "")
+}
+
 func GetCICommitAuthorName(ctx context.Context) string {
 	return getRequiredEnvVar(ctx, "CI_COMMIT_AUTHOR_NAME")
 }
@@ -113,6 +152,46 @@ func GetGitHubOwner(ctx context.Context) string {
 	return getRequiredEnvVar(ctx, "GITHUB_OWNER")
 }
 
+// Base URL of the Gitea instance, e.g. https://gitea.example.com. Only needed
+// for services that release through pull requests on Gitea.
+func GetGiteaURL() string {
+	return getOptionalEnvVar("GITEA_URL", "")
+}
+
+func GetGiteaPAT(ctx context.Context) string {
+	return getRequiredEnvVar(ctx, "GITEA_PAT")
+}
+
+// Issuer of the OIDC tokens accepted as Authorization: Bearer, e.g.
+// https://gitea.example.com/. Bearer tokens are refused when it isn't set.
+func GetOIDCIssuerURL() string {
+	return getOptionalEnvVar("OIDC_ISSUER_URL", "")
+}
+
+func GetOIDCAudience(ctx context.Context) string {
+	return getRequiredEnvVar(ctx, "OIDC_AUDIENCE")
+}
+
+// Defaults to the jwks_uri in the issuer's discovery document
+func GetOIDCJWKSURL() string {
+	return getOptionalEnvVar("OIDC_JWKS_URL", "")
+}
+
+func GetOIDCGroupsClaim() string {
+	return getOptionalEnvVar("OIDC_GROUPS_CLAIM", "groups")
+}
+
+// Comma separated group=scope pairs, e.g. my-org:owners=admin,my-org=read
+func GetOIDCScopeMapping(ctx context.Context) string {
+	return getRequiredEnvVar(ctx, "OIDC_SCOPE_MAPPING")
+}
+
+// GetCommitStatusTargetURL returns the link attached to commit statuses.
+// `{service}` and `{traceId}` are substituted, e.g. a Grafana trace URL.
+func GetCommitStatusTargetURL() string {
+	return getOptionalEnvVar("COMMIT_STATUS_TARGET_URL", "")
+}
+
 func GetTempoURI(ctx context.Context) string {
 	tempoHost := getRequiredEnvVar(ctx, "TEMPO_HOST")
 	tempoPort := getRequiredEnvVar(ctx, "TEMPO_PORT")
@@ -143,6 +222,28 @@ func GetLogLevel() zerolog.Level {
 	}
 }
 
+// The most output kept per release run, in bytes
+func GetRunLogMaxBytes() (int64, error) {
+	return strconv.ParseInt(getOptionalEnvVar("RUN_LOG_MAX_BYTES", "10485760"), 10, 64)
+}
+
+// How many release run logs are kept per service
+func GetRunLogRetention() (int, error) {
+	return strconv.Atoi(getOptionalEnvVar("RUN_LOG_RETENTION", "20"))
+}
+
+// Readiness fails when SERVICE_FILE_PATH has less free disk than this, in
+// bytes
+func GetMinFreeDiskBytes() (uint64, error) {
+	return strconv.ParseUint(getOptionalEnvVar("MIN_FREE_DISK_BYTES", "1073741824"), 10, 64)
+}
+
+// Whether metrics are also pushed over OTLP to TEMPO_HOST:TEMPO_PORT, on top
+// of being served at /metrics
+func IsMetricsOTLPEnabled() bool {
+	return strings.ToLower(getOptionalEnvVar("METRICS_OTLP_ENABLED", "false")) == "true"
+}
+
 // The Docker Compose Application name used to deploy this service
 func GetSelfServiceApplication() string {
 	return getOptionalEnvVar("SELF_SERVICE_NAME", "")
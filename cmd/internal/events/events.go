@@ -0,0 +1,183 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Type is the kind of pipeline event, sent as the SSE event name.
+type Type string
+
+const (
+	TypeTickStarted     Type = "tick_started"
+	TypeNewCommit       Type = "new_commit"
+	TypeVersionComputed Type = "version_computed"
+	TypeTagPushed       Type = "tag_pushed"
+	TypeBuildLog        Type = "build_log"
+	TypePushProgress    Type = "push_progress"
+	TypeDeployLog       Type = "deploy_log"
+	TypeDeployFinished  Type = "deploy_finished"
+	TypeReleaseFailed   Type = "release_failed"
+)
+
+// Subscribers that fall this far behind miss events rather than holding up
+// the release.
+const subscriberBuffer = 256
+
+type Event struct {
+	ID      uint64    `json:"id"`
+	Type    Type      `json:"type"`
+	Service string    `json:"service"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message,omitempty"`
+	Version string    `json:"version,omitempty"`
+	Commit  string    `json:"commit,omitempty"`
+}
+
+// Bus fans pipeline events out to subscribers. Publishing never blocks.
+type Bus interface {
+	Publish(event Event)
+	// Subscribe returns the events of serviceName, or of every service when
+	// serviceName is empty. The channel is closed by unsubscribe.
+	Subscribe(serviceName string) (events <-chan Event, unsubscribe func())
+	// WithService returns a ctx that Emit publishes serviceName's events
+	// through, so the processors and releaser don't need the Bus themselves.
+	WithService(ctx context.Context, serviceName string) context.Context
+}
+
+func NewBus() Bus {
+	return &bus{subscribers: make(map[*subscriber]struct{})}
+}
+
+type bus struct {
+	mu          sync.Mutex
+	nextID      uint64
+	subscribers map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	serviceName string
+	events      chan Event
+}
+
+func (b *bus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	event.ID = b.nextID
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	for sub := range b.subscribers {
+		if sub.serviceName != "" && sub.serviceName != event.Service {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+		}
+	}
+}
+
+func (b *bus) Subscribe(serviceName string) (<-chan Event, func()) {
+	sub := &subscriber{
+		serviceName: serviceName,
+		events:      make(chan Event, subscriberBuffer),
+	}
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return sub.events, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, sub)
+			b.mu.Unlock()
+			close(sub.events)
+		})
+	}
+}
+
+type emitterKey struct{}
+
+type emitter struct {
+	bus         *bus
+	serviceName string
+}
+
+func (b *bus) WithService(ctx context.Context, serviceName string) context.Context {
+	return context.WithValue(ctx, emitterKey{}, &emitter{bus: b, serviceName: serviceName})
+}
+
+// Emit publishes event for the service ctx was set up for by WithService. It
+// does nothing outside of a release.
+func Emit(ctx context.Context, event Event) {
+	e, ok := ctx.Value(emitterKey{}).(*emitter)
+	if !ok {
+		return
+	}
+	event.Service = e.serviceName
+	e.bus.Publish(event)
+}
+
+// maxLineBytes bounds a line that is still waiting for its newline, so
+// output without any doesn't grow the buffer forever.
+const maxLineBytes = 64 << 10
+
+// NewLineWriter returns a writer that emits each line written to it as an
+// event of eventType, for fanning out command output. A line may span several
+// writes. Close emits the last line when the output doesn't end in a newline.
+func NewLineWriter(ctx context.Context, eventType Type) io.WriteCloser {
+	return &lineWriter{ctx: ctx, eventType: eventType}
+}
+
+type lineWriter struct {
+	ctx       context.Context
+	eventType Type
+
+	mu sync.Mutex
+	// partial is the start of a line whose newline hasn't been written yet
+	partial []byte
+}
+
+func (w *lineWriter) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.partial = append(w.partial, p...)
+	start := 0
+	for {
+		i := bytes.IndexByte(w.partial[start:], '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(w.partial[start : start+i])
+		start += i + 1
+	}
+	w.partial = append(w.partial[:0], w.partial[start:]...)
+	if len(w.partial) >= maxLineBytes {
+		w.emit(w.partial)
+		w.partial = w.partial[:0]
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.emit(w.partial)
+	w.partial = nil
+	return nil
+}
+
+func (w *lineWriter) emit(line []byte) {
+	if len(line) > 0 {
+		Emit(w.ctx, Event{Type: w.eventType, Message: string(line)})
+	}
+}
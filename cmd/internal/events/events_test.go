@@ -0,0 +1,199 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// drain returns the events buffered on events without waiting for more.
+func drain(events <-chan Event) []Event {
+	var got []Event
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return got
+			}
+			got = append(got, event)
+		default:
+			return got
+		}
+	}
+}
+
+func TestSubscribeFiltersByService(t *testing.T) {
+	bus := NewBus()
+	one, unsubscribeOne := bus.Subscribe("one")
+	defer unsubscribeOne()
+	all, unsubscribeAll := bus.Subscribe("")
+	defer unsubscribeAll()
+
+	bus.Publish(Event{Type: TypeTickStarted, Service: "one"})
+	bus.Publish(Event{Type: TypeTickStarted, Service: "two"})
+	bus.Publish(Event{Type: TypeNewCommit, Service: "one"})
+
+	gotOne := drain(one)
+	if len(gotOne) != 2 || gotOne[0].Type != TypeTickStarted || gotOne[1].Type != TypeNewCommit {
+		t.Fatalf("expected service one's two events, got %+v", gotOne)
+	}
+	gotAll := drain(all)
+	if len(gotAll) != 3 {
+		t.Fatalf("expected every event, got %+v", gotAll)
+	}
+	for i, event := range gotAll {
+		if event.ID != uint64(i+1) {
+			t.Fatalf("expected IDs to count up from 1, got %d at %d", event.ID, i)
+		}
+		if event.Time.IsZero() {
+			t.Fatal("expected the time to be set")
+		}
+	}
+}
+
+func TestPublishDoesNotBlockOnSlowSubscriber(t *testing.T) {
+	bus := NewBus()
+	events, unsubscribe := bus.Subscribe("")
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range subscriberBuffer + 10 {
+			bus.Publish(Event{Type: TypeBuildLog, Message: fmt.Sprint(i)})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publishing blocked on a subscriber that doesn't read")
+	}
+	got := drain(events)
+	if len(got) != subscriberBuffer {
+		t.Fatalf("expected the first %d events to be kept, got %d", subscriberBuffer, len(got))
+	}
+	if got[0].Message != "0" {
+		t.Fatalf("expected the oldest events to be kept, got %q first", got[0].Message)
+	}
+}
+
+func TestUnsubscribeClosesChannel(t *testing.T) {
+	bus := NewBus()
+	events, unsubscribe := bus.Subscribe("one")
+	unsubscribe()
+	unsubscribe()
+
+	if _, ok := <-events; ok {
+		t.Fatal("expected the channel to be closed")
+	}
+	// Publishing after unsubscribing must not send on the closed channel
+	bus.Publish(Event{Type: TypeTickStarted, Service: "one"})
+}
+
+func TestConcurrentPublishAndUnsubscribe(t *testing.T) {
+	bus := NewBus()
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			events, unsubscribe := bus.Subscribe("")
+			bus.Publish(Event{Type: TypeTickStarted})
+			drain(events)
+			unsubscribe()
+		})
+		wg.Go(func() {
+			bus.Publish(Event{Type: TypeNewCommit})
+		})
+	}
+	wg.Wait()
+}
+
+func TestEmit(t *testing.T) {
+	bus := NewBus()
+	events, unsubscribe := bus.Subscribe("")
+	defer unsubscribe()
+
+	// Outside of a release nothing is published
+	Emit(context.Background(), Event{Type: TypeTickStarted})
+	if got := drain(events); len(got) != 0 {
+		t.Fatalf("expected no events, got %+v", got)
+	}
+
+	ctx := bus.WithService(context.Background(), "one")
+	Emit(ctx, Event{Type: TypeTagPushed, Service: "other", Version: "1.2.3"})
+	got := drain(events)
+	if len(got) != 1 || got[0].Service != "one" || got[0].Version != "1.2.3" {
+		t.Fatalf("expected a tag_pushed event for service one, got %+v", got)
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	bus := NewBus()
+	events, unsubscribe := bus.Subscribe("one")
+	defer unsubscribe()
+
+	w := NewLineWriter(bus.WithService(context.Background(), "one"), TypeBuildLog)
+	input := "step 1\n\nstep 2\nstep 3\n"
+	n, err := w.Write([]byte(input))
+	if err != nil || n != len(input) {
+		t.Fatalf("expected %d bytes written, got %d, %v", len(input), n, err)
+	}
+	got := drain(events)
+	if len(got) != 3 {
+		t.Fatalf("expected one event per non-empty line, got %+v", got)
+	}
+	for i, event := range got {
+		if want := fmt.Sprintf("step %d", i+1); event.Type != TypeBuildLog || event.Message != want {
+			t.Fatalf("expected build_log %q, got %+v", want, event)
+		}
+	}
+}
+
+func TestLineWriterJoinsLinesAcrossWrites(t *testing.T) {
+	bus := NewBus()
+	events, unsubscribe := bus.Subscribe("one")
+	defer unsubscribe()
+
+	w := NewLineWriter(bus.WithService(context.Background(), "one"), TypeBuildLog)
+	for _, chunk := range []string{`{"stream":"Step 1/2`, ` : FROM golang"}`, "\n{\"stream\":", `"Step 2/2"}`} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := drain(events)
+	if len(got) != 1 || got[0].Message != `{"stream":"Step 1/2 : FROM golang"}` {
+		t.Fatalf("expected the line split across writes as one event, got %+v", got)
+	}
+
+	// The last line has no newline, so it is only emitted on close
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got = drain(events)
+	if len(got) != 1 || got[0].Message != `{"stream":"Step 2/2"}` {
+		t.Fatalf("expected the last line on close, got %+v", got)
+	}
+}
+
+func TestLineWriterBoundsLongLines(t *testing.T) {
+	bus := NewBus()
+	events, unsubscribe := bus.Subscribe("one")
+	defer unsubscribe()
+
+	w := NewLineWriter(bus.WithService(context.Background(), "one"), TypeBuildLog)
+	if _, err := w.Write(bytes.Repeat([]byte("."), maxLineBytes)); err != nil {
+		t.Fatal(err)
+	}
+	got := drain(events)
+	if len(got) != 1 || len(got[0].Message) != maxLineBytes {
+		t.Fatalf("expected a line without a newline to be emitted once it reaches %d bytes, got %d events", maxLineBytes, len(got))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := drain(events); len(got) != 0 {
+		t.Fatalf("expected nothing left to emit, got %+v", got)
+	}
+}
@@ -0,0 +1,319 @@
+package export
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	FormatName    = "deployment-service-export"
+	FormatVersion = 1
+
+	// MinPassphraseLength guards against passphrases that are trivial to
+	// brute force offline
+	MinPassphraseLength = 12
+
+	kdfScrypt       = "scrypt"
+	cipherAES256GCM = "aes-256-gcm"
+	scryptN         = 1 << 15
+	scryptR         = 8
+	scryptP         = 1
+	maxScryptN      = 1 << 20
+	maxScryptR      = 32
+	maxScryptP      = 16
+	keyLength       = 32
+	saltLength      = 16
+)
+
+// Bundle is a portable export of every service definition and its revisions,
+// without git clones. Docker Compose env files may hold secrets, so they are
+// either left out or encrypted with a passphrase.
+type Bundle struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exportedAt"`
+	// Encryption is set when secrets are included
+	Encryption *Encryption    `json:"encryption,omitempty"`
+	Services   []ServiceEntry `json:"services"`
+}
+
+type Encryption struct {
+	KDF    string `json:"kdf"`
+	Cipher string `json:"cipher"`
+	Salt   []byte `json:"salt"`
+	N      int    `json:"n"`
+	R      int    `json:"r"`
+	P      int    `json:"p"`
+}
+
+// ServiceEntry holds a service and its revisions with the secrets stripped.
+// Secrets is the sealed serviceSecrets when the bundle is encrypted.
+type ServiceEntry struct {
+	Service   model.Service    `json:"service"`
+	Revisions []model.Revision `json:"revisions"`
+	Secrets   []byte           `json:"secrets,omitempty"`
+}
+
+// serviceSecrets are the env files stripped from a service, keyed by revision
+// ID for its revisions.
+type serviceSecrets struct {
+	Current   map[string]model.EnvVars            `json:"current,omitempty"`
+	Revisions map[string]map[string]model.EnvVars `json:"revisions,omitempty"`
+}
+
+// Entry is a service and its revisions with their secrets in place.
+type Entry struct {
+	Service   *model.Service
+	Revisions []*model.Revision
+}
+
+// ConflictPolicy decides what an import does with services that already exist.
+type ConflictPolicy string
+
+const (
+	ConflictSkip      ConflictPolicy = "skip"
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	ConflictFail      ConflictPolicy = "fail"
+)
+
+func ConflictPolicyFromExternal(policy string) (ConflictPolicy, error) {
+	switch ConflictPolicy(policy) {
+	case "":
+		return ConflictFail, nil
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+		return ConflictPolicy(policy), nil
+	default:
+		return "", apierr.Newf(apierr.CodeInvalidArgument, "invalid conflict policy %q, expected one of: %s, %s, %s",
+			policy, ConflictSkip, ConflictOverwrite, ConflictFail)
+	}
+}
+
+// ImportResult reports what happened to each service in a bundle.
+type ImportResult struct {
+	Created     []string       `json:"created"`
+	Overwritten []string       `json:"overwritten"`
+	Skipped     []string       `json:"skipped"`
+	Failed      []ImportFailed `json:"failed"`
+	// SecretsExcluded is set when the bundle was exported without a
+	// passphrase, so imported Docker Compose services have no env files.
+	SecretsExcluded bool `json:"secretsExcluded"`
+}
+
+type ImportFailed struct {
+	Name  string      `json:"name"`
+	Code  apierr.Code `json:"code"`
+	Error string      `json:"error"`
+}
+
+// NewImportFailed reports name failing to import with err, hiding the text
+// of internal errors like the API does.
+func NewImportFailed(name string, err error) ImportFailed {
+	apiErr := apierr.From(err)
+	return ImportFailed{Name: name, Code: apiErr.Code, Error: apiErr.Message}
+}
+
+func NewImportResult() *ImportResult {
+	return &ImportResult{
+		Created:     make([]string, 0),
+		Overwritten: make([]string, 0),
+		Skipped:     make([]string, 0),
+		Failed:      make([]ImportFailed, 0),
+	}
+}
+
+// NewBundle builds a bundle from entries. Secrets are encrypted with
+// passphrase, or left out when it is empty.
+func NewBundle(entries []Entry, passphrase string) (*Bundle, error) {
+	bundle := &Bundle{
+		Format:     FormatName,
+		Version:    FormatVersion,
+		ExportedAt: time.Now().UTC(),
+		Services:   make([]ServiceEntry, 0, len(entries)),
+	}
+
+	var aead cipher.AEAD
+	if passphrase != "" {
+		if len(passphrase) < MinPassphraseLength {
+			return nil, apierr.InvalidField("passphrase", fmt.Sprintf("passphrase must be at least %d characters", MinPassphraseLength))
+		}
+		salt := make([]byte, saltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		bundle.Encryption = &Encryption{
+			KDF:    kdfScrypt,
+			Cipher: cipherAES256GCM,
+			Salt:   salt,
+			N:      scryptN,
+			R:      scryptR,
+			P:      scryptP,
+		}
+		var err error
+		if aead, err = bundle.Encryption.aead(passphrase); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, entry := range entries {
+		secrets := serviceSecrets{Revisions: make(map[string]map[string]model.EnvVars)}
+		serviceEntry := ServiceEntry{
+			Revisions: make([]model.Revision, 0, len(entry.Revisions)),
+		}
+		serviceEntry.Service, secrets.Current = stripSecrets(entry.Service)
+		for _, revision := range entry.Revisions {
+			stripped := *revision
+			var envFiles map[string]model.EnvVars
+			stripped.Service, envFiles = stripSecrets(&revision.Service)
+			if envFiles != nil {
+				secrets.Revisions[revision.ID] = envFiles
+			}
+			serviceEntry.Revisions = append(serviceEntry.Revisions, stripped)
+		}
+
+		if aead != nil {
+			sealed, err := seal(aead, secrets)
+			if err != nil {
+				return nil, err
+			}
+			serviceEntry.Secrets = sealed
+		}
+		bundle.Services = append(bundle.Services, serviceEntry)
+	}
+	return bundle, nil
+}
+
+// Open validates the bundle and returns its entries, with secrets decrypted
+// when the bundle is encrypted.
+func (b *Bundle) Open(passphrase string) ([]Entry, error) {
+	if b.Format != FormatName {
+		return nil, apierr.Newf(apierr.CodeInvalidArgument, "not a %s bundle", FormatName)
+	}
+	if b.Version != FormatVersion {
+		return nil, apierr.Newf(apierr.CodeInvalidArgument, "unsupported bundle version %d", b.Version)
+	}
+
+	var aead cipher.AEAD
+	if b.Encryption != nil {
+		if passphrase == "" {
+			return nil, apierr.New(apierr.CodeInvalidArgument, "bundle secrets are encrypted, a passphrase is required")
+		}
+		var err error
+		if aead, err = b.Encryption.aead(passphrase); err != nil {
+			return nil, err
+		}
+	}
+
+	seen := make(map[string]bool, len(b.Services))
+	entries := make([]Entry, 0, len(b.Services))
+	for _, serviceEntry := range b.Services {
+		service := serviceEntry.Service
+		name := service.Name.Name
+		switch {
+		case name == "":
+			return nil, apierr.New(apierr.CodeInvalidArgument, "bundle contains a service without a name")
+		case seen[name]:
+			return nil, apierr.Newf(apierr.CodeInvalidArgument, "bundle contains service %s more than once", name)
+		case strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, "."):
+			return nil, apierr.Newf(apierr.CodeInvalidArgument, "invalid service name %q", name)
+		case service.Version == "":
+			return nil, apierr.Newf(apierr.CodeInvalidArgument, "service %s has no version", name)
+		}
+		seen[name] = true
+
+		secrets := serviceSecrets{}
+		if aead != nil {
+			if err := open(aead, serviceEntry.Secrets, &secrets); err != nil {
+				return nil, apierr.New(apierr.CodeInvalidArgument, "failed to decrypt bundle secrets, check the passphrase")
+			}
+		}
+
+		entry := Entry{
+			Service:   restoreSecrets(service, secrets.Current),
+			Revisions: make([]*model.Revision, 0, len(serviceEntry.Revisions)),
+		}
+		for _, revision := range serviceEntry.Revisions {
+			revision.Service = *restoreSecrets(revision.Service, secrets.Revisions[revision.ID])
+			entry.Revisions = append(entry.Revisions, &revision)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// stripSecrets returns a copy of service without env files, and the env files.
+// Internal state isn't portable, so it is dropped too.
+func stripSecrets(service *model.Service) (model.Service, map[string]model.EnvVars) {
+	stripped := *service
+	stripped.ReleasePullRequest = nil
+	stripped.GitRepoFilePath = ""
+	if service.Configuration.DockerCompose == nil {
+		return stripped, nil
+	}
+	compose := *service.Configuration.DockerCompose
+	envFiles := compose.EnvFiles
+	compose.EnvFiles = nil
+	stripped.Configuration.DockerCompose = &compose
+	return stripped, envFiles
+}
+
+func restoreSecrets(service model.Service, envFiles map[string]model.EnvVars) *model.Service {
+	if service.Configuration.DockerCompose != nil && envFiles != nil {
+		compose := *service.Configuration.DockerCompose
+		compose.EnvFiles = envFiles
+		service.Configuration.DockerCompose = &compose
+	}
+	return &service
+}
+
+func (e *Encryption) aead(passphrase string) (cipher.AEAD, error) {
+	if e.KDF != kdfScrypt || e.Cipher != cipherAES256GCM {
+		return nil, apierr.Newf(apierr.CodeInvalidArgument, "unsupported bundle encryption %s/%s", e.KDF, e.Cipher)
+	}
+	// The parameters come from the bundle, so bound the work they can ask for
+	if e.N > maxScryptN || e.R > maxScryptR || e.P > maxScryptP {
+		return nil, apierr.New(apierr.CodeInvalidArgument, "bundle encryption parameters are too expensive")
+	}
+	key, err := scrypt.Key([]byte(passphrase), e.Salt, e.N, e.R, e.P, keyLength)
+	if err != nil {
+		return nil, apierr.Newf(apierr.CodeInvalidArgument, "invalid bundle encryption parameters: %s", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts value, prefixing the random nonce.
+func seal(aead cipher.AEAD, value any) ([]byte, error) {
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte, value any) error {
+	if len(sealed) < aead.NonceSize() {
+		return fmt.Errorf("sealed value too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(plaintext, value)
+}
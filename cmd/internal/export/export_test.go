@@ -0,0 +1,168 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+)
+
+const testPassphrase = "correct horse battery staple"
+
+func composeService(name string, version string, token string) *model.Service {
+	return &model.Service{
+		Name:      model.Name{Name: name},
+		ID:        name + "-id",
+		Version:   version,
+		GitSSHUrl: "git@github.com:org/" + name + ".git",
+		Configuration: model.ServiceConfiguration{DockerCompose: &model.DockerComposeConfiguration{
+			EnvFiles: map[string]model.EnvVars{".env": {"TOKEN": token}},
+		}},
+		GitRepoFilePath:    "/var/lib/deployment-service/" + name + "/repo",
+		ReleasePullRequest: &model.ReleasePullRequest{},
+	}
+}
+
+func testEntries() []Entry {
+	current := composeService("compose", "v2", "current-secret")
+	return []Entry{{
+		Service: current,
+		Revisions: []*model.Revision{
+			{ID: "v1", Service: *composeService("compose", "v1", "old-secret")},
+			{ID: "v2", Service: *current},
+		},
+	}}
+}
+
+// roundTrip exports entries and opens the bundle as an import would, after
+// it has been through JSON.
+func roundTrip(t *testing.T, entries []Entry, exportPassphrase string, importPassphrase string) ([]Entry, []byte, error) {
+	t.Helper()
+	bundle, err := NewBundle(entries, exportPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var imported Bundle
+	if err := json.Unmarshal(data, &imported); err != nil {
+		t.Fatal(err)
+	}
+	opened, err := imported.Open(importPassphrase)
+	return opened, data, err
+}
+
+func token(service *model.Service) any {
+	if service.Configuration.DockerCompose == nil {
+		return nil
+	}
+	return service.Configuration.DockerCompose.EnvFiles[".env"]["TOKEN"]
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	opened, data, err := roundTrip(t, testEntries(), testPassphrase, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"current-secret", "old-secret"} {
+		if bytes.Contains(data, []byte(secret)) {
+			t.Fatalf("expected %s to be encrypted in the bundle, got %s", secret, data)
+		}
+	}
+
+	if len(opened) != 1 || len(opened[0].Revisions) != 2 {
+		t.Fatalf("expected the service with 2 revisions, got %+v", opened)
+	}
+	service := opened[0].Service
+	if service.Name.Name != "compose" || service.Version != "v2" || token(service) != "current-secret" {
+		t.Fatalf("expected the service with its env files, got %+v", service)
+	}
+	for i, expected := range []string{"old-secret", "current-secret"} {
+		if got := token(&opened[0].Revisions[i].Service); got != expected {
+			t.Fatalf("expected revision %d to have token %s, got %v", i, expected, got)
+		}
+	}
+	// Internal state isn't exported
+	if service.GitRepoFilePath != "" || service.ReleasePullRequest != nil {
+		t.Fatalf("expected internal state to be dropped, got %+v", service)
+	}
+}
+
+func TestRoundTripWithoutPassphraseLeavesOutSecrets(t *testing.T) {
+	opened, data, err := roundTrip(t, testEntries(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Fatalf("expected no secrets in the bundle, got %s", data)
+	}
+	if got := token(opened[0].Service); got != nil {
+		t.Fatalf("expected no env files, got %v", got)
+	}
+	if opened[0].Service.Configuration.DockerCompose == nil {
+		t.Fatal("expected the rest of the configuration to be kept")
+	}
+}
+
+func TestOpenRejectsWrongPassphrase(t *testing.T) {
+	for _, passphrase := range []string{"", "the wrong passphrase"} {
+		if _, _, err := roundTrip(t, testEntries(), testPassphrase, passphrase); !apierr.HasCode(err, apierr.CodeInvalidArgument) {
+			t.Fatalf("expected %s for passphrase %q, got %v", apierr.CodeInvalidArgument, passphrase, err)
+		}
+	}
+}
+
+func TestNewBundleRejectsShortPassphrase(t *testing.T) {
+	if _, err := NewBundle(testEntries(), "too short"); !apierr.HasCode(err, apierr.CodeInvalidArgument) {
+		t.Fatalf("expected %s, got %v", apierr.CodeInvalidArgument, err)
+	}
+}
+
+func TestOpenRejectsInvalidBundles(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		modify func(bundle *Bundle)
+	}{
+		{name: "format", modify: func(b *Bundle) { b.Format = "something-else" }},
+		{name: "version", modify: func(b *Bundle) { b.Version = FormatVersion + 1 }},
+		{name: "expensive key derivation", modify: func(b *Bundle) { b.Encryption.N = maxScryptN * 2 }},
+		{name: "unknown cipher", modify: func(b *Bundle) { b.Encryption.Cipher = "rot13" }},
+		{name: "duplicate service", modify: func(b *Bundle) { b.Services = append(b.Services, b.Services[0]) }},
+		{name: "path in name", modify: func(b *Bundle) { b.Services[0].Service.Name.Name = "../compose" }},
+		{name: "no version", modify: func(b *Bundle) { b.Services[0].Service.Version = "" }},
+		{name: "tampered secrets", modify: func(b *Bundle) {
+			b.Services[0].Secrets[len(b.Services[0].Secrets)-1] ^= 1
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			bundle, err := NewBundle(testEntries(), testPassphrase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.modify(bundle)
+			if _, err := bundle.Open(testPassphrase); !apierr.HasCode(err, apierr.CodeInvalidArgument) {
+				t.Fatalf("expected %s, got %v", apierr.CodeInvalidArgument, err)
+			}
+		})
+	}
+}
+
+func TestConflictPolicyFromExternal(t *testing.T) {
+	for external, expected := range map[string]ConflictPolicy{
+		"":          ConflictFail,
+		"fail":      ConflictFail,
+		"skip":      ConflictSkip,
+		"overwrite": ConflictOverwrite,
+	} {
+		if got, err := ConflictPolicyFromExternal(external); err != nil || got != expected {
+			t.Fatalf("expected %q to be %s, got %s, %v", external, expected, got, err)
+		}
+	}
+	if _, err := ConflictPolicyFromExternal("merge"); !apierr.HasCode(err, apierr.CodeInvalidArgument) {
+		t.Fatalf("expected %s, got %v", apierr.CodeInvalidArgument, err)
+	}
+}
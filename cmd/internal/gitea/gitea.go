@@ -0,0 +1,174 @@
+package gitea
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/commitstatus"
+	"github.com/ansonallard/deployment-service/cmd/internal/pullrequest"
+)
+
+const requestTimeout = 30 * time.Second
+
+// GiteaClient talks to the Gitea REST API. Only the calls the release flow
+// needs are implemented, so it uses net/http rather than the Gitea SDK.
+type GiteaClient interface {
+	pullrequest.Provider
+	commitstatus.Poster
+	// Host is the host name repos on this instance are cloned from.
+	Host() string
+}
+
+type Config struct {
+	// URL is the base URL of the Gitea instance, e.g. https://gitea.example.com
+	URL   string
+	Token string
+}
+
+type giteaClient struct {
+	baseURL    *url.URL
+	token      string
+	httpClient *http.Client
+}
+
+func NewGiteaClient(config Config) (GiteaClient, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("url not provided")
+	}
+	if config.Token == "" {
+		return nil, fmt.Errorf("token not provided")
+	}
+	baseURL, err := url.Parse(strings.TrimSuffix(config.URL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid gitea url: %w", err)
+	}
+	return &giteaClient{
+		baseURL:    baseURL,
+		token:      config.Token,
+		httpClient: &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+func (gc *giteaClient) Host() string {
+	return gc.baseURL.Hostname()
+}
+
+type createPullRequestBody struct {
+	Head  string `json:"head"`
+	Base  string `json:"base"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+type pullRequestResponse struct {
+	Number         int    `json:"number"`
+	HTMLURL        string `json:"html_url"`
+	State          string `json:"state"`
+	Merged         bool   `json:"merged"`
+	MergeCommitSHA string `json:"merge_commit_sha"`
+}
+
+func (gc *giteaClient) CreatePullRequest(ctx context.Context, repo pullrequest.Repository, opts pullrequest.CreateOptions) (*pullrequest.PullRequest, error) {
+	var pr pullRequestResponse
+	err := gc.do(ctx, http.MethodPost, fmt.Sprintf("repos/%s/%s/pulls", repo.Owner, repo.Name), createPullRequestBody{
+		Head:  opts.Head,
+		Base:  opts.Base,
+		Title: opts.Title,
+		Body:  opts.Body,
+	}, &pr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gitea pull request: %w", err)
+	}
+	return pr.toPullRequest(), nil
+}
+
+func (gc *giteaClient) GetPullRequest(ctx context.Context, repo pullrequest.Repository, number int) (*pullrequest.PullRequest, error) {
+	var pr pullRequestResponse
+	if err := gc.do(ctx, http.MethodGet, fmt.Sprintf("repos/%s/%s/pulls/%d", repo.Owner, repo.Name, number), nil, &pr); err != nil {
+		return nil, fmt.Errorf("failed to get gitea pull request %d: %w", number, err)
+	}
+	return pr.toPullRequest(), nil
+}
+
+type createCommitStatusBody struct {
+	State       string `json:"state"`
+	Context     string `json:"context"`
+	Description string `json:"description"`
+	TargetURL   string `json:"target_url,omitempty"`
+}
+
+func (gc *giteaClient) CreateCommitStatus(ctx context.Context, repo pullrequest.Repository, sha string, status commitstatus.Status) error {
+	err := gc.do(ctx, http.MethodPost, fmt.Sprintf("repos/%s/%s/statuses/%s", repo.Owner, repo.Name, sha), createCommitStatusBody{
+		State:       string(status.State),
+		Context:     status.Context,
+		Description: status.Description,
+		TargetURL:   status.TargetURL,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create gitea commit status: %w", err)
+	}
+	return nil
+}
+
+func (pr pullRequestResponse) toPullRequest() *pullrequest.PullRequest {
+	state := pullrequest.StateOpen
+	switch {
+	case pr.Merged:
+		state = pullrequest.StateMerged
+	case pr.State == "closed":
+		state = pullrequest.StateClosed
+	}
+	return &pullrequest.PullRequest{
+		Number:         pr.Number,
+		URL:            pr.HTMLURL,
+		State:          state,
+		MergeCommitSHA: pr.MergeCommitSHA,
+	}
+}
+
+func (gc *giteaClient) do(ctx context.Context, method, apiPath string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(bodyBytes)
+	}
+
+	endpoint := gc.baseURL.JoinPath("api", "v1", apiPath)
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("token %s", gc.token))
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := gc.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s returned %d: %s", method, endpoint.Path, resp.StatusCode, strings.TrimSpace(string(respBytes)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBytes, out)
+}
@@ -6,11 +6,15 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/ansonallard/deployment-service/cmd/internal/commitstatus"
+	"github.com/ansonallard/deployment-service/cmd/internal/pullrequest"
 	"github.com/google/go-github/v66/github"
 	"golang.org/x/oauth2"
 )
 
 type GitHubClient interface {
+	pullrequest.Provider
+	commitstatus.Poster
 	EnsureGithubRepoExists(ctx context.Context, repoName string) error
 	GetOwner() string
 	GetToken() string
@@ -67,3 +71,55 @@ func (gc *githubClient) EnsureGithubRepoExists(ctx context.Context, repoName str
 	}
 	return nil
 }
+
+func (gc *githubClient) CreatePullRequest(ctx context.Context, repo pullrequest.Repository, opts pullrequest.CreateOptions) (*pullrequest.PullRequest, error) {
+	pr, _, err := gc.client.PullRequests.Create(ctx, repo.Owner, repo.Name, &github.NewPullRequest{
+		Title: github.String(opts.Title),
+		Head:  github.String(opts.Head),
+		Base:  github.String(opts.Base),
+		Body:  github.String(opts.Body),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create github pull request: %w", err)
+	}
+	return toPullRequest(pr), nil
+}
+
+func (gc *githubClient) GetPullRequest(ctx context.Context, repo pullrequest.Repository, number int) (*pullrequest.PullRequest, error) {
+	pr, _, err := gc.client.PullRequests.Get(ctx, repo.Owner, repo.Name, number)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get github pull request %d: %w", number, err)
+	}
+	return toPullRequest(pr), nil
+}
+
+func (gc *githubClient) CreateCommitStatus(ctx context.Context, repo pullrequest.Repository, sha string, status commitstatus.Status) error {
+	repoStatus := &github.RepoStatus{
+		State:       github.String(string(status.State)),
+		Context:     github.String(status.Context),
+		Description: github.String(status.Description),
+	}
+	if status.TargetURL != "" {
+		repoStatus.TargetURL = github.String(status.TargetURL)
+	}
+	if _, _, err := gc.client.Repositories.CreateStatus(ctx, repo.Owner, repo.Name, sha, repoStatus); err != nil {
+		return fmt.Errorf("failed to create github commit status: %w", err)
+	}
+	return nil
+}
+
+func toPullRequest(pr *github.PullRequest) *pullrequest.PullRequest {
+	state := pullrequest.StateOpen
+	switch {
+	case pr.GetMerged():
+		state = pullrequest.StateMerged
+	case pr.GetState() == "closed":
+		state = pullrequest.StateClosed
+	}
+	return &pullrequest.PullRequest{
+		Number:         pr.GetNumber(),
+		URL:            pr.GetHTMLURL(),
+		State:          state,
+		MergeCommitSHA: pr.GetMergeCommitSHA(),
+	}
+}
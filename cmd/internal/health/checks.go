@@ -0,0 +1,85 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/moby/moby/client"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// DockerPing checks that the Docker daemon dockerClient talks to answers.
+func DockerPing(name string, dockerClient client.APIClient) Check {
+	return Check{
+		Name: name,
+		Run: func(ctx context.Context) (string, error) {
+			ping, err := dockerClient.Ping(ctx, client.PingOptions{})
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s, API version %s", dockerClient.DaemonHost(), ping.APIVersion), nil
+		},
+	}
+}
+
+// WritableDir checks that files can be created in dir and that its file
+// system has at least minFreeBytes free.
+func WritableDir(name string, dir string, minFreeBytes uint64) Check {
+	return Check{
+		Name: name,
+		Run: func(ctx context.Context) (string, error) {
+			file, err := os.CreateTemp(dir, ".healthcheck-*")
+			if err != nil {
+				return "", fmt.Errorf("%s isn't writable: %w", dir, err)
+			}
+			file.Close()
+			os.Remove(file.Name())
+
+			free, err := freeBytes(dir)
+			if err != nil {
+				return "", fmt.Errorf("failed to get free space of %s: %w", dir, err)
+			}
+			if free < minFreeBytes {
+				return "", fmt.Errorf("%s has %d bytes free, below the minimum of %d", dir, free, minFreeBytes)
+			}
+			return fmt.Sprintf("%d bytes free", free), nil
+		},
+	}
+}
+
+// SSHKey checks that the unencrypted private key at keyPath can be loaded.
+func SSHKey(name string, keyPath string) Check {
+	return Check{
+		Name: name,
+		Run: func(ctx context.Context) (string, error) {
+			pem, err := os.ReadFile(keyPath)
+			if err != nil {
+				return "", err
+			}
+			signer, err := gossh.ParsePrivateKey(pem)
+			if err != nil {
+				return "", fmt.Errorf("failed to parse %s: %w", keyPath, err)
+			}
+			return fmt.Sprintf("%s %s", signer.PublicKey().Type(), gossh.FingerprintSHA256(signer.PublicKey())), nil
+		},
+	}
+}
+
+// Executable checks that the file at path exists and can be executed.
+func Executable(name string, path string) Check {
+	return Check{
+		Name: name,
+		Run: func(ctx context.Context) (string, error) {
+			info, err := os.Stat(path)
+			if err != nil {
+				return "", err
+			}
+			if info.IsDir() || info.Mode().Perm()&0111 == 0 {
+				return "", fmt.Errorf("%s isn't executable", path)
+			}
+			return filepath.Clean(path), nil
+		},
+	}
+}
@@ -0,0 +1,175 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/service"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("deployment-service.health")
+
+// How long a single check may take before it counts as failed
+const checkTimeout = 5 * time.Second
+
+type Status string
+
+const (
+	StatusOK     Status = "ok"
+	StatusFailed Status = "failed"
+)
+
+// Check is one dependency the service needs to do its work.
+type Check struct {
+	Name string
+	// Run returns details worth reporting when the dependency is fine
+	Run func(ctx context.Context) (string, error)
+}
+
+type CheckResult struct {
+	Name       string `json:"name"`
+	Status     Status `json:"status"`
+	Details    string `json:"details,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+type Report struct {
+	Status Status        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+type Diagnostics struct {
+	Report
+	Version       string         `json:"version"`
+	GoVersion     string         `json:"goVersion"`
+	StartedAt     time.Time      `json:"startedAt"`
+	UptimeSeconds int64          `json:"uptimeSeconds"`
+	Services      ServiceSummary `json:"services"`
+}
+
+// ServiceSummary counts services by the state of their pipeline.
+type ServiceSummary struct {
+	Total   int                         `json:"total"`
+	ByState map[model.PipelineState]int `json:"byState"`
+}
+
+type Checker interface {
+	// Ready runs every check.
+	Ready(ctx context.Context) Report
+	// Diagnostics runs every check and adds what the service is running and
+	// what it manages.
+	Diagnostics(ctx context.Context) (*Diagnostics, error)
+}
+
+type Config struct {
+	Checks    []Check
+	Service   service.DeploymentService
+	Version   string
+	StartedAt time.Time
+}
+
+func NewChecker(config Config) (Checker, error) {
+	if len(config.Checks) == 0 {
+		return nil, fmt.Errorf("checks not provided")
+	}
+	if config.Service == nil {
+		return nil, fmt.Errorf("service not provided")
+	}
+	if config.StartedAt.IsZero() {
+		return nil, fmt.Errorf("startedAt not provided")
+	}
+	return &checker{
+		checks:       config.Checks,
+		service:      config.Service,
+		version:      config.Version,
+		startedAt:    config.StartedAt,
+		checkTimeout: checkTimeout,
+	}, nil
+}
+
+type checker struct {
+	checks       []Check
+	service      service.DeploymentService
+	version      string
+	startedAt    time.Time
+	checkTimeout time.Duration
+}
+
+func (hc *checker) Ready(ctx context.Context) Report {
+	ctx, span := tracer.Start(ctx, "health.ready")
+	defer span.End()
+
+	report := Report{Status: StatusOK, Checks: make([]CheckResult, len(hc.checks))}
+	// Run together, so one slow dependency doesn't add up with the others
+	var wg sync.WaitGroup
+	for i, check := range hc.checks {
+		wg.Go(func() {
+			report.Checks[i] = hc.run(ctx, check)
+		})
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusOK {
+			report.Status = StatusFailed
+			span.SetAttributes(attribute.String("health.failed_check", result.Name))
+		}
+	}
+	return report
+}
+
+func (hc *checker) run(ctx context.Context, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, hc.checkTimeout)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "health.check",
+		trace.WithAttributes(attribute.String("health.check", check.Name)),
+	)
+	defer span.End()
+
+	start := time.Now()
+	details, err := check.Run(ctx)
+	result := CheckResult{
+		Name:       check.Name,
+		Status:     StatusOK,
+		Details:    details,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		span.RecordError(err)
+		result.Status = StatusFailed
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func (hc *checker) Diagnostics(ctx context.Context) (*Diagnostics, error) {
+	ctx, span := tracer.Start(ctx, "health.diagnostics")
+	defer span.End()
+
+	services, _, err := hc.service.List(ctx, model.ListOptions{MaxResults: math.MaxInt})
+	if err != nil {
+		return nil, err
+	}
+	summary := ServiceSummary{Total: len(services), ByState: make(map[model.PipelineState]int)}
+	for _, s := range services {
+		summary.ByState[s.DeploymentStatus.EffectiveState(s)]++
+	}
+
+	return &Diagnostics{
+		Report:        hc.Ready(ctx),
+		Version:       hc.version,
+		GoVersion:     runtime.Version(),
+		StartedAt:     hc.startedAt,
+		UptimeSeconds: int64(time.Since(hc.startedAt).Seconds()),
+		Services:      summary,
+	}, nil
+}
@@ -0,0 +1,149 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/service"
+)
+
+// fakeService lists services for Diagnostics.
+type fakeService struct {
+	service.DeploymentService
+	services []*model.Service
+}
+
+func (f *fakeService) List(ctx context.Context, opts model.ListOptions) ([]*model.Service, string, error) {
+	return f.services, "", nil
+}
+
+func newTestChecker(t *testing.T, checks ...Check) *checker {
+	t.Helper()
+	c, err := NewChecker(Config{Checks: checks, Service: &fakeService{}, StartedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.(*checker)
+}
+
+func passing(name string) Check {
+	return Check{Name: name, Run: func(ctx context.Context) (string, error) { return name + " details", nil }}
+}
+
+func TestReadyReportsEachCheck(t *testing.T) {
+	hc := newTestChecker(t, passing("docker"), passing("disk"))
+	report := hc.Ready(context.Background())
+	if report.Status != StatusOK || len(report.Checks) != 2 {
+		t.Fatalf("expected both checks to pass, got %+v", report)
+	}
+	for i, name := range []string{"docker", "disk"} {
+		if result := report.Checks[i]; result.Name != name || result.Status != StatusOK || result.Details != name+" details" {
+			t.Fatalf("expected %s to pass with its details, got %+v", name, result)
+		}
+	}
+
+	hc = newTestChecker(t, passing("docker"), Check{Name: "disk", Run: func(ctx context.Context) (string, error) {
+		return "", errors.New("disk full")
+	}})
+	report = hc.Ready(context.Background())
+	if report.Status != StatusFailed {
+		t.Fatalf("expected a failing check to fail the report, got %+v", report)
+	}
+	if result := report.Checks[1]; result.Status != StatusFailed || result.Error != "disk full" {
+		t.Fatalf("expected the disk check to fail with its error, got %+v", result)
+	}
+	if report.Checks[0].Status != StatusOK {
+		t.Fatalf("expected the other check to pass, got %+v", report.Checks[0])
+	}
+}
+
+func TestReadyTimesOutSlowChecks(t *testing.T) {
+	hung := func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	hc := newTestChecker(t, Check{Name: "docker", Run: hung}, Check{Name: "git", Run: hung})
+	hc.checkTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	report := hc.Ready(context.Background())
+	// The checks run together, so they time out together
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the checks to time out after 50ms, took %s", elapsed)
+	}
+	for _, result := range report.Checks {
+		if result.Status != StatusFailed || !strings.Contains(result.Error, context.DeadlineExceeded.Error()) {
+			t.Fatalf("expected %s to time out, got %+v", result.Name, result)
+		}
+	}
+	if report.Status != StatusFailed {
+		t.Fatalf("expected the report to fail, got %s", report.Status)
+	}
+}
+
+func TestDiagnosticsSummarizesServices(t *testing.T) {
+	hc := newTestChecker(t, passing("docker"))
+	hc.version = "1.2.3"
+	hc.service = &fakeService{services: []*model.Service{
+		{DeploymentStatus: &model.DeploymentStatus{State: model.PipelineStateIdle}},
+		{DeploymentStatus: &model.DeploymentStatus{State: model.PipelineStateIdle}},
+		{
+			DeploymentStatus:   &model.DeploymentStatus{State: model.PipelineStateIdle},
+			ReleasePullRequest: &model.ReleasePullRequest{},
+		},
+	}}
+	diagnostics, err := hc.Diagnostics(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diagnostics.Status != StatusOK || diagnostics.Version != "1.2.3" || diagnostics.Checks[0].Details != "docker details" {
+		t.Fatalf("expected the checks with their details and the version, got %+v", diagnostics)
+	}
+	summary := diagnostics.Services
+	if summary.Total != 3 || summary.ByState[model.PipelineStateIdle] != 2 || summary.ByState[model.PipelineStateAwaitingApproval] != 1 {
+		t.Fatalf("expected 2 idle services and 1 awaiting approval, got %+v", summary)
+	}
+}
+
+func TestWritableDir(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := WritableDir("data", dir, 0).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("expected the check to clean up after itself, got %v", entries)
+	}
+	if _, err := WritableDir("data", filepath.Join(dir, "missing"), 0).Run(context.Background()); err == nil {
+		t.Fatal("expected a missing directory to fail")
+	}
+	if _, err := WritableDir("data", dir, math.MaxUint64).Run(context.Background()); err == nil {
+		t.Fatal("expected too little free space to fail")
+	}
+}
+
+func TestExecutable(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "build.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	notExecutable := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(notExecutable, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Executable("build", script).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{notExecutable, dir, filepath.Join(dir, "missing")} {
+		if _, err := Executable("build", path).Run(context.Background()); err == nil {
+			t.Fatalf("expected %s not to count as executable", path)
+		}
+	}
+}
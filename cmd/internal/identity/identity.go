@@ -0,0 +1,24 @@
+package identity
+
+import "context"
+
+// System is the caller recorded for changes the service makes on its own,
+// e.g. during startup checks.
+const System = "system"
+
+type callerKey struct{}
+
+// WithCaller returns a ctx identifying who made the request.
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFromContext returns the caller set by WithCaller, or System when the
+// ctx didn't come from an API request.
+func CallerFromContext(ctx context.Context) string {
+	caller, ok := ctx.Value(callerKey{}).(string)
+	if !ok || caller == "" {
+		return System
+	}
+	return caller
+}
@@ -0,0 +1,439 @@
+package knownhosts
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var tracer = otel.Tracer("deployment-service.knownhosts")
+
+const (
+	defaultSSHPort = "22"
+	scanUser       = "git"
+	scanTimeout    = 10 * time.Second
+	// Prefix of hashed host patterns, the only hashing scheme OpenSSH has
+	hashedHostPrefix = "|1|"
+)
+
+var errHostKeyCaptured = errors.New("host key captured")
+
+// The host key algorithm families offered when scanning a host. go-git picks
+// whichever algorithm the server prefers, so every family the host supports
+// is trusted on first use, like `ssh-keyscan`.
+var scanHostKeyAlgorithms = [][]string{
+	{ssh.KeyAlgoED25519},
+	{ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521},
+	{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256},
+}
+
+// HostKey is a single known_hosts entry.
+type HostKey struct {
+	Hosts       []string
+	KeyType     string
+	Fingerprint string
+	PublicKey   string
+}
+
+// KnownHosts manages the known_hosts file used to verify every SSH remote the
+// service clones from, pulls from and pushes to.
+type KnownHosts interface {
+	// HostKeyCallback always checks against the current contents of the
+	// file, so keys added or removed at runtime apply to the next connection.
+	HostKeyCallback() ssh.HostKeyCallback
+	List(ctx context.Context) ([]HostKey, error)
+	// Add trusts publicKey for host. When publicKey is empty the keys the
+	// host presents now are trusted (trust on first use).
+	Add(ctx context.Context, host string, publicKey string) ([]HostKey, error)
+	// Remove deletes every key for host, or only keys of keyType if set.
+	Remove(ctx context.Context, host string, keyType string) error
+}
+
+type Config struct {
+	FilePath string
+}
+
+type knownHosts struct {
+	filePath string
+	mu       sync.RWMutex
+	callback ssh.HostKeyCallback
+}
+
+// New creates the known_hosts file if it doesn't exist. An empty file means
+// every SSH remote is refused until its host key is added.
+func New(config Config) (KnownHosts, error) {
+	if config.FilePath == "" {
+		return nil, fmt.Errorf("filePath not provided")
+	}
+	f, err := os.OpenFile(config.FilePath, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open known_hosts: %w", err)
+	}
+	f.Close()
+
+	kh := &knownHosts{filePath: config.FilePath}
+	if err := kh.reload(); err != nil {
+		return nil, err
+	}
+	return kh, nil
+}
+
+func (kh *knownHosts) reload() error {
+	callback, err := knownhosts.New(kh.filePath)
+	if err != nil {
+		return fmt.Errorf("failed to load known_hosts: %w", err)
+	}
+	kh.callback = callback
+	return nil
+}
+
+func (kh *knownHosts) HostKeyCallback() ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		kh.mu.RLock()
+		callback := kh.callback
+		kh.mu.RUnlock()
+
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			host := knownhosts.Normalize(hostname)
+			if len(keyErr.Want) == 0 {
+				return fmt.Errorf(
+					"host key verification failed: %s (%s %s) is not a known host, add it to known_hosts before using it",
+					host, key.Type(), ssh.FingerprintSHA256(key),
+				)
+			}
+			if !slices.ContainsFunc(keyErr.Want, func(k knownhosts.KnownKey) bool { return k.Key.Type() == key.Type() }) {
+				return fmt.Errorf(
+					"host key verification failed: %s presented a %s key, but no key of that type is trusted for it, add it to known_hosts before using it",
+					host, key.Type(),
+				)
+			}
+			return fmt.Errorf(
+				"host key verification failed: host key for %s has changed to %s %s, remove the old key if this change is expected",
+				host, key.Type(), ssh.FingerprintSHA256(key),
+			)
+		}
+		return err
+	}
+}
+
+func (kh *knownHosts) List(ctx context.Context) ([]HostKey, error) {
+	_, span := tracer.Start(ctx, "knownhosts.list")
+	defer span.End()
+
+	kh.mu.RLock()
+	defer kh.mu.RUnlock()
+
+	lines, err := kh.readLines()
+	if err != nil {
+		return nil, err
+	}
+	hostKeys := make([]HostKey, 0, len(lines))
+	for _, line := range lines {
+		if line.hostKey != nil {
+			hostKeys = append(hostKeys, *line.hostKey)
+		}
+	}
+	return hostKeys, nil
+}
+
+func (kh *knownHosts) Add(ctx context.Context, host string, publicKey string) ([]HostKey, error) {
+	ctx, span := tracer.Start(ctx, "knownhosts.add",
+		trace.WithAttributes(
+			attribute.String("host", host),
+			attribute.Bool("trust_on_first_use", publicKey == ""),
+		),
+	)
+	defer span.End()
+
+	if host == "" {
+		return nil, apierr.New(apierr.CodeInvalidArgument, "host is required")
+	}
+	address := withDefaultPort(host)
+
+	var keys []ssh.PublicKey
+	if publicKey == "" {
+		scanned, err := scanHostKeys(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+		keys = scanned
+	} else {
+		parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+		if err != nil {
+			return nil, apierr.Newf(apierr.CodeInvalidArgument, "invalid public key: %s", err)
+		}
+		keys = []ssh.PublicKey{parsed}
+	}
+
+	kh.mu.Lock()
+	defer kh.mu.Unlock()
+
+	normalized := knownhosts.Normalize(address)
+	lines, err := kh.readLines()
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeys := make([]HostKey, 0, len(keys))
+	var newLines []string
+	for _, key := range keys {
+		existing, err := findHostKey(lines, normalized, key)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			hostKeys = append(hostKeys, *existing)
+			continue
+		}
+		newLines = append(newLines, knownhosts.Line([]string{normalized}, key))
+		hostKeys = append(hostKeys, *newHostKey([]string{normalized}, key))
+	}
+	if len(newLines) == 0 {
+		return hostKeys, nil
+	}
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line.raw)
+		buf.WriteString("\n")
+	}
+	for _, line := range newLines {
+		buf.WriteString(line)
+		buf.WriteString("\n")
+	}
+	if err := kh.writeFile(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return hostKeys, nil
+}
+
+// findHostKey returns the existing entry if key is already trusted for host,
+// and a conflict if a different key of the same type is.
+func findHostKey(lines []knownHostsLine, host string, key ssh.PublicKey) (*HostKey, error) {
+	for _, line := range lines {
+		if line.hostKey == nil || !matchesHost(line.hostKey.Hosts, host) {
+			continue
+		}
+		if line.hostKey.KeyType != key.Type() {
+			continue
+		}
+		if line.hostKey.PublicKey == serializeKey(key) {
+			return line.hostKey, nil
+		}
+		return nil, apierr.New(apierr.CodeConflict,
+			fmt.Sprintf("a different %s key is already trusted for %s, remove it first", key.Type(), host),
+		)
+	}
+	return nil, nil
+}
+
+func (kh *knownHosts) Remove(ctx context.Context, host string, keyType string) error {
+	_, span := tracer.Start(ctx, "knownhosts.remove",
+		trace.WithAttributes(
+			attribute.String("host", host),
+			attribute.String("key_type", keyType),
+		),
+	)
+	defer span.End()
+
+	normalized := knownhosts.Normalize(withDefaultPort(host))
+
+	kh.mu.Lock()
+	defer kh.mu.Unlock()
+
+	lines, err := kh.readLines()
+	if err != nil {
+		return err
+	}
+
+	removed := false
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if line.hostKey != nil && matchesHost(line.hostKey.Hosts, normalized) &&
+			(keyType == "" || line.hostKey.KeyType == keyType) {
+			removed = true
+			continue
+		}
+		buf.WriteString(line.raw)
+		buf.WriteString("\n")
+	}
+	if !removed {
+		return apierr.Newf(apierr.CodeNotFound, "no known host key for %s", normalized)
+	}
+	return kh.writeFile(buf.Bytes())
+}
+
+// matchesHost reports whether the normalized host is one of an entry's host
+// patterns. Hashed patterns, written by `ssh-keyscan -H` or ssh with
+// HashKnownHosts, are matched by hashing host with the pattern's salt.
+func matchesHost(patterns []string, host string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		salt, hash, ok := parseHashedHost(pattern)
+		if !ok {
+			return pattern == host
+		}
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(host))
+		return hmac.Equal(mac.Sum(nil), hash)
+	})
+}
+
+// parseHashedHost splits a hashed host pattern, |1|<salt>|<hash>.
+func parseHashedHost(pattern string) (salt []byte, hash []byte, ok bool) {
+	encoded, ok := strings.CutPrefix(pattern, hashedHostPrefix)
+	if !ok {
+		return nil, nil, false
+	}
+	encodedSalt, encodedHash, ok := strings.Cut(encoded, "|")
+	if !ok {
+		return nil, nil, false
+	}
+	salt, err := base64.StdEncoding.DecodeString(encodedSalt)
+	if err != nil {
+		return nil, nil, false
+	}
+	hash, err = base64.StdEncoding.DecodeString(encodedHash)
+	if err != nil {
+		return nil, nil, false
+	}
+	return salt, hash, true
+}
+
+type knownHostsLine struct {
+	raw string
+	// nil for comments, blank lines and markers we don't manage
+	hostKey *HostKey
+}
+
+func (kh *knownHosts) readLines() ([]knownHostsLine, error) {
+	fileBytes, err := os.ReadFile(kh.filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+
+	lines := make([]knownHostsLine, 0)
+	for _, raw := range strings.Split(strings.TrimRight(string(fileBytes), "\n"), "\n") {
+		line := knownHostsLine{raw: raw}
+		trimmed := strings.TrimSpace(raw)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			marker, hosts, key, _, _, err := ssh.ParseKnownHosts([]byte(raw))
+			if err == nil && marker == "" {
+				line.hostKey = newHostKey(hosts, key)
+			}
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 1 && lines[0].raw == "" {
+		return lines[:0], nil
+	}
+	return lines, nil
+}
+
+// writeFile replaces the file atomically and reloads the callback. Callers
+// must hold the write lock.
+func (kh *knownHosts) writeFile(contents []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(kh.filePath), ".known_hosts-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write known_hosts: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write known_hosts: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), kh.filePath); err != nil {
+		return fmt.Errorf("failed to replace known_hosts: %w", err)
+	}
+	return kh.reload()
+}
+
+// scanHostKeys collects the host key for each algorithm family address
+// supports.
+func scanHostKeys(ctx context.Context, address string) ([]ssh.PublicKey, error) {
+	keys := make([]ssh.PublicKey, 0, len(scanHostKeyAlgorithms))
+	var lastErr error
+	for _, algorithms := range scanHostKeyAlgorithms {
+		key, err := scanHostKey(ctx, address, algorithms)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, apierr.Newf(apierr.CodeInvalidArgument, "failed to read host key from %s: %s", address, lastErr)
+	}
+	return keys, nil
+}
+
+// scanHostKey connects to address just far enough to receive its host key,
+// then aborts the handshake.
+func scanHostKey(ctx context.Context, address string, algorithms []string) (ssh.PublicKey, error) {
+	var captured ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User: scanUser,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			captured = key
+			return errHostKeyCaptured
+		},
+		HostKeyAlgorithms: algorithms,
+		Timeout:           scanTimeout,
+	}
+
+	dialer := net.Dialer{Timeout: scanTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_, _, _, err = ssh.NewClientConn(conn, address, config)
+	if captured == nil {
+		return nil, err
+	}
+	return captured, nil
+}
+
+func withDefaultPort(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), defaultSSHPort)
+}
+
+func serializeKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+func newHostKey(hosts []string, key ssh.PublicKey) *HostKey {
+	return &HostKey{
+		Hosts:       hosts,
+		KeyType:     key.Type(),
+		Fingerprint: ssh.FingerprintSHA256(key),
+		PublicKey:   serializeKey(key),
+	}
+}
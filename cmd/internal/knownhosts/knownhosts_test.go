@@ -0,0 +1,241 @@
+package knownhosts
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var testRemote = &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func newKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	return newSigner(t).PublicKey()
+}
+
+// newTestKnownHosts returns known hosts over a file holding lines.
+func newTestKnownHosts(t *testing.T, lines ...string) (KnownHosts, string) {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), "known_hosts")
+	if len(lines) > 0 {
+		if err := os.WriteFile(filePath, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kh, err := New(Config{FilePath: filePath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kh, filePath
+}
+
+func readFile(t *testing.T, filePath string) string {
+	t.Helper()
+	fileBytes, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(fileBytes)
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	kh, _ := newTestKnownHosts(t)
+	callback := kh.HostKeyCallback()
+	key := newKey(t)
+
+	err := callback("github.com:22", testRemote, key)
+	if err == nil || !strings.Contains(err.Error(), "is not a known host") {
+		t.Fatalf("expected an unknown host to be refused, got %v", err)
+	}
+
+	if _, err := kh.Add(context.Background(), "github.com", serializeKey(key)); err != nil {
+		t.Fatal(err)
+	}
+	// The callback picks up keys added after it was created
+	if err := callback("github.com:22", testRemote, key); err != nil {
+		t.Fatalf("expected the added key to be accepted, got %v", err)
+	}
+
+	err = callback("github.com:22", testRemote, newKey(t))
+	if err == nil || !strings.Contains(err.Error(), "has changed") {
+		t.Fatalf("expected a changed key to be refused, got %v", err)
+	}
+	if err := callback("gitea.local:22", testRemote, key); err == nil {
+		t.Fatal("expected the key not to be trusted for another host")
+	}
+}
+
+func TestAddAndRemove(t *testing.T) {
+	kh, filePath := newTestKnownHosts(t, "# managed by deployment-service")
+	ctx := context.Background()
+	key := newKey(t)
+
+	added, err := kh.Add(ctx, "gitea.local:2222", serializeKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 1 || added[0].Hosts[0] != "[gitea.local]:2222" || added[0].Fingerprint != ssh.FingerprintSHA256(key) {
+		t.Fatalf("unexpected added keys %+v", added)
+	}
+	// Adding the same key again changes nothing
+	before := readFile(t, filePath)
+	if _, err := kh.Add(ctx, "gitea.local:2222", serializeKey(key)); err != nil {
+		t.Fatal(err)
+	}
+	if after := readFile(t, filePath); after != before {
+		t.Fatalf("expected the file to be unchanged, got %q", after)
+	}
+	if _, err := kh.Add(ctx, "gitea.local:2222", serializeKey(newKey(t))); !apierr.HasCode(err, apierr.CodeConflict) {
+		t.Fatalf("expected %s for a second key of the same type, got %v", apierr.CodeConflict, err)
+	}
+
+	listed, err := kh.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].PublicKey != serializeKey(key) {
+		t.Fatalf("expected the added key to be listed, got %+v", listed)
+	}
+
+	if err := kh.Remove(ctx, "gitea.local:2222", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := kh.HostKeyCallback()("gitea.local:2222", testRemote, key); err == nil {
+		t.Fatal("expected the removed key to be refused")
+	}
+	if err := kh.Remove(ctx, "gitea.local:2222", ""); !apierr.HasCode(err, apierr.CodeNotFound) {
+		t.Fatalf("expected %s, got %v", apierr.CodeNotFound, err)
+	}
+	// Lines that aren't host keys are kept
+	if contents := readFile(t, filePath); contents != "# managed by deployment-service\n" {
+		t.Fatalf("expected only the comment to be left, got %q", contents)
+	}
+}
+
+func TestAddRejectsInvalidInput(t *testing.T) {
+	kh, _ := newTestKnownHosts(t)
+	if _, err := kh.Add(context.Background(), "", serializeKey(newKey(t))); !apierr.HasCode(err, apierr.CodeInvalidArgument) {
+		t.Fatalf("expected %s for a missing host, got %v", apierr.CodeInvalidArgument, err)
+	}
+	if _, err := kh.Add(context.Background(), "github.com", "ssh-ed25519 not-a-key"); !apierr.HasCode(err, apierr.CodeInvalidArgument) {
+		t.Fatalf("expected %s for a bad key, got %v", apierr.CodeInvalidArgument, err)
+	}
+}
+
+func TestHashedEntries(t *testing.T) {
+	key := newKey(t)
+	// As written by `ssh-keyscan -H github.com`
+	hashed := knownhosts.Line([]string{knownhosts.HashHostname("github.com")}, key)
+	kh, filePath := newTestKnownHosts(t, hashed)
+	ctx := context.Background()
+
+	if err := kh.HostKeyCallback()("github.com:22", testRemote, key); err != nil {
+		t.Fatalf("expected the hashed entry to be trusted, got %v", err)
+	}
+	if _, err := kh.Add(ctx, "github.com", serializeKey(key)); err != nil {
+		t.Fatal(err)
+	}
+	if contents := readFile(t, filePath); contents != hashed+"\n" {
+		t.Fatalf("expected the hashed entry to be recognised instead of duplicated, got %q", contents)
+	}
+	if _, err := kh.Add(ctx, "github.com", serializeKey(newKey(t))); !apierr.HasCode(err, apierr.CodeConflict) {
+		t.Fatalf("expected %s against the hashed entry, got %v", apierr.CodeConflict, err)
+	}
+	if err := kh.Remove(ctx, "gitea.local", ""); !apierr.HasCode(err, apierr.CodeNotFound) {
+		t.Fatalf("expected the hashed entry not to match another host, got %v", err)
+	}
+	if err := kh.Remove(ctx, "github.com", ""); err != nil {
+		t.Fatal(err)
+	}
+	if contents := readFile(t, filePath); contents != "" {
+		t.Fatalf("expected the hashed entry to be removed, got %q", contents)
+	}
+}
+
+func TestRemoveOnlyKeyType(t *testing.T) {
+	ed25519Key := newKey(t)
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, err := ssh.NewPublicKey(&private.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kh, _ := newTestKnownHosts(t,
+		knownhosts.Line([]string{"github.com"}, ed25519Key),
+		knownhosts.Line([]string{"github.com"}, ecdsaKey),
+	)
+	if err := kh.Remove(context.Background(), "github.com", ssh.KeyAlgoED25519); err != nil {
+		t.Fatal(err)
+	}
+	listed, err := kh.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].KeyType != ecdsaKey.Type() {
+		t.Fatalf("expected only the ecdsa key to be left, got %+v", listed)
+	}
+}
+
+// TestAddTrustsScannedKey runs an SSH server that only has an ed25519 host
+// key, so scanning for the other families fails.
+func TestAddTrustsScannedKey(t *testing.T) {
+	signer := newSigner(t)
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				// The scan aborts the handshake once it has the host key
+				_, _, _, _ = ssh.NewServerConn(conn, config)
+			}()
+		}
+	}()
+
+	kh, _ := newTestKnownHosts(t)
+	added, err := kh.Add(context.Background(), listener.Addr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 1 || added[0].PublicKey != serializeKey(signer.PublicKey()) {
+		t.Fatalf("expected the server's host key to be trusted, got %+v", added)
+	}
+	if err := kh.HostKeyCallback()(listener.Addr().String(), listener.Addr(), signer.PublicKey()); err != nil {
+		t.Fatalf("expected the scanned key to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Instruments are created on the global MeterProvider, which forwards them
+// to the provider InitMeterProvider sets.
+var meter = otel.Meter("deployment-service.metrics")
+
+// Stage is the step of a release a failure happened in.
+type Stage string
+
+const (
+	// StageCheck is resolving credentials and fetching the tracked branch
+	StageCheck   Stage = "check"
+	StageVersion Stage = "version"
+	// StageRelease is committing, tagging and pushing the release, or opening
+	// and merging its pull request
+	StageRelease Stage = "release"
+	StageBuild   Stage = "build"
+	StageDeploy  Stage = "deploy"
+)
+
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+// Buckets in seconds, from a quick fetch up to a long image build
+var durationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1200, 1800}
+
+var (
+	tickDuration = mustHistogram("deployment_service.tick.duration",
+		"Time a background processing tick took, per service and outcome")
+	versionDuration = mustHistogram("deployment_service.version.duration",
+		"Time calculating the next version of a service took")
+	buildDuration = mustHistogram("deployment_service.build.duration",
+		"Time building and publishing, or deploying, a service took, per service type")
+	pushDuration = mustHistogram("deployment_service.push.duration",
+		"Time pushing a Docker image to the registry took")
+	failures   = mustCounter("deployment_service.release.failures", "Failed releases, per service and stage")
+	queueDepth = mustUpDownCounter("deployment_service.queue.depth", "Ticks waiting on another operation on their service")
+)
+
+func mustHistogram(name string, description string) metric.Float64Histogram {
+	histogram, err := meter.Float64Histogram(name,
+		metric.WithDescription(description),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...),
+	)
+	if err != nil {
+		panic(err)
+	}
+	return histogram
+}
+
+func mustCounter(name string, description string) metric.Int64Counter {
+	counter, err := meter.Int64Counter(name, metric.WithDescription(description))
+	if err != nil {
+		panic(err)
+	}
+	return counter
+}
+
+func mustUpDownCounter(name string, description string) metric.Int64UpDownCounter {
+	counter, err := meter.Int64UpDownCounter(name, metric.WithDescription(description))
+	if err != nil {
+		panic(err)
+	}
+	return counter
+}
+
+func outcome(err error) string {
+	if err != nil {
+		return outcomeFailure
+	}
+	return outcomeSuccess
+}
+
+// RecordTick records one background processing tick of serviceName.
+func RecordTick(ctx context.Context, serviceName string, start time.Time, err error) {
+	tickDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+		attribute.String("service", serviceName),
+		attribute.String("outcome", outcome(err)),
+	))
+}
+
+// RecordVersionCalculation records calculating the next version of
+// serviceName.
+func RecordVersionCalculation(ctx context.Context, serviceName string, start time.Time, err error) {
+	versionDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+		attribute.String("service", serviceName),
+		attribute.String("outcome", outcome(err)),
+	))
+}
+
+// RecordBuild records building service, serviceType being its configuration
+// type, e.g. npm or dockerCompose.
+func RecordBuild(ctx context.Context, serviceName string, serviceType string, start time.Time, err error) {
+	buildDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+		attribute.String("service", serviceName),
+		attribute.String("service_type", serviceType),
+		attribute.String("outcome", outcome(err)),
+	))
+}
+
+// RecordPush records pushing an image of serviceName.
+func RecordPush(ctx context.Context, serviceName string, start time.Time, err error) {
+	pushDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+		attribute.String("service", serviceName),
+		attribute.String("outcome", outcome(err)),
+	))
+}
+
+// RecordFailure counts a release of serviceName failing in stage.
+func RecordFailure(ctx context.Context, serviceName string, stage Stage) {
+	failures.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("service", serviceName),
+		attribute.String("stage", string(stage)),
+	))
+}
+
+// Queued counts a tick as waiting until the returned func is called.
+func Queued(ctx context.Context) func() {
+	queueDepth.Add(ctx, 1)
+	return func() { queueDepth.Add(ctx, -1) }
+}
+
+// ServiceLister lists services with their deployment status. It is the part
+// of service.DeploymentService the service metrics need, which can't be
+// imported here since the releaser records to this package.
+type ServiceLister interface {
+	List(ctx context.Context, opts model.ListOptions) ([]*model.Service, string, error)
+}
+
+// RegisterServiceMetrics reports the deployment status of every service on
+// each collection: its pipeline state, and when it last deployed and failed.
+func RegisterServiceMetrics(ctx context.Context, services ServiceLister) error {
+	log := zerolog.Ctx(ctx)
+	if services == nil {
+		return fmt.Errorf("service not provided")
+	}
+	state, err := meter.Int64ObservableGauge("deployment_service.service.state",
+		metric.WithDescription("1 for the state the pipeline of a service is in, 0 for the others"),
+	)
+	if err != nil {
+		return err
+	}
+	lastDeployed, err := meter.Int64ObservableGauge("deployment_service.service.last_deployed_timestamp",
+		metric.WithDescription("Unix time the service last built and published, or deployed, successfully"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return err
+	}
+	lastFailure, err := meter.Int64ObservableGauge("deployment_service.service.last_failure_timestamp",
+		metric.WithDescription("Unix time the last run of the service failed"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return err
+	}
+
+	states := []model.PipelineState{
+		model.PipelineStateIdle,
+		model.PipelineStateQueued,
+		model.PipelineStateRunning,
+		model.PipelineStateFailed,
+		model.PipelineStateAwaitingApproval,
+	}
+	_, err = meter.RegisterCallback(func(ctx context.Context, observer metric.Observer) error {
+		list, _, err := services.List(ctx, model.ListOptions{MaxResults: math.MaxInt})
+		if err != nil {
+			// Failing the callback would drop every other metric of the scrape
+			log.Warn().Err(err).Msg("Failed to list services for metrics")
+			return nil
+		}
+		for _, s := range list {
+			serviceAttribute := attribute.String("service", s.Name.Name)
+			current := s.DeploymentStatus.EffectiveState(s)
+			for _, st := range states {
+				var value int64
+				if st == current {
+					value = 1
+				}
+				observer.ObserveInt64(state, value, metric.WithAttributes(serviceAttribute, attribute.String("state", string(st))))
+			}
+			if deployedAt := s.DeploymentStatus.LastDeployedAt; deployedAt != nil {
+				observer.ObserveInt64(lastDeployed, deployedAt.Unix(), metric.WithAttributes(serviceAttribute))
+			}
+			if failedAt := s.DeploymentStatus.LastErrorAt; failedAt != nil {
+				observer.ObserveInt64(lastFailure, failedAt.Unix(), metric.WithAttributes(serviceAttribute))
+			}
+		}
+		return nil
+	}, state, lastDeployed, lastFailure)
+	return err
+}
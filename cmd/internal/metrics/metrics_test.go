@@ -0,0 +1,202 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+)
+
+// metricsHandler serves what every test records. The global MeterProvider
+// can only be set once, so the tests share it.
+var metricsHandler http.Handler
+
+func TestMain(m *testing.M) {
+	handler, shutdown, err := InitMeterProvider(context.Background(), ProviderConfig{ServiceName: "deployment-service-test"})
+	if err != nil {
+		panic(err)
+	}
+	metricsHandler = handler
+	code := m.Run()
+	_ = shutdown(context.Background())
+	os.Exit(code)
+}
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	response := httptest.NewRecorder()
+	metricsHandler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// value returns the value of the sample of name with labels, which are
+// key="value" pairs, in the scraped metrics. ok is false without one.
+func value(t *testing.T, scraped string, name string, labels ...string) (float64, bool) {
+	t.Helper()
+lines:
+	for _, line := range strings.Split(scraped, "\n") {
+		if !strings.HasPrefix(line, name+"{") && !strings.HasPrefix(line, name+" ") {
+			continue
+		}
+		for _, label := range labels {
+			if !strings.Contains(line, label) {
+				continue lines
+			}
+		}
+		v, err := strconv.ParseFloat(line[strings.LastIndex(line, " ")+1:], 64)
+		if err != nil {
+			t.Fatalf("unexpected sample %q: %v", line, err)
+		}
+		return v, true
+	}
+	return 0, false
+}
+
+func TestRecordFailureCountsPerServiceAndStage(t *testing.T) {
+	ctx := context.Background()
+	RecordFailure(ctx, "failures-a", StageBuild)
+	RecordFailure(ctx, "failures-a", StageBuild)
+	RecordFailure(ctx, "failures-a", StageDeploy)
+	RecordFailure(ctx, "failures-b", StageBuild)
+
+	scraped := scrape(t)
+	for _, tt := range []struct {
+		service  string
+		stage    Stage
+		expected float64
+	}{
+		{service: "failures-a", stage: StageBuild, expected: 2},
+		{service: "failures-a", stage: StageDeploy, expected: 1},
+		{service: "failures-b", stage: StageBuild, expected: 1},
+	} {
+		got, ok := value(t, scraped, "deployment_service_release_failures_total", `service="`+tt.service+`"`, `stage="`+string(tt.stage)+`"`)
+		if !ok || got != tt.expected {
+			t.Fatalf("expected %v %s failures of %s, got %v (found: %t)", tt.expected, tt.stage, tt.service, got, ok)
+		}
+	}
+}
+
+func TestRecordDurationsPerOutcome(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now().Add(-2 * time.Second)
+	RecordTick(ctx, "durations", start, nil)
+	RecordTick(ctx, "durations", start, errors.New("fetch failed"))
+	RecordTick(ctx, "durations", start, errors.New("build failed"))
+	RecordBuild(ctx, "durations", "npm", start, nil)
+
+	scraped := scrape(t)
+	for _, tt := range []struct {
+		name     string
+		labels   []string
+		expected float64
+	}{
+		{name: "deployment_service_tick_duration_seconds_count", labels: []string{`service="durations"`, `outcome="success"`}, expected: 1},
+		{name: "deployment_service_tick_duration_seconds_count", labels: []string{`service="durations"`, `outcome="failure"`}, expected: 2},
+		{name: "deployment_service_build_duration_seconds_count", labels: []string{`service="durations"`, `service_type="npm"`, `outcome="success"`}, expected: 1},
+		// The ticks took about 2s each
+		{name: "deployment_service_tick_duration_seconds_bucket", labels: []string{`service="durations"`, `outcome="failure"`, `le="1"`}, expected: 0},
+		{name: "deployment_service_tick_duration_seconds_bucket", labels: []string{`service="durations"`, `outcome="failure"`, `le="2.5"`}, expected: 2},
+	} {
+		got, ok := value(t, scraped, tt.name, tt.labels...)
+		if !ok || got != tt.expected {
+			t.Fatalf("expected %s%v to be %v, got %v (found: %t)", tt.name, tt.labels, tt.expected, got, ok)
+		}
+	}
+}
+
+func TestQueuedTracksQueueDepth(t *testing.T) {
+	ctx := context.Background()
+	depth := func() float64 {
+		t.Helper()
+		got, ok := value(t, scrape(t), "deployment_service_queue_depth")
+		if !ok {
+			t.Fatal("expected a queue depth")
+		}
+		return got
+	}
+
+	// The gauge only appears once something was queued
+	before, _ := value(t, scrape(t), "deployment_service_queue_depth")
+	first := Queued(ctx)
+	second := Queued(ctx)
+	if got := depth(); got != before+2 {
+		t.Fatalf("expected a queue depth of %v, got %v", before+2, got)
+	}
+	first()
+	if got := depth(); got != before+1 {
+		t.Fatalf("expected a queue depth of %v, got %v", before+1, got)
+	}
+	second()
+	if got := depth(); got != before {
+		t.Fatalf("expected the queue depth back at %v, got %v", before, got)
+	}
+}
+
+type fakeLister struct {
+	services []*model.Service
+	err      error
+}
+
+func (f *fakeLister) List(ctx context.Context, opts model.ListOptions) ([]*model.Service, string, error) {
+	return f.services, "", f.err
+}
+
+func TestRegisterServiceMetrics(t *testing.T) {
+	deployedAt := time.Unix(1700000000, 0)
+	failedAt := time.Unix(1700000100, 0)
+	lister := &fakeLister{services: []*model.Service{
+		{
+			Name:             model.Name{Name: "service-metrics-a"},
+			DeploymentStatus: &model.DeploymentStatus{State: model.PipelineStateFailed, LastDeployedAt: &deployedAt, LastErrorAt: &failedAt},
+		},
+		{
+			Name:               model.Name{Name: "service-metrics-b"},
+			DeploymentStatus:   &model.DeploymentStatus{State: model.PipelineStateIdle},
+			ReleasePullRequest: &model.ReleasePullRequest{},
+		},
+	}}
+	if err := RegisterServiceMetrics(context.Background(), lister); err != nil {
+		t.Fatal(err)
+	}
+
+	scraped := scrape(t)
+	for _, tt := range []struct {
+		name     string
+		labels   []string
+		expected float64
+	}{
+		{name: "deployment_service_service_state", labels: []string{`service="service-metrics-a"`, `state="failed"`}, expected: 1},
+		{name: "deployment_service_service_state", labels: []string{`service="service-metrics-a"`, `state="idle"`}, expected: 0},
+		{name: "deployment_service_service_state", labels: []string{`service="service-metrics-b"`, `state="awaitingApproval"`}, expected: 1},
+		{name: "deployment_service_service_state", labels: []string{`service="service-metrics-b"`, `state="idle"`}, expected: 0},
+		{name: "deployment_service_service_last_deployed_timestamp_seconds", labels: []string{`service="service-metrics-a"`}, expected: 1700000000},
+		{name: "deployment_service_service_last_failure_timestamp_seconds", labels: []string{`service="service-metrics-a"`}, expected: 1700000100},
+	} {
+		got, ok := value(t, scraped, tt.name, tt.labels...)
+		if !ok || got != tt.expected {
+			t.Fatalf("expected %s%v to be %v, got %v (found: %t)", tt.name, tt.labels, tt.expected, got, ok)
+		}
+	}
+	if _, ok := value(t, scraped, "deployment_service_service_last_deployed_timestamp_seconds", `service="service-metrics-b"`); ok {
+		t.Fatal("expected no last deployed time for a service that never deployed")
+	}
+
+	// Failing to list doesn't drop the other metrics from the scrape
+	lister.err = errors.New("disk unavailable")
+	RecordFailure(context.Background(), "service-metrics-a", StageCheck)
+	if _, ok := value(t, scrape(t), "deployment_service_release_failures_total", `service="service-metrics-a"`); !ok {
+		t.Fatal("expected the failures to still be served")
+	}
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	otelprometheus "go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+type ProviderConfig struct {
+	ServiceName    string
+	ServiceVersion string
+	// OTLPEndpoint is the collector metrics are also pushed to, over the same
+	// insecure gRPC connection as traces. Metrics are only scraped when empty.
+	OTLPEndpoint string
+}
+
+// InitMeterProvider sets the global MeterProvider, which the instruments of
+// this package and otelgin record to. It returns the handler serving the
+// metrics in the Prometheus text format, and a func flushing the OTLP
+// exporter on shutdown.
+func InitMeterProvider(ctx context.Context, config ProviderConfig) (http.Handler, func(context.Context) error, error) {
+	if config.ServiceName == "" {
+		return nil, nil, fmt.Errorf("serviceName not set")
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceName(config.ServiceName),
+			semconv.ServiceVersion(config.ServiceVersion),
+		),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+
+	// A registry of our own rather than the default one, so /metrics only has
+	// what is registered here
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	prometheusExporter, err := otelprometheus.New(otelprometheus.WithRegisterer(registry))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Prometheus exporter: %w", err)
+	}
+	options := []sdkmetric.Option{
+		sdkmetric.WithResource(res),
+		sdkmetric.WithReader(prometheusExporter),
+	}
+
+	var conn *grpc.ClientConn
+	if config.OTLPEndpoint != "" {
+		conn, err = grpc.NewClient(config.OTLPEndpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create gRPC connection: %w", err)
+		}
+		otlpExporter, err := otlpmetricgrpc.New(ctx, otlpmetricgrpc.WithGRPCConn(conn))
+		if err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		options = append(options, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(otlpExporter)))
+	}
+
+	mp := sdkmetric.NewMeterProvider(options...)
+	otel.SetMeterProvider(mp)
+
+	shutdown := func(ctx context.Context) error {
+		err := mp.Shutdown(ctx)
+		if conn != nil {
+			err = errors.Join(err, conn.Close())
+		}
+		return err
+	}
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{}), shutdown, nil
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/audit"
+	"github.com/gin-gonic/gin"
+)
+
+// AuditMiddleware records every authenticated request that can change
+// something. It must be registered before ErrorHandlerMiddleware, so the
+// status it records is the one the error handler responded with.
+func AuditMiddleware(log audit.Log) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, request := audit.WithRequest(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return
+		}
+		// Unknown routes and requests that never got past authz have no
+		// caller to attribute them to
+		route := c.FullPath()
+		if route == "" || c.Writer.Status() == http.StatusUnauthorized {
+			return
+		}
+		service := c.Param("name")
+		if service == "" {
+			service = request.Service()
+		}
+		// The request's ctx is the one authz added the caller to
+		log.Record(c.Request.Context(), audit.Entry{
+			Action:  fmt.Sprintf("%s %s", c.Request.Method, route),
+			Service: service,
+			Method:  c.Request.Method,
+			Path:    c.Request.URL.Path,
+			Status:  c.Writer.Status(),
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/audit"
+	"github.com/ansonallard/deployment-service/cmd/internal/identity"
+	"github.com/gin-gonic/gin"
+)
+
+func TestAuditMiddlewareRecordsChanges(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auditLog, err := audit.NewLog(audit.Config{FilePath: filepath.Join(t.TempDir(), "audit.jsonl")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.Use(AuditMiddleware(auditLog))
+	router.Use(ErrorHandlerMiddleware())
+	// Stands in for authz, which adds the caller or refuses the request
+	authz := func(c *gin.Context) {
+		if c.GetHeader("x-api-key") == "" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Request = c.Request.WithContext(identity.WithCaller(c.Request.Context(), "key:ci"))
+	}
+	v1 := router.Group("/v1", authz)
+	v1.GET("/services/:name", func(c *gin.Context) { c.Status(http.StatusOK) })
+	v1.DELETE("/services/:name", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	v1.POST("/services", func(c *gin.Context) {
+		audit.SetService(c.Request.Context(), "created-service")
+		c.Status(http.StatusCreated)
+	})
+	v1.PUT("/services/:name/git", func(c *gin.Context) {
+		_ = c.Error(apierr.Newf(apierr.CodeServiceNotFound, "service not found"))
+	})
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		apiKey bool
+	}{
+		{method: http.MethodDelete, path: "/v1/services/my-service", apiKey: true},
+		{method: http.MethodPost, path: "/v1/services", apiKey: true},
+		{method: http.MethodPut, path: "/v1/services/my-service/git", apiKey: true},
+		// Not recorded: reads, unauthenticated requests and unknown routes
+		{method: http.MethodGet, path: "/v1/services/my-service", apiKey: true},
+		{method: http.MethodDelete, path: "/v1/services/other-service"},
+		{method: http.MethodPost, path: "/v1/unknown", apiKey: true},
+	} {
+		request := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.apiKey {
+			request.Header.Set("x-api-key", "live-api-key")
+		}
+		router.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	entries, err := auditLog.Query(context.Background(), audit.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []audit.Entry{
+		{Action: "PUT /v1/services/:name/git", Service: "my-service", Method: http.MethodPut, Path: "/v1/services/my-service/git", Status: http.StatusNotFound},
+		{Action: "POST /v1/services", Service: "created-service", Method: http.MethodPost, Path: "/v1/services", Status: http.StatusCreated},
+		{Action: "DELETE /v1/services/:name", Service: "my-service", Method: http.MethodDelete, Path: "/v1/services/my-service", Status: http.StatusNoContent},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %+v", len(expected), entries)
+	}
+	for i, entry := range entries {
+		want := expected[i]
+		if entry.Action != want.Action || entry.Service != want.Service || entry.Method != want.Method ||
+			entry.Path != want.Path || entry.Status != want.Status || entry.Actor != "key:ci" {
+			t.Fatalf("expected entry %+v from key:ci, got %+v", want, entry)
+		}
+	}
+}
@@ -1,48 +1,184 @@
 package authz
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 
-	"github.com/ansonallard/go_utils/openapi/ierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/apikeys"
+	"github.com/ansonallard/deployment-service/cmd/internal/identity"
+	"github.com/ansonallard/deployment-service/cmd/internal/oidc"
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
 )
 
 const (
 	apiKeyHeaderKey = "x-api-key"
+	bearerPrefix    = "Bearer "
+	// apiKeyCaller identifies requests made with API_KEY in revisions.
+	// Other keys are recorded as api-key:<name>.
+	apiKeyCaller = "api-key"
+	// Requests made with a bearer token are recorded as oidc:<username>
+	oidcCallerPrefix = "oidc"
 )
 
+// Routes that need ScopeAdmin, whatever the method
+var adminPathPrefixes = []string{"/v1/api-keys", "/v1/audit", "/v1/export", "/v1/import"}
+
+// Routes that change a host wide setting rather than a service
+var adminWritePathPrefixes = []string{"/v1/known-hosts"}
+
+// Routes that trigger work on a service without changing its configuration
+var triggerPathSuffixes = []string{"/repair"}
+
 type AuthZ interface {
 	AuthMiddleware() gin.HandlerFunc
 }
 
-func NewAuthZ(apiKey string) AuthZ {
-	if apiKey == "" {
-		panic("apiKey not set")
+type AuthZConfig struct {
+	Keys apikeys.Store
+	// Tokens verifies Authorization: Bearer tokens. Optional; without it only
+	// API keys are accepted.
+	Tokens oidc.Verifier
+}
+
+func NewAuthZ(config AuthZConfig) (AuthZ, error) {
+	if config.Keys == nil {
+		return nil, fmt.Errorf("keys not set")
 	}
 	return &authz{
-		apiKey: apiKey,
-	}
+		keys:   config.Keys,
+		tokens: config.Tokens,
+	}, nil
 }
 
 type authz struct {
-	apiKey string
+	keys   apikeys.Store
+	tokens oidc.Verifier
+}
+
+// principal is who a request was authenticated as.
+type principal struct {
+	caller string
+	scope  apikeys.Scope
+	// services the principal is restricted to. Empty means every service.
+	services []string
+	logger   zerolog.Logger
 }
 
 func (az *authz) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		apiKey, ok := c.Request.Header[http.CanonicalHeaderKey(apiKeyHeaderKey)]
-		if !ok || len(apiKey) != 1 {
-			c.Error(fmt.Errorf("%s not present", http.CanonicalHeaderKey(apiKeyHeaderKey)))
+		var p *principal
+		var err error
+		if authorization := c.GetHeader("Authorization"); authorization != "" {
+			p, err = az.authenticateToken(c, authorization)
+		} else {
+			p, err = az.authenticateKey(c)
+		}
+		if err != nil {
+			c.Error(err)
 			c.Abort()
 			return
 		}
-		if apiKey[0] != az.apiKey {
-			c.Error(&ierr.UnAuthorizedError{})
+
+		required := RequiredScope(c.Request.Method, c.FullPath())
+		if !p.scope.Includes(required) {
+			c.Error(apierr.Newf(apierr.CodePermissionDenied, "%s has scope %s, %s is required", p.caller, p.scope, required))
 			c.Abort()
 			return
 		}
+		if len(p.services) > 0 {
+			// Routes without a service, e.g. listing every service, aren't
+			// available to keys restricted to some
+			serviceName := c.Param("name")
+			if serviceName == "" || !slices.Contains(p.services, serviceName) {
+				c.Error(apierr.Newf(apierr.CodePermissionDenied, "%s is restricted to services %s",
+					p.caller, strings.Join(p.services, ", ")))
+				c.Abort()
+				return
+			}
+		}
+
+		ctx := identity.WithCaller(p.logger.WithContext(c.Request.Context()), p.caller)
+		c.Request = c.Request.WithContext(ctx)
+		if required != apikeys.ScopeRead {
+			p.logger.Info().Str("method", c.Request.Method).Str("path", c.Request.URL.Path).
+				Msg("Authorized change")
+		}
 
 		c.Next()
 	}
 }
+
+func (az *authz) authenticateKey(c *gin.Context) (*principal, error) {
+	apiKey, ok := c.Request.Header[http.CanonicalHeaderKey(apiKeyHeaderKey)]
+	if !ok || len(apiKey) != 1 {
+		return nil, apierr.Newf(apierr.CodeUnauthenticated, "%s not present", http.CanonicalHeaderKey(apiKeyHeaderKey))
+	}
+	key, err := az.keys.Authenticate(apiKey[0])
+	if err != nil {
+		return nil, apierr.New(apierr.CodeUnauthenticated, "invalid API key")
+	}
+
+	caller := apiKeyCaller
+	if key.Name != apikeys.BootstrapKeyName {
+		caller = fmt.Sprintf("%s:%s", apiKeyCaller, key.Name)
+	}
+	return &principal{
+		caller:   caller,
+		scope:    key.Scope,
+		services: key.Services,
+		logger:   zerolog.Ctx(c.Request.Context()).With().Str("apiKey", key.Name).Str("apiKeyId", key.ID).Logger(),
+	}, nil
+}
+
+func (az *authz) authenticateToken(c *gin.Context, authorization string) (*principal, error) {
+	if az.tokens == nil {
+		return nil, apierr.New(apierr.CodeUnauthenticated, "bearer tokens aren't enabled, use the x-api-key header")
+	}
+	if len(authorization) < len(bearerPrefix) || !strings.EqualFold(authorization[:len(bearerPrefix)], bearerPrefix) {
+		return nil, apierr.New(apierr.CodeUnauthenticated, "Authorization header must be a bearer token")
+	}
+
+	ctx := c.Request.Context()
+	id, err := az.tokens.Verify(ctx, strings.TrimSpace(authorization[len(bearerPrefix):]))
+	switch {
+	case errors.Is(err, oidc.ErrNoScope):
+		return nil, apierr.Newf(apierr.CodePermissionDenied, "%s:%s isn't in any group with access", oidcCallerPrefix, id.Username)
+	case err != nil:
+		// The reason stays in our logs rather than helping whoever sent the token
+		zerolog.Ctx(ctx).Info().Err(err).Msg("Rejected bearer token")
+		return nil, apierr.New(apierr.CodeUnauthenticated, "invalid bearer token")
+	}
+	return &principal{
+		caller: fmt.Sprintf("%s:%s", oidcCallerPrefix, id.Username),
+		scope:  id.Scope,
+		logger: zerolog.Ctx(ctx).With().Str("user", id.Username).Str("subject", id.Subject).Logger(),
+	}, nil
+}
+
+// RequiredScope returns the scope needed for a request to the route fullPath.
+func RequiredScope(method string, fullPath string) apikeys.Scope {
+	for _, prefix := range adminPathPrefixes {
+		if strings.HasPrefix(fullPath, prefix) {
+			return apikeys.ScopeAdmin
+		}
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return apikeys.ScopeRead
+	}
+	for _, prefix := range adminWritePathPrefixes {
+		if strings.HasPrefix(fullPath, prefix) {
+			return apikeys.ScopeAdmin
+		}
+	}
+	for _, suffix := range triggerPathSuffixes {
+		if strings.HasSuffix(fullPath, suffix) {
+			return apikeys.ScopeTrigger
+		}
+	}
+	return apikeys.ScopeWrite
+}
@@ -1,16 +1,16 @@
 package middleware
 
 import (
-	"context"
-	"net/http"
-
-	"github.com/ansonallard/deployment-service/cmd/internal/model"
-	"github.com/ansonallard/go_utils/openapi/ierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/identity"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// ErrorHandlerMiddleware converts ierr types to HTTP status codes
+// ErrorHandlerMiddleware converts the last error of a request to its status
+// code and an apierr.Response. Internal errors are logged in full but only
+// returned as the internal code, with the trace ID to find them by.
 func ErrorHandlerMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Next() // Process request
@@ -18,28 +18,31 @@ func ErrorHandlerMiddleware() gin.HandlerFunc {
 		// Check if there were errors
 		if len(c.Errors) > 0 {
 			err := c.Errors.Last().Err
+			apiErr := apierr.From(err)
+			status := apiErr.Code.Status()
+
 			ctx := c.Request.Context()
+			response := apierr.Response{
+				Code:    apiErr.Code,
+				Message: apiErr.Message,
+				Details: apiErr.Details,
+			}
+			if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+				response.TraceID = spanContext.TraceID().String()
+			}
 
-			switch err.(type) {
-			case *ierr.BadRequestError:
-				abortWithStatusResponse(ctx, http.StatusBadRequest, err, c)
-			case *ierr.UnAuthorizedError:
-				abortWithStatusResponse(ctx, http.StatusUnauthorized, err, c)
-			case *ierr.NotFoundError:
-				abortWithStatusResponse(ctx, http.StatusNotFound, err, c)
-			case *ierr.ConflictError:
-				abortWithStatusResponse(ctx, http.StatusConflict, err, c)
-			case *model.PreConditionFailedError:
-				abortWithStatusResponse(ctx, http.StatusPreconditionFailed, err, c)
-			default:
-				abortWithStatusResponse(ctx, http.StatusInternalServerError, err, c)
+			log := zerolog.Ctx(ctx)
+			event := log.Warn()
+			if apiErr.Code == apierr.CodeInternal {
+				event = log.Error()
 			}
+			// Headers aren't logged since they carry the caller's API key or
+			// bearer token
+			event.Err(err).Int("status", status).Str("code", string(apiErr.Code)).
+				Str("method", c.Request.Method).Str("route", c.FullPath()).
+				Str("traceID", response.TraceID).Str("caller", identity.CallerFromContext(ctx)).
+				Msg("API Response Error")
+			c.AbortWithStatusJSON(status, response)
 		}
 	}
 }
-
-func abortWithStatusResponse(ctx context.Context, code int, err error, c *gin.Context) {
-	log := zerolog.Ctx(ctx)
-	log.Warn().Err(err).Int("status", code).Interface("request", c.Request).Msg("API Response Error")
-	c.AbortWithStatusJSON(code, map[string]string{"message": err.Error()})
-}
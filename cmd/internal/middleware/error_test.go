@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/identity"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+// serveError responds to a request carrying credentials with err, and
+// returns the response and what was logged.
+func serveError(t *testing.T, err error) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	var logged bytes.Buffer
+	logger := zerolog.New(&logged)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		ctx := logger.WithContext(c.Request.Context())
+		c.Request = c.Request.WithContext(identity.WithCaller(ctx, "key:ci"))
+	})
+	router.Use(ErrorHandlerMiddleware())
+	router.GET("/v1/services/:name", func(c *gin.Context) {
+		c.Error(err)
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/v1/services/my-service", nil)
+	request.Header.Set("x-api-key", "live-api-key")
+	request.Header.Set("Authorization", "Bearer live-token")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	return response, logged.String()
+}
+
+func TestErrorHandlerDoesNotLogCredentials(t *testing.T) {
+	for _, err := range []error{
+		apierr.Newf(apierr.CodeServiceNotFound, "service my-service not found"),
+		errors.New("disk full"),
+	} {
+		response, logged := serveError(t, err)
+		if strings.Contains(logged, "live-api-key") || strings.Contains(logged, "live-token") {
+			t.Fatalf("expected credentials not to be logged, got %s", logged)
+		}
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(logged), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry["method"] != http.MethodGet || entry["route"] != "/v1/services/:name" || entry["caller"] != "key:ci" {
+			t.Fatalf("expected the method, route and caller to be logged, got %s", logged)
+		}
+		if want := apierr.From(err).Code.Status(); response.Code != want {
+			t.Fatalf("expected status %d, got %d", want, response.Code)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+type queryKey struct{}
+
+// QueryParameters makes the raw query available to the strict OpenAPI
+// handlers, which only receive the parameters declared in the spec.
+func QueryParameters() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.WithValue(c.Request.Context(), queryKey{}, c.Request.URL.Query())
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// QueryFromContext returns the query stored by QueryParameters, or an empty
+// query if there is none.
+func QueryFromContext(ctx context.Context) url.Values {
+	query, ok := ctx.Value(queryKey{}).(url.Values)
+	if !ok {
+		return url.Values{}
+	}
+	return query
+}
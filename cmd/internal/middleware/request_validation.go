@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gin-gonic/gin"
+)
+
+// RequestValidator rejects requests that don't match spec. Every invalid
+// parameter and body field is reported in the error's details, rather than
+// only the first.
+func RequestValidator(spec *openapi3.T) (gin.HandlerFunc, error) {
+	router, err := gorillamux.NewRouter(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create router: %w", err)
+	}
+	options := &openapi3filter.Options{
+		MultiError: true,
+		AuthenticationFunc: func(ctx context.Context, input *openapi3filter.AuthenticationInput) error {
+			return nil // auth is handled by authZMiddleware.AuthMiddleware()
+		},
+	}
+	return func(c *gin.Context) {
+		route, pathParams, err := router.FindRoute(c.Request)
+		if err != nil {
+			if errors.Is(err, routers.ErrPathNotFound) {
+				_ = c.Error(apierr.New(apierr.CodeNotFound, err.Error()))
+			} else {
+				_ = c.Error(apierr.New(apierr.CodeInvalidArgument, err.Error()))
+			}
+			c.Abort()
+			return
+		}
+		if err := openapi3filter.ValidateRequest(c.Request.Context(), &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    options,
+		}); err != nil {
+			_ = c.Error(requestValidationError(err))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}, nil
+}
+
+// requestValidationError maps the errors ValidateRequest found onto a field
+// error each.
+func requestValidationError(err error) *apierr.Error {
+	var details []apierr.FieldError
+	var collect func(err error)
+	collect = func(err error) {
+		var multiErr openapi3.MultiError
+		if errors.As(err, &multiErr) {
+			for _, e := range multiErr {
+				collect(e)
+			}
+			return
+		}
+		var requestErr *openapi3filter.RequestError
+		if !errors.As(err, &requestErr) {
+			details = append(details, apierr.FieldError{Message: err.Error()})
+			return
+		}
+		field := "body"
+		if requestErr.Parameter != nil {
+			field = requestErr.Parameter.Name
+		}
+		if requestErr.Err == nil {
+			details = append(details, apierr.FieldError{Field: field, Message: requestErr.Reason})
+			return
+		}
+		// A body or parameter with several invalid values is a MultiError
+		// of SchemaErrors
+		var schemaErrs openapi3.MultiError
+		if !errors.As(requestErr.Err, &schemaErrs) {
+			schemaErrs = openapi3.MultiError{requestErr.Err}
+		}
+		for _, e := range schemaErrs {
+			var schemaErr *openapi3.SchemaError
+			if !errors.As(e, &schemaErr) {
+				details = append(details, apierr.FieldError{Field: field, Message: e.Error()})
+				continue
+			}
+			path := field
+			if pointer := schemaErr.JSONPointer(); len(pointer) > 0 {
+				path = strings.Join(append([]string{field}, pointer...), ".")
+			}
+			details = append(details, apierr.FieldError{Field: path, Message: schemaErr.Reason})
+		}
+	}
+	collect(err)
+
+	messages := make([]string, 0, len(details))
+	for _, detail := range details {
+		if detail.Field == "" {
+			messages = append(messages, detail.Message)
+			continue
+		}
+		messages = append(messages, fmt.Sprintf("%s: %s", detail.Field, detail.Message))
+	}
+	return &apierr.Error{
+		Code:    apierr.CodeInvalidArgument,
+		Message: fmt.Sprintf("invalid request: %s", strings.Join(messages, "; ")),
+		Details: details,
+	}
+}
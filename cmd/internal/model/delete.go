@@ -0,0 +1,93 @@
+package model
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+)
+
+// Query parameters of DeleteService that aren't part of the generated client.
+const (
+	deleteModeParam    = "mode"
+	deleteArchiveParam = "archive"
+)
+
+// DeleteMode decides what is torn down along with a service's definition.
+type DeleteMode string
+
+const (
+	// DeleteModeRetain removes the service's directory and leaves everything
+	// it deployed running.
+	DeleteModeRetain DeleteMode = "retain"
+	// DeleteModeStop also stops Docker Compose stacks before removing the clone.
+	DeleteModeStop DeleteMode = "stop"
+	// DeleteModePurge also removes the images built and deployed for the
+	// service.
+	DeleteModePurge DeleteMode = "purge"
+)
+
+type DeleteOptions struct {
+	Mode DeleteMode
+	// Archive moves the service's directory aside instead of removing it
+	Archive bool
+	// Report is set when the caller asked for a mode or archive, and so
+	// expects to be told what was torn down
+	Report bool
+}
+
+// DeleteOptionsFromQuery parses the delete query parameters. Without a mode
+// the service is deleted as before, with DeleteModeRetain.
+func DeleteOptionsFromQuery(query url.Values) (DeleteOptions, error) {
+	opts := DeleteOptions{Mode: DeleteModeRetain}
+	if query.Has(deleteModeParam) {
+		opts.Report = true
+		switch mode := DeleteMode(query.Get(deleteModeParam)); mode {
+		case DeleteModeRetain, DeleteModeStop, DeleteModePurge:
+			opts.Mode = mode
+		default:
+			return DeleteOptions{}, apierr.InvalidField(deleteModeParam, fmt.Sprintf("invalid %s %q, expected one of: %s, %s, %s",
+				deleteModeParam, mode, DeleteModeRetain, DeleteModeStop, DeleteModePurge))
+		}
+	}
+	if query.Has(deleteArchiveParam) {
+		opts.Report = true
+		archive, err := strconv.ParseBool(query.Get(deleteArchiveParam))
+		if err != nil {
+			return DeleteOptions{}, apierr.InvalidField(deleteArchiveParam, fmt.Sprintf("invalid %s %q, expected true or false",
+				deleteArchiveParam, query.Get(deleteArchiveParam)))
+		}
+		opts.Archive = archive
+	}
+	return opts, nil
+}
+
+// DeleteReport says what a delete tore down.
+type DeleteReport struct {
+	Mode DeleteMode `json:"mode"`
+	// StackStopped is set when the service's Docker Compose stack was stopped
+	StackStopped bool `json:"stackStopped"`
+	// StackImagesRemoved is set when the images of the stack were removed
+	// from the deploy daemon
+	StackImagesRemoved bool `json:"stackImagesRemoved"`
+	// RemovedImages are the service's images removed from the build daemon
+	RemovedImages []string `json:"removedImages"`
+	// ArchivedTo is where the service's directory was moved, when archived
+	ArchivedTo string `json:"archivedTo,omitempty"`
+	// Failed lists what couldn't be torn down. The service is deleted anyway.
+	Failed []DeleteFailure `json:"failed"`
+}
+
+type DeleteFailure struct {
+	Resource string `json:"resource"`
+	Error    string `json:"error"`
+}
+
+func NewDeleteReport(mode DeleteMode) *DeleteReport {
+	return &DeleteReport{
+		Mode:          mode,
+		RemovedImages: make([]string, 0),
+		Failed:        make([]DeleteFailure, 0),
+	}
+}
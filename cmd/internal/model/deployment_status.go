@@ -0,0 +1,174 @@
+package model
+
+import "time"
+
+// PipelineState is what a service's release pipeline is doing.
+type PipelineState string
+
+const (
+	PipelineStateIdle PipelineState = "idle"
+	// PipelineStateQueued is waiting for another release or an API operation
+	// on the service to finish.
+	PipelineStateQueued  PipelineState = "queued"
+	PipelineStateRunning PipelineState = "running"
+	// PipelineStateFailed means the last run failed. It is retried on the
+	// next tick.
+	PipelineStateFailed PipelineState = "failed"
+	// PipelineStateAwaitingApproval is waiting on its release pull request to
+	// be merged.
+	PipelineStateAwaitingApproval PipelineState = "awaitingApproval"
+)
+
+// ProvisioningState is how far a new service has got with its first clone.
+type ProvisioningState string
+
+const (
+	ProvisioningStateProvisioning ProvisioningState = "provisioning"
+	ProvisioningStateReady        ProvisioningState = "ready"
+	// ProvisioningStateFailed means every clone attempt failed, or the clone
+	// failed validation. Repairing the service retries it.
+	ProvisioningStateFailed ProvisioningState = "provision_failed"
+)
+
+// DeploymentStatus is the state the background processor records as it
+// releases a service. Unlike the service definition it isn't configuration,
+// so it is stored separately and has no revisions.
+type DeploymentStatus struct {
+	State               PipelineState `json:"state"`
+	LastReleasedVersion string        `json:"last_released_version,omitempty"`
+	// LastRunID is the run whose log GET /services/{name}/runs/{runId}/logs
+	// returns
+	LastRunID string `json:"last_run_id,omitempty"`
+	// LastReleaseCommit is the commit the last release was tagged on
+	LastReleaseCommit   string     `json:"last_release_commit,omitempty"`
+	LastDeployedVersion string     `json:"last_deployed_version,omitempty"`
+	LastDeployedAt      *time.Time `json:"last_deployed_at,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time `json:"last_error_at,omitempty"`
+	// HeadCommit is the head of the tracked branch when it was last fetched
+	HeadCommit string    `json:"head_commit,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	// Provisioning is empty for services created before it was recorded,
+	// which are ready
+	Provisioning         ProvisioningState `json:"provisioning,omitempty"`
+	ProvisioningError    string            `json:"provisioning_error,omitempty"`
+	ProvisioningAttempts int               `json:"provisioning_attempts,omitempty"`
+	// ProvisioningProgress is the last progress line of the clone
+	ProvisioningProgress string `json:"provisioning_progress,omitempty"`
+}
+
+// NewDeploymentStatus is the status of a service that hasn't run yet.
+func NewDeploymentStatus() *DeploymentStatus {
+	return &DeploymentStatus{State: PipelineStateIdle}
+}
+
+// EffectiveState is State, except that an idle service waiting on its
+// release pull request is awaiting approval.
+func (d *DeploymentStatus) EffectiveState(service *Service) PipelineState {
+	pending := service.ReleasePullRequest
+	if d.State == PipelineStateIdle && pending != nil && !pending.Declined {
+		return PipelineStateAwaitingApproval
+	}
+	return d.State
+}
+
+// EffectiveProvisioning is Provisioning, with services from before it was
+// recorded counted as ready.
+func (d *DeploymentStatus) EffectiveProvisioning() ProvisioningState {
+	if d.Provisioning == "" {
+		return ProvisioningStateReady
+	}
+	return d.Provisioning
+}
+
+// Provisioned is whether the service has a worktree the background job can
+// release from.
+func (d *DeploymentStatus) Provisioned() bool {
+	return d.EffectiveProvisioning() == ProvisioningStateReady
+}
+
+// ProvisioningStarted records attempt at cloning the service.
+func (d *DeploymentStatus) ProvisioningStarted(attempt int) {
+	d.Provisioning = ProvisioningStateProvisioning
+	d.ProvisioningAttempts = attempt
+	d.ProvisioningProgress = ""
+}
+
+// ProvisioningFinished records the service as ready, or as failed to
+// provision with err.
+func (d *DeploymentStatus) ProvisioningFinished(err error) {
+	d.ProvisioningProgress = ""
+	if err != nil {
+		d.Provisioning = ProvisioningStateFailed
+		d.ProvisioningError = err.Error()
+		return
+	}
+	d.Provisioning = ProvisioningStateReady
+	d.ProvisioningError = ""
+}
+
+// Failed records err as the outcome of the current run.
+func (d *DeploymentStatus) Failed(err error) {
+	now := time.Now().UTC()
+	d.State = PipelineStateFailed
+	d.LastError = err.Error()
+	d.LastErrorAt = &now
+}
+
+// Released records version being tagged on commit.
+func (d *DeploymentStatus) Released(version string, commit string) {
+	d.LastReleasedVersion = version
+	d.LastReleaseCommit = commit
+}
+
+// Deployed records version being built and published, or deployed for Docker
+// Compose services.
+func (d *DeploymentStatus) Deployed(version string) {
+	now := time.Now().UTC()
+	d.LastDeployedVersion = version
+	d.LastDeployedAt = &now
+}
+
+// DeploymentStatusExternal is the deployment status returned under
+// "deployment" in service responses.
+type DeploymentStatusExternal struct {
+	State               PipelineState `json:"state"`
+	LastReleasedVersion string        `json:"lastReleasedVersion,omitempty"`
+	LastRunID           string        `json:"lastRunId,omitempty"`
+	LastReleaseCommit   string        `json:"lastReleaseCommit,omitempty"`
+	LastDeployedVersion string        `json:"lastDeployedVersion,omitempty"`
+	LastDeployedAt      *time.Time    `json:"lastDeployedAt,omitempty"`
+	LastError           string        `json:"lastError,omitempty"`
+	LastErrorAt         *time.Time    `json:"lastErrorAt,omitempty"`
+	HeadCommit          string        `json:"headCommit,omitempty"`
+	// Unreleased is set when the tracked branch has moved past the last release
+	Unreleased bool      `json:"unreleased"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+
+	// Provisioning is how far the first clone of the service has got
+	Provisioning         ProvisioningState `json:"provisioning"`
+	ProvisioningError    string            `json:"provisioningError,omitempty"`
+	ProvisioningAttempts int               `json:"provisioningAttempts,omitempty"`
+	ProvisioningProgress string            `json:"provisioningProgress,omitempty"`
+}
+
+func (d *DeploymentStatus) ToExternal(service *Service) DeploymentStatusExternal {
+	return DeploymentStatusExternal{
+		State:                d.EffectiveState(service),
+		LastReleasedVersion:  d.LastReleasedVersion,
+		LastRunID:            d.LastRunID,
+		LastReleaseCommit:    d.LastReleaseCommit,
+		LastDeployedVersion:  d.LastDeployedVersion,
+		LastDeployedAt:       d.LastDeployedAt,
+		LastError:            d.LastError,
+		LastErrorAt:          d.LastErrorAt,
+		HeadCommit:           d.HeadCommit,
+		Provisioning:         d.EffectiveProvisioning(),
+		ProvisioningError:    d.ProvisioningError,
+		ProvisioningAttempts: d.ProvisioningAttempts,
+		ProvisioningProgress: d.ProvisioningProgress,
+		Unreleased:           d.HeadCommit != "" && d.HeadCommit != d.LastReleaseCommit,
+		UpdatedAt:            d.UpdatedAt,
+	}
+}
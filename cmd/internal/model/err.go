@@ -1,22 +1,30 @@
 package model
 
+import (
+	"errors"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+)
+
 type unionMemberNotPresent struct{}
 
 func (u *unionMemberNotPresent) Error() string {
 	return "union member not present"
 }
 
-type PreConditionFailedError struct {
-	message string
-}
-
-func NewPreConditionFailedError(message string) *PreConditionFailedError {
-	return &PreConditionFailedError{message: message}
+// invalidRequest maps a failure to decode a request, e.g. a union of the
+// generated client that doesn't match any member, onto CodeInvalidArgument.
+// Errors that already have a code are kept.
+func invalidRequest(err error) error {
+	var apiErr *apierr.Error
+	if errors.As(err, &apiErr) {
+		return err
+	}
+	return apierr.Newf(apierr.CodeInvalidArgument, "invalid request: %s", err)
 }
 
-func (e *PreConditionFailedError) Error() string {
-	if e.message != "" {
-		return e.message
-	}
-	return "precondition failed"
+// NewVersionMismatchError is returned when the If-Match header isn't the
+// service's current version.
+func NewVersionMismatchError() *apierr.Error {
+	return apierr.New(apierr.CodeVersionMismatch, "If-Match doesn't match the service's current version")
 }
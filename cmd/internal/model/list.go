@@ -0,0 +1,227 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment_service_go_client/lib/deployment_service_go_client"
+)
+
+const defaultMaxResults = 100
+
+// Query parameters of ListServices that aren't part of the generated client's
+// ListServicesParams.
+const (
+	listConfigurationTypeParam = "configurationType"
+	listStatusParam            = "status"
+	listNamePrefixParam        = "namePrefix"
+	listSortByParam            = "sortBy"
+	listSortOrderParam         = "sortOrder"
+)
+
+type SortBy string
+
+const (
+	SortByName SortBy = "name"
+	// SortByCreated orders by creation time, which the service ID's ULID
+	// encodes.
+	SortByCreated SortBy = "createdAt"
+)
+
+type SortOrder string
+
+const (
+	SortOrderAsc  SortOrder = "asc"
+	SortOrderDesc SortOrder = "desc"
+)
+
+// ServiceStatus summarises where a service is in its release cycle.
+type ServiceStatus string
+
+const (
+	ServiceStatusActive ServiceStatus = "active"
+	// ServiceStatusAwaitingMerge is waiting on its release pull request.
+	ServiceStatusAwaitingMerge ServiceStatus = "awaitingMerge"
+	// ServiceStatusReleaseDeclined had its release pull request closed and
+	// waits for new commits.
+	ServiceStatusReleaseDeclined ServiceStatus = "releaseDeclined"
+)
+
+var serviceStatuses = []ServiceStatus{
+	ServiceStatusActive,
+	ServiceStatusAwaitingMerge,
+	ServiceStatusReleaseDeclined,
+}
+
+func (s *Service) Status() ServiceStatus {
+	switch {
+	case s.ReleasePullRequest == nil:
+		return ServiceStatusActive
+	case s.ReleasePullRequest.Declined:
+		return ServiceStatusReleaseDeclined
+	default:
+		return ServiceStatusAwaitingMerge
+	}
+}
+
+// ConfigurationType is the API name of the service's configuration, e.g. npm
+// or dockerCompose.
+func (s *Service) ConfigurationType() string {
+	switch {
+	case s.Configuration.Npm != nil:
+		return string(serviceConfigNpm)
+	case s.Configuration.OpenAPI != nil:
+		return string(serviceConfigOpenAPI)
+	case s.Configuration.Go != nil:
+		return string(serviceConfigGo)
+	case s.Configuration.DockerCompose != nil:
+		return string(serviceConfigDockerCompose)
+	case s.Configuration.DockerBuild != nil:
+		return string(serviceConfigDockerBuild)
+	default:
+		return ""
+	}
+}
+
+// ListOptions selects a page of services. Empty filters match every service.
+type ListOptions struct {
+	MaxResults        int
+	Cursor            *ListCursor
+	ConfigurationType string
+	Status            ServiceStatus
+	NamePrefix        string
+	SortBy            SortBy
+	SortOrder         SortOrder
+}
+
+func (o ListOptions) Matches(service *Service) bool {
+	if o.ConfigurationType != "" && service.ConfigurationType() != o.ConfigurationType {
+		return false
+	}
+	if o.Status != "" && service.Status() != o.Status {
+		return false
+	}
+	return strings.HasPrefix(service.Name.Name, o.NamePrefix)
+}
+
+// SortKey is the value services are ordered by. Names are unique, so they
+// break ties.
+func (o ListOptions) SortKey(service *Service) string {
+	if o.SortBy == SortByCreated {
+		return service.ID
+	}
+	return service.Name.Name
+}
+
+// Less reports whether a sorts before b.
+func (o ListOptions) Less(a, b *Service) bool {
+	keyA, keyB := o.SortKey(a), o.SortKey(b)
+	if keyA == keyB {
+		keyA, keyB = a.Name.Name, b.Name.Name
+	}
+	if o.SortOrder == SortOrderDesc {
+		return keyA > keyB
+	}
+	return keyA < keyB
+}
+
+// After reports whether service comes after the page the cursor ended on.
+func (o ListOptions) After(service *Service) bool {
+	if o.Cursor == nil {
+		return true
+	}
+	last := &Service{Name: Name{Name: o.Cursor.Name}, ID: o.Cursor.Key}
+	return o.Less(last, service)
+}
+
+// ListCursor marks the last service of a page. It is handed out as an opaque
+// nextToken and is only valid with the sort it was issued for.
+type ListCursor struct {
+	SortBy    SortBy    `json:"s"`
+	SortOrder SortOrder `json:"o"`
+	Key       string    `json:"k"`
+	Name      string    `json:"n"`
+}
+
+func (o ListOptions) CursorAfter(service *Service) *ListCursor {
+	return &ListCursor{
+		SortBy:    o.SortBy,
+		SortOrder: o.SortOrder,
+		Key:       o.SortKey(service),
+		Name:      service.Name.Name,
+	}
+}
+
+func (c *ListCursor) Encode() string {
+	cursorBytes, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(cursorBytes)
+}
+
+func decodeListCursor(token string) (*ListCursor, error) {
+	cursorBytes, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, apierr.InvalidField("nextToken", "invalid nextToken")
+	}
+	cursor := new(ListCursor)
+	if err := json.Unmarshal(cursorBytes, cursor); err != nil {
+		return nil, apierr.InvalidField("nextToken", "invalid nextToken")
+	}
+	return cursor, nil
+}
+
+// FromListRequest builds the list options from the generated params and the
+// raw query, which carries the filter and sort parameters.
+func FromListRequest(params deployment_service_go_client.ListServicesParams, query url.Values) (ListOptions, error) {
+	opts := ListOptions{
+		MaxResults:        defaultMaxResults,
+		ConfigurationType: query.Get(listConfigurationTypeParam),
+		Status:            ServiceStatus(query.Get(listStatusParam)),
+		NamePrefix:        query.Get(listNamePrefixParam),
+		SortBy:            SortBy(query.Get(listSortByParam)),
+		SortOrder:         SortOrder(query.Get(listSortOrderParam)),
+	}
+	if params.MaxResults != nil {
+		if *params.MaxResults < 1 {
+			return ListOptions{}, apierr.InvalidField("maxResults", "maxResults must be at least 1")
+		}
+		opts.MaxResults = *params.MaxResults
+	}
+
+	if opts.ConfigurationType != "" && !slices.Contains(serviceConfigurationMembers, serviceConfigMember(opts.ConfigurationType)) {
+		return ListOptions{}, apierr.InvalidField(listConfigurationTypeParam, fmt.Sprintf("invalid %s %q", listConfigurationTypeParam, opts.ConfigurationType))
+	}
+	if opts.Status != "" && !slices.Contains(serviceStatuses, opts.Status) {
+		return ListOptions{}, apierr.InvalidField(listStatusParam, fmt.Sprintf("invalid %s %q", listStatusParam, opts.Status))
+	}
+	switch opts.SortBy {
+	case "":
+		opts.SortBy = SortByName
+	case SortByName, SortByCreated:
+	default:
+		return ListOptions{}, apierr.InvalidField(listSortByParam, fmt.Sprintf("invalid %s %q, expected one of: %s, %s", listSortByParam, opts.SortBy, SortByName, SortByCreated))
+	}
+	switch opts.SortOrder {
+	case "":
+		opts.SortOrder = SortOrderAsc
+	case SortOrderAsc, SortOrderDesc:
+	default:
+		return ListOptions{}, apierr.InvalidField(listSortOrderParam, fmt.Sprintf("invalid %s %q, expected one of: %s, %s", listSortOrderParam, opts.SortOrder, SortOrderAsc, SortOrderDesc))
+	}
+
+	if params.NextToken != nil && *params.NextToken != "" {
+		cursor, err := decodeListCursor(*params.NextToken)
+		if err != nil {
+			return ListOptions{}, err
+		}
+		if cursor.SortBy != opts.SortBy || cursor.SortOrder != opts.SortOrder {
+			return ListOptions{}, apierr.InvalidField("nextToken", "nextToken was issued for a different sort")
+		}
+		opts.Cursor = cursor
+	}
+	return opts, nil
+}
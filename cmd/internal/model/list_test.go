@@ -0,0 +1,150 @@
+package model
+
+import (
+	"net/url"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment_service_go_client/lib/deployment_service_go_client"
+)
+
+// listPage cuts a page from services like the repo does, returning the
+// nextToken for the page after it.
+func listPage(services []*Service, opts ListOptions) ([]*Service, string) {
+	page := make([]*Service, 0)
+	for _, service := range services {
+		if opts.Matches(service) && opts.After(service) {
+			page = append(page, service)
+		}
+	}
+	sort.Slice(page, func(i, j int) bool { return opts.Less(page[i], page[j]) })
+	if len(page) <= opts.MaxResults {
+		return page, ""
+	}
+	page = page[:opts.MaxResults]
+	return page, opts.CursorAfter(page[len(page)-1]).Encode()
+}
+
+// listAll pages through services with query, following nextToken the way a
+// client would.
+func listAll(t *testing.T, services []*Service, maxResults int, query url.Values) []string {
+	t.Helper()
+	var names []string
+	var token *string
+	for range len(services) + 1 {
+		opts, err := FromListRequest(deployment_service_go_client.ListServicesParams{MaxResults: &maxResults, NextToken: token}, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, next := listPage(services, opts)
+		for _, service := range page {
+			names = append(names, service.Name.Name)
+		}
+		if next == "" {
+			return names
+		}
+		token = &next
+	}
+	t.Fatal("paging didn't end")
+	return nil
+}
+
+func testServices() []*Service {
+	// IDs are ULIDs in creation order, which differs from name order
+	return []*Service{
+		{Name: Name{Name: "delta"}, ID: "01A", Configuration: ServiceConfiguration{Npm: &NpmConfiguration{}}},
+		{Name: Name{Name: "alpha"}, ID: "01D", Configuration: ServiceConfiguration{Go: &GoConfiguration{}}},
+		{Name: Name{Name: "echo"}, ID: "01B", Configuration: ServiceConfiguration{Go: &GoConfiguration{}}},
+		{Name: Name{Name: "charlie"}, ID: "01E", Configuration: ServiceConfiguration{Npm: &NpmConfiguration{}}, ReleasePullRequest: &ReleasePullRequest{}},
+		{Name: Name{Name: "bravo"}, ID: "01C", Configuration: ServiceConfiguration{Go: &GoConfiguration{}}},
+	}
+}
+
+func TestListCursorPaging(t *testing.T) {
+	tests := []struct {
+		name  string
+		query url.Values
+		want  []string
+	}{
+		{name: "name ascending by default", want: []string{"alpha", "bravo", "charlie", "delta", "echo"}},
+		{name: "name descending", query: url.Values{"sortOrder": {"desc"}}, want: []string{"echo", "delta", "charlie", "bravo", "alpha"}},
+		{name: "created ascending", query: url.Values{"sortBy": {"createdAt"}}, want: []string{"delta", "echo", "bravo", "alpha", "charlie"}},
+		{name: "created descending", query: url.Values{"sortBy": {"createdAt"}, "sortOrder": {"desc"}}, want: []string{"charlie", "alpha", "bravo", "echo", "delta"}},
+		{name: "filtered by type", query: url.Values{"configurationType": {"go"}}, want: []string{"alpha", "bravo", "echo"}},
+		{name: "filtered by status", query: url.Values{"status": {"awaitingMerge"}}, want: []string{"charlie"}},
+		{name: "filtered by prefix", query: url.Values{"namePrefix": {"ch"}}, want: []string{"charlie"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, maxResults := range []int{1, 2, 5, 10} {
+				if got := listAll(t, testServices(), maxResults, tt.query); !slices.Equal(got, tt.want) {
+					t.Fatalf("maxResults %d: expected %v, got %v", maxResults, tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestListCursorSurvivesChanges(t *testing.T) {
+	services := testServices()
+	maxResults := 2
+	opts, err := FromListRequest(deployment_service_go_client.ListServicesParams{MaxResults: &maxResults}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, next := listPage(services, opts)
+	if names := []string{first[0].Name.Name, first[1].Name.Name}; !slices.Equal(names, []string{"alpha", "bravo"}) {
+		t.Fatalf("unexpected first page %v", names)
+	}
+
+	// The service the cursor points at is deleted, and services are created
+	// before and after it
+	services = slices.DeleteFunc(services, func(s *Service) bool { return s.Name.Name == "bravo" })
+	services = append(services, &Service{Name: Name{Name: "aardvark"}, ID: "01F"}, &Service{Name: Name{Name: "cobra"}, ID: "01G"})
+
+	opts, err = FromListRequest(deployment_service_go_client.ListServicesParams{MaxResults: &maxResults, NextToken: &next}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := listPage(services, opts)
+	if names := []string{second[0].Name.Name, second[1].Name.Name}; !slices.Equal(names, []string{"charlie", "cobra"}) {
+		t.Fatalf("expected the page to carry on after bravo, got %v", names)
+	}
+}
+
+func TestListCursorRejectsBadTokens(t *testing.T) {
+	byName := ListOptions{SortBy: SortByName, SortOrder: SortOrderAsc}.CursorAfter(&Service{Name: Name{Name: "alpha"}}).Encode()
+	tests := []struct {
+		name  string
+		token string
+		query url.Values
+		field string
+	}{
+		{name: "not base64", token: "!!!", field: "nextToken"},
+		{name: "not JSON", token: "bm90IGpzb24", field: "nextToken"},
+		{name: "issued for another sort", token: byName, query: url.Values{"sortBy": {"createdAt"}}, field: "nextToken"},
+		{name: "issued for another order", token: byName, query: url.Values{"sortOrder": {"desc"}}, field: "nextToken"},
+		{name: "invalid sort", query: url.Values{"sortBy": {"size"}}, field: "sortBy"},
+		{name: "invalid status", query: url.Values{"status": {"broken"}}, field: "status"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := deployment_service_go_client.ListServicesParams{}
+			if tt.token != "" {
+				params.NextToken = &tt.token
+			}
+			_, err := FromListRequest(params, tt.query)
+			apiErr := apierr.From(err)
+			if err == nil || apiErr.Code != apierr.CodeInvalidArgument || len(apiErr.Details) != 1 || apiErr.Details[0].Field != tt.field {
+				t.Fatalf("expected an invalid %s, got %v", tt.field, err)
+			}
+		})
+	}
+
+	zero := 0
+	if _, err := FromListRequest(deployment_service_go_client.ListServicesParams{MaxResults: &zero}, nil); !apierr.HasCode(err, apierr.CodeInvalidArgument) {
+		t.Fatalf("expected maxResults 0 to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/ansonallard/deployment_service_go_client/lib/deployment_service_go_client"
+)
+
+// RevisionAction is the change that produced a revision.
+type RevisionAction string
+
+const (
+	RevisionActionCreate    RevisionAction = "create"
+	RevisionActionUpdate    RevisionAction = "update"
+	RevisionActionUpdateGit RevisionAction = "updateGit"
+	RevisionActionRevert    RevisionAction = "revert"
+	// RevisionActionBaseline records the state of a service that existed
+	// before revisions were kept.
+	RevisionActionBaseline RevisionAction = "baseline"
+	// RevisionActionImport records the state a service was imported with,
+	// when the bundle didn't carry a revision for it.
+	RevisionActionImport RevisionAction = "import"
+)
+
+// Revision is an immutable snapshot of a service's definition. Its ID is the
+// service Version (ETag) the change produced.
+type Revision struct {
+	ID        string         `json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	Caller    string         `json:"caller"`
+	Action    RevisionAction `json:"action"`
+	// RevertedFrom is the revision a revert restored.
+	RevertedFrom string  `json:"reverted_from,omitempty"`
+	Service      Service `json:"service"`
+}
+
+// NewRevision snapshots service. Background state such as a pending release
+// pull request isn't configuration, so it is left out.
+func NewRevision(service *Service, caller string, action RevisionAction) *Revision {
+	snapshot := *service
+	snapshot.ReleasePullRequest = nil
+	snapshot.GitRepoFilePath = ""
+	return &Revision{
+		ID:        service.Version,
+		CreatedAt: time.Now().UTC(),
+		Caller:    caller,
+		Action:    action,
+		Service:   snapshot,
+	}
+}
+
+// RevisionChangeOp says how a field differs between two revisions.
+type RevisionChangeOp string
+
+const (
+	RevisionChangeAdded   RevisionChangeOp = "added"
+	RevisionChangeRemoved RevisionChangeOp = "removed"
+	RevisionChangeChanged RevisionChangeOp = "changed"
+)
+
+// RevisionChange is one differing field. Path is dot separated, in terms of
+// the API representation of the service.
+type RevisionChange struct {
+	Op   RevisionChangeOp `json:"op"`
+	Path string           `json:"path"`
+	From any              `json:"from,omitempty"`
+	To   any              `json:"to,omitempty"`
+}
+
+// DiffRevisions lists the fields that differ between from and to, ordered by
+// path.
+func DiffRevisions(from, to *Revision) ([]RevisionChange, error) {
+	fromFields, err := flattenExternal(&from.Service)
+	if err != nil {
+		return nil, err
+	}
+	toFields, err := flattenExternal(&to.Service)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]RevisionChange, 0)
+	for path, fromValue := range fromFields {
+		toValue, ok := toFields[path]
+		switch {
+		case !ok:
+			changes = append(changes, RevisionChange{Op: RevisionChangeRemoved, Path: path, From: fromValue})
+		case !reflect.DeepEqual(fromValue, toValue):
+			changes = append(changes, RevisionChange{Op: RevisionChangeChanged, Path: path, From: fromValue, To: toValue})
+		}
+	}
+	for path, toValue := range toFields {
+		if _, ok := fromFields[path]; !ok {
+			changes = append(changes, RevisionChange{Op: RevisionChangeAdded, Path: path, To: toValue})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// flattenExternal maps each leaf of the service's API representation to its
+// dot separated path.
+func flattenExternal(service *Service) (map[string]any, error) {
+	serviceDto := new(deployment_service_go_client.Service)
+	if err := service.ToExternal(serviceDto); err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(serviceDto)
+	if err != nil {
+		return nil, err
+	}
+	var document any
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]any)
+	var walk func(prefix string, value any)
+	walk = func(prefix string, value any) {
+		switch typed := value.(type) {
+		case map[string]any:
+			for key, child := range typed {
+				walk(joinPath(prefix, key), child)
+			}
+		case []any:
+			for i, child := range typed {
+				walk(joinPath(prefix, fmt.Sprint(i)), child)
+			}
+		default:
+			fields[prefix] = typed
+		}
+	}
+	walk("", document)
+	return fields, nil
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,67 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func composeRevision(version string, branch string, configuration *DockerComposeConfiguration) *Revision {
+	return &Revision{
+		ID: version,
+		Service: Service{
+			Name:          Name{Name: "my-service"},
+			ID:            "id",
+			Version:       version,
+			GitSSHUrl:     "git@github.com:org/repo.git",
+			GitBranchName: branch,
+			Configuration: ServiceConfiguration{DockerCompose: configuration},
+		},
+	}
+}
+
+func TestDiffRevisions(t *testing.T) {
+	from := composeRevision("v1", "refs/heads/main", &DockerComposeConfiguration{
+		EnvFiles: map[string]EnvVars{".env": {"LOG_LEVEL": "info", "OLD_FLAG": "on"}},
+	})
+	to := composeRevision("v2", "refs/heads/release", &DockerComposeConfiguration{
+		EnvFiles:      map[string]EnvVars{".env": {"LOG_LEVEL": "debug", "NEW_FLAG": "on"}},
+		RefreshImages: true,
+	})
+
+	changes, err := DiffRevisions(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Ordered by path, and the version every revision has its own of isn't
+	// a change
+	expected := []RevisionChange{
+		{Op: RevisionChangeChanged, Path: "configuration.dockerCompose.envFiles..env.LOG_LEVEL", From: "info", To: "debug"},
+		{Op: RevisionChangeAdded, Path: "configuration.dockerCompose.envFiles..env.NEW_FLAG", To: "on"},
+		{Op: RevisionChangeRemoved, Path: "configuration.dockerCompose.envFiles..env.OLD_FLAG", From: "on"},
+		{Op: RevisionChangeChanged, Path: "configuration.dockerCompose.refreshImages", From: false, To: true},
+		{Op: RevisionChangeChanged, Path: "git.branchName", From: "refs/heads/main", To: "refs/heads/release"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected changes\n%+v\ngot\n%+v", expected, changes)
+	}
+
+	// Diffing the other way round swaps each change
+	changes, err = DiffRevisions(to, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != len(expected) || changes[1].Op != RevisionChangeRemoved || changes[2].Op != RevisionChangeAdded || changes[4].To != "refs/heads/main" {
+		t.Fatalf("expected the reversed changes, got %+v", changes)
+	}
+}
+
+func TestDiffRevisionsWithoutChanges(t *testing.T) {
+	revision := composeRevision("v1", "refs/heads/main", &DockerComposeConfiguration{})
+	changes, err := DiffRevisions(revision, composeRevision("v2", "refs/heads/main", &DockerComposeConfiguration{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes == nil || len(changes) != 0 {
+		t.Fatalf("expected an empty list of changes, got %#v", changes)
+	}
+}
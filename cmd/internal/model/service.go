@@ -5,19 +5,113 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
 	"github.com/ansonallard/deployment-service/cmd/internal/utils"
 	"github.com/ansonallard/deployment_service_go_client/lib/deployment_service_go_client"
-	"github.com/ansonallard/go_utils/openapi/ierr"
+	"github.com/tidwall/sjson"
 )
 
 type Service struct {
 	Name
-	ID              string `json:"id"`
-	Version         string `json:"version"`
-	GitSSHUrl       string `json:"git_ssh_url"`
-	GitBranchName   string `json:"branch_name"`
-	GitRepoFilePath string
+	ID            string `json:"id"`
+	Version       string `json:"version"`
+	GitSSHUrl     string `json:"git_ssh_url"`
+	GitBranchName string `json:"branch_name"`
+	// GitCredential names an entry in the server-side credential store. Empty
+	// means the default SSH key.
+	GitCredential      string          `json:"git_credential,omitempty"`
+	GitReleaseStrategy ReleaseStrategy `json:"git_release_strategy,omitempty"`
+	// ReleasePullRequest tracks an open release pull request. Only used with
+	// ReleaseStrategyPullRequest.
+	ReleasePullRequest *ReleasePullRequest `json:"release_pull_request,omitempty"`
+	// GitRepoFilePath is derived from the service directory when loaded
+	GitRepoFilePath string               `json:"-"`
 	Configuration   ServiceConfiguration `json:"configuration"`
+	// DeploymentStatus is stored separately and only attached when the
+	// service is returned from the API
+	DeploymentStatus *DeploymentStatus `json:"-"`
+}
+
+// ReleaseStrategy is how the release commit reaches the tracked branch.
+type ReleaseStrategy string
+
+const (
+	// ReleaseStrategyPush pushes the release commit straight to the branch.
+	ReleaseStrategyPush ReleaseStrategy = "push"
+	// ReleaseStrategyPullRequest pushes it to a release/X.Y.Z branch and opens
+	// a pull request, for branches that are protected against direct pushes.
+	ReleaseStrategyPullRequest ReleaseStrategy = "pull_request"
+)
+
+const (
+	releaseStrategyPushExternal        = "push"
+	releaseStrategyPullRequestExternal = "pullRequest"
+)
+
+// ReleaseStrategyFromExternal parses the API value of releaseStrategy. An
+// empty value means the default, ReleaseStrategyPush.
+func ReleaseStrategyFromExternal(strategy string) (ReleaseStrategy, error) {
+	switch strategy {
+	case "":
+		return "", nil
+	case releaseStrategyPushExternal:
+		return ReleaseStrategyPush, nil
+	case releaseStrategyPullRequestExternal:
+		return ReleaseStrategyPullRequest, nil
+	default:
+		return "", apierr.InvalidField("releaseStrategy", fmt.Sprintf("invalid releaseStrategy %q, expected one of: %s, %s",
+			strategy, releaseStrategyPushExternal, releaseStrategyPullRequestExternal))
+	}
+}
+
+func (r ReleaseStrategy) toExternal() string {
+	if r == ReleaseStrategyPullRequest {
+		return releaseStrategyPullRequestExternal
+	}
+	return releaseStrategyPushExternal
+}
+
+// ReleasePullRequest is a release waiting on its pull request to be merged.
+type ReleasePullRequest struct {
+	Version string `json:"version"`
+	Branch  string `json:"branch"`
+	Number  int    `json:"number"`
+	URL     string `json:"url"`
+	// BaseCommit is the head of the tracked branch the release was cut from.
+	BaseCommit string `json:"base_commit"`
+	// Declined is set when the pull request is closed without merging. No new
+	// release is attempted until the tracked branch moves past BaseCommit.
+	Declined bool `json:"declined,omitempty"`
+}
+
+// GitSettingsUpdate changes where a service is cloned from. Nil fields keep
+// their current value; an empty Credential switches to the default SSH key.
+type GitSettingsUpdate struct {
+	SSHUrl          *string
+	BranchName      *string
+	Credential      *string
+	ReleaseStrategy *ReleaseStrategy
+}
+
+func (s *Service) ApplyGitSettingsUpdate(update GitSettingsUpdate) {
+	previous := *s
+	if update.SSHUrl != nil {
+		s.GitSSHUrl = *update.SSHUrl
+	}
+	if update.BranchName != nil {
+		s.GitBranchName = *update.BranchName
+	}
+	if update.Credential != nil {
+		s.GitCredential = *update.Credential
+	}
+	if update.ReleaseStrategy != nil {
+		s.GitReleaseStrategy = *update.ReleaseStrategy
+	}
+	// A pending release pull request is only valid for the remote, branch and
+	// strategy it was opened under
+	if s.GitSSHUrl != previous.GitSSHUrl || s.GitBranchName != previous.GitBranchName || s.GitReleaseStrategy != previous.GitReleaseStrategy {
+		s.ReleasePullRequest = nil
+	}
 }
 
 type Name struct {
@@ -96,6 +190,18 @@ type DockerBuildConfiguration struct {
 
 type EnvVars map[string]any
 
+// gitConfigurationExtensions holds git options read from the raw request body
+// that aren't part of the generated client's GitConfigurationOptions.
+type gitConfigurationExtensions struct {
+	Credential      string `json:"credential,omitempty"`
+	ReleaseStrategy string `json:"releaseStrategy,omitempty"`
+}
+
+const (
+	gitCredentialJSONKey      = "credential"
+	gitReleaseStrategyJSONKey = "releaseStrategy"
+)
+
 type serviceConfigMember string
 type npmConfigMember string
 type goConfigMember string
@@ -158,7 +264,7 @@ func detectUnionMember[T unionMember](raw []byte, members []T) (T, error) {
 			strs[i] = string(m)
 		}
 		var zero T
-		return zero, ierr.NewBadRequestError(fmt.Sprintf("invalid configuration: none of the expected fields present, expected one of: %s", strings.Join(strs, ", ")))
+		return zero, apierr.Newf(apierr.CodeInvalidArgument, "invalid configuration: none of the expected fields present, expected one of: %s", strings.Join(strs, ", "))
 	}
 	if len(present) > 1 {
 		strs := make([]string, len(present))
@@ -166,28 +272,47 @@ func detectUnionMember[T unionMember](raw []byte, members []T) (T, error) {
 			strs[i] = string(m)
 		}
 		var zero T
-		return zero, ierr.NewBadRequestError(fmt.Sprintf("invalid configuration: multiple configuration types provided: %s", strings.Join(strs, ", ")))
+		return zero, apierr.Newf(apierr.CodeInvalidArgument, "invalid configuration: multiple configuration types provided: %s", strings.Join(strs, ", "))
 	}
 	return present[0], nil
 }
 
 func (s *Service) FromCreateRequest(dto *deployment_service_go_client.CreateServiceRequest) error {
 	var err error
+	if err := ValidateServiceName(dto.Service.Name); err != nil {
+		return err
+	}
 	s.Name.Name = dto.Service.Name
 
 	var gitConfigurationOptions deployment_service_go_client.GitConfigurationOptions
 	if gitConfigurationOptions, err = dto.Service.Git.AsGitConfigurationOptions(); err != nil {
-		return err
+		return invalidRequest(err)
 	}
 
 	s.GitSSHUrl = gitConfigurationOptions.SshUrl
 	s.GitBranchName = gitConfigurationOptions.BranchName
 
+	rawGitConfiguration, err := dto.Service.Git.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	var extensions gitConfigurationExtensions
+	if err := json.Unmarshal(rawGitConfiguration, &extensions); err != nil {
+		return apierr.Newf(apierr.CodeInvalidArgument, "invalid git configuration: %s", err)
+	}
+	s.GitCredential = extensions.Credential
+	if s.GitReleaseStrategy, err = ReleaseStrategyFromExternal(extensions.ReleaseStrategy); err != nil {
+		return err
+	}
+
 	s.ID = utils.GenerateUlidString()
 	s.Version = utils.GenerateUlidString()
 
 	serviceConfiguration, err := s.generateServiceConfiguration(dto.Service.Configuration)
 	if err != nil {
+		return invalidRequest(err)
+	}
+	if err := serviceConfiguration.Validate(); err != nil {
 		return err
 	}
 	s.Configuration = *serviceConfiguration
@@ -197,6 +322,9 @@ func (s *Service) FromCreateRequest(dto *deployment_service_go_client.CreateServ
 func (s *Service) FromUpdateRequest(dto *deployment_service_go_client.UpdateServiceRequest) error {
 	serviceConfiguration, err := s.generateServiceConfiguration(dto.Service.Configuration)
 	if err != nil {
+		return invalidRequest(err)
+	}
+	if err := serviceConfiguration.Validate(); err != nil {
 		return err
 	}
 	s.Configuration = *serviceConfiguration
@@ -213,6 +341,21 @@ func (s *Service) ToExternal(serviceDto *deployment_service_go_client.Service) e
 	}); err != nil {
 		return err
 	}
+	rawGitConfiguration, err := serviceDto.Git.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if s.GitCredential != "" {
+		if rawGitConfiguration, err = sjson.SetBytes(rawGitConfiguration, gitCredentialJSONKey, s.GitCredential); err != nil {
+			return err
+		}
+	}
+	if rawGitConfiguration, err = sjson.SetBytes(rawGitConfiguration, gitReleaseStrategyJSONKey, s.GitReleaseStrategy.toExternal()); err != nil {
+		return err
+	}
+	if err := serviceDto.Git.UnmarshalJSON(rawGitConfiguration); err != nil {
+		return err
+	}
 
 	switch {
 	case s.Configuration.Npm != nil:
@@ -226,12 +369,33 @@ func (s *Service) ToExternal(serviceDto *deployment_service_go_client.Service) e
 	case s.Configuration.DockerBuild != nil:
 		s.toDockerBuildExternal(serviceDto)
 	default:
-		return ierr.NewBadRequestError("invalid service configuration")
+		return apierr.New(apierr.CodeInternal, "invalid service configuration")
 	}
 
 	return nil
 }
 
+// deploymentJSONKey is where the deployment status is added to the service,
+// which the generated client doesn't know about.
+const deploymentJSONKey = "deployment"
+
+// ToExternalJSON is ToExternal with the deployment status added, when one is
+// attached.
+func (s *Service) ToExternalJSON() (json.RawMessage, error) {
+	serviceDto := new(deployment_service_go_client.Service)
+	if err := s.ToExternal(serviceDto); err != nil {
+		return nil, err
+	}
+	rawService, err := json.Marshal(serviceDto)
+	if err != nil {
+		return nil, err
+	}
+	if s.DeploymentStatus == nil {
+		return rawService, nil
+	}
+	return sjson.SetBytes(rawService, deploymentJSONKey, s.DeploymentStatus.ToExternal(s))
+}
+
 func (s *Service) toNpmExternal(serviceDto *deployment_service_go_client.Service) {
 	npmConfiguration := deployment_service_go_client.NPMConfigurationChoices{}
 	var serviceType deployment_service_go_client.NPMServiceType
@@ -323,18 +487,6 @@ func (s *Service) toGoExternal(serviceDto *deployment_service_go_client.Service)
 	})
 }
 
-func FromListRequest(params deployment_service_go_client.ListServicesParams) (maxResults int, nextToken string) {
-	maxResults = 100
-	nextToken = ""
-	if params.MaxResults != nil {
-		maxResults = *params.MaxResults
-	}
-	if params.NextToken != nil {
-		nextToken = *params.NextToken
-	}
-	return maxResults, nextToken
-}
-
 func (s *Service) toDockerBuildExternal(serviceDto *deployment_service_go_client.Service) {
 	serviceDto.Configuration = deployment_service_go_client.ServiceConfiguration{}
 	serviceDto.Configuration.FromDockerBuildConfiguration(deployment_service_go_client.DockerBuildConfiguration{
@@ -385,7 +537,7 @@ func (s *Service) generateServiceConfiguration(serviceConfig deployment_service_
 	case serviceConfigDockerBuild:
 		return s.handleDockerBuildConfiguration(serviceConfig)
 	default:
-		return nil, ierr.NewBadRequestError(fmt.Sprintf("unhandled configuration type: %s", member))
+		return nil, apierr.Newf(apierr.CodeInvalidArgument, "unhandled configuration type: %s", member)
 	}
 }
 
@@ -431,9 +583,9 @@ func (s *Service) handleNpmConfiguration(serviceConfig deployment_service_go_cli
 		}, nil
 	case npmConfigLibrary:
 		// TODO: handle library case
-		return nil, ierr.NewBadRequestError("npm library configuration not yet implemented")
+		return nil, apierr.New(apierr.CodeInvalidArgument, "npm library configuration not yet implemented")
 	default:
-		return nil, ierr.NewBadRequestError(fmt.Sprintf("unhandled npm configuration type: %s", member))
+		return nil, apierr.Newf(apierr.CodeInvalidArgument, "unhandled npm configuration type: %s", member)
 	}
 }
 
@@ -445,7 +597,7 @@ func (s *Service) handleOpenApiconfiugration(serviceConfig deployment_service_go
 
 	// yamlFile is a required property that distinguishes this type structurally
 	if len(openapiConfigurationDto.Openapi.YamlFile) == 0 {
-		return nil, ierr.NewBadRequestError("invalid openapi configuration: yamlFile is required")
+		return nil, apierr.InvalidField("yamlFile", "invalid openapi configuration: yamlFile is required")
 	}
 
 	internalServiceConfig := ServiceConfiguration{
@@ -506,7 +658,7 @@ func (s *Service) handleGoConfiguration(serviceConfig deployment_service_go_clie
 			},
 		}, nil
 	default:
-		return nil, ierr.NewBadRequestError(fmt.Sprintf("unhandled go configuration type: %s", member))
+		return nil, apierr.Newf(apierr.CodeInvalidArgument, "unhandled go configuration type: %s", member)
 	}
 }
 
@@ -0,0 +1,60 @@
+package model
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+)
+
+// Service names become directory names under SERVICE_FILE_PATH and docker
+// image names, so they are restricted to DNS labels.
+var serviceNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+func ValidateServiceName(name string) error {
+	if !serviceNamePattern.MatchString(name) {
+		return apierr.InvalidField("name", fmt.Sprintf(
+			"invalid service name %q: use 1 to 63 lowercase letters, digits and '-', starting and ending with a letter or digit", name))
+	}
+	return nil
+}
+
+// Validate checks the service's name and every user supplied path in its
+// configuration.
+func (s *Service) Validate() error {
+	if err := ValidateServiceName(s.Name.Name); err != nil {
+		return err
+	}
+	return s.Configuration.Validate()
+}
+
+// Validate checks that every path in the configuration is relative to, and
+// stays inside, the service's repo.
+func (c *ServiceConfiguration) Validate() error {
+	switch {
+	case c.OpenAPI != nil && c.OpenAPI.OpenAPI != nil:
+		return validateRepoPath("yamlFile", c.OpenAPI.OpenAPI.YamlFile, false)
+	case c.Go != nil && c.Go.Service != nil:
+		return validateRepoPath("binaryDirectory", c.Go.Service.BinaryDirectory, true)
+	case c.DockerBuild != nil:
+		return validateRepoPath("dockerfilePath", c.DockerBuild.DockerfilePath, true)
+	case c.DockerCompose != nil:
+		for envFile := range c.DockerCompose.EnvFiles {
+			if err := validateRepoPath("envFiles", envFile, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateRepoPath(field string, value string, optional bool) error {
+	if value == "" && optional {
+		return nil
+	}
+	if !filepath.IsLocal(value) {
+		return apierr.InvalidField(field, fmt.Sprintf("invalid %s %q: must be a relative path inside the repo", field, value))
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/rs/zerolog"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// How long fetched keys are used before they are fetched again
+	jwksCacheTTL = time.Hour
+	// Tokens signed with a key we don't know refetch the keys, but no more
+	// often than this, so bad tokens can't hammer the provider
+	jwksMinRefreshInterval = time.Minute
+	// Limit on discovery and JWKS responses
+	maxResponseBytes = 1 << 20
+)
+
+type discoveryDocument struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// keySet fetches the provider's signing keys and caches them by key ID.
+type keySet struct {
+	issuerURL  string
+	httpClient *http.Client
+	// Concurrent refreshes share one fetch
+	refresh singleflight.Group
+
+	mu          sync.Mutex
+	jwksURL     string
+	keys        map[string]jose.JSONWebKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+	fetching    bool
+}
+
+// key returns the public key kid, fetching the keys again if they are stale
+// or don't include it. Refreshes are rate limited either way, and while they
+// fail the keys last fetched are used.
+func (ks *keySet) key(ctx context.Context, kid string) (jose.JSONWebKey, error) {
+	ks.mu.Lock()
+	now := time.Now()
+	key, ok := ks.keys[kid]
+	stale := now.Sub(ks.fetchedAt) > jwksCacheTTL
+	limited := now.Sub(ks.lastAttempt) < jwksMinRefreshInterval
+	fetching := ks.fetching
+	ks.mu.Unlock()
+	if ok && (!stale || limited) {
+		return key, nil
+	}
+	// A fetch already in flight is waited for, since it may bring kid
+	if limited && !fetching {
+		return jose.JSONWebKey{}, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	// The fetch outlives a caller that gives up, so its result is still
+	// cached for the next one
+	result := ks.refresh.DoChan("", func() (any, error) {
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), requestTimeout)
+		defer cancel()
+		return ks.fetchAndStore(fetchCtx)
+	})
+	var keys map[string]jose.JSONWebKey
+	select {
+	case <-ctx.Done():
+		return jose.JSONWebKey{}, ctx.Err()
+	case res := <-result:
+		if res.Err != nil {
+			if ok {
+				// The provider being down shouldn't lock everyone out while the
+				// key we have is still in the set we last fetched
+				zerolog.Ctx(ctx).Warn().Err(res.Err).Msg("Failed to refresh OIDC signing keys, using cached keys")
+				return key, nil
+			}
+			return jose.JSONWebKey{}, fmt.Errorf("failed to fetch OIDC signing keys: %w", res.Err)
+		}
+		keys = res.Val.(map[string]jose.JSONWebKey)
+	}
+	if key, ok = keys[kid]; !ok {
+		return jose.JSONWebKey{}, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// fetchAndStore fetches the keys and caches them. The attempt counts towards
+// the rate limit whether it succeeds or not. A caller that saw a fetch in
+// flight may only get here once it has finished, and gets its keys instead of
+// fetching again.
+func (ks *keySet) fetchAndStore(ctx context.Context) (map[string]jose.JSONWebKey, error) {
+	ks.mu.Lock()
+	if time.Since(ks.lastAttempt) < jwksMinRefreshInterval {
+		keys := ks.keys
+		ks.mu.Unlock()
+		return keys, nil
+	}
+	ks.lastAttempt = time.Now()
+	ks.fetching = true
+	ks.mu.Unlock()
+
+	keys, err := ks.fetch(ctx)
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.fetching = false
+	if err != nil {
+		return nil, err
+	}
+	ks.keys = keys
+	ks.fetchedAt = time.Now()
+	return keys, nil
+}
+
+func (ks *keySet) fetch(ctx context.Context) (map[string]jose.JSONWebKey, error) {
+	ks.mu.Lock()
+	jwksURL := ks.jwksURL
+	ks.mu.Unlock()
+	if jwksURL == "" {
+		var discovery discoveryDocument
+		if err := ks.getJSON(ctx, strings.TrimSuffix(ks.issuerURL, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		if discovery.Issuer != ks.issuerURL {
+			return nil, fmt.Errorf("discovery document is for issuer %q, expected %q", discovery.Issuer, ks.issuerURL)
+		}
+		if discovery.JWKSURI == "" {
+			return nil, fmt.Errorf("discovery document has no jwks_uri")
+		}
+		// Discovery only has to happen once
+		jwksURL = discovery.JWKSURI
+		ks.mu.Lock()
+		ks.jwksURL = jwksURL
+		ks.mu.Unlock()
+	}
+
+	// Keys are parsed one at a time, so one the provider publishes for
+	// something we don't support doesn't lose the rest
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := ks.getJSON(ctx, jwksURL, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]jose.JSONWebKey, len(set.Keys))
+	for _, raw := range set.Keys {
+		var k jose.JSONWebKey
+		if err := json.Unmarshal(raw, &k); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("Skipping unsupported OIDC signing key")
+			continue
+		}
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// Only asymmetric keys sign tokens. A symmetric key in the set would
+		// be public, so it can't be trusted.
+		if !k.IsPublic() {
+			zerolog.Ctx(ctx).Warn().Str("kid", k.KeyID).Msg("Skipping OIDC signing key that isn't a public key")
+			continue
+		}
+		keys[k.KeyID] = k
+	}
+	return keys, nil
+}
+
+func (ks *keySet) getJSON(ctx context.Context, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := ks.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(out)
+}
@@ -0,0 +1,295 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apikeys"
+	"github.com/go-jose/go-jose/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var tracer = otel.Tracer("deployment-service.oidc")
+
+const (
+	requestTimeout = 30 * time.Second
+	// Allowed difference between our clock and the provider's
+	clockSkew = time.Minute
+	// DefaultGroupsClaim is the claim mapped to scopes unless configured
+	// otherwise. Gitea puts the user's organizations and org:team pairs in it.
+	DefaultGroupsClaim = "groups"
+)
+
+// ErrNoScope is returned for valid tokens whose claims don't map to any scope.
+var ErrNoScope = errors.New("token doesn't grant any scope")
+
+// Verifier checks bearer tokens issued by an OIDC provider. Only the
+// asymmetric algorithms a provider publishes keys for are accepted.
+type Verifier interface {
+	// Verify checks rawToken's signature, issuer, audience and lifetime and
+	// maps its claims to a scope.
+	Verify(ctx context.Context, rawToken string) (*Identity, error)
+}
+
+// Identity is who a verified token belongs to.
+type Identity struct {
+	Subject string
+	// Username is the preferred_username claim, or the subject without one
+	Username string
+	Scope    apikeys.Scope
+}
+
+type Config struct {
+	// IssuerURL must match the iss claim exactly
+	IssuerURL string
+	// Audience must be in the aud claim, usually the OAuth client ID
+	Audience string
+	// JWKSURL defaults to the jwks_uri of the issuer's discovery document
+	JWKSURL string
+	// GroupsClaim defaults to DefaultGroupsClaim
+	GroupsClaim string
+	// ScopeMapping maps values of the groups claim to scopes. A token gets
+	// the broadest scope any of its groups maps to.
+	ScopeMapping map[string]apikeys.Scope
+}
+
+func NewVerifier(config Config) (Verifier, error) {
+	if config.IssuerURL == "" {
+		return nil, fmt.Errorf("issuerURL not provided")
+	}
+	if config.Audience == "" {
+		return nil, fmt.Errorf("audience not provided")
+	}
+	if len(config.ScopeMapping) == 0 {
+		return nil, fmt.Errorf("scopeMapping not provided")
+	}
+	groupsClaim := config.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = DefaultGroupsClaim
+	}
+	return &verifier{
+		issuerURL:    config.IssuerURL,
+		audience:     config.Audience,
+		groupsClaim:  groupsClaim,
+		scopeMapping: config.ScopeMapping,
+		keys: &keySet{
+			issuerURL:  config.IssuerURL,
+			jwksURL:    config.JWKSURL,
+			httpClient: &http.Client{Timeout: requestTimeout},
+		},
+	}, nil
+}
+
+// ParseScopeMapping parses group=scope pairs separated by commas, e.g.
+// "my-org:owners=admin,my-org:developers=write,my-org=read".
+func ParseScopeMapping(mapping string) (map[string]apikeys.Scope, error) {
+	scopes := make(map[string]apikeys.Scope)
+	for pair := range strings.SplitSeq(mapping, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		group, scope, ok := strings.Cut(pair, "=")
+		if !ok || group == "" {
+			return nil, fmt.Errorf("invalid scope mapping %q, expected group=scope", pair)
+		}
+		parsed, err := apikeys.ScopeFromExternal(scope)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scope mapping %q: %w", pair, err)
+		}
+		scopes[group] = parsed
+	}
+	return scopes, nil
+}
+
+type verifier struct {
+	issuerURL    string
+	audience     string
+	groupsClaim  string
+	scopeMapping map[string]apikeys.Scope
+	keys         *keySet
+}
+
+type claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	ExpiresAt         *int64   `json:"exp"`
+	NotBefore         *int64   `json:"nbf"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// signatureAlgorithms are the algorithms tokens may be signed with. Notably
+// missing are none and the HMAC algorithms, which would need a shared secret.
+var signatureAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+}
+
+// curves are the curves of the ES algorithms, which each have their own.
+var curves = map[jose.SignatureAlgorithm]elliptic.Curve{
+	jose.ES256: elliptic.P256(),
+	jose.ES384: elliptic.P384(),
+	jose.ES512: elliptic.P521(),
+}
+
+// audience is a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+func (v *verifier) Verify(ctx context.Context, rawToken string) (*Identity, error) {
+	ctx, span := tracer.Start(ctx, "oidc.verify")
+	defer span.End()
+
+	token, err := jose.ParseSignedCompact(rawToken, signatureAlgorithms)
+	var unexpected *jose.ErrUnexpectedSignatureAlgorithm
+	if errors.As(err, &unexpected) {
+		return nil, fmt.Errorf("unsupported token algorithm %q", unexpected.Got)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed token: %w", err)
+	}
+	h := token.Signatures[0].Header
+	key, err := v.keys.key(ctx, h.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	alg := jose.SignatureAlgorithm(h.Algorithm)
+	if !keyMatches(key, alg) {
+		return nil, fmt.Errorf("token algorithm %s doesn't match its key", alg)
+	}
+	payload, err := token.Verify(key.Key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if err := v.validateClaims(&c, time.Now()); err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{Subject: c.Subject, Username: c.PreferredUsername}
+	if identity.Username == "" {
+		identity.Username = c.Subject
+	}
+	span.SetAttributes(attribute.String("oidc.subject", c.Subject))
+
+	groups, err := v.groups(payload)
+	if err != nil {
+		return nil, err
+	}
+	scope, ok := v.scope(groups)
+	if !ok {
+		return identity, ErrNoScope
+	}
+	identity.Scope = scope
+	span.SetAttributes(attribute.String("oidc.scope", string(scope)))
+	return identity, nil
+}
+
+func (v *verifier) validateClaims(c *claims, now time.Time) error {
+	if c.Issuer != v.issuerURL {
+		return fmt.Errorf("token issued by %q, expected %q", c.Issuer, v.issuerURL)
+	}
+	if !slices.Contains(c.Audience, v.audience) {
+		return fmt.Errorf("token isn't for audience %q", v.audience)
+	}
+	if c.Subject == "" {
+		return fmt.Errorf("token has no subject")
+	}
+	if c.ExpiresAt == nil {
+		return fmt.Errorf("token has no expiry")
+	}
+	if now.After(time.Unix(*c.ExpiresAt, 0).Add(clockSkew)) {
+		return fmt.Errorf("token expired")
+	}
+	if c.NotBefore != nil && now.Add(clockSkew).Before(time.Unix(*c.NotBefore, 0)) {
+		return fmt.Errorf("token not valid yet")
+	}
+	return nil
+}
+
+// groups returns the values of the groups claim, which may be a list or a
+// space separated string like the standard scope claim.
+func (v *verifier) groups(payload []byte) ([]string, error) {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &all); err != nil {
+		return nil, err
+	}
+	value, ok := all[v.groupsClaim]
+	if !ok {
+		return nil, nil
+	}
+	var list []string
+	if err := json.Unmarshal(value, &list); err == nil {
+		return list, nil
+	}
+	var single string
+	if err := json.Unmarshal(value, &single); err != nil {
+		return nil, fmt.Errorf("claim %s isn't a string or a list of strings", v.groupsClaim)
+	}
+	return strings.Fields(single), nil
+}
+
+// scope returns the broadest scope groups map to.
+func (v *verifier) scope(groups []string) (apikeys.Scope, bool) {
+	var best apikeys.Scope
+	found := false
+	for _, group := range groups {
+		scope, ok := v.scopeMapping[group]
+		if !ok {
+			continue
+		}
+		if !found || scope.Includes(best) {
+			best = scope
+			found = true
+		}
+	}
+	return best, found
+}
+
+// keyMatches reports whether key may check signatures made with alg. The
+// token names its algorithm, so without this a key the provider published for
+// one algorithm could be used with another.
+func keyMatches(key jose.JSONWebKey, alg jose.SignatureAlgorithm) bool {
+	if key.Algorithm != "" && key.Algorithm != string(alg) {
+		return false
+	}
+	switch alg {
+	case jose.RS256, jose.RS384, jose.RS512, jose.PS256, jose.PS384, jose.PS512:
+		_, ok := key.Key.(*rsa.PublicKey)
+		return ok
+	case jose.ES256, jose.ES384, jose.ES512:
+		ecKey, ok := key.Key.(*ecdsa.PublicKey)
+		return ok && ecKey.Curve == curves[alg]
+	default:
+		return false
+	}
+}
@@ -0,0 +1,420 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apikeys"
+	"github.com/go-jose/go-jose/v4"
+)
+
+const (
+	testAudience = "deployment-service"
+	rsaKeyID     = "rsa"
+	ecKeyID      = "ec"
+	// rs256KeyID is the RSA key again, published for RS256 only
+	rs256KeyID = "rsa-rs256"
+	// hmacKeyID is a symmetric key, which must never be trusted
+	hmacKeyID = "hmac"
+)
+
+// provider serves a discovery document and the JWKS of its keys.
+type provider struct {
+	server      *httptest.Server
+	rsaKey      *rsa.PrivateKey
+	ecKey       *ecdsa.PrivateKey
+	jwksFetches atomic.Int32
+	// failing makes the JWKS endpoint return 500
+	failing atomic.Bool
+	// block, when set, holds JWKS requests until it is closed
+	block chan struct{}
+}
+
+func newProvider(t *testing.T) *provider {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &provider{rsaKey: rsaKey, ecKey: ecKey}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(discoveryDocument{Issuer: p.server.URL, JWKSURI: p.server.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		p.jwksFetches.Add(1)
+		if p.block != nil {
+			<-p.block
+		}
+		if p.failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &rsaKey.PublicKey, KeyID: rsaKeyID, Use: "sig"},
+			{Key: &ecKey.PublicKey, KeyID: ecKeyID, Use: "sig"},
+			{Key: &rsaKey.PublicKey, KeyID: rs256KeyID, Use: "sig", Algorithm: string(jose.RS256)},
+			{Key: hmacSecret, KeyID: hmacKeyID, Use: "sig", Algorithm: string(jose.HS256)},
+		}})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *provider) verifier(t *testing.T) *verifier {
+	t.Helper()
+	v, err := NewVerifier(Config{
+		IssuerURL:    p.server.URL,
+		Audience:     testAudience,
+		ScopeMapping: map[string]apikeys.Scope{"my-org:developers": apikeys.ScopeWrite},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v.(*verifier)
+}
+
+// claims returns valid claims, which tests then break.
+func (p *provider) claims() map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":    p.server.URL,
+		"sub":    "1234",
+		"aud":    testAudience,
+		"exp":    now.Add(time.Hour).Unix(),
+		"nbf":    now.Add(-time.Minute).Unix(),
+		"groups": []string{"my-org:developers"},
+	}
+}
+
+// sign returns a token with claims, signed with alg by the key kid names.
+func (p *provider) sign(t *testing.T, alg string, kid string, claims map[string]any) string {
+	t.Helper()
+	signingInput := encodeSegment(t, map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	var signature []byte
+	var err error
+	switch alg {
+	case "none":
+	case "HS256":
+		// The classic confusion attack: the public key used as an HMAC secret
+		secret := p.rsaKey.N.Bytes()
+		if kid == hmacKeyID {
+			secret = hmacSecret
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case "RS256":
+		digest := sha256.Sum256([]byte(signingInput))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, p.rsaKey, crypto.SHA256, digest[:])
+	case "PS256":
+		digest := sha256.Sum256([]byte(signingInput))
+		signature, err = rsa.SignPSS(rand.Reader, p.rsaKey, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES256":
+		digest := sha256.Sum256([]byte(signingInput))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, p.ecKey, digest[:])
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	default:
+		t.Fatalf("can't sign with %s", alg)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encodeSegment(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// hmacSecret is the key the provider publishes under hmacKeyID.
+var hmacSecret = []byte("a shared secret that is published for everyone")
+
+func TestVerifyAcceptsSignatures(t *testing.T) {
+	p := newProvider(t)
+	v := p.verifier(t)
+	for _, tt := range []struct {
+		alg string
+		kid string
+	}{
+		{alg: "RS256", kid: rsaKeyID},
+		{alg: "PS256", kid: rsaKeyID},
+		{alg: "ES256", kid: ecKeyID},
+		{alg: "RS256", kid: rs256KeyID},
+	} {
+		t.Run(tt.alg+" "+tt.kid, func(t *testing.T) {
+			identity, err := v.Verify(context.Background(), p.sign(t, tt.alg, tt.kid, p.claims()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.Subject != "1234" || identity.Scope != apikeys.ScopeWrite {
+				t.Fatalf("expected subject 1234 with scope %s, got %+v", apikeys.ScopeWrite, identity)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	p := newProvider(t)
+	v := p.verifier(t)
+	for _, tt := range []struct {
+		name    string
+		alg     string
+		kid     string
+		modify  func(claims map[string]any)
+		wantErr string
+	}{
+		{
+			name: "wrong issuer", alg: "RS256", kid: rsaKeyID,
+			modify:  func(c map[string]any) { c["iss"] = "https://attacker.example.com" },
+			wantErr: "token issued by",
+		},
+		{
+			name: "wrong audience", alg: "RS256", kid: rsaKeyID,
+			modify:  func(c map[string]any) { c["aud"] = []string{"another-client"} },
+			wantErr: "isn't for audience",
+		},
+		{
+			name: "expired", alg: "RS256", kid: rsaKeyID,
+			modify:  func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+			wantErr: "token expired",
+		},
+		{
+			name: "not valid yet", alg: "RS256", kid: rsaKeyID,
+			modify:  func(c map[string]any) { c["nbf"] = time.Now().Add(time.Hour).Unix() },
+			wantErr: "not valid yet",
+		},
+		{name: "alg none", alg: "none", kid: rsaKeyID, wantErr: "unsupported token algorithm"},
+		{name: "HS256", alg: "HS256", kid: rsaKeyID, wantErr: "unsupported token algorithm"},
+		{name: "HS256 with a published secret", alg: "HS256", kid: hmacKeyID, wantErr: "unsupported token algorithm"},
+		{name: "algorithm doesn't match key type", alg: "ES256", kid: rsaKeyID, wantErr: "doesn't match its key"},
+		{name: "algorithm doesn't match key alg", alg: "PS256", kid: rs256KeyID, wantErr: "doesn't match its key"},
+		{name: "no expiry", alg: "RS256", kid: rsaKeyID, modify: func(c map[string]any) { delete(c, "exp") }, wantErr: "no expiry"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := p.claims()
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+			_, err := v.Verify(context.Background(), p.sign(t, tt.alg, tt.kid, claims))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSymmetricKeysAreNotTrusted(t *testing.T) {
+	p := newProvider(t)
+	v := p.verifier(t)
+	if _, err := v.keys.key(context.Background(), rsaKeyID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.keys.key(context.Background(), hmacKeyID); err == nil || !strings.Contains(err.Error(), "unknown signing key") {
+		t.Fatalf("expected the HMAC key to be skipped, got %v", err)
+	}
+}
+
+func TestKeyMatches(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name     string
+		key      jose.JSONWebKey
+		alg      jose.SignatureAlgorithm
+		expected bool
+	}{
+		{name: "RSA key for RS256", key: jose.JSONWebKey{Key: &rsaKey.PublicKey}, alg: jose.RS256, expected: true},
+		{name: "RSA key for PS512", key: jose.JSONWebKey{Key: &rsaKey.PublicKey}, alg: jose.PS512, expected: true},
+		{name: "RSA key for ES256", key: jose.JSONWebKey{Key: &rsaKey.PublicKey}, alg: jose.ES256},
+		{name: "RS256 key for PS256", key: jose.JSONWebKey{Key: &rsaKey.PublicKey, Algorithm: string(jose.RS256)}, alg: jose.PS256},
+		{name: "P-256 key for ES256", key: jose.JSONWebKey{Key: &p256Key.PublicKey}, alg: jose.ES256, expected: true},
+		{name: "P-256 key for ES384", key: jose.JSONWebKey{Key: &p256Key.PublicKey}, alg: jose.ES384},
+		{name: "P-384 key for ES384", key: jose.JSONWebKey{Key: &p384Key.PublicKey}, alg: jose.ES384, expected: true},
+		{name: "EC key for RS256", key: jose.JSONWebKey{Key: &p256Key.PublicKey}, alg: jose.RS256},
+		{name: "RSA key for none", key: jose.JSONWebKey{Key: &rsaKey.PublicKey}, alg: "none"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keyMatches(tt.key, tt.alg); got != tt.expected {
+				t.Fatalf("expected %t, got %t", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsTamperedClaims(t *testing.T) {
+	p := newProvider(t)
+	v := p.verifier(t)
+	token := p.sign(t, "ES256", ecKeyID, p.claims())
+	parts := strings.Split(token, ".")
+	claims := p.claims()
+	claims["sub"] = "admin"
+	parts[1] = encodeSegment(t, claims)
+	if _, err := v.Verify(context.Background(), strings.Join(parts, ".")); err == nil {
+		t.Fatal("expected a token with changed claims to be rejected")
+	}
+}
+
+func TestUnknownKeyIDRefetchesOnce(t *testing.T) {
+	p := newProvider(t)
+	v := p.verifier(t)
+	ctx := context.Background()
+	if _, err := v.Verify(ctx, p.sign(t, "RS256", rsaKeyID, p.claims())); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.jwksFetches.Load(); got != 1 {
+		t.Fatalf("expected 1 JWKS fetch, got %d", got)
+	}
+
+	// Let the next refresh through the rate limit
+	v.keys.mu.Lock()
+	v.keys.lastAttempt = time.Now().Add(-2 * jwksMinRefreshInterval)
+	v.keys.mu.Unlock()
+	for range 3 {
+		if _, err := v.Verify(ctx, p.sign(t, "RS256", "rotated", p.claims())); err == nil || !strings.Contains(err.Error(), "unknown signing key") {
+			t.Fatalf("expected an unknown signing key, got %v", err)
+		}
+	}
+	if got := p.jwksFetches.Load(); got != 2 {
+		t.Fatalf("expected the unknown key to refetch once, got %d fetches", got)
+	}
+	// Known keys are still served from the cache
+	if _, err := v.Verify(ctx, p.sign(t, "ES256", ecKeyID, p.claims())); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.jwksFetches.Load(); got != 2 {
+		t.Fatalf("expected no more fetches, got %d", got)
+	}
+}
+
+func TestStaleKeysAreServedWhileRefreshFails(t *testing.T) {
+	p := newProvider(t)
+	v := p.verifier(t)
+	ctx := context.Background()
+	token := p.sign(t, "RS256", rsaKeyID, p.claims())
+	if _, err := v.Verify(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+
+	p.failing.Store(true)
+	v.keys.mu.Lock()
+	v.keys.fetchedAt = time.Now().Add(-2 * jwksCacheTTL)
+	v.keys.lastAttempt = v.keys.fetchedAt
+	v.keys.mu.Unlock()
+	if _, err := v.Verify(ctx, token); err != nil {
+		t.Fatalf("expected the cached key to be used, got %v", err)
+	}
+	// Stale keys are rate limited too, so a provider outage isn't hammered
+	for range 3 {
+		if _, err := v.Verify(ctx, token); err != nil {
+			t.Fatalf("expected the cached key to be used, got %v", err)
+		}
+	}
+	if got := p.jwksFetches.Load(); got != 2 {
+		t.Fatalf("expected one refresh of the stale keys, got %d fetches", got)
+	}
+}
+
+func TestConcurrentVerifiesShareOneFetch(t *testing.T) {
+	p := newProvider(t)
+	p.block = make(chan struct{})
+	v := p.verifier(t)
+	token := p.sign(t, "RS256", rsaKeyID, p.claims())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Go(func() {
+			_, err := v.Verify(context.Background(), token)
+			errs <- err
+		})
+	}
+	// Give every caller time to reach the fetch
+	time.Sleep(50 * time.Millisecond)
+	close(p.block)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := p.jwksFetches.Load(); got != 1 {
+		t.Fatalf("expected one JWKS fetch, got %d", got)
+	}
+}
+
+func TestFetchOutlivesCanceledCaller(t *testing.T) {
+	p := newProvider(t)
+	p.block = make(chan struct{})
+	v := p.verifier(t)
+	token := p.sign(t, "RS256", rsaKeyID, p.claims())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := v.Verify(ctx, token); err == nil {
+		t.Fatal("expected the caller to give up")
+	}
+	close(p.block)
+
+	// The detached fetch still caches the keys
+	deadline := time.Now().Add(time.Second)
+	for {
+		v.keys.mu.Lock()
+		_, ok := v.keys.keys[rsaKeyID]
+		v.keys.mu.Unlock()
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("keys weren't cached after the caller gave up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := v.Verify(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.jwksFetches.Load(); got != 1 {
+		t.Fatalf("expected one JWKS fetch, got %d", got)
+	}
+}
@@ -0,0 +1,112 @@
+package pullrequest
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const githubHost = "github.com"
+
+type State string
+
+const (
+	StateOpen   State = "open"
+	StateClosed State = "closed"
+	StateMerged State = "merged"
+)
+
+type PullRequest struct {
+	Number int
+	URL    string
+	State  State
+	// MergeCommitSHA is the commit the pull request landed as on the base
+	// branch. Only set once merged.
+	MergeCommitSHA string
+}
+
+// Repository identifies a repository on a forge.
+type Repository struct {
+	Owner string
+	Name  string
+}
+
+type CreateOptions struct {
+	// Head is the branch holding the changes, Base the branch to merge into.
+	Head  string
+	Base  string
+	Title string
+	Body  string
+}
+
+// Provider opens and tracks pull requests on a forge such as GitHub or Gitea.
+type Provider interface {
+	CreatePullRequest(ctx context.Context, repo Repository, opts CreateOptions) (*PullRequest, error)
+	GetPullRequest(ctx context.Context, repo Repository, number int) (*PullRequest, error)
+}
+
+// Resolver picks the provider that hosts a git remote.
+type Resolver interface {
+	Resolve(gitURL string) (Provider, Repository, error)
+}
+
+type ResolverConfig struct {
+	GitHub Provider // Optional
+	Gitea  Provider // Optional
+	// GiteaHost is the host remotes on the Gitea instance are cloned from.
+	GiteaHost string
+}
+
+func NewResolver(config ResolverConfig) Resolver {
+	return &resolver{
+		github:    config.GitHub,
+		gitea:     config.Gitea,
+		giteaHost: config.GiteaHost,
+	}
+}
+
+type resolver struct {
+	github    Provider
+	gitea     Provider
+	giteaHost string
+}
+
+func (r *resolver) Resolve(gitURL string) (Provider, Repository, error) {
+	host, repo, err := ParseRemote(gitURL)
+	if err != nil {
+		return nil, Repository{}, err
+	}
+	switch {
+	case host == githubHost && r.github != nil:
+		return r.github, repo, nil
+	case host == r.giteaHost && r.gitea != nil:
+		return r.gitea, repo, nil
+	default:
+		return nil, Repository{}, fmt.Errorf("no pull request provider configured for %s", host)
+	}
+}
+
+// ParseRemote extracts the host, owner and repository name from an SSH,
+// scp-style or HTTPS git remote.
+func ParseRemote(gitURL string) (string, Repository, error) {
+	var host, repoPath string
+	if u, err := url.Parse(gitURL); err == nil && u.Scheme != "" && u.Host != "" {
+		host = u.Hostname()
+		repoPath = u.Path
+	} else if at := strings.Index(gitURL, "@"); at >= 0 && strings.Contains(gitURL[at:], ":") {
+		// scp-style: git@host:owner/repo.git
+		hostAndPath := gitURL[at+1:]
+		colon := strings.Index(hostAndPath, ":")
+		host = hostAndPath[:colon]
+		repoPath = hostAndPath[colon+1:]
+	} else {
+		return "", Repository{}, fmt.Errorf("unrecognised git remote %q", gitURL)
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimSuffix(repoPath, ".git"), "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", Repository{}, fmt.Errorf("git remote %q is not of the form owner/repo", gitURL)
+	}
+	return host, Repository{Owner: parts[0], Name: parts[1]}, nil
+}
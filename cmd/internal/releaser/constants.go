@@ -6,4 +6,5 @@ const (
 	GoUserKey      = "gouser"
 	GoPATKey       = "gopat"
 	GithubPATKey   = "github_token"
+	GitSigningKey  = "git_signing_key"
 )
@@ -13,9 +13,14 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/Masterminds/semver/v3"
+	"github.com/ansonallard/deployment-service/cmd/internal/events"
 	logwriter "github.com/ansonallard/deployment-service/cmd/internal/log_writer"
+	"github.com/ansonallard/deployment-service/cmd/internal/metrics"
+	"github.com/ansonallard/deployment-service/cmd/internal/runlog"
 	"github.com/moby/moby/api/types/registry"
 	"github.com/moby/moby/client"
 	"github.com/rs/zerolog"
@@ -33,6 +38,8 @@ type DockerReleaser interface {
 	BuildImageWithSecrets(ctx context.Context, repositoryPath, dockerfilePath string, tags []string, secrets map[string][]byte) error
 	PushImage(ctx context.Context, serviceName string, tag string) error
 	RemoveImage(ctx context.Context, tag string) error
+	// ListImages returns the tags of the service's images on the build daemon.
+	ListImages(ctx context.Context, serviceName string) ([]string, error)
 	CreateArtifactTag(serviceName string, version *semver.Version) string
 	CreateLatestArtifactTag(serviceName string) string
 }
@@ -115,8 +122,10 @@ func (r *dockerReleaser) BuildImage(ctx context.Context, repositoryPath, dockerf
 	}
 	defer resp.Body.Close()
 
-	// Stream build logs to stdout
-	_, err = io.Copy(os.Stdout, resp.Body)
+	// Stream build logs to stdout and event subscribers
+	buildLog := events.NewLineWriter(ctx, events.TypeBuildLog)
+	defer buildLog.Close()
+	_, err = io.Copy(io.MultiWriter(os.Stdout, buildLog, runlog.Writer(ctx)), resp.Body)
 	if err != nil {
 		return fmt.Errorf("failed to read build output: %w", err)
 	}
@@ -212,9 +221,16 @@ func (r *dockerReleaser) BuildImageWithSecrets(
 		fmt.Sprintf("DOCKER_HOST=%s", r.dockerHost),
 	}
 
-	// Pipe stdout and stderr to zerolog
-	cmd.Stdout = logwriter.NewZerologWriter(*log, zerolog.InfoLevel)
-	cmd.Stderr = logwriter.NewZerologWriter(*log, zerolog.InfoLevel)
+	// Pipe stdout and stderr to zerolog, event subscribers and the run log
+	buildLog := events.NewLineWriter(ctx, events.TypeBuildLog)
+	defer buildLog.Close()
+	output := io.MultiWriter(
+		logwriter.NewZerologWriter(*log, zerolog.InfoLevel),
+		buildLog,
+		runlog.Writer(ctx),
+	)
+	cmd.Stdout = output
+	cmd.Stderr = output
 
 	// Execute the build
 	if err := cmd.Run(); err != nil {
@@ -278,7 +294,7 @@ func CreateTar(dir string) (io.ReadCloser, error) {
 }
 
 // PushImage pushes the built Docker image to the registry.
-func (r *dockerReleaser) PushImage(ctx context.Context, serviceName string, tag string) error {
+func (r *dockerReleaser) PushImage(ctx context.Context, serviceName string, tag string) (retErr error) {
 	ctx, span := tracer.Start(ctx, "releaser.push_image",
 		trace.WithAttributes(
 			attribute.String("service.name", serviceName),
@@ -286,6 +302,8 @@ func (r *dockerReleaser) PushImage(ctx context.Context, serviceName string, tag
 		),
 	)
 	defer span.End()
+	start := time.Now()
+	defer func() { metrics.RecordPush(ctx, serviceName, start, retErr) }()
 
 	log := zerolog.Ctx(ctx)
 
@@ -323,6 +341,8 @@ func (r *dockerReleaser) PushImage(ctx context.Context, serviceName string, tag
 			Str("tag", tag).
 			Str("imagePushOutput", line).
 			Msg("Image push progress")
+		events.Emit(ctx, events.Event{Type: events.TypePushProgress, Message: line})
+		fmt.Fprintln(runlog.Writer(ctx), line)
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -365,6 +385,31 @@ func (r *dockerReleaser) RemoveImage(ctx context.Context, tag string) error {
 	return nil
 }
 
+func (r *dockerReleaser) ListImages(ctx context.Context, serviceName string) ([]string, error) {
+	ctx, span := tracer.Start(ctx, "releaser.list_images",
+		trace.WithAttributes(attribute.String("service.name", serviceName)),
+	)
+	defer span.End()
+
+	repository := fmt.Sprintf("%s/%s", r.artifactPrefix, serviceName)
+	result, err := r.dockerclient.ImageList(ctx, client.ImageListOptions{
+		Filters: make(client.Filters).Add("reference", repository),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+	tags := make([]string, 0)
+	for _, image := range result.Items {
+		// An image can also be tagged for other repositories
+		for _, tag := range image.RepoTags {
+			if strings.HasPrefix(tag, repository+":") {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags, nil
+}
+
 func (r *dockerReleaser) CreateArtifactTag(serviceName string, version *semver.Version) string {
 	return fmt.Sprintf("%s/%s:%s", r.artifactPrefix, serviceName, version.String())
 }
@@ -2,17 +2,23 @@ package repo
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/credentials"
+	"github.com/ansonallard/deployment-service/cmd/internal/identity"
 	"github.com/ansonallard/deployment-service/cmd/internal/model"
 	"github.com/ansonallard/deployment-service/cmd/internal/utils"
-	"github.com/ansonallard/go_utils/openapi/ierr"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/rs/zerolog"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
@@ -21,18 +27,44 @@ import (
 
 var tracer = otel.Tracer("deployment-service.repo")
 
+// busyWait is how long API operations wait for a running release of the
+// service to finish before failing with CodeServiceBusy.
+const busyWait = 30 * time.Second
+
 type DeploymentService interface {
+	// Create stores a new service as provisioning, without cloning it.
+	// Provision clones it.
 	Create(ctx context.Context, service *model.Service) error
+	// Provision clones the service's repo into its worktree, writing the
+	// clone's progress to progress when it isn't nil. It waits for the
+	// service lock rather than failing, since nothing else should hold it
+	// for long before the service is provisioned.
+	Provision(ctx context.Context, name string, progress io.Writer) (*model.Service, error)
 	Get(ctx context.Context, serviceName string) (*model.Service, error)
-	List(ctx context.Context, maxResults int, nextToken string) ([]*model.Service, error)
+	// List returns a page of services and the token for the next page, which
+	// is empty on the last page.
+	List(ctx context.Context, opts model.ListOptions) ([]*model.Service, string, error)
 	Update(ctx context.Context, name string, ifMatch string, partial *model.Service) (*model.Service, error)
-	Delete(ctx context.Context, serviceName string) error
+	UpdateGit(ctx context.Context, name string, ifMatch string, update model.GitSettingsUpdate) (*model.Service, error)
+	Repair(ctx context.Context, name string) (*model.Service, error)
+	SetReleasePullRequest(ctx context.Context, name string, releasePullRequest *model.ReleasePullRequest) error
+	Delete(ctx context.Context, serviceName string, archive bool, teardown func(service *model.Service) error) (string, error)
+	// Fsck checks and repairs the stored services, quarantining unreadable
+	// ones. It returns the names of the quarantined services.
+	Fsck(ctx context.Context) ([]string, error)
+	ListRevisions(ctx context.Context, serviceName string) ([]*model.Revision, error)
+	GetRevision(ctx context.Context, serviceName string, revisionID string) (*model.Revision, error)
+	Revert(ctx context.Context, serviceName string, ifMatch string, revisionID string) (*model.Service, error)
+	Import(ctx context.Context, service *model.Service, revisions []*model.Revision, overwrite bool) error
+	GetDeploymentStatus(ctx context.Context, serviceName string) (*model.DeploymentStatus, error)
+	UpdateDeploymentStatus(ctx context.Context, serviceName string, update func(status *model.DeploymentStatus)) error
 }
 
 type DeploymentServieConfig struct {
 	ServiceFilPath string
-	SSHKeyPath     string
+	Credentials    credentials.Store
 	GitClient      GitClient
+	ServiceLocks   ServiceLocks
 	GitRepoOrigin  string
 }
 
@@ -40,12 +72,15 @@ func NewDeploymentService(config DeploymentServieConfig) (DeploymentService, err
 	if config.ServiceFilPath == "" {
 		return nil, fmt.Errorf("serviceFilePath not set")
 	}
-	if config.SSHKeyPath == "" {
-		return nil, fmt.Errorf("sshKeyPath not set")
+	if config.Credentials == nil {
+		return nil, fmt.Errorf("credentials not set")
 	}
 	if config.GitClient == nil {
 		return nil, fmt.Errorf("git client not set")
 	}
+	if config.ServiceLocks == nil {
+		return nil, fmt.Errorf("service locks not set")
+	}
 	if err := dirExists(config.ServiceFilPath); err != nil {
 		return nil, err
 	}
@@ -53,9 +88,12 @@ func NewDeploymentService(config DeploymentServieConfig) (DeploymentService, err
 		filePath:                 config.ServiceFilPath,
 		serviceConfigurationFile: "service_definition.json",
 		gitRepoPath:              "repo",
-		sshKeyPath:               config.SSHKeyPath,
+		credentials:              config.Credentials,
 		gitClient:                config.GitClient,
+		serviceLocks:             config.ServiceLocks,
+		definitionLocks:          newDefinitionLocks(path.Join(config.ServiceFilPath, locksDir)),
 		gitRepoOrigin:            config.GitRepoOrigin,
+		busyWait:                 busyWait,
 	}, nil
 }
 
@@ -63,9 +101,12 @@ type deploymentService struct {
 	filePath                 string
 	serviceConfigurationFile string
 	gitRepoPath              string
-	sshKeyPath               string
+	credentials              credentials.Store
 	gitClient                GitClient
+	serviceLocks             ServiceLocks
+	definitionLocks          *definitionLocks
 	gitRepoOrigin            string
+	busyWait                 time.Duration
 }
 
 func (ds *deploymentService) Create(ctx context.Context, service *model.Service) error {
@@ -74,58 +115,135 @@ func (ds *deploymentService) Create(ctx context.Context, service *model.Service)
 	)
 	defer span.End()
 
-	servicePath := ds.getServiceFilePath(service.Name.Name)
+	return ds.create(ctx, service, []*model.Revision{
+		model.NewRevision(service, identity.CallerFromContext(ctx), model.RevisionActionCreate),
+	})
+}
 
-	if err := os.MkdirAll(servicePath, os.ModePerm); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+// create stores a new service with revisions as its history, marked as
+// provisioning.
+func (ds *deploymentService) create(ctx context.Context, service *model.Service, revisions []*model.Revision) error {
+	if !validServiceDirName(service.Name.Name) {
+		return apierr.InvalidField("name", fmt.Sprintf("invalid service name %q", service.Name.Name))
 	}
-
-	fileBytes, err := json.MarshalIndent(service, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal service: %w", err)
+	// An unknown or mismatched credential is rejected straight away, rather
+	// than by every clone attempt
+	if _, err := ds.credentials.AuthMethod(service.GitCredential, service.GitSSHUrl); err != nil {
+		return err
 	}
 
-	if err := os.WriteFile(ds.getServiceConfigurationFilePath(service.Name.Name), fileBytes, 0644); err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+	if err := ds.createServiceDefinition(service, revisions); err != nil {
+		return err
 	}
-
-	gitRepoPath := ds.getGitRepoFilePath(service.Name.Name)
-	if err := os.MkdirAll(gitRepoPath, os.ModePerm); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+	if err := ds.UpdateDeploymentStatus(ctx, service.Name.Name, func(status *model.DeploymentStatus) {
+		status.ProvisioningStarted(0)
+	}); err != nil {
+		if cleanupErr := os.RemoveAll(ds.getServiceFilePath(service.Name.Name)); cleanupErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to clean up service file: %w", cleanupErr))
+		}
+		return err
 	}
-	service.GitRepoFilePath = gitRepoPath
+	service.GitRepoFilePath = ds.getGitRepoFilePath(service.Name.Name)
+	return nil
+}
 
-	sshAuth, err := ssh.NewPublicKeysFromFile("git", ds.sshKeyPath, "")
+func (ds *deploymentService) Provision(ctx context.Context, name string, progress io.Writer) (*model.Service, error) {
+	ctx, span := tracer.Start(ctx, "repo.provision",
+		trace.WithAttributes(attribute.String("service.name", name)),
+	)
+	defer span.End()
+
+	unlock := ds.serviceLocks.Lock(name)
+	defer unlock()
+
+	current, err := ds.Get(ctx, name)
 	if err != nil {
-		return fmt.Errorf("failed to load ssh key: %w", err)
+		return nil, err
+	}
+	if err := ds.recloneAndSwap(ctx, current, progress, func() error { return nil }); err != nil {
+		return nil, err
 	}
+	return current, nil
+}
 
-	cloneCtx, cloneSpan := tracer.Start(ctx, "repo.git.clone",
+// Import stores a service from an export bundle with its revisions, marked as
+// provisioning like a created one. With overwrite an existing service takes
+// the bundle's definition; its worktree is left in place until Provision
+// re-clones it.
+func (ds *deploymentService) Import(ctx context.Context, service *model.Service, revisions []*model.Revision, overwrite bool) error {
+	ctx, span := tracer.Start(ctx, "repo.import",
 		trace.WithAttributes(
 			attribute.String("service.name", service.Name.Name),
-			attribute.String("git.url", service.GitSSHUrl),
-			attribute.String("git.branch", service.GitBranchName),
+			attribute.Bool("overwrite", overwrite),
 		),
 	)
-	_, err = ds.gitClient.Clone(cloneCtx, gitRepoPath, &git.CloneOptions{
-		URL:           service.GitSSHUrl,
-		ReferenceName: plumbing.ReferenceName(service.GitBranchName),
-		SingleBranch:  true,
-		Auth:          sshAuth,
-		RemoteName:    ds.gitRepoOrigin,
+	defer span.End()
+
+	if !overwrite {
+		return ds.create(ctx, service, revisions)
+	}
+	if _, err := ds.Get(ctx, service.Name.Name); err != nil {
+		if apierr.HasCode(err, apierr.CodeServiceNotFound) {
+			return ds.create(ctx, service, revisions)
+		}
+		return err
+	}
+	if _, err := ds.credentials.AuthMethod(service.GitCredential, service.GitSSHUrl); err != nil {
+		return err
+	}
+
+	if err := ds.replaceServiceDefinition(service, revisions); err != nil {
+		return err
+	}
+	service.GitRepoFilePath = ds.getGitRepoFilePath(service.Name.Name)
+	return ds.UpdateDeploymentStatus(ctx, service.Name.Name, func(status *model.DeploymentStatus) {
+		status.ProvisioningStarted(0)
 	})
+}
+
+// replaceServiceDefinition overwrites an existing service's definition and
+// stores the revisions it doesn't have yet.
+func (ds *deploymentService) replaceServiceDefinition(service *model.Service, revisions []*model.Revision) error {
+	if !ds.serviceExists(service.Name.Name) {
+		return errServiceNotFound(service.Name.Name)
+	}
+	unlock, err := ds.definitionLocks.Lock(service.Name.Name)
 	if err != nil {
-		cloneSpan.RecordError(err)
-		cloneSpan.SetStatus(codes.Error, err.Error())
+		return err
 	}
-	cloneSpan.End()
+	defer unlock()
+	if err := ds.writeServiceDefinition(service); err != nil {
+		return err
+	}
+	return ds.writeMissingRevisions(service.Name.Name, revisions)
+}
+
+// createServiceDefinition creates the service's directory and definition. It
+// uses Mkdir rather than MkdirAll, so of two concurrent creates only one wins.
+func (ds *deploymentService) createServiceDefinition(service *model.Service, revisions []*model.Revision) error {
+	unlock, err := ds.definitionLocks.Lock(service.Name.Name)
 	if err != nil {
-		if err := os.RemoveAll(servicePath); err != nil {
-			return fmt.Errorf("failed to clean up service file")
-		}
-		return fmt.Errorf("failed to clone repo: %w", err)
+		return err
 	}
+	defer unlock()
 
+	servicePath := ds.getServiceFilePath(service.Name.Name)
+	if err := os.Mkdir(servicePath, os.ModePerm); err != nil {
+		if os.IsExist(err) {
+			return apierr.Newf(apierr.CodeAlreadyExists, "service %s already exists", service.Name.Name)
+		}
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	err = ds.writeServiceDefinition(service)
+	if err == nil {
+		err = ds.writeMissingRevisions(service.Name.Name, revisions)
+	}
+	if err != nil {
+		if cleanupErr := os.RemoveAll(servicePath); cleanupErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to clean up service file: %w", cleanupErr))
+		}
+		return err
+	}
 	return nil
 }
 
@@ -135,55 +253,59 @@ func (ds *deploymentService) Get(ctx context.Context, serviceName string) (*mode
 	)
 	defer span.End()
 
-	servicePath := ds.getServiceFilePath(serviceName)
-	file, err := os.Stat(servicePath)
-	if err != nil {
-		return nil, &ierr.NotFoundError{}
-	}
-	if !file.IsDir() {
-		return nil, &ierr.NotFoundError{}
+	if !ds.serviceExists(serviceName) {
+		return nil, errServiceNotFound(serviceName)
 	}
-	fileBytes, err := os.ReadFile(ds.getServiceConfigurationFilePath(serviceName))
+
+	unlock, err := ds.definitionLocks.RLock(serviceName)
 	if err != nil {
 		return nil, err
 	}
-	service := new(model.Service)
-	if err := json.Unmarshal(fileBytes, service); err != nil {
-		return nil, err
-	}
-	service.GitRepoFilePath = ds.getGitRepoFilePath(serviceName)
-	return service, nil
+	defer unlock()
+	return ds.readServiceDefinition(serviceName)
 }
 
-func (ds *deploymentService) List(ctx context.Context, maxResults int, nextToken string) ([]*model.Service, error) {
+func (ds *deploymentService) List(ctx context.Context, opts model.ListOptions) ([]*model.Service, string, error) {
 	ctx, span := tracer.Start(ctx, "repo.list",
-		trace.WithAttributes(attribute.Int("max_results", maxResults)),
+		trace.WithAttributes(
+			attribute.Int("max_results", opts.MaxResults),
+			attribute.String("sort_by", string(opts.SortBy)),
+			attribute.String("sort_order", string(opts.SortOrder)),
+		),
 	)
 	defer span.End()
 
-	services := make([]*model.Service, 0)
-
 	dirEntries, err := os.ReadDir(ds.filePath)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	counter := 0
+	// Filtering and created-at ordering need every definition, so the whole
+	// directory is read and the page cut afterwards
+	services := make([]*model.Service, 0)
 	for _, dirEntry := range dirEntries {
-		if !dirEntry.IsDir() {
+		if !dirEntry.IsDir() || isHidden(dirEntry.Name()) {
 			continue
 		}
 		serviceName := dirEntry.Name()
 		service, err := ds.Get(ctx, serviceName)
 		if err != nil {
-			return nil, err
+			// One broken definition mustn't hide every other service
+			zerolog.Ctx(ctx).Error().Err(err).Str("service", serviceName).Msg("Skipping unreadable service")
+			continue
 		}
-		counter++
-		services = append(services, service)
-		if counter >= maxResults {
-			break
+		if opts.Matches(service) && opts.After(service) {
+			services = append(services, service)
 		}
 	}
-	return services, nil
+	sort.Slice(services, func(i, j int) bool {
+		return opts.Less(services[i], services[j])
+	})
+
+	if len(services) <= opts.MaxResults {
+		return services, "", nil
+	}
+	page := services[:opts.MaxResults]
+	return page, opts.CursorAfter(page[len(page)-1]).Encode(), nil
 }
 
 func (ds *deploymentService) Update(ctx context.Context, name string, ifMatch string, partial *model.Service) (*model.Service, error) {
@@ -194,45 +316,261 @@ func (ds *deploymentService) Update(ctx context.Context, name string, ifMatch st
 	)
 	defer span.End()
 
-	current, err := ds.Get(ctx, name)
+	if _, err := ds.Get(ctx, name); err != nil {
+		return nil, err
+	}
+	return ds.mutateServiceDefinition(ctx, name, func(current *model.Service) (*model.Revision, error) {
+		if current.Version != ifMatch {
+			return nil, model.NewVersionMismatchError()
+		}
+		current.Configuration = partial.Configuration
+		current.Version = utils.GenerateUlidString()
+		return model.NewRevision(current, identity.CallerFromContext(ctx), model.RevisionActionUpdate), nil
+	})
+}
+
+// UpdateGit points the service at a new remote, branch or credential. The new
+// settings are cloned next to the current worktree first, so a bad URL or a
+// missing branch leaves the service untouched.
+func (ds *deploymentService) UpdateGit(ctx context.Context, name string, ifMatch string, update model.GitSettingsUpdate) (*model.Service, error) {
+	ctx, span := tracer.Start(ctx, "repo.update_git",
+		trace.WithAttributes(attribute.String("service.name", name)),
+	)
+	defer span.End()
+
+	unlock, err := ds.waitForService(ctx, name)
 	if err != nil {
 		return nil, err
 	}
+	defer unlock()
 
+	current, err := ds.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
 	if current.Version != ifMatch {
-		return nil, &model.PreConditionFailedError{}
+		return nil, model.NewVersionMismatchError()
+	}
+
+	current.ApplyGitSettingsUpdate(update)
+	newVersion := utils.GenerateUlidString()
+
+	// The clone can take a while, so If-Match is checked again when the new
+	// settings are written
+	var updated *model.Service
+	if err := ds.recloneAndSwap(ctx, current, nil, func() error {
+		updated, err = ds.mutateServiceDefinition(ctx, name, func(latest *model.Service) (*model.Revision, error) {
+			if latest.Version != ifMatch {
+				return nil, model.NewVersionMismatchError()
+			}
+			latest.ApplyGitSettingsUpdate(update)
+			latest.Version = newVersion
+			return model.NewRevision(latest, identity.CallerFromContext(ctx), model.RevisionActionUpdateGit), nil
+		})
+		return err
+	}); err != nil {
+		return nil, err
 	}
+	return updated, nil
+}
 
-	current.Configuration = partial.Configuration
-	current.Version = utils.GenerateUlidString()
+// Repair replaces the service's worktree with a fresh clone of its current
+// git settings.
+func (ds *deploymentService) Repair(ctx context.Context, name string) (*model.Service, error) {
+	ctx, span := tracer.Start(ctx, "repo.repair",
+		trace.WithAttributes(attribute.String("service.name", name)),
+	)
+	defer span.End()
 
-	fileBytes, err := json.MarshalIndent(current, "", "  ")
+	unlock, err := ds.waitForService(ctx, name)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal service: %w", err)
+		return nil, err
 	}
+	defer unlock()
 
-	if err := os.WriteFile(ds.getServiceConfigurationFilePath(name), fileBytes, 0644); err != nil {
-		return nil, fmt.Errorf("failed to write file: %w", err)
+	current, err := ds.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := ds.recloneAndSwap(ctx, current, nil, func() error { return nil }); err != nil {
+		return nil, err
 	}
-
-	current.GitRepoFilePath = ds.getGitRepoFilePath(name)
 	return current, nil
 }
 
-func (ds *deploymentService) Delete(ctx context.Context, serviceName string) error {
+// SetReleasePullRequest records the release pull request the background job
+// is waiting on, or clears it when nil. It is internal state, so the
+// service's version is left unchanged.
+func (ds *deploymentService) SetReleasePullRequest(ctx context.Context, name string, releasePullRequest *model.ReleasePullRequest) error {
+	ctx, span := tracer.Start(ctx, "repo.set_release_pull_request",
+		trace.WithAttributes(attribute.String("service.name", name)),
+	)
+	defer span.End()
+
+	if _, err := ds.Get(ctx, name); err != nil {
+		return err
+	}
+	_, err := ds.mutateServiceDefinition(ctx, name, func(current *model.Service) (*model.Revision, error) {
+		current.ReleasePullRequest = releasePullRequest
+		return nil, nil
+	})
+	return err
+}
+
+// waitForService takes the service's lock for an API operation, waiting up to
+// busyWait for a background tick holding it to finish.
+func (ds *deploymentService) waitForService(ctx context.Context, name string) (func(), error) {
+	ctx, cancel := context.WithTimeout(ctx, ds.busyWait)
+	defer cancel()
+	unlock, err := ds.serviceLocks.LockContext(ctx, name)
+	if err != nil {
+		return nil, errServiceBusy()
+	}
+	return unlock, nil
+}
+
+// recloneAndSwap clones service into a temporary directory, then renames it
+// over the current worktree and runs persist. If persist fails the previous
+// worktree is restored. The clone's progress is written to progress when it
+// isn't nil. Callers must hold the service lock.
+func (ds *deploymentService) recloneAndSwap(ctx context.Context, service *model.Service, progress io.Writer, persist func() error) error {
+	ctx, span := tracer.Start(ctx, "repo.reclone",
+		trace.WithAttributes(
+			attribute.String("service.name", service.Name.Name),
+			attribute.String("git.url", service.GitSSHUrl),
+			attribute.String("git.branch", service.GitBranchName),
+		),
+	)
+	defer span.End()
+
+	gitAuth, err := ds.credentials.AuthMethod(service.GitCredential, service.GitSSHUrl)
+	if err != nil {
+		return err
+	}
+
+	servicePath := ds.getServiceFilePath(service.Name.Name)
+	gitRepoPath := ds.getGitRepoFilePath(service.Name.Name)
+	suffix := utils.GenerateUlidString()
+	clonePath := path.Join(servicePath, fmt.Sprintf(".%s-%s", ds.gitRepoPath, suffix))
+	previousPath := path.Join(servicePath, fmt.Sprintf(".%s-previous-%s", ds.gitRepoPath, suffix))
+	defer os.RemoveAll(clonePath)
+
+	_, err = ds.gitClient.Clone(ctx, clonePath, &git.CloneOptions{
+		URL:           service.GitSSHUrl,
+		ReferenceName: plumbing.ReferenceName(service.GitBranchName),
+		SingleBranch:  true,
+		Auth:          gitAuth,
+		RemoteName:    ds.gitRepoOrigin,
+		Progress:      progress,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if errors.Is(err, git.NoMatchingRefSpecError{}) || errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return apierr.Newf(apierr.CodeBranchNotFound, "branch %s not found in %s", service.GitBranchName, service.GitSSHUrl)
+		}
+		return cloneError(service, err)
+	}
+
+	// A corrupted service may have lost its worktree entirely
+	hadPrevious := true
+	if err := os.Rename(gitRepoPath, previousPath); err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to move current worktree aside: %w", err)
+		}
+		hadPrevious = false
+	}
+	restore := func() {
+		if hadPrevious {
+			_ = os.RemoveAll(gitRepoPath)
+			_ = os.Rename(previousPath, gitRepoPath)
+		}
+	}
+
+	if err := os.Rename(clonePath, gitRepoPath); err != nil {
+		restore()
+		return fmt.Errorf("failed to swap in new worktree: %w", err)
+	}
+	if err := persist(); err != nil {
+		restore()
+		return err
+	}
+
+	service.GitRepoFilePath = gitRepoPath
+	if hadPrevious {
+		if err := os.RemoveAll(previousPath); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("path", previousPath).Msg("Failed to remove previous worktree")
+		}
+	}
+	return nil
+}
+
+// Delete removes the service's directory, or moves it to
+// SERVICE_FILE_PATH/.archive when archive is set, and returns where it was
+// archived to. A non-nil teardown runs first, while the service can't be
+// processed; if it fails nothing is removed.
+func (ds *deploymentService) Delete(ctx context.Context, serviceName string, archive bool, teardown func(service *model.Service) error) (string, error) {
 	ctx, span := tracer.Start(ctx, "repo.delete",
-		trace.WithAttributes(attribute.String("service.name", serviceName)),
+		trace.WithAttributes(
+			attribute.String("service.name", serviceName),
+			attribute.Bool("archive", archive),
+		),
 	)
 	defer span.End()
 
+	if !validServiceDirName(serviceName) {
+		return "", errServiceNotFound(serviceName)
+	}
 	servicePath := ds.getServiceFilePath(serviceName)
 	if _, err := os.Stat(servicePath); err != nil {
-		return &ierr.NotFoundError{}
+		return "", errServiceNotFound(serviceName)
+	}
+
+	if teardown != nil {
+		unlock, err := ds.waitForService(ctx, serviceName)
+		if err != nil {
+			return "", err
+		}
+		defer unlock()
+
+		service, err := ds.Get(ctx, serviceName)
+		if err != nil {
+			return "", err
+		}
+		if err := teardown(service); err != nil {
+			return "", err
+		}
+	}
+
+	unlock, err := ds.definitionLocks.Lock(serviceName)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	if archive {
+		destination, err := ds.moveAside(serviceName, archiveDir)
+		if err != nil {
+			return "", fmt.Errorf("failed to archive service: %w", err)
+		}
+		return destination, nil
 	}
 	if err := os.RemoveAll(servicePath); err != nil {
-		return fmt.Errorf("failed to delete service: %w", err)
+		return "", fmt.Errorf("failed to delete service: %w", err)
 	}
-	return nil
+	return "", nil
+}
+
+// validServiceDirName reports whether serviceName can only refer to a service
+// directory directly inside SERVICE_FILE_PATH. Names are validated when
+// services are created, this guards every lookup that bypasses that.
+func validServiceDirName(serviceName string) bool {
+	return serviceName != "" && !strings.ContainsAny(serviceName, `/\`) && !isHidden(serviceName)
+}
+
+// serviceExists reports whether serviceName is a valid name with a service
+// directory. Definition locks are only taken for services that exist.
+func (ds *deploymentService) serviceExists(serviceName string) bool {
+	return validServiceDirName(serviceName) && dirExists(ds.getServiceFilePath(serviceName)) == nil
 }
 
 func (ds *deploymentService) getServiceFilePath(serviceName string) string {
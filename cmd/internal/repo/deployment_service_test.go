@@ -0,0 +1,193 @@
+package repo
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/identity"
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/utils"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+const testServiceName = "service"
+
+type noCredentials struct{}
+
+func (noCredentials) AuthMethod(credentialName, gitURL string) (transport.AuthMethod, error) {
+	return nil, nil
+}
+
+// newTestRepo returns a repo over a temporary SERVICE_FILE_PATH, cloning from
+// in-memory remotes, and the URL of a remote with one commit on main.
+func newTestRepo(t *testing.T) (*deploymentService, string) {
+	t.Helper()
+	client := NewInMemoryGitClient()
+	url, err := client.CreateRemote(testServiceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.PushCommit(context.Background(), url, "main", "feat: initial commit", map[string]string{"version.txt": "0.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	ds, err := NewDeploymentService(DeploymentServieConfig{
+		ServiceFilPath: t.TempDir(),
+		Credentials:    noCredentials{},
+		GitClient:      client,
+		ServiceLocks:   NewServiceLocks(),
+		GitRepoOrigin:  git.DefaultRemoteName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ds.(*deploymentService), url
+}
+
+func newTestService(url string) *model.Service {
+	return &model.Service{
+		Name:          model.Name{Name: testServiceName},
+		ID:            utils.GenerateUlidString(),
+		Version:       utils.GenerateUlidString(),
+		GitSSHUrl:     url,
+		GitBranchName: "main",
+		Configuration: model.ServiceConfiguration{Go: &model.GoConfiguration{Service: &model.GoServiceConfiguration{}}},
+	}
+}
+
+// createProvisioned stores service and clones it.
+func createProvisioned(t *testing.T, ds *deploymentService, service *model.Service) {
+	t.Helper()
+	ctx := context.Background()
+	if err := ds.Create(ctx, service); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.Provision(ctx, service.Name.Name, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepairWaitsForRunningTick(t *testing.T) {
+	ds, url := newTestRepo(t)
+	createProvisioned(t, ds, newTestService(url))
+
+	// A background tick holds the service for a moment
+	unlock := ds.serviceLocks.Lock(testServiceName)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		unlock()
+	}()
+	if _, err := ds.Repair(context.Background(), testServiceName); err != nil {
+		t.Fatalf("expected repair to wait for the tick, got %v", err)
+	}
+}
+
+func TestRepairGivesUpOnLongTick(t *testing.T) {
+	ds, url := newTestRepo(t)
+	createProvisioned(t, ds, newTestService(url))
+	ds.busyWait = 20 * time.Millisecond
+
+	unlock := ds.serviceLocks.Lock(testServiceName)
+	defer unlock()
+	_, err := ds.Repair(context.Background(), testServiceName)
+	if !apierr.HasCode(err, apierr.CodeServiceBusy) {
+		t.Fatalf("expected %s, got %v", apierr.CodeServiceBusy, err)
+	}
+}
+
+func TestImportProvisionsLikeCreate(t *testing.T) {
+	ds, url := newTestRepo(t)
+	ctx := context.Background()
+	service := newTestService(url)
+	if err := ds.Import(ctx, service, []*model.Revision{model.NewRevision(service, identity.System, model.RevisionActionImport)}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is cloned until the service is provisioned
+	if _, err := os.Stat(ds.getGitRepoFilePath(testServiceName)); !os.IsNotExist(err) {
+		t.Fatalf("expected import not to clone, got %v", err)
+	}
+	status, err := ds.GetDeploymentStatus(ctx, testServiceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.EffectiveProvisioning() != model.ProvisioningStateProvisioning {
+		t.Fatalf("expected the service to be provisioning, got %s", status.EffectiveProvisioning())
+	}
+	if _, err := ds.Provision(ctx, testServiceName, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(ds.getGitRepoFilePath(testServiceName), "version.txt")); err != nil {
+		t.Fatalf("expected provisioning to clone, got %v", err)
+	}
+}
+
+func TestImportOverwriteKeepsCloneUntilProvisioned(t *testing.T) {
+	ds, url := newTestRepo(t)
+	ctx := context.Background()
+	createProvisioned(t, ds, newTestService(url))
+	if err := ds.UpdateDeploymentStatus(ctx, testServiceName, func(status *model.DeploymentStatus) {
+		status.ProvisioningFinished(nil)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	imported := newTestService(url)
+	imported.GitBranchName = "release"
+	if err := ds.Import(ctx, imported, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := ds.Get(ctx, testServiceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.GitBranchName != "release" {
+		t.Fatalf("expected the imported definition, got branch %s", stored.GitBranchName)
+	}
+	if _, err := os.Stat(path.Join(ds.getGitRepoFilePath(testServiceName), "version.txt")); err != nil {
+		t.Fatalf("expected the current clone to be kept, got %v", err)
+	}
+	status, err := ds.GetDeploymentStatus(ctx, testServiceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.EffectiveProvisioning() != model.ProvisioningStateProvisioning {
+		t.Fatalf("expected the service to be provisioning again, got %s", status.EffectiveProvisioning())
+	}
+}
+
+func TestUnknownServicesLeaveNoLockFiles(t *testing.T) {
+	ds, url := newTestRepo(t)
+	createProvisioned(t, ds, newTestService(url))
+	ctx := context.Background()
+
+	if _, err := ds.GetDeploymentStatus(ctx, "unknown"); !apierr.HasCode(err, apierr.CodeServiceNotFound) {
+		t.Fatalf("expected %s, got %v", apierr.CodeServiceNotFound, err)
+	}
+	if err := ds.UpdateDeploymentStatus(ctx, "unknown", func(status *model.DeploymentStatus) {}); !apierr.HasCode(err, apierr.CodeServiceNotFound) {
+		t.Fatalf("expected %s, got %v", apierr.CodeServiceNotFound, err)
+	}
+	if _, err := ds.Get(ctx, "unknown"); !apierr.HasCode(err, apierr.CodeServiceNotFound) {
+		t.Fatalf("expected %s, got %v", apierr.CodeServiceNotFound, err)
+	}
+	if _, err := ds.Update(ctx, "unknown", "version", newTestService(url)); !apierr.HasCode(err, apierr.CodeServiceNotFound) {
+		t.Fatalf("expected %s, got %v", apierr.CodeServiceNotFound, err)
+	}
+	if _, err := ds.Repair(ctx, "unknown"); !apierr.HasCode(err, apierr.CodeServiceNotFound) {
+		t.Fatalf("expected %s, got %v", apierr.CodeServiceNotFound, err)
+	}
+
+	entries, err := os.ReadDir(path.Join(ds.filePath, locksDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != testServiceName+lockFileSuffix {
+			t.Fatalf("expected only the existing service's lock file, found %s", entry.Name())
+		}
+	}
+}
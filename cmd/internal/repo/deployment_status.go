@@ -0,0 +1,129 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const deploymentStatusFile = "deployment_status.json"
+
+// GetDeploymentStatus returns the service's deployment status, or an idle
+// status if the service hasn't run yet.
+func (ds *deploymentService) GetDeploymentStatus(ctx context.Context, serviceName string) (*model.DeploymentStatus, error) {
+	_, span := tracer.Start(ctx, "repo.get_deployment_status",
+		trace.WithAttributes(attribute.String("service.name", serviceName)),
+	)
+	defer span.End()
+
+	if !ds.serviceExists(serviceName) {
+		return nil, errServiceNotFound(serviceName)
+	}
+	unlock, err := ds.definitionLocks.RLock(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return ds.readDeploymentStatus(serviceName)
+}
+
+// UpdateDeploymentStatus applies update to the service's deployment status.
+// It is only written when update changes it, so the background job can call
+// it every tick.
+func (ds *deploymentService) UpdateDeploymentStatus(ctx context.Context, serviceName string, update func(status *model.DeploymentStatus)) error {
+	_, span := tracer.Start(ctx, "repo.update_deployment_status",
+		trace.WithAttributes(attribute.String("service.name", serviceName)),
+	)
+	defer span.End()
+
+	if !ds.serviceExists(serviceName) {
+		return errServiceNotFound(serviceName)
+	}
+	unlock, err := ds.definitionLocks.Lock(serviceName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// The service may have been deleted while waiting for the lock
+	if !ds.serviceExists(serviceName) {
+		return errServiceNotFound(serviceName)
+	}
+
+	status, err := ds.readDeploymentStatus(serviceName)
+	if err != nil {
+		return err
+	}
+	previous := *status
+	update(status)
+	if reflect.DeepEqual(previous, *status) {
+		return nil
+	}
+	status.UpdatedAt = time.Now().UTC()
+	return ds.writeDeploymentStatus(serviceName, status)
+}
+
+// readDeploymentStatus reads the service's deployment status. Callers must
+// hold the service's definition lock.
+func (ds *deploymentService) readDeploymentStatus(serviceName string) (*model.DeploymentStatus, error) {
+	statusBytes, err := os.ReadFile(ds.getDeploymentStatusFilePath(serviceName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return model.NewDeploymentStatus(), nil
+		}
+		return nil, err
+	}
+	status := new(model.DeploymentStatus)
+	if err := json.Unmarshal(statusBytes, status); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", deploymentStatusFile, err)
+	}
+	return status, nil
+}
+
+// writeDeploymentStatus replaces the service's deployment status. Callers must
+// hold the service's definition lock.
+func (ds *deploymentService) writeDeploymentStatus(serviceName string, status *model.DeploymentStatus) error {
+	statusBytes, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal deployment status: %w", err)
+	}
+	if err := writeFileAtomic(ds.getDeploymentStatusFilePath(serviceName), statusBytes, 0644); err != nil {
+		return fmt.Errorf("failed to write deployment status: %w", err)
+	}
+	return nil
+}
+
+func (ds *deploymentService) getDeploymentStatusFilePath(serviceName string) string {
+	return path.Join(ds.getServiceFilePath(serviceName), deploymentStatusFile)
+}
+
+// resetInterruptedDeploymentStatus clears a status left queued or running by
+// a restart, so it doesn't report a run that is no longer happening. Callers
+// must hold the service's definition lock.
+func (ds *deploymentService) resetInterruptedDeploymentStatus(ctx context.Context, serviceName string) error {
+	status, err := ds.readDeploymentStatus(serviceName)
+	if err != nil {
+		return err
+	}
+	switch status.State {
+	case model.PipelineStateQueued:
+		status.State = model.PipelineStateIdle
+	case model.PipelineStateRunning:
+		status.Failed(errors.New("interrupted by a restart"))
+	default:
+		return nil
+	}
+	zerolog.Ctx(ctx).Info().Str("service", serviceName).Str("state", string(status.State)).
+		Msg("Reset deployment status of interrupted run")
+	status.UpdatedAt = time.Now().UTC()
+	return ds.writeDeploymentStatus(serviceName, status)
+}
@@ -0,0 +1,33 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+)
+
+func errServiceNotFound(serviceName string) error {
+	return apierr.Newf(apierr.CodeServiceNotFound, "service %q not found", serviceName)
+}
+
+func errServiceBusy() error {
+	return apierr.New(apierr.CodeServiceBusy, "service is being processed, try again shortly")
+}
+
+func errRevisionNotFound(revisionID string) error {
+	return apierr.Newf(apierr.CodeRevisionNotFound, "revision %q not found", revisionID)
+}
+
+// cloneError maps a failed clone of service onto CodeCloneFailed, with the
+// reason the remote gave. Failing to write the clone locally is internal,
+// since its error has our paths in it.
+func cloneError(service *model.Service, err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return fmt.Errorf("failed to clone repo: %w", err)
+	}
+	return apierr.Newf(apierr.CodeCloneFailed, "failed to clone %s: %s", service.GitSSHUrl, err)
+}
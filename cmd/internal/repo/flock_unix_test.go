@@ -0,0 +1,67 @@
+//go:build linux || darwin
+
+package repo
+
+import (
+	"testing"
+	"time"
+)
+
+// Each definitionLocks opens its own lock files, so two of them over one
+// directory lock each other out like two processes would.
+func TestDefinitionLocksExcludeOtherProcesses(t *testing.T) {
+	dir := t.TempDir()
+	first, second := newDefinitionLocks(dir), newDefinitionLocks(dir)
+
+	unlock, err := first.Lock("service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acquired := make(chan func())
+	go func() {
+		unlock, err := second.RLock("service")
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- unlock
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("read a definition another process is writing")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case unlock := <-acquired:
+		unlock()
+	case <-time.After(time.Second):
+		t.Fatal("lock wasn't acquired once it was released")
+	}
+}
+
+func TestDefinitionLocksShareReads(t *testing.T) {
+	dir := t.TempDir()
+	first, second := newDefinitionLocks(dir), newDefinitionLocks(dir)
+
+	unlockFirst, err := first.RLock("service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlockFirst()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		unlock, err := second.RLock("service")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		unlock()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a read waited for another read")
+	}
+}
@@ -2,8 +2,12 @@ package repo
 
 import (
 	"context"
+	"errors"
 
+	"github.com/ansonallard/deployment-service/cmd/internal/signing"
 	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
@@ -12,8 +16,48 @@ import (
 
 var gitTracer = otel.Tracer("deployment-service.repo.git")
 
+// GitClient is the only way the service touches git, so the versioning and
+// release flow can run against the in-memory remotes of NewInMemoryGitClient
+// instead of a real one.
 type GitClient interface {
-	Clone(ctx context.Context, path string, opts *git.CloneOptions) (*git.Repository, error)
+	Clone(ctx context.Context, path string, opts *git.CloneOptions) (Repository, error)
+	Open(ctx context.Context, path string) (Repository, error)
+}
+
+// Repository is a single cloned repository. Fetch, Pull and Push treat
+// git.NoErrAlreadyUpToDate as success.
+type Repository interface {
+	Fetch(ctx context.Context, opts *git.FetchOptions) error
+	Pull(ctx context.Context, opts *git.PullOptions) error
+	Head(ctx context.Context) (*plumbing.Reference, error)
+	Log(ctx context.Context, from plumbing.Hash) (object.CommitIter, error)
+	Tags(ctx context.Context) ([]Tag, error)
+	Commit(ctx context.Context, opts CommitOptions) (plumbing.Hash, error)
+	CreateTag(ctx context.Context, name string, hash plumbing.Hash, opts TagOptions) (*plumbing.Reference, error)
+	Push(ctx context.Context, opts *git.PushOptions) error
+	Reset(ctx context.Context, opts *git.ResetOptions) error
+}
+
+// Tag is a tag resolved to the commit it points at.
+type Tag struct {
+	Name      string
+	Target    plumbing.Hash
+	Annotated bool
+}
+
+type CommitOptions struct {
+	Message string
+	Author  *object.Signature
+	// StageAll stages every change in the worktree before committing.
+	StageAll   bool
+	AllowEmpty bool
+	Signer     git.Signer // Optional, the commit is unsigned when nil
+}
+
+type TagOptions struct {
+	Tagger  *object.Signature
+	Message string
+	Signer  git.Signer // Optional, the tag is unsigned when nil
 }
 
 func NewGitClient() GitClient {
@@ -22,7 +66,7 @@ func NewGitClient() GitClient {
 
 type gitClient struct{}
 
-func (g *gitClient) Clone(ctx context.Context, path string, opts *git.CloneOptions) (*git.Repository, error) {
+func (g *gitClient) Clone(ctx context.Context, path string, opts *git.CloneOptions) (Repository, error) {
 	ctx, span := gitTracer.Start(ctx, "git.clone",
 		trace.WithAttributes(
 			attribute.String("git.url", opts.URL),
@@ -33,8 +77,212 @@ func (g *gitClient) Clone(ctx context.Context, path string, opts *git.CloneOptio
 
 	repo, err := git.PlainCloneContext(ctx, path, false, opts)
 	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, err.Error())
+		recordSpanError(span, err)
+		return nil, err
+	}
+	return &repository{repo: repo}, nil
+}
+
+func (g *gitClient) Open(ctx context.Context, path string) (Repository, error) {
+	_, span := gitTracer.Start(ctx, "git.open",
+		trace.WithAttributes(attribute.String("repo_path", path)),
+	)
+	defer span.End()
+
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+	return &repository{repo: repo}, nil
+}
+
+// repository wraps a go-git repository, tracing every operation.
+type repository struct {
+	repo *git.Repository
+}
+
+func (r *repository) Fetch(ctx context.Context, opts *git.FetchOptions) error {
+	ctx, span := gitTracer.Start(ctx, "git.fetch",
+		trace.WithAttributes(attribute.String("git.remote", opts.RemoteName)),
+	)
+	defer span.End()
+
+	err := r.repo.FetchContext(ctx, opts)
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		recordSpanError(span, err)
+		return err
+	}
+	return nil
+}
+
+func (r *repository) Pull(ctx context.Context, opts *git.PullOptions) error {
+	ctx, span := gitTracer.Start(ctx, "git.pull",
+		trace.WithAttributes(attribute.String("git.remote", opts.RemoteName)),
+	)
+	defer span.End()
+
+	wt, err := r.repo.Worktree()
+	if err != nil {
+		recordSpanError(span, err)
+		return err
+	}
+	err = wt.PullContext(ctx, opts)
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		recordSpanError(span, err)
+		return err
+	}
+	return nil
+}
+
+func (r *repository) Head(ctx context.Context) (*plumbing.Reference, error) {
+	_, span := gitTracer.Start(ctx, "git.head")
+	defer span.End()
+
+	ref, err := r.repo.Head()
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+	return ref, nil
+}
+
+func (r *repository) Log(ctx context.Context, from plumbing.Hash) (object.CommitIter, error) {
+	_, span := gitTracer.Start(ctx, "git.log",
+		trace.WithAttributes(attribute.String("git.from", from.String())),
+	)
+	defer span.End()
+
+	iter, err := r.repo.Log(&git.LogOptions{From: from})
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+	return iter, nil
+}
+
+// Tags resolves annotated tags to their target, so callers can compare
+// Target with a commit hash regardless of the kind of tag.
+func (r *repository) Tags(ctx context.Context) ([]Tag, error) {
+	_, span := gitTracer.Start(ctx, "git.tags")
+	defer span.End()
+
+	iter, err := r.repo.Tags()
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+
+	tags := make([]Tag, 0)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		tag := Tag{Name: ref.Name().Short(), Target: ref.Hash()}
+		if tagObj, err := r.repo.TagObject(ref.Hash()); err == nil {
+			tag.Target = tagObj.Target
+			tag.Annotated = true
+		}
+		tags = append(tags, tag)
+		return nil
+	})
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
 	}
-	return repo, err
+	span.SetAttributes(attribute.Int("git.tag_count", len(tags)))
+	return tags, nil
+}
+
+func (r *repository) Commit(ctx context.Context, opts CommitOptions) (plumbing.Hash, error) {
+	_, span := gitTracer.Start(ctx, "git.commit",
+		trace.WithAttributes(attribute.Bool("git.signed", opts.Signer != nil)),
+	)
+	defer span.End()
+
+	wt, err := r.repo.Worktree()
+	if err != nil {
+		recordSpanError(span, err)
+		return plumbing.ZeroHash, err
+	}
+	if opts.StageAll {
+		if err := wt.AddGlob("*"); err != nil {
+			recordSpanError(span, err)
+			return plumbing.ZeroHash, err
+		}
+	}
+	hash, err := wt.Commit(opts.Message, &git.CommitOptions{
+		Author:            opts.Author,
+		AllowEmptyCommits: opts.AllowEmpty,
+		Signer:            opts.Signer,
+	})
+	if err != nil {
+		recordSpanError(span, err)
+		return plumbing.ZeroHash, err
+	}
+	span.SetAttributes(attribute.String("git.commit", hash.String()))
+	return hash, nil
+}
+
+func (r *repository) CreateTag(ctx context.Context, name string, hash plumbing.Hash, opts TagOptions) (*plumbing.Reference, error) {
+	_, span := gitTracer.Start(ctx, "git.tag",
+		trace.WithAttributes(
+			attribute.String("git.tag", name),
+			attribute.String("git.commit", hash.String()),
+			attribute.Bool("git.signed", opts.Signer != nil),
+		),
+	)
+	defer span.End()
+
+	var (
+		ref *plumbing.Reference
+		err error
+	)
+	if opts.Signer != nil {
+		ref, err = signing.CreateSignedTag(r.repo, name, hash, opts.Tagger, opts.Message, opts.Signer)
+	} else {
+		ref, err = r.repo.CreateTag(name, hash, &git.CreateTagOptions{
+			Tagger:  opts.Tagger,
+			Message: opts.Message,
+		})
+	}
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+	return ref, nil
+}
+
+func (r *repository) Push(ctx context.Context, opts *git.PushOptions) error {
+	ctx, span := gitTracer.Start(ctx, "git.push",
+		trace.WithAttributes(attribute.String("git.remote", opts.RemoteName)),
+	)
+	defer span.End()
+
+	err := r.repo.PushContext(ctx, opts)
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		recordSpanError(span, err)
+		return err
+	}
+	return nil
+}
+
+func (r *repository) Reset(ctx context.Context, opts *git.ResetOptions) error {
+	_, span := gitTracer.Start(ctx, "git.reset",
+		trace.WithAttributes(attribute.String("git.commit", opts.Commit.String())),
+	)
+	defer span.End()
+
+	wt, err := r.repo.Worktree()
+	if err != nil {
+		recordSpanError(span, err)
+		return err
+	}
+	if err := wt.Reset(opts); err != nil {
+		recordSpanError(span, err)
+		return err
+	}
+	return nil
+}
+
+func recordSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
 }
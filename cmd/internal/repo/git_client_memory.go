@@ -0,0 +1,164 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// memoryProtocol is the URL scheme remotes created by InMemoryGitClient are
+// served on, e.g. mem://1/my-service.
+const memoryProtocol = "mem"
+
+var (
+	installMemoryProtocol sync.Once
+	memoryRemotes         = &memoryLoader{storers: make(map[string]storer.Storer)}
+	memoryClientIDs       atomic.Int64
+)
+
+// memoryLoader resolves mem:// endpoints to the storage of the remote that
+// was registered for them.
+type memoryLoader struct {
+	mu      sync.RWMutex
+	storers map[string]storer.Storer
+}
+
+func (l *memoryLoader) Load(ep *transport.Endpoint) (storer.Storer, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	s, ok := l.storers[ep.String()]
+	if !ok {
+		return nil, transport.ErrRepositoryNotFound
+	}
+	return s, nil
+}
+
+func (l *memoryLoader) register(ep *transport.Endpoint, s storer.Storer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.storers[ep.String()] = s
+}
+
+// InMemoryGitClient is a GitClient whose remotes live entirely in memory, so
+// the versioning and release flow can run in tests without a real remote.
+// Clones are ordinary repositories on disk, since the processors write
+// version files into service.GitRepoFilePath and the repo layer renames
+// worktrees into place.
+type InMemoryGitClient struct {
+	gitClient
+	id int64
+}
+
+var _ GitClient = (*InMemoryGitClient)(nil)
+
+func NewInMemoryGitClient() *InMemoryGitClient {
+	installMemoryProtocol.Do(func() {
+		client.InstallProtocol(memoryProtocol, server.NewClient(memoryRemotes))
+	})
+	return &InMemoryGitClient{id: memoryClientIDs.Add(1)}
+}
+
+// CreateRemote creates an empty bare repository and returns the URL to clone
+// it from. PushCommit adds commits to it.
+func (c *InMemoryGitClient) CreateRemote(name string) (string, error) {
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		return "", err
+	}
+	url := fmt.Sprintf("%s://%d/%s", memoryProtocol, c.id, name)
+	ep, err := transport.NewEndpoint(url)
+	if err != nil {
+		return "", err
+	}
+	memoryRemotes.register(ep, repo.Storer)
+	return url, nil
+}
+
+// PushCommit commits files, keyed by their path in the repo, on top of branch
+// of the remote at url and pushes the commit, as a developer would. The
+// branch is created when the remote is empty.
+func (c *InMemoryGitClient) PushCommit(ctx context.Context, url string, branch string, message string, files map[string]string) (plumbing.Hash, error) {
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	fs := memfs.New()
+	repo, err := git.CloneContext(ctx, memory.NewStorage(), fs, &git.CloneOptions{
+		URL:           url,
+		ReferenceName: branchRef,
+		SingleBranch:  true,
+	})
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		repo, err = c.initCheckout(url, fs, branchRef)
+	}
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to check out %s: %w", url, err)
+	}
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	for name, contents := range files {
+		if err := util.WriteFile(fs, name, []byte(contents), 0o644); err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if _, err := wt.Add(name); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	hash, err := wt.Commit(message, &git.CommitOptions{
+		Author:            &object.Signature{Name: "Developer", Email: "developer@example.com", When: time.Now()},
+		AllowEmptyCommits: true,
+	})
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if err := repo.PushContext(ctx, &git.PushOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:%s", branchRef, branchRef))},
+	}); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to push to %s: %w", url, err)
+	}
+	return hash, nil
+}
+
+// initCheckout starts the first branch of the empty remote at url, and points
+// the remote's HEAD at it so plain clones check it out.
+func (c *InMemoryGitClient) initCheckout(url string, fs billy.Filesystem, branchRef plumbing.ReferenceName) (*git.Repository, error) {
+	ep, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := memoryRemotes.Load(ep)
+	if err != nil {
+		return nil, err
+	}
+	if err := remote.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branchRef)); err != nil {
+		return nil, err
+	}
+
+	repo, err := git.Init(memory.NewStorage(), fs)
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branchRef)); err != nil {
+		return nil, err
+	}
+	if _, err := repo.CreateRemote(&config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{url}}); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
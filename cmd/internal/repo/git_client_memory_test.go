@@ -0,0 +1,139 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestInMemoryGitClientRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	client := NewInMemoryGitClient()
+	url, err := client.CreateRemote("service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := client.PushCommit(ctx, url, "main", "feat: first", map[string]string{"version.txt": "0.0.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "repo")
+	cloned, err := client.Clone(ctx, path, &git.CloneOptions{
+		URL:           url,
+		ReferenceName: plumbing.NewBranchReferenceName("main"),
+		SingleBranch:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := cloned.Head(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Hash() != first {
+		t.Fatalf("expected HEAD %s, got %s", first, head.Hash())
+	}
+
+	// The worktree is on disk, where the processors write version files
+	if err := os.WriteFile(filepath.Join(path, "version.txt"), []byte("0.1.0"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := client.Open(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	author := &object.Signature{Name: "CI", Email: "ci@example.com", When: time.Now()}
+	release, err := repo.Commit(ctx, CommitOptions{Message: "ci: Release version 0.1.0", Author: author, StageAll: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateTag(ctx, "0.1.0", release, TagOptions{Tagger: author, Message: "Release 0.1.0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Push(ctx, &git.PushOptions{RemoteName: "origin"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Push(ctx, &git.PushOptions{RemoteName: "origin", RefSpecs: []config.RefSpec{"refs/tags/*:refs/tags/*"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second clone sees the release commit, its file and the tag
+	second, err := client.Clone(ctx, filepath.Join(t.TempDir(), "repo"), &git.CloneOptions{URL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err = second.Head(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Hash() != release {
+		t.Fatalf("expected the release commit %s on the remote, got %s", release, head.Hash())
+	}
+	tags, err := second.Tags(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].Name != "0.1.0" || tags[0].Target != release || !tags[0].Annotated {
+		t.Fatalf("expected annotated tag 0.1.0 on %s, got %+v", release, tags)
+	}
+
+	// Commits pushed later are pulled into the first clone
+	next, err := client.PushCommit(ctx, url, "main", "fix: second", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Pull(ctx, &git.PullOptions{RemoteName: "origin"}); err != nil {
+		t.Fatal(err)
+	}
+	head, err = repo.Head(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Hash() != next {
+		t.Fatalf("expected HEAD %s after pulling, got %s", next, head.Hash())
+	}
+	contents, err := os.ReadFile(filepath.Join(path, "version.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "0.1.0" {
+		t.Fatalf("expected version.txt to keep the released version, got %q", contents)
+	}
+}
+
+func TestInMemoryGitClientMissingBranch(t *testing.T) {
+	ctx := context.Background()
+	client := NewInMemoryGitClient()
+	url, err := client.CreateRemote("service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.PushCommit(ctx, url, "main", "feat: first", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Clone(ctx, filepath.Join(t.TempDir(), "repo"), &git.CloneOptions{
+		URL:           url,
+		ReferenceName: plumbing.NewBranchReferenceName("missing"),
+		SingleBranch:  true,
+	})
+	if !errors.Is(err, git.NoMatchingRefSpecError{}) && !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		t.Fatalf("expected a missing reference error, got %v", err)
+	}
+}
+
+func TestInMemoryGitClientUnknownRemote(t *testing.T) {
+	client := NewInMemoryGitClient()
+	if _, err := client.Clone(context.Background(), filepath.Join(t.TempDir(), "repo"), &git.CloneOptions{URL: "mem://0/missing"}); err == nil {
+		t.Fatal("expected cloning an unknown remote to fail")
+	}
+}
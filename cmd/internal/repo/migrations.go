@@ -0,0 +1,169 @@
+package repo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+)
+
+// CurrentSchemaVersion is the schema version definitions are written with.
+// Bump it together with a new entry in migrations whenever the stored layout
+// of model.Service changes, e.g. a field or embedded type is renamed.
+const CurrentSchemaVersion = 1
+
+const schemaVersionKey = "schema_version"
+
+// ErrNewerSchema is returned for a definition written by a newer version of
+// the deployment service, which this version can't read without losing fields.
+var ErrNewerSchema = errors.New("definition has a newer schema version")
+
+// migration upgrades a stored definition from version-1 to version. It works on
+// the raw JSON document, so fields that no longer exist on model.Service can
+// still be read.
+type migration struct {
+	version     int
+	description string
+	migrate     func(document map[string]any) error
+}
+
+// migrations are applied in order to definitions older than their version.
+// Definitions written before schema versions were stored are version 0.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "drop GitRepoFilePath, which is derived from the service directory on load",
+		migrate: func(document map[string]any) error {
+			delete(document, "GitRepoFilePath")
+			return nil
+		},
+	},
+}
+
+// migrateDocument upgrades document to CurrentSchemaVersion in place and
+// returns the version it was stored with.
+func migrateDocument(document map[string]any) (int, error) {
+	version, err := schemaVersion(document)
+	if err != nil {
+		return 0, err
+	}
+	if version > CurrentSchemaVersion {
+		return version, fmt.Errorf("%w %d, this version supports up to %d", ErrNewerSchema, version, CurrentSchemaVersion)
+	}
+	for _, migration := range migrations {
+		if migration.version <= version {
+			continue
+		}
+		if err := migration.migrate(document); err != nil {
+			return version, fmt.Errorf("failed to migrate to schema version %d (%s): %w", migration.version, migration.description, err)
+		}
+	}
+	document[schemaVersionKey] = CurrentSchemaVersion
+	return version, nil
+}
+
+func schemaVersion(document map[string]any) (int, error) {
+	raw, ok := document[schemaVersionKey]
+	if !ok {
+		return 0, nil
+	}
+	number, ok := raw.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("invalid %s %v", schemaVersionKey, raw)
+	}
+	version, err := number.Int64()
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid %s %v", schemaVersionKey, raw)
+	}
+	return int(version), nil
+}
+
+// decodeServiceDefinition migrates a stored definition to the current schema
+// and decodes it. It returns the schema version the definition was stored with.
+func decodeServiceDefinition(data []byte) (*model.Service, int, error) {
+	document, err := decodeDocument(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	storedVersion, err := migrateDocument(document)
+	if err != nil {
+		return nil, storedVersion, err
+	}
+	service := new(model.Service)
+	if err := remarshal(document, service); err != nil {
+		return nil, storedVersion, err
+	}
+	return service, storedVersion, nil
+}
+
+// encodeServiceDefinition encodes service with the current schema version.
+func encodeServiceDefinition(service *model.Service) ([]byte, error) {
+	document := make(map[string]any)
+	if err := remarshal(service, &document); err != nil {
+		return nil, err
+	}
+	document[schemaVersionKey] = CurrentSchemaVersion
+	return json.MarshalIndent(document, "", "  ")
+}
+
+// decodeRevision migrates the service snapshot in a stored revision. Revisions
+// are immutable, so they are migrated every time they are read rather than
+// rewritten.
+func decodeRevision(data []byte) (*model.Revision, error) {
+	document, err := decodeDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	if service, ok := document["service"].(map[string]any); ok {
+		if _, err := migrateDocument(service); err != nil {
+			return nil, err
+		}
+	}
+	revision := new(model.Revision)
+	if err := remarshal(document, revision); err != nil {
+		return nil, err
+	}
+	return revision, nil
+}
+
+// encodeRevision encodes revision with the current schema version on its
+// service snapshot.
+func encodeRevision(revision *model.Revision) ([]byte, error) {
+	document := make(map[string]any)
+	if err := remarshal(revision, &document); err != nil {
+		return nil, err
+	}
+	if service, ok := document["service"].(map[string]any); ok {
+		service[schemaVersionKey] = CurrentSchemaVersion
+	}
+	return json.MarshalIndent(document, "", "  ")
+}
+
+func decodeDocument(data []byte) (map[string]any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// Keep numbers as written, env file values included
+	decoder.UseNumber()
+	document := make(map[string]any)
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+func remarshal(from any, to any) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	if document, ok := to.(*map[string]any); ok {
+		decoded, err := decodeDocument(data)
+		if err != nil {
+			return err
+		}
+		*document = decoded
+		return nil
+	}
+	return json.Unmarshal(data, to)
+}
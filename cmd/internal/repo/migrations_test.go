@@ -0,0 +1,153 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+)
+
+// A definition from before schema versions were stored
+const unversionedDefinition = `{
+  "name": "service",
+  "id": "01J0000000000000000000000",
+  "version": "01J0000000000000000000001",
+  "git_ssh_url": "git@github.com:owner/service.git",
+  "branch_name": "main",
+  "GitRepoFilePath": "/old/path/service/repo",
+  "configuration": {"Go": {"Service": null}}
+}`
+
+func TestMigrationsEndAtCurrentVersion(t *testing.T) {
+	for i, migration := range migrations {
+		if migration.version != i+1 {
+			t.Fatalf("expected migration %d to be for version %d, got %d", i, i+1, migration.version)
+		}
+	}
+	if last := migrations[len(migrations)-1].version; last != CurrentSchemaVersion {
+		t.Fatalf("expected the last migration to be for version %d, got %d", CurrentSchemaVersion, last)
+	}
+}
+
+func TestDecodeMigratesUnversionedDefinition(t *testing.T) {
+	service, storedVersion, err := decodeServiceDefinition([]byte(unversionedDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storedVersion != 0 {
+		t.Fatalf("expected stored version 0, got %d", storedVersion)
+	}
+	if service.Name.Name != "service" || service.GitBranchName != "main" || service.Configuration.Go == nil {
+		t.Fatalf("expected the definition's fields to be kept, got %+v", service)
+	}
+
+	encoded, err := encodeServiceDefinition(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document map[string]any
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := document["GitRepoFilePath"]; ok {
+		t.Fatal("expected GitRepoFilePath to be dropped")
+	}
+	if document[schemaVersionKey] != float64(CurrentSchemaVersion) {
+		t.Fatalf("expected %s %d, got %v", schemaVersionKey, CurrentSchemaVersion, document[schemaVersionKey])
+	}
+
+	// Decoding what was encoded needs no migration
+	if _, storedVersion, err := decodeServiceDefinition(encoded); err != nil || storedVersion != CurrentSchemaVersion {
+		t.Fatalf("expected stored version %d, got %d, %v", CurrentSchemaVersion, storedVersion, err)
+	}
+}
+
+func TestDecodeRejectsBadSchemaVersions(t *testing.T) {
+	tests := []struct {
+		name      string
+		version   string
+		wantNewer bool
+	}{
+		{name: "newer", version: "99", wantNewer: true},
+		{name: "negative", version: "-1"},
+		{name: "fractional", version: "1.5"},
+		{name: "string", version: `"1"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := decodeServiceDefinition([]byte(`{"name": "service", "schema_version": ` + tt.version + `}`))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if errors.Is(err, ErrNewerSchema) != tt.wantNewer {
+				t.Fatalf("expected ErrNewerSchema to be %t, got %v", tt.wantNewer, err)
+			}
+		})
+	}
+}
+
+func TestDecodeRevisionMigratesSnapshot(t *testing.T) {
+	revision, err := decodeRevision([]byte(`{"id": "01J0000000000000000000001", "action": "create", "service": ` + unversionedDefinition + `}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision.Service.Name.Name != "service" || revision.Service.GitSSHUrl != "git@github.com:owner/service.git" {
+		t.Fatalf("expected the snapshot to be decoded, got %+v", revision.Service)
+	}
+
+	// Revisions with snapshots from a newer version aren't read either
+	if _, err := decodeRevision([]byte(`{"id": "x", "service": {"schema_version": 99}}`)); !errors.Is(err, ErrNewerSchema) {
+		t.Fatalf("expected %v, got %v", ErrNewerSchema, err)
+	}
+}
+
+func TestFsckRewritesOldDefinitionsWithBackup(t *testing.T) {
+	ds, _ := newTestRepo(t)
+	ctx := context.Background()
+	if err := os.MkdirAll(ds.getServiceFilePath(testServiceName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	definitionPath := ds.getServiceConfigurationFilePath(testServiceName)
+	if err := os.WriteFile(definitionPath, []byte(unversionedDefinition), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	quarantined, err := ds.Fsck(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 0 {
+		t.Fatalf("expected nothing to be quarantined, got %v", quarantined)
+	}
+	backup, err := os.ReadFile(definitionPath + ".v0" + backupFileSuffix)
+	if err != nil {
+		t.Fatalf("expected a backup of the original, got %v", err)
+	}
+	if string(backup) != unversionedDefinition {
+		t.Fatal("expected the backup to be the original definition")
+	}
+	_, storedVersion, err := ds.readServiceDefinitionVersion(testServiceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storedVersion != CurrentSchemaVersion {
+		t.Fatalf("expected the definition to be rewritten with version %d, got %d", CurrentSchemaVersion, storedVersion)
+	}
+}
+
+func TestFsckFailsOnNewerSchema(t *testing.T) {
+	ds, _ := newTestRepo(t)
+	if err := os.MkdirAll(ds.getServiceFilePath(testServiceName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ds.getServiceConfigurationFilePath(testServiceName), []byte(`{"name": "service", "schema_version": 99}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.Fsck(context.Background()); !errors.Is(err, ErrNewerSchema) {
+		t.Fatalf("expected startup to fail with %v, got %v", ErrNewerSchema, err)
+	}
+	if _, err := os.Stat(ds.getServiceFilePath(testServiceName)); err != nil {
+		t.Fatalf("expected the service not to be quarantined, got %v", err)
+	}
+}
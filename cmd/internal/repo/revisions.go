@@ -0,0 +1,181 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/identity"
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	revisionsDir       = "revisions"
+	revisionFileSuffix = ".json"
+)
+
+// ListRevisions returns the service's revisions, newest first.
+func (ds *deploymentService) ListRevisions(ctx context.Context, serviceName string) ([]*model.Revision, error) {
+	ctx, span := tracer.Start(ctx, "repo.list_revisions",
+		trace.WithAttributes(attribute.String("service.name", serviceName)),
+	)
+	defer span.End()
+
+	if _, err := ds.Get(ctx, serviceName); err != nil {
+		return nil, err
+	}
+
+	unlock, err := ds.definitionLocks.RLock(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	entries, err := os.ReadDir(ds.getRevisionsPath(serviceName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	revisions := make([]*model.Revision, 0, len(entries))
+	for _, entry := range entries {
+		revisionID, ok := strings.CutSuffix(entry.Name(), revisionFileSuffix)
+		if !ok || isHidden(entry.Name()) {
+			continue
+		}
+		revision, err := ds.readRevision(serviceName, revisionID)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	// IDs are ULIDs, so they sort by creation time
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].ID > revisions[j].ID
+	})
+	return revisions, nil
+}
+
+func (ds *deploymentService) GetRevision(ctx context.Context, serviceName string, revisionID string) (*model.Revision, error) {
+	ctx, span := tracer.Start(ctx, "repo.get_revision",
+		trace.WithAttributes(
+			attribute.String("service.name", serviceName),
+			attribute.String("revision.id", revisionID),
+		),
+	)
+	defer span.End()
+
+	if _, err := ds.Get(ctx, serviceName); err != nil {
+		return nil, err
+	}
+
+	unlock, err := ds.definitionLocks.RLock(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return ds.readRevision(serviceName, revisionID)
+}
+
+// Revert restores the configuration of an earlier revision as a new revision.
+// Git settings are left as they are, since changing them needs a re-clone.
+func (ds *deploymentService) Revert(ctx context.Context, serviceName string, ifMatch string, revisionID string) (*model.Service, error) {
+	ctx, span := tracer.Start(ctx, "repo.revert",
+		trace.WithAttributes(
+			attribute.String("service.name", serviceName),
+			attribute.String("revision.id", revisionID),
+		),
+	)
+	defer span.End()
+
+	revision, err := ds.GetRevision(ctx, serviceName, revisionID)
+	if err != nil {
+		return nil, err
+	}
+	return ds.mutateServiceDefinition(ctx, serviceName, func(current *model.Service) (*model.Revision, error) {
+		if current.Version != ifMatch {
+			return nil, model.NewVersionMismatchError()
+		}
+		current.Configuration = revision.Service.Configuration
+		current.Version = utils.GenerateUlidString()
+
+		reverted := model.NewRevision(current, identity.CallerFromContext(ctx), model.RevisionActionRevert)
+		reverted.RevertedFrom = revision.ID
+		return reverted, nil
+	})
+}
+
+// readRevision reads a revision. Callers must hold the service's definition
+// lock.
+func (ds *deploymentService) readRevision(serviceName string, revisionID string) (*model.Revision, error) {
+	if !validRevisionID(revisionID) {
+		return nil, errRevisionNotFound(revisionID)
+	}
+	revisionBytes, err := os.ReadFile(ds.getRevisionFilePath(serviceName, revisionID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errRevisionNotFound(revisionID)
+		}
+		return nil, err
+	}
+	revision, err := decodeRevision(revisionBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode revision %s: %w", revisionID, err)
+	}
+	return revision, nil
+}
+
+// writeRevision stores a new revision. Revisions are immutable, so writing an
+// existing ID fails. Callers must hold the service's definition lock.
+func (ds *deploymentService) writeRevision(serviceName string, revision *model.Revision) error {
+	if !validRevisionID(revision.ID) {
+		return fmt.Errorf("invalid revision id %q", revision.ID)
+	}
+	if err := os.MkdirAll(ds.getRevisionsPath(serviceName), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create revisions directory: %w", err)
+	}
+	revisionPath := ds.getRevisionFilePath(serviceName, revision.ID)
+	if _, err := os.Stat(revisionPath); err == nil {
+		return fmt.Errorf("revision %s already exists", revision.ID)
+	}
+	revisionBytes, err := encodeRevision(revision)
+	if err != nil {
+		return fmt.Errorf("failed to marshal revision: %w", err)
+	}
+	if err := writeFileAtomic(revisionPath, revisionBytes, 0444); err != nil {
+		return fmt.Errorf("failed to write revision: %w", err)
+	}
+	return nil
+}
+
+// writeMissingRevisions stores the revisions that aren't already stored.
+// Callers must hold the service's definition lock.
+func (ds *deploymentService) writeMissingRevisions(serviceName string, revisions []*model.Revision) error {
+	for _, revision := range revisions {
+		if _, err := os.Stat(ds.getRevisionFilePath(serviceName, revision.ID)); err == nil {
+			continue
+		}
+		if err := ds.writeRevision(serviceName, revision); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Revision IDs come from URLs and import bundles, so they must not reach
+// outside the revisions directory.
+func validRevisionID(revisionID string) bool {
+	return revisionID != "" && !strings.ContainsAny(revisionID, `/\`) && !isHidden(revisionID)
+}
+
+func (ds *deploymentService) getRevisionsPath(serviceName string) string {
+	return path.Join(ds.getServiceFilePath(serviceName), revisionsDir)
+}
+
+func (ds *deploymentService) getRevisionFilePath(serviceName string, revisionID string) string {
+	return path.Join(ds.getRevisionsPath(serviceName), revisionID+revisionFileSuffix)
+}
@@ -0,0 +1,396 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/identity"
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/utils"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	quarantineDir = ".quarantine"
+	archiveDir    = ".archive"
+	locksDir      = ".locks"
+	// Suffix of the per-service lock files in locksDir
+	lockFileSuffix = ".lock"
+	// Prefix of the temporary files definitions and revisions are written to
+	// before being renamed into place
+	tempFilePrefix = ".tmp-"
+	// Suffix of the copies kept of definitions before a schema migration
+	backupFileSuffix = ".bak"
+)
+
+// definitionLocks guards each service's definition file. Reads, including the
+// background job's Get every tick, share the lock; writes hold it exclusively
+// across the read-compare-write, so two concurrent updates can't both pass
+// their If-Match check. Each lock is also taken as an advisory file lock on
+// SERVICE_FILE_PATH/.locks/<name>.lock, so other processes sharing
+// SERVICE_FILE_PATH, such as a second replica, are serialised too.
+type definitionLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.RWMutex
+	dir   string
+}
+
+func newDefinitionLocks(dir string) *definitionLocks {
+	return &definitionLocks{locks: make(map[string]*sync.RWMutex), dir: dir}
+}
+
+func (dl *definitionLocks) get(serviceName string) *sync.RWMutex {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	lock, ok := dl.locks[serviceName]
+	if !ok {
+		lock = &sync.RWMutex{}
+		dl.locks[serviceName] = lock
+	}
+	return lock
+}
+
+func (dl *definitionLocks) RLock(serviceName string) (func(), error) {
+	lock := dl.get(serviceName)
+	lock.RLock()
+	unlockFile, err := dl.lockFile(serviceName, false)
+	if err != nil {
+		lock.RUnlock()
+		return nil, err
+	}
+	return func() {
+		unlockFile()
+		lock.RUnlock()
+	}, nil
+}
+
+func (dl *definitionLocks) Lock(serviceName string) (func(), error) {
+	lock := dl.get(serviceName)
+	lock.Lock()
+	unlockFile, err := dl.lockFile(serviceName, true)
+	if err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlockFile()
+		lock.Unlock()
+	}, nil
+}
+
+// lockFile takes the service's file lock. Lock files are never removed, since
+// a process could be waiting on one that is unlinked from under it, so callers
+// check the service exists first rather than leave one behind for every name
+// they're asked about.
+func (dl *definitionLocks) lockFile(serviceName string, exclusive bool) (func(), error) {
+	if err := os.MkdirAll(dl.dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	file, err := os.OpenFile(path.Join(dl.dir, serviceName+lockFileSuffix), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := flock(file, exclusive); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", file.Name(), err)
+	}
+	// Closing the file releases the lock
+	return func() { file.Close() }, nil
+}
+
+// readServiceDefinition reads and decodes a service's definition, migrating it
+// to the current schema. Callers must hold the service's definition lock.
+func (ds *deploymentService) readServiceDefinition(serviceName string) (*model.Service, error) {
+	service, _, err := ds.readServiceDefinitionVersion(serviceName)
+	return service, err
+}
+
+// readServiceDefinitionVersion is readServiceDefinition, also returning the
+// schema version the definition is stored with.
+func (ds *deploymentService) readServiceDefinitionVersion(serviceName string) (*model.Service, int, error) {
+	fileBytes, err := os.ReadFile(ds.getServiceConfigurationFilePath(serviceName))
+	if err != nil {
+		return nil, 0, err
+	}
+	service, storedVersion, err := decodeServiceDefinition(fileBytes)
+	if err != nil {
+		return nil, storedVersion, fmt.Errorf("failed to decode %s: %w", ds.serviceConfigurationFile, err)
+	}
+	service.GitRepoFilePath = ds.getGitRepoFilePath(serviceName)
+	return service, storedVersion, nil
+}
+
+// writeServiceDefinition atomically replaces a service's definition. Callers
+// must hold the service's definition lock.
+func (ds *deploymentService) writeServiceDefinition(service *model.Service) error {
+	fileBytes, err := encodeServiceDefinition(service)
+	if err != nil {
+		return fmt.Errorf("failed to marshal service: %w", err)
+	}
+
+	if err := writeFileAtomic(ds.getServiceConfigurationFilePath(service.Name.Name), fileBytes, 0644); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
+
+// mutateServiceDefinition applies mutate to the stored definition and writes
+// it back, all under the service's definition lock. The revision mutate
+// returns is recorded with it; a nil revision records nothing, for internal
+// state that isn't configuration. Nothing is written when mutate returns an
+// error.
+func (ds *deploymentService) mutateServiceDefinition(
+	ctx context.Context,
+	serviceName string,
+	mutate func(service *model.Service) (*model.Revision, error),
+) (*model.Service, error) {
+	if !ds.serviceExists(serviceName) {
+		return nil, errServiceNotFound(serviceName)
+	}
+	unlock, err := ds.definitionLocks.Lock(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	current, err := ds.readServiceDefinition(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	revision, err := mutate(current)
+	if err != nil {
+		return nil, err
+	}
+	if err := ds.writeServiceDefinition(current); err != nil {
+		return nil, err
+	}
+	if revision != nil {
+		if err := ds.writeRevision(serviceName, revision); err != nil {
+			// The definition is already in place; a missing revision only
+			// leaves a gap in the history
+			zerolog.Ctx(ctx).Error().Err(err).Str("service", serviceName).Str("revision", revision.ID).
+				Msg("Failed to record revision")
+		}
+	}
+	return current, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to name and renames it
+// over name, so a crash leaves either the old or the new contents in place.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), tempFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, name); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(name))
+}
+
+// syncDir flushes a directory so a rename into it survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Fsck checks every service directory before background processing starts.
+// Services whose definition is missing or unreadable are moved to
+// SERVICE_FILE_PATH/.quarantine instead of failing startup, and debris from
+// interrupted writes and re-clones is cleaned up. It returns the names of the
+// quarantined services.
+func (ds *deploymentService) Fsck(ctx context.Context) ([]string, error) {
+	ctx, span := tracer.Start(ctx, "repo.fsck")
+	defer span.End()
+	log := zerolog.Ctx(ctx)
+
+	dirEntries, err := os.ReadDir(ds.filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	quarantined := make([]string, 0)
+	for _, dirEntry := range dirEntries {
+		serviceName := dirEntry.Name()
+		if !dirEntry.IsDir() || isHidden(serviceName) {
+			continue
+		}
+
+		unlock, err := ds.definitionLocks.Lock(serviceName)
+		if err != nil {
+			return quarantined, err
+		}
+		problem := ds.fsckService(ctx, serviceName)
+		unlock()
+		if problem == nil {
+			continue
+		}
+		if errors.Is(problem, ErrNewerSchema) {
+			// Quarantining would hide every service after a downgrade
+			return quarantined, fmt.Errorf("service %s: %w", serviceName, problem)
+		}
+		destination, err := ds.moveAside(serviceName, quarantineDir)
+		if err != nil {
+			return quarantined, fmt.Errorf("failed to quarantine service %s: %w", serviceName, err)
+		}
+		log.Error().Err(problem).Str("service", serviceName).Str("quarantinedTo", destination).
+			Msg("Quarantined service with an unreadable definition")
+		quarantined = append(quarantined, serviceName)
+	}
+
+	span.SetAttributes(attribute.Int("fsck.quarantined", len(quarantined)))
+	return quarantined, nil
+}
+
+// fsckService repairs what it can for one service and returns why the service
+// should be quarantined, or nil if it is healthy. Callers must hold the
+// service's definition lock.
+func (ds *deploymentService) fsckService(ctx context.Context, serviceName string) error {
+	log := zerolog.Ctx(ctx)
+
+	servicePath := ds.getServiceFilePath(serviceName)
+	entries, err := os.ReadDir(servicePath)
+	if err != nil {
+		return err
+	}
+
+	gitRepoPath := ds.getGitRepoFilePath(serviceName)
+	_, repoErr := os.Stat(gitRepoPath)
+	previousPrefix := fmt.Sprintf(".%s-previous-", ds.gitRepoPath)
+	clonePrefix := fmt.Sprintf(".%s-", ds.gitRepoPath)
+	for _, entry := range entries {
+		entryPath := path.Join(servicePath, entry.Name())
+		switch {
+		case strings.HasPrefix(entry.Name(), tempFilePrefix):
+			log.Warn().Str("service", serviceName).Str("path", entryPath).Msg("Removing partially written service definition")
+			if err := os.Remove(entryPath); err != nil {
+				return err
+			}
+		case entry.Name() == revisionsDir:
+			if err := removeTempFiles(entryPath); err != nil {
+				return err
+			}
+		case strings.HasPrefix(entry.Name(), previousPrefix) && os.IsNotExist(repoErr):
+			// Interrupted between moving the old worktree aside and swapping in the new one
+			log.Warn().Str("service", serviceName).Str("path", entryPath).Msg("Restoring worktree from interrupted re-clone")
+			if err := os.Rename(entryPath, gitRepoPath); err != nil {
+				return err
+			}
+			repoErr = nil
+		case strings.HasPrefix(entry.Name(), clonePrefix):
+			log.Warn().Str("service", serviceName).Str("path", entryPath).Msg("Removing leftover clone")
+			if err := os.RemoveAll(entryPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	service, storedVersion, err := ds.readServiceDefinitionVersion(serviceName)
+	if err != nil {
+		return err
+	}
+	if service.Name.Name != serviceName {
+		return fmt.Errorf("definition is for service %q", service.Name.Name)
+	}
+	if storedVersion < CurrentSchemaVersion {
+		if err := ds.migrateServiceDefinition(service, storedVersion); err != nil {
+			return err
+		}
+		log.Info().Str("service", serviceName).Int("fromVersion", storedVersion).Int("toVersion", CurrentSchemaVersion).
+			Msg("Migrated service definition")
+	}
+
+	if err := ds.resetInterruptedDeploymentStatus(ctx, serviceName); err != nil {
+		log.Error().Err(err).Str("service", serviceName).Msg("Failed to reset deployment status")
+	}
+
+	// Services created before revisions were kept, or whose last revision
+	// failed to write, get the current definition recorded as a baseline
+	if _, err := os.Stat(ds.getRevisionFilePath(serviceName, service.Version)); os.IsNotExist(err) {
+		log.Info().Str("service", serviceName).Str("revision", service.Version).Msg("Recording baseline revision")
+		if err := ds.writeRevision(serviceName, model.NewRevision(service, identity.System, model.RevisionActionBaseline)); err != nil {
+			log.Error().Err(err).Str("service", serviceName).Msg("Failed to record baseline revision")
+		}
+	}
+	return nil
+}
+
+// migrateServiceDefinition keeps a copy of a definition stored with an older
+// schema version as service_definition.json.v<N>.bak, then rewrites it with
+// the current one. Callers must hold the service's definition lock.
+func (ds *deploymentService) migrateServiceDefinition(service *model.Service, storedVersion int) error {
+	definitionPath := ds.getServiceConfigurationFilePath(service.Name.Name)
+	original, err := os.ReadFile(definitionPath)
+	if err != nil {
+		return err
+	}
+	backupPath := fmt.Sprintf("%s.v%d%s", definitionPath, storedVersion, backupFileSuffix)
+	if err := writeFileAtomic(backupPath, original, 0444); err != nil {
+		return fmt.Errorf("failed to back up definition: %w", err)
+	}
+	return ds.writeServiceDefinition(service)
+}
+
+func removeTempFiles(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), tempFilePrefix) {
+			if err := os.Remove(path.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// moveAside moves the service's directory into the hidden directory dir, as
+// <name>-<id> so earlier copies are kept.
+func (ds *deploymentService) moveAside(serviceName string, dir string) (string, error) {
+	asidePath := path.Join(ds.filePath, dir)
+	if err := os.MkdirAll(asidePath, os.ModePerm); err != nil {
+		return "", err
+	}
+	destination := path.Join(asidePath, fmt.Sprintf("%s-%s", serviceName, utils.GenerateUlidString()))
+	if err := os.Rename(ds.getServiceFilePath(serviceName), destination); err != nil {
+		return "", err
+	}
+	return destination, nil
+}
+
+// Hidden directories hold quarantined services and other bookkeeping, never
+// a service.
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
@@ -0,0 +1,66 @@
+package repo
+
+import (
+	"context"
+	"sync"
+)
+
+// ServiceLocks serialises work on a single service's clone, so a re-clone
+// never swaps the worktree out from under a running background tick.
+type ServiceLocks interface {
+	// Lock blocks until the service is free.
+	Lock(serviceName string) (unlock func())
+	// TryLock returns false instead of waiting when the service is busy.
+	TryLock(serviceName string) (unlock func(), ok bool)
+	// LockContext blocks until the service is free or ctx is done, returning
+	// ctx's error in the latter case.
+	LockContext(ctx context.Context, serviceName string) (unlock func(), err error)
+}
+
+func NewServiceLocks() ServiceLocks {
+	return &serviceLocks{locks: make(map[string]chan struct{})}
+}
+
+// serviceLocks holds a semaphore of one per service, since a sync.Mutex
+// can't be waited on with a deadline.
+type serviceLocks struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+func (sl *serviceLocks) Lock(serviceName string) func() {
+	lock := sl.get(serviceName)
+	lock <- struct{}{}
+	return func() { <-lock }
+}
+
+func (sl *serviceLocks) TryLock(serviceName string) (func(), bool) {
+	lock := sl.get(serviceName)
+	select {
+	case lock <- struct{}{}:
+		return func() { <-lock }, true
+	default:
+		return nil, false
+	}
+}
+
+func (sl *serviceLocks) LockContext(ctx context.Context, serviceName string) (func(), error) {
+	lock := sl.get(serviceName)
+	select {
+	case lock <- struct{}{}:
+		return func() { <-lock }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (sl *serviceLocks) get(serviceName string) chan struct{} {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	lock, ok := sl.locks[serviceName]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		sl.locks[serviceName] = lock
+	}
+	return lock
+}
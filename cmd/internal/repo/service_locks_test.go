@@ -0,0 +1,71 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestServiceLocksLockContextWaitsForUnlock(t *testing.T) {
+	locks := NewServiceLocks()
+	unlock := locks.Lock("service")
+
+	acquired := make(chan func())
+	go func() {
+		unlock, err := locks.LockContext(context.Background(), "service")
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- unlock
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquired a lock that is held")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case unlock := <-acquired:
+		unlock()
+	case <-time.After(time.Second):
+		t.Fatal("lock wasn't acquired once it was released")
+	}
+}
+
+func TestServiceLocksLockContextGivesUp(t *testing.T) {
+	locks := NewServiceLocks()
+	unlock := locks.Lock("service")
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := locks.LockContext(ctx, "service"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	// Other services aren't affected
+	other, err := locks.LockContext(context.Background(), "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+}
+
+func TestServiceLocksTryLock(t *testing.T) {
+	locks := NewServiceLocks()
+	unlock, ok := locks.TryLock("service")
+	if !ok {
+		t.Fatal("expected a free lock to be taken")
+	}
+	if _, ok := locks.TryLock("service"); ok {
+		t.Fatal("expected a held lock not to be taken")
+	}
+	unlock()
+	unlock, ok = locks.TryLock("service")
+	if !ok {
+		t.Fatal("expected a released lock to be taken")
+	}
+	unlock()
+}
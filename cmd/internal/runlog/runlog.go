@@ -0,0 +1,326 @@
+package runlog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/securepath"
+	"github.com/ansonallard/deployment-service/cmd/internal/utils"
+	"github.com/oklog/ulid/v2"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("deployment-service.runlog")
+
+const (
+	runsDir       = "runs"
+	logFileSuffix = ".log"
+)
+
+// Store keeps the subprocess and Docker output of each release in its own
+// file, SERVICE_FILE_PATH/<name>/runs/<runId>.log.
+type Store interface {
+	// Start begins the log of a new run of serviceName and returns a ctx
+	// carrying it, for Writer. Older runs beyond the retention are removed.
+	Start(ctx context.Context, serviceName string) (context.Context, *Run, error)
+	// List returns the service's runs, newest first.
+	List(ctx context.Context, serviceName string) ([]RunInfo, error)
+	// Open returns the run's log and a channel that is closed once the run
+	// has finished, which it already is for runs that aren't in progress.
+	Open(ctx context.Context, serviceName string, runID string) (io.ReadCloser, <-chan struct{}, error)
+}
+
+type RunInfo struct {
+	ID         string    `json:"id"`
+	StartedAt  time.Time `json:"startedAt"`
+	Size       int64     `json:"size"`
+	InProgress bool      `json:"inProgress"`
+}
+
+type StoreConfig struct {
+	ServiceFilePath string
+	// MaxBytes caps each run's log. Output past it is dropped.
+	MaxBytes int64
+	// Retention is how many runs are kept per service.
+	Retention int
+}
+
+func NewStore(config StoreConfig) (Store, error) {
+	if config.ServiceFilePath == "" {
+		return nil, fmt.Errorf("serviceFilePath not set")
+	}
+	if config.MaxBytes <= 0 {
+		return nil, fmt.Errorf("maxBytes must be positive")
+	}
+	if config.Retention <= 0 {
+		return nil, fmt.Errorf("retention must be positive")
+	}
+	return &store{
+		filePath:  config.ServiceFilePath,
+		maxBytes:  config.MaxBytes,
+		retention: config.Retention,
+		active:    make(map[string]*Run),
+	}, nil
+}
+
+type store struct {
+	filePath  string
+	maxBytes  int64
+	retention int
+	mu        sync.Mutex
+	// active runs by runsPath/runId
+	active map[string]*Run
+}
+
+func (s *store) Start(ctx context.Context, serviceName string) (context.Context, *Run, error) {
+	ctx, span := tracer.Start(ctx, "runlog.start",
+		trace.WithAttributes(attribute.String("service.name", serviceName)),
+	)
+	defer span.End()
+
+	runsPath, err := s.runsPath(serviceName)
+	if err != nil {
+		return ctx, nil, err
+	}
+	// Not MkdirAll, which would bring back a service deleted mid-release
+	if err := os.Mkdir(runsPath, os.ModePerm); err != nil && !os.IsExist(err) {
+		return ctx, nil, err
+	}
+	s.prune(ctx, runsPath)
+
+	id := utils.GenerateUlidString()
+	file, err := os.OpenFile(filepath.Join(runsPath, id+logFileSuffix), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return ctx, nil, err
+	}
+	run := &Run{
+		ID:       id,
+		file:     file,
+		maxBytes: s.maxBytes,
+		done:     make(chan struct{}),
+		release: func() {
+			s.mu.Lock()
+			delete(s.active, filepath.Join(runsPath, id))
+			s.mu.Unlock()
+		},
+	}
+	s.mu.Lock()
+	s.active[filepath.Join(runsPath, id)] = run
+	s.mu.Unlock()
+
+	span.SetAttributes(attribute.String("run.id", id))
+	fmt.Fprintf(run, "Run %s of %s started at %s\n", id, serviceName, time.Now().UTC().Format(time.RFC3339))
+	return context.WithValue(ctx, runKey{}, run), run, nil
+}
+
+// prune removes the oldest finished runs so that, with the run being started,
+// at most retention are kept.
+func (s *store) prune(ctx context.Context, runsPath string) {
+	ids, err := runIDs(runsPath)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("path", runsPath).Msg("Failed to list run logs for pruning")
+		return
+	}
+	// Runs in progress can't be removed, but still count, so newer finished
+	// runs go in their place
+	excess := len(ids) - (s.retention - 1)
+	for i := len(ids) - 1; i >= 0 && excess > 0; i-- {
+		if s.isActive(runsPath, ids[i]) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(runsPath, ids[i]+logFileSuffix)); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("run", ids[i]).Msg("Failed to remove old run log")
+		}
+		excess--
+	}
+}
+
+func (s *store) List(ctx context.Context, serviceName string) ([]RunInfo, error) {
+	_, span := tracer.Start(ctx, "runlog.list",
+		trace.WithAttributes(attribute.String("service.name", serviceName)),
+	)
+	defer span.End()
+
+	runsPath, err := s.runsPath(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := runIDs(runsPath)
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]RunInfo, 0, len(ids))
+	for _, id := range ids {
+		info, err := os.Stat(filepath.Join(runsPath, id+logFileSuffix))
+		if err != nil {
+			// Pruned since it was listed
+			continue
+		}
+		runs = append(runs, RunInfo{
+			ID:         id,
+			StartedAt:  ulid.Time(ulid.MustParse(id).Time()).UTC(),
+			Size:       info.Size(),
+			InProgress: s.isActive(runsPath, id),
+		})
+	}
+	return runs, nil
+}
+
+func (s *store) Open(ctx context.Context, serviceName string, runID string) (io.ReadCloser, <-chan struct{}, error) {
+	_, span := tracer.Start(ctx, "runlog.open",
+		trace.WithAttributes(
+			attribute.String("service.name", serviceName),
+			attribute.String("run.id", runID),
+		),
+	)
+	defer span.End()
+
+	if _, err := ulid.ParseStrict(runID); err != nil {
+		return nil, nil, apierr.Newf(apierr.CodeNotFound, "run %q not found", runID)
+	}
+	runsPath, err := s.runsPath(serviceName)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(filepath.Join(runsPath, runID+logFileSuffix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, apierr.Newf(apierr.CodeNotFound, "run %q not found", runID)
+		}
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	run, ok := s.active[filepath.Join(runsPath, runID)]
+	s.mu.Unlock()
+	if ok {
+		return file, run.done, nil
+	}
+	done := make(chan struct{})
+	close(done)
+	return file, done, nil
+}
+
+func (s *store) isActive(runsPath string, runID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.active[filepath.Join(runsPath, runID)]
+	return ok
+}
+
+func (s *store) runsPath(serviceName string) (string, error) {
+	if err := model.ValidateServiceName(serviceName); err != nil {
+		return "", apierr.Newf(apierr.CodeServiceNotFound, "service %q not found", serviceName)
+	}
+	servicePath, err := securepath.Join(s.filePath, serviceName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(servicePath, runsDir), nil
+}
+
+// runIDs returns the IDs of the runs in runsPath, newest first.
+func runIDs(runsPath string) ([]string, error) {
+	entries, err := os.ReadDir(runsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), logFileSuffix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if _, err := ulid.ParseStrict(id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	// ULIDs sort by creation time
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	return ids, nil
+}
+
+// Run is the log of one release. A nil Run is valid and logs nothing.
+type Run struct {
+	ID        string
+	mu        sync.Mutex
+	file      *os.File
+	written   int64
+	maxBytes  int64
+	truncated bool
+	done      chan struct{}
+	release   func()
+}
+
+// Write appends p to the run's log until it reaches its size limit. It never
+// fails, so a full disk doesn't fail the build writing to it.
+func (r *Run) Write(p []byte) (int, error) {
+	if r == nil {
+		return len(p), nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil || r.truncated {
+		return len(p), nil
+	}
+	if remaining := r.maxBytes - r.written; int64(len(p)) > remaining {
+		r.truncated = true
+		n, _ := r.file.Write(p[:remaining])
+		r.written += int64(n)
+		fmt.Fprintf(r.file, "\n[log truncated at %d bytes]\n", r.maxBytes)
+		return len(p), nil
+	}
+	n, _ := r.file.Write(p)
+	r.written += int64(n)
+	return len(p), nil
+}
+
+// Finish records the run's outcome and closes its log.
+func (r *Run) Finish(err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return
+	}
+	outcome := "succeeded"
+	if err != nil {
+		outcome = fmt.Sprintf("failed: %s", err)
+	}
+	// Written past the size limit, so the outcome is always there
+	fmt.Fprintf(r.file, "Run %s at %s\n", outcome, time.Now().UTC().Format(time.RFC3339))
+	r.file.Close()
+	r.file = nil
+	r.release()
+	close(r.done)
+}
+
+type runKey struct{}
+
+// Writer returns the log of the run ctx belongs to, or io.Discard outside of
+// a run.
+func Writer(ctx context.Context) io.Writer {
+	run, ok := ctx.Value(runKey{}).(*Run)
+	if !ok {
+		return io.Discard
+	}
+	return run
+}
@@ -0,0 +1,185 @@
+package runlog
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+)
+
+const testServiceName = "my-service"
+
+func newTestStore(t *testing.T, maxBytes int64, retention int) Store {
+	t.Helper()
+	serviceFilePath := t.TempDir()
+	if err := os.Mkdir(filepath.Join(serviceFilePath, testServiceName), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewStore(StoreConfig{ServiceFilePath: serviceFilePath, MaxBytes: maxBytes, Retention: retention})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func readRun(t *testing.T, s Store, runID string) string {
+	t.Helper()
+	logFile, _, err := s.Open(context.Background(), testServiceName, runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+	contents, err := io.ReadAll(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(contents)
+}
+
+func TestWriteTruncatesAtMaxBytes(t *testing.T) {
+	s := newTestStore(t, 128, 5)
+	ctx, run, err := s.Start(context.Background(), testServiceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := Writer(ctx)
+	for range 10 {
+		if n, err := w.Write([]byte(strings.Repeat("x", 49) + "\n")); n != 50 || err != nil {
+			t.Fatalf("expected writes past the limit to succeed, got %d, %v", n, err)
+		}
+	}
+	run.Finish(errors.New("build failed"))
+
+	contents := readRun(t, s, run.ID)
+	logged, outcome, ok := strings.Cut(contents, "\n[log truncated at 128 bytes]\n")
+	if !ok {
+		t.Fatalf("expected a truncation marker, got %q", contents)
+	}
+	if len(logged) != 128 {
+		t.Fatalf("expected 128 bytes before the marker, got %d", len(logged))
+	}
+	// The outcome is written past the limit
+	if !strings.HasPrefix(outcome, "Run failed: build failed at ") {
+		t.Fatalf("expected the outcome after the marker, got %q", outcome)
+	}
+}
+
+func TestStartPrunesOldRuns(t *testing.T) {
+	s := newTestStore(t, 1024, 3)
+	ctx := context.Background()
+	_, active, err := s.Start(ctx, testServiceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var finished []string
+	for range 4 {
+		_, run, err := s.Start(ctx, testServiceName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		run.Finish(nil)
+		finished = append(finished, run.ID)
+	}
+
+	runs, err := s.List(ctx, testServiceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, run := range runs {
+		ids = append(ids, run.ID)
+		if run.InProgress != (run.ID == active.ID) {
+			t.Fatalf("expected only %s to be in progress, got %+v", active.ID, runs)
+		}
+	}
+	// The oldest run is still in progress, so it's kept beyond the retention
+	expected := []string{finished[3], finished[2], active.ID}
+	if strings.Join(ids, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected runs %v, got %v", expected, ids)
+	}
+	if _, _, err := s.Open(ctx, testServiceName, finished[0]); !apierr.HasCode(err, apierr.CodeNotFound) {
+		t.Fatalf("expected the pruned run to be gone, got %v", err)
+	}
+
+	// Once it has finished, it's pruned like the others
+	active.Finish(nil)
+	if _, _, err := s.Start(ctx, testServiceName); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Open(ctx, testServiceName, active.ID); !apierr.HasCode(err, apierr.CodeNotFound) {
+		t.Fatalf("expected the finished run to be pruned, got %v", err)
+	}
+}
+
+func TestOpenReportsWhenRunFinishes(t *testing.T) {
+	s := newTestStore(t, 1024, 5)
+	ctx := context.Background()
+	runCtx, run, err := s.Start(ctx, testServiceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logFile, done, err := s.Open(ctx, testServiceName, run.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+	select {
+	case <-done:
+		t.Fatal("expected the run to be in progress")
+	default:
+	}
+
+	// Output written while the run is followed is read from the same file
+	if _, err := io.ReadAll(logFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Writer(runCtx).Write([]byte("building\n")); err != nil {
+		t.Fatal(err)
+	}
+	run.Finish(nil)
+	<-done
+	rest, err := io.ReadAll(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(rest), "building\nRun succeeded at ") {
+		t.Fatalf("expected the new output and the outcome, got %q", rest)
+	}
+
+	// Finished runs are done as soon as they're opened
+	_, done, err = s.Open(ctx, testServiceName, run.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	default:
+		t.Fatal("expected the finished run to be done")
+	}
+}
+
+func TestOpenUnknownRun(t *testing.T) {
+	s := newTestStore(t, 1024, 5)
+	for _, runID := range []string{"01ARZ3NDEKTSV4RRFFQ69G5FAV", "../../etc/passwd", "not-a-run"} {
+		if _, _, err := s.Open(context.Background(), testServiceName, runID); !apierr.HasCode(err, apierr.CodeNotFound) {
+			t.Fatalf("expected %s for %q, got %v", apierr.CodeNotFound, runID, err)
+		}
+	}
+}
+
+func TestWriterOutsideRun(t *testing.T) {
+	if Writer(context.Background()) != io.Discard {
+		t.Fatal("expected output outside a run to be discarded")
+	}
+	// A nil run is valid
+	var run *Run
+	if n, err := run.Write([]byte("output")); n != 6 || err != nil {
+		t.Fatalf("expected the write to be dropped, got %d, %v", n, err)
+	}
+	run.Finish(nil)
+}
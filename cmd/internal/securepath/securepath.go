@@ -0,0 +1,56 @@
+package securepath
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsafePath is returned by Join for paths that leave their root.
+var ErrUnsafePath = errors.New("path is outside of its root")
+
+// Join joins elem onto root, like filepath.Join, and fails with
+// ErrUnsafePath if the result is outside root. Besides ".." segments, the
+// parts of the result that already exist are resolved, so a symlink inside
+// root, e.g. one committed to a cloned repo, can't point out of it either.
+func Join(root string, elem string) (string, error) {
+	root = filepath.Clean(root)
+	joined := filepath.Join(root, elem)
+	if !within(root, joined) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, elem)
+	}
+
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	existing := joined
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			if !within(resolvedRoot, resolved) {
+				return "", fmt.Errorf("%w: %q resolves to %q", ErrUnsafePath, elem, resolved)
+			}
+			return joined, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if _, err := os.Lstat(existing); err == nil {
+			// A dangling symlink, which creating the file would follow
+			return "", fmt.Errorf("%w: %q is a dangling symlink", ErrUnsafePath, elem)
+		}
+		// root exists, so walking up always ends at a path that resolves
+		existing = filepath.Dir(existing)
+	}
+}
+
+func within(root string, target string) bool {
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
@@ -0,0 +1,116 @@
+package securepath
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJoinTraversal(t *testing.T) {
+	root := t.TempDir()
+	tests := []struct {
+		elem    string
+		want    string
+		wantErr bool
+	}{
+		{elem: "a/b.txt", want: filepath.Join(root, "a", "b.txt")},
+		{elem: "a/../b.txt", want: filepath.Join(root, "b.txt")},
+		{elem: ".", want: root},
+		{elem: "..foo", want: filepath.Join(root, "..foo")},
+		// Absolute paths are joined like filepath.Join does, so stay inside
+		{elem: "/etc/passwd", want: filepath.Join(root, "etc", "passwd")},
+		{elem: "..", wantErr: true},
+		{elem: "../sibling", wantErr: true},
+		{elem: "a/../../sibling", wantErr: true},
+		{elem: "a/b/../../../sibling", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.elem, func(t *testing.T) {
+			got, err := Join(root, tt.elem)
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnsafePath) {
+					t.Fatalf("expected %v, got %q, %v", ErrUnsafePath, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestJoinSymlinks(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	mustWrite(t, filepath.Join(outside, "secret"))
+	mustWrite(t, filepath.Join(root, "inside", "file"))
+	mustSymlink(t, outside, filepath.Join(root, "to-outside"))
+	mustSymlink(t, filepath.Join(outside, "secret"), filepath.Join(root, "secret-link"))
+	mustSymlink(t, filepath.Join(root, "inside"), filepath.Join(root, "to-inside"))
+	mustSymlink(t, "../"+filepath.Base(outside), filepath.Join(root, "relative-out"))
+	mustSymlink(t, filepath.Join(outside, "missing"), filepath.Join(root, "dangling"))
+
+	tests := []struct {
+		elem    string
+		wantErr bool
+	}{
+		{elem: "to-inside/file"},
+		{elem: "to-inside/new-file"},
+		{elem: "to-outside", wantErr: true},
+		{elem: "to-outside/secret", wantErr: true},
+		// Files that don't exist yet are checked through their parents
+		{elem: "to-outside/new/file", wantErr: true},
+		{elem: "secret-link", wantErr: true},
+		{elem: "relative-out/secret", wantErr: true},
+		{elem: "dangling", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.elem, func(t *testing.T) {
+			_, err := Join(root, tt.elem)
+			if tt.wantErr && !errors.Is(err, ErrUnsafePath) {
+				t.Fatalf("expected %v, got %v", ErrUnsafePath, err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// A root that is itself a symlink is resolved before comparing.
+func TestJoinSymlinkedRoot(t *testing.T) {
+	real := t.TempDir()
+	mustWrite(t, filepath.Join(real, "file"))
+	root := filepath.Join(t.TempDir(), "root")
+	mustSymlink(t, real, root)
+
+	got, err := Join(root, "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != filepath.Join(root, "file") {
+		t.Fatalf("expected the path under the given root, got %q", got)
+	}
+}
+
+func mustWrite(t *testing.T, name string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustSymlink(t *testing.T, target string, name string) {
+	t.Helper()
+	if err := os.Symlink(target, name); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+}
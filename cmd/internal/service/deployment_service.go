@@ -3,10 +3,18 @@ package service
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strings"
 
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/audit"
+	"github.com/ansonallard/deployment-service/cmd/internal/compose"
+	"github.com/ansonallard/deployment-service/cmd/internal/export"
+	"github.com/ansonallard/deployment-service/cmd/internal/identity"
 	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/releaser"
 	"github.com/ansonallard/deployment-service/cmd/internal/repo"
-	"github.com/ansonallard/go_utils/openapi/ierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/validation"
 	"github.com/rs/zerolog"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -16,11 +24,31 @@ import (
 var tracer = otel.Tracer("deployment-service.service")
 
 type DeploymentService interface {
+	// Create stores service as provisioning and returns, cloning and
+	// validating it in the background.
 	Create(ctx context.Context, service *model.Service) error
+	// Validate checks service against its repo without creating it.
+	Validate(ctx context.Context, service *model.Service) (*validation.Report, error)
 	Get(ctx context.Context, serviceName string) (*model.Service, error)
-	List(ctx context.Context, maxResults int, nextToken string) ([]*model.Service, error)
+	List(ctx context.Context, opts model.ListOptions) ([]*model.Service, string, error)
 	Update(ctx context.Context, name string, ifMatch string, partial *model.Service) (*model.Service, error)
-	Delete(ctx context.Context, serviceName string) error
+	UpdateGit(ctx context.Context, name string, ifMatch string, update model.GitSettingsUpdate) (*model.Service, error)
+	Repair(ctx context.Context, name string) (*model.Service, error)
+	// Delete removes the service, tearing down what it deployed according to
+	// opts.Mode.
+	Delete(ctx context.Context, serviceName string, opts model.DeleteOptions) (*model.DeleteReport, error)
+	ListRevisions(ctx context.Context, serviceName string) ([]*model.Revision, error)
+	GetRevision(ctx context.Context, serviceName string, revisionID string) (*model.Revision, error)
+	// DiffRevisions compares revisionID with againstID, or with the revision
+	// before it when againstID is empty.
+	DiffRevisions(ctx context.Context, serviceName string, revisionID string, againstID string) (*model.Revision, []model.RevisionChange, error)
+	Revert(ctx context.Context, serviceName string, ifMatch string, revisionID string) (*model.Service, error)
+	// Export bundles every service and its revisions. Secrets are encrypted
+	// with passphrase, or left out when it is empty.
+	Export(ctx context.Context, passphrase string) (*export.Bundle, error)
+	// Import recreates the services in bundle as provisioning and returns,
+	// cloning them in the background like Create.
+	Import(ctx context.Context, bundle *export.Bundle, passphrase string, policy export.ConflictPolicy) (*export.ImportResult, error)
 	CollectExistingServicesForBackgroundProcessing(ctx context.Context) error
 }
 
@@ -29,11 +57,27 @@ type ServiceChannel = chan string
 type DeploymentServiceConfig struct {
 	Repo                 repo.DeploymentService
 	BackgroundJobChannel ServiceChannel
+	Validator            validation.Validator
+	// Compose and DockerReleaser tear down what a service deployed when it
+	// is deleted
+	Compose        compose.ComposeRunner
+	DockerReleaser releaser.DockerReleaser
+	// SelfServiceName is the service that deploys this application, whose
+	// stack can't be stopped from inside it
+	SelfServiceName string
+	// Context stops background provisioning when it is done, on shutdown.
+	// Defaults to context.Background().
+	Context context.Context
 }
 
 type deploymentService struct {
 	repo                 repo.DeploymentService
 	backgroundJobChannel ServiceChannel
+	validator            validation.Validator
+	compose              compose.ComposeRunner
+	dockerReleaser       releaser.DockerReleaser
+	selfServiceName      string
+	provisioning         *provisioningRuns
 }
 
 func NewDeploymentService(config DeploymentServiceConfig) (DeploymentService, error) {
@@ -43,7 +87,28 @@ func NewDeploymentService(config DeploymentServiceConfig) (DeploymentService, er
 	if config.BackgroundJobChannel == nil {
 		return nil, fmt.Errorf("background channel not set")
 	}
-	return &deploymentService{repo: config.Repo, backgroundJobChannel: config.BackgroundJobChannel}, nil
+	if config.Validator == nil {
+		return nil, fmt.Errorf("validator not set")
+	}
+	if config.Compose == nil {
+		return nil, fmt.Errorf("compose not set")
+	}
+	if config.DockerReleaser == nil {
+		return nil, fmt.Errorf("docker releaser not set")
+	}
+	lifecycle := config.Context
+	if lifecycle == nil {
+		lifecycle = context.Background()
+	}
+	return &deploymentService{
+		repo:                 config.Repo,
+		backgroundJobChannel: config.BackgroundJobChannel,
+		validator:            config.Validator,
+		compose:              config.Compose,
+		dockerReleaser:       config.DockerReleaser,
+		selfServiceName:      config.SelfServiceName,
+		provisioning:         newProvisioningRuns(lifecycle),
+	}, nil
 }
 
 func (ds *deploymentService) Create(ctx context.Context, service *model.Service) error {
@@ -51,44 +116,79 @@ func (ds *deploymentService) Create(ctx context.Context, service *model.Service)
 		trace.WithAttributes(attribute.String("service.name", service.Name.Name)),
 	)
 	defer span.End()
+	audit.SetService(ctx, service.Name.Name)
 
 	existingService, err := ds.Get(ctx, service.Name.Name)
-	if err != nil {
-		switch err.(type) {
-		case *ierr.NotFoundError:
-			// Continue on
-		default:
-			return err
-		}
+	if err != nil && !apierr.HasCode(err, apierr.CodeServiceNotFound) {
+		return err
 	}
 	if existingService != nil {
-		return &ierr.ConflictError{}
+		return apierr.Newf(apierr.CodeAlreadyExists, "service %s already exists", service.Name.Name)
 	}
 	err = ds.repo.Create(ctx, service)
 	if err != nil {
 		return err
 	}
-	// Kick off background processing
+	if err := ds.attachDeploymentStatus(ctx, service); err != nil {
+		return err
+	}
+	// Kick off background processing, which skips the service until it is
+	// provisioned
 	ds.backgroundJobChannel <- service.Name.Name
+	ds.startProvisioning(ctx, service.Name.Name)
 	return nil
 }
 
+func (ds *deploymentService) Validate(ctx context.Context, service *model.Service) (*validation.Report, error) {
+	ctx, span := tracer.Start(ctx, "service.validate",
+		trace.WithAttributes(attribute.String("service.name", service.Name.Name)),
+	)
+	defer span.End()
+
+	return ds.validator.Validate(ctx, service)
+}
+
 func (ds *deploymentService) Get(ctx context.Context, serviceName string) (*model.Service, error) {
 	ctx, span := tracer.Start(ctx, "service.get",
 		trace.WithAttributes(attribute.String("service.name", serviceName)),
 	)
 	defer span.End()
 
-	return ds.repo.Get(ctx, serviceName)
+	service, err := ds.repo.Get(ctx, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	if err := ds.attachDeploymentStatus(ctx, service); err != nil {
+		return nil, err
+	}
+	return service, nil
 }
 
-func (ds *deploymentService) List(ctx context.Context, maxResults int, nextToken string) ([]*model.Service, error) {
+func (ds *deploymentService) List(ctx context.Context, opts model.ListOptions) ([]*model.Service, string, error) {
 	ctx, span := tracer.Start(ctx, "service.list",
-		trace.WithAttributes(attribute.Int("max_results", maxResults)),
+		trace.WithAttributes(attribute.Int("max_results", opts.MaxResults)),
 	)
 	defer span.End()
 
-	return ds.repo.List(ctx, maxResults, nextToken)
+	services, nextToken, err := ds.repo.List(ctx, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, service := range services {
+		if err := ds.attachDeploymentStatus(ctx, service); err != nil {
+			return nil, "", err
+		}
+	}
+	return services, nextToken, nil
+}
+
+func (ds *deploymentService) attachDeploymentStatus(ctx context.Context, service *model.Service) error {
+	deploymentStatus, err := ds.repo.GetDeploymentStatus(ctx, service.Name.Name)
+	if err != nil {
+		return err
+	}
+	service.DeploymentStatus = deploymentStatus
+	return nil
 }
 
 func (ds *deploymentService) Update(ctx context.Context, name string, ifMatch string, partial *model.Service) (*model.Service, error) {
@@ -97,32 +197,384 @@ func (ds *deploymentService) Update(ctx context.Context, name string, ifMatch st
 	)
 	defer span.End()
 
-	return ds.repo.Update(ctx, name, ifMatch, partial)
+	updated, err := ds.repo.Update(ctx, name, ifMatch, partial)
+	if err != nil {
+		return nil, err
+	}
+	if err := ds.attachDeploymentStatus(ctx, updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// UpdateGit re-clones the service from its new git settings. The background
+// job re-reads the service every tick, so it picks up the new clone without
+// being restarted. A service that failed to provision is provisioned by it.
+func (ds *deploymentService) UpdateGit(ctx context.Context, name string, ifMatch string, update model.GitSettingsUpdate) (*model.Service, error) {
+	ctx, span := tracer.Start(ctx, "service.update_git",
+		trace.WithAttributes(attribute.String("service.name", name)),
+	)
+	defer span.End()
+
+	return ds.reprovision(ctx, name, func() (*model.Service, error) {
+		return ds.repo.UpdateGit(ctx, name, ifMatch, update)
+	})
 }
 
-func (ds *deploymentService) Delete(ctx context.Context, serviceName string) error {
+// Repair re-clones the service, which also retries provisioning a service
+// that failed to provision.
+func (ds *deploymentService) Repair(ctx context.Context, name string) (*model.Service, error) {
+	ctx, span := tracer.Start(ctx, "service.repair",
+		trace.WithAttributes(attribute.String("service.name", name)),
+	)
+	defer span.End()
+
+	return ds.reprovision(ctx, name, func() (*model.Service, error) {
+		return ds.repo.Repair(ctx, name)
+	})
+}
+
+// reprovision runs reclone, which replaces the service's worktree. It is
+// refused while the service is still provisioning, and a service that failed
+// to provision is validated again once reclone succeeds.
+func (ds *deploymentService) reprovision(ctx context.Context, name string, reclone func() (*model.Service, error)) (*model.Service, error) {
+	status, err := ds.repo.GetDeploymentStatus(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if status.EffectiveProvisioning() == model.ProvisioningStateProvisioning {
+		return nil, apierr.New(apierr.CodeServiceBusy, "service is still provisioning, try again once it has finished")
+	}
+
+	service, err := reclone()
+	if err != nil {
+		return nil, err
+	}
+	if status.EffectiveProvisioning() == model.ProvisioningStateFailed {
+		if err := ds.finishProvisioning(ctx, service); err != nil {
+			return nil, err
+		}
+	}
+	if err := ds.attachDeploymentStatus(ctx, service); err != nil {
+		return nil, err
+	}
+	return service, nil
+}
+
+func (ds *deploymentService) Delete(ctx context.Context, serviceName string, opts model.DeleteOptions) (*model.DeleteReport, error) {
 	ctx, span := tracer.Start(ctx, "service.delete",
+		trace.WithAttributes(
+			attribute.String("service.name", serviceName),
+			attribute.String("delete.mode", string(opts.Mode)),
+			attribute.Bool("delete.archive", opts.Archive),
+		),
+	)
+	defer span.End()
+
+	if opts.Mode != model.DeleteModeRetain && ds.selfServiceName != "" && serviceName == ds.selfServiceName {
+		return nil, apierr.InvalidField("mode", fmt.Sprintf("%s deploys the deployment service, it can only be deleted with mode %s",
+			serviceName, model.DeleteModeRetain))
+	}
+
+	report := model.NewDeleteReport(opts.Mode)
+	var teardown func(service *model.Service) error
+	if opts.Mode != model.DeleteModeRetain {
+		teardown = func(service *model.Service) error {
+			return ds.teardown(ctx, service, opts.Mode, report)
+		}
+	}
+	archivedTo, err := ds.repo.Delete(ctx, serviceName, opts.Archive, teardown)
+	if err != nil {
+		return nil, err
+	}
+	// Provisioning waiting to retry a failed clone would otherwise sleep on
+	ds.provisioning.stop(serviceName)
+	report.ArchivedTo = archivedTo
+	return report, nil
+}
+
+// teardown stops the service's Docker Compose stack and, when purging, removes
+// its images. A stack that fails to stop aborts the delete, since removing the
+// clone would leave it running with nothing left to stop it from. Images that
+// fail to be removed are only reported.
+func (ds *deploymentService) teardown(ctx context.Context, service *model.Service, mode model.DeleteMode, report *model.DeleteReport) error {
+	log := zerolog.Ctx(ctx)
+
+	if service.Configuration.DockerCompose != nil {
+		down := ds.compose.Down
+		if mode == model.DeleteModePurge {
+			down = ds.compose.DownRemovingImages
+		}
+		if err := down(ctx, service.GitRepoFilePath); err != nil {
+			return fmt.Errorf("failed to stop docker compose stack: %w", err)
+		}
+		report.StackStopped = true
+		report.StackImagesRemoved = mode == model.DeleteModePurge
+		log.Info().Str("service", service.Name.Name).Bool("imagesRemoved", report.StackImagesRemoved).
+			Msg("Stopped docker compose stack")
+	}
+
+	if mode != model.DeleteModePurge {
+		return nil
+	}
+	tags, err := ds.dockerReleaser.ListImages(ctx, service.Name.Name)
+	if err != nil {
+		report.Failed = append(report.Failed, model.DeleteFailure{Resource: "images", Error: err.Error()})
+		return nil
+	}
+	for _, tag := range tags {
+		if err := ds.dockerReleaser.RemoveImage(ctx, tag); err != nil {
+			log.Warn().Err(err).Str("service", service.Name.Name).Str("tag", tag).Msg("Failed to remove image")
+			report.Failed = append(report.Failed, model.DeleteFailure{Resource: tag, Error: err.Error()})
+			continue
+		}
+		report.RemovedImages = append(report.RemovedImages, tag)
+	}
+	return nil
+}
+
+func (ds *deploymentService) ListRevisions(ctx context.Context, serviceName string) ([]*model.Revision, error) {
+	ctx, span := tracer.Start(ctx, "service.list_revisions",
 		trace.WithAttributes(attribute.String("service.name", serviceName)),
 	)
 	defer span.End()
 
-	return ds.repo.Delete(ctx, serviceName)
+	return ds.repo.ListRevisions(ctx, serviceName)
+}
+
+func (ds *deploymentService) GetRevision(ctx context.Context, serviceName string, revisionID string) (*model.Revision, error) {
+	ctx, span := tracer.Start(ctx, "service.get_revision",
+		trace.WithAttributes(
+			attribute.String("service.name", serviceName),
+			attribute.String("revision.id", revisionID),
+		),
+	)
+	defer span.End()
+
+	revision, err := ds.repo.GetRevision(ctx, serviceName, revisionID)
+	if err != nil {
+		return nil, err
+	}
+	// The status is the service's current one, not the one at the revision
+	if err := ds.attachDeploymentStatus(ctx, &revision.Service); err != nil {
+		return nil, err
+	}
+	return revision, nil
+}
+
+func (ds *deploymentService) DiffRevisions(ctx context.Context, serviceName string, revisionID string, againstID string) (*model.Revision, []model.RevisionChange, error) {
+	ctx, span := tracer.Start(ctx, "service.diff_revisions",
+		trace.WithAttributes(
+			attribute.String("service.name", serviceName),
+			attribute.String("revision.id", revisionID),
+			attribute.String("revision.against", againstID),
+		),
+	)
+	defer span.End()
+
+	revision, err := ds.repo.GetRevision(ctx, serviceName, revisionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var against *model.Revision
+	if againstID != "" {
+		if against, err = ds.repo.GetRevision(ctx, serviceName, againstID); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		revisions, err := ds.repo.ListRevisions(ctx, serviceName)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Newest first, so the previous revision is the next one in the list
+		for i, candidate := range revisions {
+			if candidate.ID == revisionID && i+1 < len(revisions) {
+				against = revisions[i+1]
+				break
+			}
+		}
+		if against == nil {
+			return nil, nil, apierr.Newf(apierr.CodeInvalidArgument, "revision %s is the first revision, nothing to compare against", revisionID)
+		}
+	}
+
+	changes, err := model.DiffRevisions(against, revision)
+	if err != nil {
+		return nil, nil, err
+	}
+	return against, changes, nil
+}
+
+func (ds *deploymentService) Revert(ctx context.Context, serviceName string, ifMatch string, revisionID string) (*model.Service, error) {
+	ctx, span := tracer.Start(ctx, "service.revert",
+		trace.WithAttributes(
+			attribute.String("service.name", serviceName),
+			attribute.String("revision.id", revisionID),
+		),
+	)
+	defer span.End()
+
+	reverted, err := ds.repo.Revert(ctx, serviceName, ifMatch, revisionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ds.attachDeploymentStatus(ctx, reverted); err != nil {
+		return nil, err
+	}
+	return reverted, nil
+}
+
+func (ds *deploymentService) Export(ctx context.Context, passphrase string) (*export.Bundle, error) {
+	ctx, span := tracer.Start(ctx, "service.export",
+		trace.WithAttributes(attribute.Bool("export.encrypted", passphrase != "")),
+	)
+	defer span.End()
+
+	services, err := ds.listAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]export.Entry, 0, len(services))
+	for _, service := range services {
+		revisions, err := ds.repo.ListRevisions(ctx, service.Name.Name)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, export.Entry{Service: service, Revisions: revisions})
+	}
+	span.SetAttributes(attribute.Int("export.services", len(entries)))
+	return export.NewBundle(entries, passphrase)
+}
+
+func (ds *deploymentService) Import(ctx context.Context, bundle *export.Bundle, passphrase string, policy export.ConflictPolicy) (*export.ImportResult, error) {
+	ctx, span := tracer.Start(ctx, "service.import",
+		trace.WithAttributes(attribute.String("import.conflict_policy", string(policy))),
+	)
+	defer span.End()
+	log := zerolog.Ctx(ctx)
+
+	entries, err := bundle.Open(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]bool, len(entries))
+	conflicts := make([]string, 0)
+	for _, entry := range entries {
+		if _, err := ds.repo.Get(ctx, entry.Service.Name.Name); err == nil {
+			existing[entry.Service.Name.Name] = true
+			conflicts = append(conflicts, entry.Service.Name.Name)
+		}
+	}
+	if policy == export.ConflictFail && len(conflicts) > 0 {
+		return nil, apierr.Newf(apierr.CodeAlreadyExists, "services already exist: %s", strings.Join(conflicts, ", "))
+	}
+
+	result := export.NewImportResult()
+	result.SecretsExcluded = bundle.Encryption == nil
+	for _, entry := range entries {
+		service := entry.Service
+		name := service.Name.Name
+		if existing[name] && policy == export.ConflictSkip {
+			result.Skipped = append(result.Skipped, name)
+			continue
+		}
+
+		// Bundles may come from a version that accepted names and paths
+		// that are rejected now
+		if err := service.Validate(); err != nil {
+			result.Failed = append(result.Failed, export.NewImportFailed(name, err))
+			continue
+		}
+
+		revisions := entry.Revisions
+		if !slices.ContainsFunc(revisions, func(revision *model.Revision) bool { return revision.ID == service.Version }) {
+			revisions = append(revisions, model.NewRevision(service, identity.CallerFromContext(ctx), model.RevisionActionImport))
+		}
+
+		if existing[name] {
+			// Only one provisioning of a service runs at a time
+			status, err := ds.repo.GetDeploymentStatus(ctx, name)
+			if err == nil && status.EffectiveProvisioning() == model.ProvisioningStateProvisioning {
+				err = apierr.New(apierr.CodeServiceBusy, "service is still provisioning, try again once it has finished")
+			}
+			if err != nil {
+				result.Failed = append(result.Failed, export.NewImportFailed(name, err))
+				continue
+			}
+		}
+
+		if err := ds.repo.Import(ctx, service, revisions, existing[name]); err != nil {
+			log.Error().Err(err).Str("service", name).Msg("Failed to import service")
+			result.Failed = append(result.Failed, export.NewImportFailed(name, err))
+			continue
+		}
+		if existing[name] {
+			// Its background job is already running and skips the service
+			// until the new clone is provisioned
+			result.Overwritten = append(result.Overwritten, name)
+		} else {
+			result.Created = append(result.Created, name)
+			ds.backgroundJobChannel <- name
+		}
+		ds.startProvisioning(ctx, name)
+	}
+
+	span.SetAttributes(
+		attribute.Int("import.created", len(result.Created)),
+		attribute.Int("import.overwritten", len(result.Overwritten)),
+		attribute.Int("import.skipped", len(result.Skipped)),
+		attribute.Int("import.failed", len(result.Failed)),
+	)
+	return result, nil
+}
+
+// listAll pages through every service.
+func (ds *deploymentService) listAll(ctx context.Context) ([]*model.Service, error) {
+	services := make([]*model.Service, 0)
+	opts := model.ListOptions{MaxResults: 100, SortBy: model.SortByName, SortOrder: model.SortOrderAsc}
+	for {
+		page, nextToken, err := ds.List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, page...)
+		if nextToken == "" {
+			return services, nil
+		}
+		opts.Cursor = opts.CursorAfter(page[len(page)-1])
+	}
 }
 
 func (ds *deploymentService) CollectExistingServicesForBackgroundProcessing(ctx context.Context) error {
 	ctx, span := tracer.Start(ctx, "service.collect_existing")
 	defer span.End()
+	log := zerolog.Ctx(ctx)
 
-	services, err := ds.List(ctx, 100, "")
+	quarantined, err := ds.repo.Fsck(ctx)
+	if err != nil {
+		return err
+	}
+	if len(quarantined) > 0 {
+		log.Error().Strs("services", quarantined).
+			Msgf("Quarantined %d services with unreadable definitions. They will not be processed", len(quarantined))
+	}
+
+	services, err := ds.listAll(ctx)
 	if err != nil {
 		return err
 	}
-	log := zerolog.Ctx(ctx)
 	log.Info().Interface("services", services).Int("numberOfServices", len(services)).
 		Msgf("Collected %d pre-existing services. Sending notifications for processing", len(services))
 	for _, service := range services {
 		ds.backgroundJobChannel <- service.Name.Name
 		log.Info().Interface("service", service).Msg("Notified for processing")
+		// Provisioning interrupted by a restart starts over
+		if service.DeploymentStatus.EffectiveProvisioning() == model.ProvisioningStateProvisioning {
+			log.Info().Str("service", service.Name.Name).Msg("Resuming provisioning")
+			ds.startProvisioning(ctx, service.Name.Name)
+		}
 	}
 	return nil
 }
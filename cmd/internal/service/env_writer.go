@@ -4,9 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"path/filepath"
 	"sort"
 
+	"github.com/ansonallard/deployment-service/cmd/internal/securepath"
 	"github.com/rs/zerolog"
 )
 
@@ -62,7 +62,10 @@ func (w *envFileWriter) WriteEnvFile(ctx context.Context, path, envFile string,
 		return fmt.Errorf("path is not a directory: %s", path)
 	}
 
-	targetFile := filepath.Join(path, envFile)
+	targetFile, err := securepath.Join(path, envFile)
+	if err != nil {
+		return fmt.Errorf("invalid env file %q: %w", envFile, err)
+	}
 
 	// Sort keys for deterministic ordering
 	keys := make([]string, 0, len(envVars))
@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/export"
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/repo"
+	"github.com/ansonallard/deployment-service/cmd/internal/utils"
+	"github.com/ansonallard/deployment-service/cmd/internal/validation"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+const testPassphrase = "correct horse battery staple"
+
+type noCredentials struct{}
+
+func (noCredentials) AuthMethod(credentialName, gitURL string) (transport.AuthMethod, error) {
+	return nil, nil
+}
+
+// validClones passes every clone, so provisioning finishes.
+type validClones struct {
+	validation.Validator
+}
+
+func (validClones) CheckClone(ctx context.Context, service *model.Service, dir string) (*validation.Report, error) {
+	return &validation.Report{Valid: true}, nil
+}
+
+// newImportTest returns a service over a repo of its own, cloning from
+// client's remotes.
+func newImportTest(t *testing.T, client *repo.InMemoryGitClient) *deploymentService {
+	t.Helper()
+	r, err := repo.NewDeploymentService(repo.DeploymentServieConfig{
+		ServiceFilPath: t.TempDir(),
+		Credentials:    noCredentials{},
+		GitClient:      client,
+		ServiceLocks:   repo.NewServiceLocks(),
+		GitRepoOrigin:  git.DefaultRemoteName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &deploymentService{
+		repo:                 r,
+		backgroundJobChannel: make(chan string, 10),
+		validator:            validClones{},
+		provisioning:         newProvisioningRuns(context.Background()),
+	}
+}
+
+// newRemote creates a remote for name with one commit.
+func newRemote(t *testing.T, client *repo.InMemoryGitClient, name string) string {
+	t.Helper()
+	url, err := client.CreateRemote(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.PushCommit(context.Background(), url, "main", "feat: initial commit", map[string]string{"version.txt": "0.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	return url
+}
+
+func newComposeService(name string, url string, token string) *model.Service {
+	return &model.Service{
+		Name:          model.Name{Name: name},
+		ID:            utils.GenerateUlidString(),
+		Version:       utils.GenerateUlidString(),
+		GitSSHUrl:     url,
+		GitBranchName: "main",
+		Configuration: model.ServiceConfiguration{DockerCompose: &model.DockerComposeConfiguration{
+			EnvFiles: map[string]model.EnvVars{".env": {"TOKEN": token}},
+		}},
+	}
+}
+
+// create creates service and waits for it to be provisioned.
+func create(t *testing.T, ds *deploymentService, service *model.Service) {
+	t.Helper()
+	if err := ds.Create(context.Background(), service); err != nil {
+		t.Fatal(err)
+	}
+	waitForProvisioning(t, ds, service.Name.Name)
+}
+
+// waitForProvisioning waits for the service's provisioning to finish, so the
+// test doesn't end while it writes.
+func waitForProvisioning(t *testing.T, ds *deploymentService, name string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := ds.repo.GetDeploymentStatus(context.Background(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.EffectiveProvisioning() == model.ProvisioningStateReady {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s to be provisioned, got %+v", name, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// exportBundle exports ds and returns the bundle as an import reads it.
+func exportBundle(t *testing.T, ds *deploymentService) *export.Bundle {
+	t.Helper()
+	bundle, err := ds.Export(context.Background(), testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var imported export.Bundle
+	if err := json.Unmarshal(data, &imported); err != nil {
+		t.Fatal(err)
+	}
+	return &imported
+}
+
+func envToken(t *testing.T, ds *deploymentService, name string) any {
+	t.Helper()
+	service, err := ds.Get(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service.Configuration.DockerCompose.EnvFiles[".env"]["TOKEN"]
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	client := repo.NewInMemoryGitClient()
+	source := newImportTest(t, client)
+	alpha := newComposeService("alpha", newRemote(t, client, "alpha"), "alpha-secret")
+	beta := newComposeService("beta", newRemote(t, client, "beta"), "beta-secret")
+	create(t, source, alpha)
+	create(t, source, beta)
+
+	target := newImportTest(t, client)
+	result, err := target.Import(context.Background(), exportBundle(t, source), testPassphrase, export.ConflictFail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Created) != 2 || len(result.Failed) != 0 || result.SecretsExcluded {
+		t.Fatalf("expected both services to be created with their secrets, got %+v", result)
+	}
+	for _, service := range []*model.Service{alpha, beta} {
+		name := service.Name.Name
+		waitForProvisioning(t, target, name)
+		imported, err := target.Get(context.Background(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if imported.ID != service.ID || imported.Version != service.Version || imported.GitSSHUrl != service.GitSSHUrl {
+			t.Fatalf("expected %s to be imported as it was exported, got %+v", name, imported)
+		}
+		if got := envToken(t, target, name); got != name+"-secret" {
+			t.Fatalf("expected %s's env file to be imported, got token %v", name, got)
+		}
+		revisions, err := target.ListRevisions(context.Background(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revisions) != 1 || revisions[0].ID != service.Version {
+			t.Fatalf("expected %s's revision to be imported, got %+v", name, revisions)
+		}
+	}
+}
+
+func TestImportConflictPolicies(t *testing.T) {
+	client := repo.NewInMemoryGitClient()
+	alphaURL := newRemote(t, client, "alpha")
+	betaURL := newRemote(t, client, "beta")
+	source := newImportTest(t, client)
+	create(t, source, newComposeService("alpha", alphaURL, "exported-secret"))
+	create(t, source, newComposeService("beta", betaURL, "beta-secret"))
+	bundle := exportBundle(t, source)
+
+	// newTarget returns a service where alpha already exists, but beta
+	// doesn't
+	newTarget := func(t *testing.T) *deploymentService {
+		t.Helper()
+		target := newImportTest(t, client)
+		create(t, target, newComposeService("alpha", alphaURL, "existing-secret"))
+		return target
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		target := newTarget(t)
+		_, err := target.Import(context.Background(), bundle, testPassphrase, export.ConflictFail)
+		if !apierr.HasCode(err, apierr.CodeAlreadyExists) {
+			t.Fatalf("expected %s, got %v", apierr.CodeAlreadyExists, err)
+		}
+		// Nothing is imported, not even the services that don't conflict
+		if got := envToken(t, target, "alpha"); got != "existing-secret" {
+			t.Fatalf("expected alpha to be unchanged, got token %v", got)
+		}
+		if _, err := target.Get(context.Background(), "beta"); !apierr.HasCode(err, apierr.CodeServiceNotFound) {
+			t.Fatalf("expected beta not to be imported, got %v", err)
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		target := newTarget(t)
+		result, err := target.Import(context.Background(), bundle, testPassphrase, export.ConflictSkip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitForProvisioning(t, target, "beta")
+		if len(result.Skipped) != 1 || result.Skipped[0] != "alpha" || len(result.Created) != 1 || result.Created[0] != "beta" {
+			t.Fatalf("expected alpha to be skipped and beta created, got %+v", result)
+		}
+		if got := envToken(t, target, "alpha"); got != "existing-secret" {
+			t.Fatalf("expected alpha to be unchanged, got token %v", got)
+		}
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		target := newTarget(t)
+		result, err := target.Import(context.Background(), bundle, testPassphrase, export.ConflictOverwrite)
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitForProvisioning(t, target, "alpha")
+		waitForProvisioning(t, target, "beta")
+		if len(result.Overwritten) != 1 || result.Overwritten[0] != "alpha" || len(result.Created) != 1 || result.Created[0] != "beta" {
+			t.Fatalf("expected alpha to be overwritten and beta created, got %+v", result)
+		}
+		if got := envToken(t, target, "alpha"); got != "exported-secret" {
+			t.Fatalf("expected alpha to be replaced, got token %v", got)
+		}
+	})
+}
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	provisionAttempts = 5
+	// provisionBackoff is the wait before the second attempt, doubling after
+	// each failed one
+	provisionBackoff = 10 * time.Second
+	// progressInterval is how often the clone's progress is written to the
+	// deployment status
+	progressInterval = time.Second
+)
+
+// provisioningRuns holds a cancel for each service being provisioned, and
+// cancels all of them once lifecycle is done.
+type provisioningRuns struct {
+	lifecycle context.Context
+	mu        sync.Mutex
+	runs      map[string]*provisioningRun
+}
+
+type provisioningRun struct {
+	cancel context.CancelFunc
+}
+
+func newProvisioningRuns(lifecycle context.Context) *provisioningRuns {
+	return &provisioningRuns{lifecycle: lifecycle, runs: make(map[string]*provisioningRun)}
+}
+
+// start registers a run for name, cancelling any earlier one, and returns a
+// ctx that is cancelled when the run is stopped, along with a func to call
+// once the run is over.
+func (pr *provisioningRuns) start(ctx context.Context, name string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	stopOnShutdown := context.AfterFunc(pr.lifecycle, cancel)
+	run := &provisioningRun{cancel: cancel}
+	pr.mu.Lock()
+	if previous, ok := pr.runs[name]; ok {
+		previous.cancel()
+	}
+	pr.runs[name] = run
+	pr.mu.Unlock()
+	return ctx, func() {
+		pr.mu.Lock()
+		if pr.runs[name] == run {
+			delete(pr.runs, name)
+		}
+		pr.mu.Unlock()
+		stopOnShutdown()
+		cancel()
+	}
+}
+
+// stop cancels the run for name, if there is one.
+func (pr *provisioningRuns) stop(name string) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if run, ok := pr.runs[name]; ok {
+		run.cancel()
+		delete(pr.runs, name)
+	}
+}
+
+// startProvisioning provisions the service in the background. It outlives
+// the request that started it, but stops when the service is deleted or on
+// shutdown.
+func (ds *deploymentService) startProvisioning(ctx context.Context, name string) {
+	ctx, done := ds.provisioning.start(context.WithoutCancel(ctx), name)
+	go func() {
+		defer done()
+		ds.provision(ctx, name)
+	}()
+}
+
+// provision clones a newly created service in the background, retrying
+// failed clones with a backoff. Clones failing with a bad request, e.g. a
+// missing branch, aren't retried. The service is ready once the clone passes
+// validation.
+func (ds *deploymentService) provision(ctx context.Context, name string) {
+	// A root trace of its own, since the request that created the service
+	// has already returned
+	ctx, span := tracer.Start(ctx, "service.provision",
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithAttributes(attribute.String("service.name", name)),
+	)
+	defer span.End()
+	log := zerolog.Ctx(ctx).With().Str("service", name).Logger()
+
+	backoff := provisionBackoff
+	for attempt := 1; ; attempt++ {
+		if err := ds.repo.UpdateDeploymentStatus(ctx, name, func(status *model.DeploymentStatus) {
+			status.ProvisioningStarted(attempt)
+		}); err != nil {
+			log.Warn().Err(err).Msg("Stopped provisioning, failed to update deployment status")
+			return
+		}
+
+		progress := newProgressWriter(func(line string) {
+			_ = ds.repo.UpdateDeploymentStatus(ctx, name, func(status *model.DeploymentStatus) {
+				status.ProvisioningProgress = line
+			})
+		})
+		service, err := ds.repo.Provision(ctx, name, progress)
+		progress.Stop()
+		if err == nil {
+			if err := ds.finishProvisioning(ctx, service); err != nil {
+				log.Error().Err(err).Msg("Failed to record provisioning")
+			}
+			span.SetAttributes(attribute.Int("provisioning.attempts", attempt))
+			return
+		}
+
+		switch {
+		case apierr.HasCode(err, apierr.CodeServiceNotFound):
+			log.Info().Msg("Service was deleted while provisioning")
+			return
+		case apierr.HasCode(err, apierr.CodeBranchNotFound), apierr.HasCode(err, apierr.CodeInvalidCredential):
+			ds.failProvisioning(ctx, name, err)
+			return
+		}
+		if attempt == provisionAttempts {
+			ds.failProvisioning(ctx, name, fmt.Errorf("gave up after %d attempts: %w", attempt, err))
+			return
+		}
+		log.Warn().Err(err).Int("attempt", attempt).Dur("retryIn", backoff).Msg("Failed to provision service, retrying")
+		// Shown while retrying, cleared once provisioned
+		_ = ds.repo.UpdateDeploymentStatus(ctx, name, func(status *model.DeploymentStatus) {
+			status.ProvisioningError = err.Error()
+		})
+		select {
+		case <-ctx.Done():
+			log.Info().Err(ctx.Err()).Msg("Stopped provisioning")
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// finishProvisioning validates the fresh clone of service, marking it ready,
+// or failed with the problems found.
+func (ds *deploymentService) finishProvisioning(ctx context.Context, service *model.Service) error {
+	report, err := ds.validator.CheckClone(ctx, service, service.GitRepoFilePath)
+	if err != nil {
+		return err
+	}
+	var provisioningErr error
+	if !report.Valid {
+		provisioningErr = fmt.Errorf("service failed validation: %s", report.Summary())
+	}
+	return ds.repo.UpdateDeploymentStatus(ctx, service.Name.Name, func(status *model.DeploymentStatus) {
+		status.ProvisioningFinished(provisioningErr)
+	})
+}
+
+func (ds *deploymentService) failProvisioning(ctx context.Context, name string, provisioningErr error) {
+	log := zerolog.Ctx(ctx)
+	log.Error().Err(provisioningErr).Str("service", name).Msg("Failed to provision service")
+	if err := ds.repo.UpdateDeploymentStatus(ctx, name, func(status *model.DeploymentStatus) {
+		status.ProvisioningFinished(provisioningErr)
+	}); err != nil {
+		log.Error().Err(err).Str("service", name).Msg("Failed to record provisioning failure")
+	}
+}
+
+// progressWriter passes the last line of a clone's progress to report, at
+// most once every progressInterval. git rewrites a line in place with \r as
+// it counts up, so both \r and \n end a line.
+type progressWriter struct {
+	mu       sync.Mutex
+	report   func(line string)
+	partial  bytes.Buffer
+	last     string
+	reported string
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newProgressWriter(report func(line string)) *progressWriter {
+	w := &progressWriter{
+		report: report,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, b := range p {
+		if b != '\r' && b != '\n' {
+			w.partial.WriteByte(b)
+			continue
+		}
+		if line := strings.TrimSpace(w.partial.String()); line != "" {
+			w.last = line
+		}
+		w.partial.Reset()
+	}
+	return len(p), nil
+}
+
+func (w *progressWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			line := w.last
+			w.mu.Unlock()
+			if line != w.reported {
+				w.report(line)
+				w.reported = line
+			}
+		}
+	}
+}
+
+// Stop stops reporting, waiting for a report in flight so it can't overwrite
+// the outcome of the clone.
+func (w *progressWriter) Stop() {
+	close(w.stop)
+	<-w.done
+}
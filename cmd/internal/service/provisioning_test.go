@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/repo"
+)
+
+// unreachableRepo fails every clone as if the remote were down, so
+// provisioning keeps waiting to retry.
+type unreachableRepo struct {
+	repo.DeploymentService
+	attempts atomic.Int32
+	cloned   chan struct{}
+}
+
+func (r *unreachableRepo) UpdateDeploymentStatus(ctx context.Context, serviceName string, update func(status *model.DeploymentStatus)) error {
+	return nil
+}
+
+func (r *unreachableRepo) Provision(ctx context.Context, name string, progress io.Writer) (*model.Service, error) {
+	r.attempts.Add(1)
+	r.cloned <- struct{}{}
+	return nil, errors.New("connection refused")
+}
+
+func (r *unreachableRepo) Delete(ctx context.Context, serviceName string, archive bool, teardown func(service *model.Service) error) (string, error) {
+	return "", nil
+}
+
+func newProvisioningTest(lifecycle context.Context) (*deploymentService, *unreachableRepo) {
+	r := &unreachableRepo{cloned: make(chan struct{}, 1)}
+	return &deploymentService{repo: r, provisioning: newProvisioningRuns(lifecycle)}, r
+}
+
+// waitForStop waits for every provisioning run of ds to return.
+func waitForStop(t *testing.T, ds *deploymentService) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		ds.provisioning.mu.Lock()
+		running := len(ds.provisioning.runs)
+		ds.provisioning.mu.Unlock()
+		if running == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected provisioning to stop instead of waiting to retry")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDeleteStopsProvisioningBackoff(t *testing.T) {
+	ds, r := newProvisioningTest(context.Background())
+	ds.startProvisioning(context.Background(), "service")
+	<-r.cloned
+
+	if _, err := ds.Delete(context.Background(), "service", model.DeleteOptions{Mode: model.DeleteModeRetain}); err != nil {
+		t.Fatal(err)
+	}
+	// The first retry is provisionBackoff away, so only a cancelled wait
+	// returns this soon
+	waitForStop(t, ds)
+	if got := r.attempts.Load(); got != 1 {
+		t.Fatalf("expected no retry after the delete, got %d attempts", got)
+	}
+}
+
+func TestShutdownStopsProvisioningBackoff(t *testing.T) {
+	lifecycle, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+	ds, r := newProvisioningTest(lifecycle)
+	ds.startProvisioning(context.Background(), "service")
+	<-r.cloned
+
+	shutdown()
+	waitForStop(t, ds)
+	if got := r.attempts.Load(); got != 1 {
+		t.Fatalf("expected no retry after shutdown, got %d attempts", got)
+	}
+}
+
+func TestStartProvisioningOutlivesRequest(t *testing.T) {
+	ds, r := newProvisioningTest(context.Background())
+	request, cancel := context.WithCancel(context.Background())
+	ds.startProvisioning(request, "service")
+	<-r.cloned
+	cancel()
+
+	time.Sleep(20 * time.Millisecond)
+	ds.provisioning.mu.Lock()
+	running := len(ds.provisioning.runs)
+	ds.provisioning.mu.Unlock()
+	if running != 1 {
+		t.Fatal("expected provisioning to keep going after the request that started it returned")
+	}
+	ds.provisioning.stop("service")
+	waitForStop(t, ds)
+}
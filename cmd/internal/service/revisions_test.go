@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/apierr"
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/repo"
+)
+
+// updateToken updates the service's env file token, returning the version
+// of its new revision.
+func updateToken(t *testing.T, ds *deploymentService, name string, version string, token string) string {
+	t.Helper()
+	updated, err := ds.Update(context.Background(), name, version, &model.Service{
+		Configuration: model.ServiceConfiguration{DockerCompose: &model.DockerComposeConfiguration{
+			EnvFiles: map[string]model.EnvVars{".env": {"TOKEN": token}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return updated.Version
+}
+
+func TestDiffRevisions(t *testing.T) {
+	client := repo.NewInMemoryGitClient()
+	ds := newImportTest(t, client)
+	service := newComposeService("alpha", newRemote(t, client, "alpha"), "first")
+	create(t, ds, service)
+	first := service.Version
+	second := updateToken(t, ds, "alpha", first, "second")
+	third := updateToken(t, ds, "alpha", second, "third")
+	path := "configuration.dockerCompose.envFiles..env.TOKEN"
+
+	for _, tt := range []struct {
+		name            string
+		revision        string
+		against         string
+		expectedAgainst string
+		expected        []model.RevisionChange
+	}{
+		{
+			name:            "against the previous revision",
+			revision:        third,
+			expectedAgainst: second,
+			expected:        []model.RevisionChange{{Op: model.RevisionChangeChanged, Path: path, From: "second", To: "third"}},
+		},
+		{
+			name:            "against an older revision",
+			revision:        third,
+			against:         first,
+			expectedAgainst: first,
+			expected:        []model.RevisionChange{{Op: model.RevisionChangeChanged, Path: path, From: "first", To: "third"}},
+		},
+		{
+			name:            "against a newer revision",
+			revision:        first,
+			against:         third,
+			expectedAgainst: third,
+			expected:        []model.RevisionChange{{Op: model.RevisionChangeChanged, Path: path, From: "third", To: "first"}},
+		},
+		{
+			name:            "against itself",
+			revision:        second,
+			against:         second,
+			expectedAgainst: second,
+			expected:        []model.RevisionChange{},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			against, changes, err := ds.DiffRevisions(context.Background(), "alpha", tt.revision, tt.against)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if against.ID != tt.expectedAgainst {
+				t.Fatalf("expected to compare against %s, got %s", tt.expectedAgainst, against.ID)
+			}
+			if !reflect.DeepEqual(changes, tt.expected) {
+				t.Fatalf("expected changes %+v, got %+v", tt.expected, changes)
+			}
+		})
+	}
+
+	// The first revision has nothing before it to compare against
+	if _, _, err := ds.DiffRevisions(context.Background(), "alpha", first, ""); !apierr.HasCode(err, apierr.CodeInvalidArgument) {
+		t.Fatalf("expected %s, got %v", apierr.CodeInvalidArgument, err)
+	}
+	if _, _, err := ds.DiffRevisions(context.Background(), "alpha", third, "unknown"); err == nil {
+		t.Fatal("expected an error for an unknown revision")
+	}
+}
@@ -0,0 +1,296 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+)
+
+// Format is the kind of key used to sign commits and tags.
+type Format string
+
+const (
+	FormatOpenPGP Format = "openpgp"
+	FormatSSH     Format = "ssh"
+)
+
+const (
+	sshSigNamespace = "git"
+	sshSigHashAlg   = "sha512"
+	sshSigMagic     = "SSHSIG"
+	sshSigVersion   = 1
+	sshSigArmorType = "SSH SIGNATURE"
+	// ssh-keygen wraps armored signatures at 70 columns
+	sshSigLineLength = 70
+)
+
+// Signer signs git commit and tag objects. It satisfies go-git's git.Signer,
+// so it can be handed directly to git.CommitOptions.
+type Signer interface {
+	git.Signer
+	Format() Format
+	// ExportPrivateKey returns the decrypted private key in armored form, for
+	// handing to build steps that sign with the git CLI.
+	ExportPrivateKey() ([]byte, error)
+}
+
+type Config struct {
+	Format     Format
+	KeyPath    string
+	Passphrase string
+}
+
+// NewSigner loads the signing key from disk. Keys protected by a passphrase
+// are decrypted once at startup.
+func NewSigner(config Config) (Signer, error) {
+	if config.KeyPath == "" {
+		return nil, fmt.Errorf("keyPath not provided")
+	}
+	keyBytes, err := os.ReadFile(config.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+
+	switch config.Format {
+	case FormatOpenPGP:
+		return newOpenPGPSigner(keyBytes, config.Passphrase)
+	case FormatSSH:
+		return newSSHSigner(keyBytes, config.Passphrase)
+	default:
+		return nil, fmt.Errorf("unsupported signing format: %q", config.Format)
+	}
+}
+
+type openPGPSigner struct {
+	entity *openpgp.Entity
+}
+
+func newOpenPGPSigner(keyBytes []byte, passphrase string) (Signer, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(keyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read openpgp key: %w", err)
+	}
+	if len(entities) == 0 {
+		return nil, fmt.Errorf("no openpgp key found")
+	}
+	entity := entities[0]
+	if entity.PrivateKey == nil {
+		return nil, fmt.Errorf("openpgp key has no private key")
+	}
+
+	if entity.PrivateKey.Encrypted {
+		if passphrase == "" {
+			return nil, fmt.Errorf("openpgp key is encrypted but no passphrase was provided")
+		}
+		if err := entity.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("failed to decrypt openpgp key: %w", err)
+		}
+	}
+	return &openPGPSigner{entity: entity}, nil
+}
+
+func (s *openPGPSigner) Format() Format {
+	return FormatOpenPGP
+}
+
+func (s *openPGPSigner) Sign(message io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&buf, s.entity, message, nil); err != nil {
+		return nil, fmt.Errorf("failed to sign with openpgp key: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *openPGPSigner) ExportPrivateKey() ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.entity.SerializePrivateWithoutSigning(w, nil); err != nil {
+		return nil, fmt.Errorf("failed to export openpgp key: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type sshSigner struct {
+	rawKey any
+	signer ssh.Signer
+}
+
+func newSSHSigner(keyBytes []byte, passphrase string) (Signer, error) {
+	var (
+		rawKey any
+		err    error
+	)
+	if passphrase != "" {
+		rawKey, err = ssh.ParseRawPrivateKeyWithPassphrase(keyBytes, []byte(passphrase))
+	} else {
+		rawKey, err = ssh.ParseRawPrivateKey(keyBytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ssh signing key: %w", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(rawKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ssh signer: %w", err)
+	}
+	return &sshSigner{rawKey: rawKey, signer: signer}, nil
+}
+
+func (s *sshSigner) Format() Format {
+	return FormatSSH
+}
+
+// Sign produces an armored SSHSIG signature in the format `git` expects when
+// `gpg.format` is `ssh`. See PROTOCOL.sshsig in the OpenSSH source.
+func (s *sshSigner) Sign(message io.Reader) ([]byte, error) {
+	h := sha512.New()
+	if _, err := io.Copy(h, message); err != nil {
+		return nil, err
+	}
+
+	signedData := ssh.Marshal(struct {
+		Namespace string
+		Reserved  string
+		HashAlg   string
+		Hash      string
+	}{
+		Namespace: sshSigNamespace,
+		HashAlg:   sshSigHashAlg,
+		Hash:      string(h.Sum(nil)),
+	})
+	signedData = append([]byte(sshSigMagic), signedData...)
+
+	var (
+		sig *ssh.Signature
+		err error
+	)
+	// RSA keys must not use the legacy SHA-1 "ssh-rsa" algorithm.
+	if algSigner, ok := s.signer.(ssh.AlgorithmSigner); ok && s.signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = algSigner.SignWithAlgorithm(rand.Reader, signedData, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = s.signer.Sign(rand.Reader, signedData)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign with ssh key: %w", err)
+	}
+
+	blob := ssh.Marshal(struct {
+		Version   uint32
+		PublicKey string
+		Namespace string
+		Reserved  string
+		HashAlg   string
+		Signature string
+	}{
+		Version:   sshSigVersion,
+		PublicKey: string(s.signer.PublicKey().Marshal()),
+		Namespace: sshSigNamespace,
+		HashAlg:   sshSigHashAlg,
+		Signature: string(ssh.Marshal(sig)),
+	})
+	blob = append([]byte(sshSigMagic), blob...)
+
+	return armorSSHSignature(blob), nil
+}
+
+func (s *sshSigner) ExportPrivateKey() ([]byte, error) {
+	block, err := ssh.MarshalPrivateKey(s.rawKey, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to export ssh signing key: %w", err)
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+func armorSSHSignature(blob []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("-----BEGIN %s-----\n", sshSigArmorType))
+	for len(encoded) > sshSigLineLength {
+		sb.WriteString(encoded[:sshSigLineLength])
+		sb.WriteString("\n")
+		encoded = encoded[sshSigLineLength:]
+	}
+	sb.WriteString(encoded)
+	sb.WriteString(fmt.Sprintf("\n-----END %s-----\n", sshSigArmorType))
+	return []byte(sb.String())
+}
+
+// CreateSignedTag creates an annotated tag signed with signer. go-git's
+// CreateTagOptions only accepts an OpenPGP entity, so the tag object is built
+// by hand to support SSH keys as well.
+func CreateSignedTag(
+	repo *git.Repository,
+	name string,
+	hash plumbing.Hash,
+	tagger *object.Signature,
+	message string,
+	signer git.Signer,
+) (*plumbing.Reference, error) {
+	tagRefName := plumbing.NewTagReferenceName(name)
+	if _, err := repo.Reference(tagRefName, false); err == nil {
+		return nil, git.ErrTagExists
+	} else if err != plumbing.ErrReferenceNotFound {
+		return nil, err
+	}
+
+	target, err := repo.Storer.EncodedObject(plumbing.AnyObject, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve tag target: %w", err)
+	}
+
+	tag := &object.Tag{
+		Name:       name,
+		Tagger:     *tagger,
+		Message:    strings.TrimSpace(message) + "\n",
+		TargetType: target.Type(),
+		Target:     hash,
+	}
+
+	unsigned := &plumbing.MemoryObject{}
+	if err := tag.EncodeWithoutSignature(unsigned); err != nil {
+		return nil, err
+	}
+	reader, err := unsigned.Reader()
+	if err != nil {
+		return nil, err
+	}
+	signature, err := signer.Sign(reader)
+	if err != nil {
+		return nil, err
+	}
+	tag.PGPSignature = string(signature)
+
+	obj := repo.Storer.NewEncodedObject()
+	if err := tag.Encode(obj); err != nil {
+		return nil, err
+	}
+	tagHash, err := repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := plumbing.NewHashReference(tagRefName, tagHash)
+	if err := repo.Storer.SetReference(ref); err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
@@ -0,0 +1,464 @@
+package signing
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+)
+
+const testSignerIdentity = "ci@example.com"
+
+func TestSSHSignedCommitVerifies(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        func(t *testing.T) crypto.Signer
+		passphrase string
+	}{
+		{name: "ed25519", key: newEd25519Key},
+		{name: "rsa", key: newRSAKey},
+		{name: "ecdsa", key: newECDSAKey},
+		{name: "encrypted ed25519", key: newEd25519Key, passphrase: "correct horse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.key(t)
+			signer := newTestSSHSigner(t, key, tt.passphrase)
+			pub, err := ssh.NewPublicKey(key.Public())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dir, repo := initRepo(t)
+			hash := commitFile(t, repo, signer)
+			commit, err := repo.CommitObject(hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			message := encodeWithoutSignature(t, commit)
+
+			verifySSHSig(t, pub, message, []byte(commit.PGPSignature))
+			sshKeygenVerify(t, pub, message, []byte(commit.PGPSignature))
+			gitVerify(t, dir, pub, "verify-commit", hash.String())
+		})
+	}
+}
+
+func TestSSHSignedTagVerifies(t *testing.T) {
+	key := newEd25519Key(t)
+	signer := newTestSSHSigner(t, key, "")
+	pub, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, repo := initRepo(t)
+	hash := commitFile(t, repo, signer)
+	ref, err := CreateSignedTag(repo, "v1.0.0", hash, testSignature(), "v1.0.0", signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := repo.TagObject(ref.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := &plumbing.MemoryObject{}
+	if err := tag.EncodeWithoutSignature(unsigned); err != nil {
+		t.Fatal(err)
+	}
+	message := readObject(t, unsigned)
+
+	verifySSHSig(t, pub, message, []byte(tag.PGPSignature))
+	sshKeygenVerify(t, pub, message, []byte(tag.PGPSignature))
+	gitVerify(t, dir, pub, "verify-tag", "v1.0.0")
+
+	if _, err := CreateSignedTag(repo, "v1.0.0", hash, testSignature(), "v1.0.0", signer); err != git.ErrTagExists {
+		t.Fatalf("expected ErrTagExists creating the tag again, got %v", err)
+	}
+}
+
+func TestSSHSignatureRejectsOtherMessage(t *testing.T) {
+	key := newEd25519Key(t)
+	signer := newTestSSHSigner(t, key, "")
+	pub, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := signer.Sign(strings.NewReader("signed message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSSHSig(pub, []byte("other message"), signature); err == nil {
+		t.Fatal("signature verified against a message it wasn't made for")
+	}
+}
+
+func TestOpenPGPSignedCommitVerifies(t *testing.T) {
+	for _, passphrase := range []string{"", "correct horse"} {
+		name := "unencrypted"
+		if passphrase != "" {
+			name = "encrypted"
+		}
+		t.Run(name, func(t *testing.T) {
+			entity, err := openpgp.NewEntity("CI", "", testSignerIdentity, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			publicKey := armoredPublicKey(t, entity)
+			if passphrase != "" {
+				if err := entity.EncryptPrivateKeys([]byte(passphrase), nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			signer, err := NewSigner(Config{
+				Format:     FormatOpenPGP,
+				KeyPath:    writeKey(t, armoredPrivateKey(t, entity)),
+				Passphrase: passphrase,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dir, repo := initRepo(t)
+			hash := commitFile(t, repo, signer)
+			commit, err := repo.CommitObject(hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := commit.Verify(publicKey); err != nil {
+				t.Fatalf("commit signature doesn't verify: %v", err)
+			}
+			gpgVerifyCommit(t, dir, publicKey, hash.String())
+		})
+	}
+}
+
+func TestNewSignerRejectsWrongPassphrase(t *testing.T) {
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(newEd25519Key(t), "", []byte("right"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewSigner(Config{Format: FormatSSH, KeyPath: writeKey(t, pem.EncodeToMemory(block)), Passphrase: "wrong"})
+	if err == nil {
+		t.Fatal("expected an error decrypting with the wrong passphrase")
+	}
+}
+
+func newEd25519Key(t *testing.T) crypto.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newRSAKey(t *testing.T) crypto.Signer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newECDSAKey(t *testing.T) crypto.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newTestSSHSigner(t *testing.T, key crypto.Signer, passphrase string) Signer {
+	t.Helper()
+	var (
+		block *pem.Block
+		err   error
+	)
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(key, "")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewSigner(Config{Format: FormatSSH, KeyPath: writeKey(t, pem.EncodeToMemory(block)), Passphrase: passphrase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func writeKey(t *testing.T, key []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "signing_key")
+	if err := os.WriteFile(path, key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func armoredPrivateKey(t *testing.T, entity *openpgp.Entity) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.SerializePrivateWithoutSigning(w, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func armoredPublicKey(t *testing.T, entity *openpgp.Entity) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func testSignature() *object.Signature {
+	return &object.Signature{Name: "CI", Email: testSignerIdentity, When: time.Now()}
+}
+
+func initRepo(t *testing.T) (string, *git.Repository) {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, repo
+}
+
+func commitFile(t *testing.T, repo *git.Repository, signer Signer) plumbing.Hash {
+	t.Helper()
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(worktree.Filesystem.Root(), "VERSION"), []byte("1.0.0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := worktree.Add("VERSION"); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := worktree.Commit("chore: release 1.0.0", &git.CommitOptions{
+		Author: testSignature(),
+		Signer: signer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func encodeWithoutSignature(t *testing.T, commit *object.Commit) []byte {
+	t.Helper()
+	unsigned := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(unsigned); err != nil {
+		t.Fatal(err)
+	}
+	return readObject(t, unsigned)
+}
+
+func readObject(t *testing.T, obj *plumbing.MemoryObject) []byte {
+	t.Helper()
+	reader, err := obj.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(reader); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// verifySSHSig checks signature the way ssh-keygen -Y verify does, following
+// PROTOCOL.sshsig, so the format is covered without the OpenSSH tools.
+func verifySSHSig(t *testing.T, pub ssh.PublicKey, message []byte, armored []byte) {
+	t.Helper()
+	if err := checkSSHSig(pub, message, armored); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkSSHSig(pub ssh.PublicKey, message []byte, armored []byte) error {
+	text := strings.TrimSpace(string(armored))
+	begin, end := "-----BEGIN SSH SIGNATURE-----", "-----END SSH SIGNATURE-----"
+	if !strings.HasPrefix(text, begin) || !strings.HasSuffix(text, end) {
+		return fmt.Errorf("signature isn't armored as an SSH SIGNATURE: %q", text)
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if len(line) > sshSigLineLength {
+			return fmt.Errorf("armored line longer than %d columns", sshSigLineLength)
+		}
+	}
+	blob, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(text[len(begin):len(text)-len(end)], "\n", ""))
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(blob, []byte(sshSigMagic)) {
+		return fmt.Errorf("signature doesn't start with %s", sshSigMagic)
+	}
+	var sig struct {
+		Version   uint32
+		PublicKey string
+		Namespace string
+		Reserved  string
+		HashAlg   string
+		Signature string
+	}
+	if err := ssh.Unmarshal(blob[len(sshSigMagic):], &sig); err != nil {
+		return err
+	}
+	if sig.Version != sshSigVersion || sig.Namespace != sshSigNamespace || sig.HashAlg != sshSigHashAlg {
+		return fmt.Errorf("unexpected signature header: version %d, namespace %q, hash %q", sig.Version, sig.Namespace, sig.HashAlg)
+	}
+	if !bytes.Equal([]byte(sig.PublicKey), pub.Marshal()) {
+		return fmt.Errorf("signature names a different public key")
+	}
+	var signature ssh.Signature
+	if err := ssh.Unmarshal([]byte(sig.Signature), &signature); err != nil {
+		return err
+	}
+	if pub.Type() == ssh.KeyAlgoRSA && signature.Format != ssh.KeyAlgoRSASHA512 {
+		return fmt.Errorf("rsa signature uses %s, want %s", signature.Format, ssh.KeyAlgoRSASHA512)
+	}
+
+	h := sha512.Sum512(message)
+	signedData := append([]byte(sshSigMagic), ssh.Marshal(struct {
+		Namespace string
+		Reserved  string
+		HashAlg   string
+		Hash      string
+	}{
+		Namespace: sshSigNamespace,
+		HashAlg:   sshSigHashAlg,
+		Hash:      string(h[:]),
+	})...)
+	return pub.Verify(signedData, &signature)
+}
+
+// sshKeygenVerify checks signature with ssh-keygen -Y verify, when it's
+// installed.
+func sshKeygenVerify(t *testing.T, pub ssh.PublicKey, message []byte, signature []byte) {
+	t.Helper()
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Log("ssh-keygen not installed, skipping ssh-keygen -Y verify")
+		return
+	}
+	dir := t.TempDir()
+	allowedSigners := writeAllowedSigners(t, dir, pub)
+	sigPath := filepath.Join(dir, "message.sig")
+	if err := os.WriteFile(sigPath, signature, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("ssh-keygen", "-Y", "verify",
+		"-f", allowedSigners, "-I", testSignerIdentity, "-n", sshSigNamespace, "-s", sigPath)
+	cmd.Stdin = bytes.NewReader(message)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen -Y verify failed: %v\n%s", err, out)
+	}
+}
+
+// gitVerify runs git verify-commit or verify-tag on an SSH signed object,
+// when git is installed.
+func gitVerify(t *testing.T, dir string, pub ssh.PublicKey, verb string, rev string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Logf("git not installed, skipping git %s", verb)
+		return
+	}
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Logf("ssh-keygen not installed, skipping git %s", verb)
+		return
+	}
+	allowedSigners := writeAllowedSigners(t, t.TempDir(), pub)
+	cmd := exec.Command("git", "-c", "gpg.format=ssh", "-c", "gpg.ssh.allowedSignersFile="+allowedSigners, verb, rev)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s failed: %v\n%s", verb, err, out)
+	}
+}
+
+// gpgVerifyCommit runs git verify-commit on an OpenPGP signed commit against
+// a keyring holding only publicKey, when git and gpg are installed.
+func gpgVerifyCommit(t *testing.T, dir string, publicKey string, rev string) {
+	t.Helper()
+	for _, bin := range []string{"git", "gpg"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Logf("%s not installed, skipping git verify-commit", bin)
+			return
+		}
+	}
+	// gpg-agent's socket path must be short, so the home can't be nested deep
+	// in t.TempDir()
+	gnupgHome, err := os.MkdirTemp("", "gnupg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = exec.Command("gpgconf", "--homedir", gnupgHome, "--kill", "all").Run()
+		_ = os.RemoveAll(gnupgHome)
+	})
+	env := append(os.Environ(), "GNUPGHOME="+gnupgHome, "GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1")
+
+	importKey := exec.Command("gpg", "--batch", "--import")
+	importKey.Stdin = strings.NewReader(publicKey)
+	importKey.Env = env
+	if out, err := importKey.CombinedOutput(); err != nil {
+		t.Fatalf("failed to import public key: %v\n%s", err, out)
+	}
+	cmd := exec.Command("git", "verify-commit", rev)
+	cmd.Dir = dir
+	cmd.Env = env
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git verify-commit failed: %v\n%s", err, out)
+	}
+}
+
+func writeAllowedSigners(t *testing.T, dir string, pub ssh.PublicKey) string {
+	t.Helper()
+	path := filepath.Join(dir, "allowed_signers")
+	line := testSignerIdentity + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))) + "\n"
+	if err := os.WriteFile(path, []byte(line), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
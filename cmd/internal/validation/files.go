@@ -0,0 +1,165 @@
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/securepath"
+	"github.com/getkin/kin-openapi/openapi3"
+	yaml "github.com/oasdiff/yaml3"
+)
+
+const (
+	packageJSONFile = "package.json"
+	versionFile     = "version.txt"
+	goModFile       = "go.mod"
+	goSumFile       = "go.sum"
+	dockerfile      = "Dockerfile"
+)
+
+// The file names docker compose looks for, in its order of preference
+var composeFiles = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+// checkFiles checks that the clone at dir has the files the processor for
+// configuration reads or builds from.
+func checkFiles(ctx context.Context, dir string, configuration model.ServiceConfiguration) []Problem {
+	switch {
+	case configuration.Npm != nil:
+		return checkPackageJSON(dir)
+	case configuration.OpenAPI != nil && configuration.OpenAPI.OpenAPI != nil:
+		return checkOpenAPISpec(ctx, dir, configuration.OpenAPI.OpenAPI.YamlFile)
+	case configuration.Go != nil:
+		return checkGo(dir, configuration.Go)
+	case configuration.DockerCompose != nil:
+		return checkComposeFile(dir)
+	case configuration.DockerBuild != nil:
+		path := configuration.DockerBuild.DockerfilePath
+		if path == "" {
+			path = dockerfile
+		}
+		if _, problem := readFile(dir, path); problem != nil {
+			return []Problem{*problem}
+		}
+	}
+	return nil
+}
+
+// readFile reads the file at path in the clone at dir, reporting it missing
+// or outside of the repo.
+func readFile(dir string, path string) ([]byte, *Problem) {
+	fullPath, err := securepath.Join(dir, path)
+	if err != nil {
+		return nil, &Problem{Code: ProblemInvalidFile, Path: path, Message: fmt.Sprintf("%s is outside of the repo", path)}
+	}
+	data, err := os.ReadFile(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &Problem{Code: ProblemMissingFile, Path: path, Message: fmt.Sprintf("%s not found", path)}
+		}
+		return nil, &Problem{Code: ProblemInvalidFile, Path: path, Message: fmt.Sprintf("failed to read %s: %s", path, err)}
+	}
+	return data, nil
+}
+
+func checkPackageJSON(dir string) []Problem {
+	data, problem := readFile(dir, packageJSONFile)
+	if problem != nil {
+		return []Problem{*problem}
+	}
+	var packageJSON map[string]any
+	if err := json.Unmarshal(data, &packageJSON); err != nil {
+		return []Problem{{Code: ProblemInvalidFile, Path: packageJSONFile, Message: fmt.Sprintf("%s isn't a JSON object: %s", packageJSONFile, err)}}
+	}
+	return nil
+}
+
+// checkOpenAPISpec checks that the spec is valid and has the info.version the
+// release sets. External $refs aren't followed, since only the spec itself is
+// copied into the client builds.
+func checkOpenAPISpec(ctx context.Context, dir string, path string) []Problem {
+	data, problem := readFile(dir, path)
+	if problem != nil {
+		return []Problem{*problem}
+	}
+	doc, err := openapi3.NewLoader().LoadFromData(data)
+	if err != nil {
+		return []Problem{{Code: ProblemInvalidFile, Path: path, Message: fmt.Sprintf("failed to parse %s: %s", path, err)}}
+	}
+	if err := doc.Validate(ctx); err != nil {
+		return []Problem{{Code: ProblemInvalidFile, Path: path, Message: fmt.Sprintf("%s isn't a valid OpenAPI spec: %s", path, err)}}
+	}
+	if doc.Info == nil || doc.Info.Version == "" {
+		return []Problem{{Code: ProblemInvalidFile, Path: path, Message: fmt.Sprintf("%s has no info.version", path)}}
+	}
+	return nil
+}
+
+func checkGo(dir string, configuration *model.GoConfiguration) []Problem {
+	var problems []Problem
+	// The release writes the version to the first version.txt found
+	found := false
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return fs.SkipDir
+		}
+		if !d.IsDir() && d.Name() == versionFile {
+			found = true
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if !found {
+		problems = append(problems, Problem{Code: ProblemMissingFile, Path: versionFile, Message: fmt.Sprintf("%s not found", versionFile)})
+	}
+	if configuration.Service == nil {
+		return problems
+	}
+
+	// The generated Dockerfile downloads modules before copying the rest
+	for _, path := range []string{goModFile, goSumFile} {
+		if _, problem := readFile(dir, path); problem != nil {
+			problems = append(problems, *problem)
+		}
+	}
+	if binaryDirectory := configuration.Service.BinaryDirectory; binaryDirectory != "" {
+		fullPath, err := securepath.Join(dir, binaryDirectory)
+		if err != nil {
+			problems = append(problems, Problem{Code: ProblemInvalidFile, Path: binaryDirectory, Message: fmt.Sprintf("%s is outside of the repo", binaryDirectory)})
+		} else if info, err := os.Stat(fullPath); err != nil || !info.IsDir() {
+			problems = append(problems, Problem{Code: ProblemMissingFile, Path: binaryDirectory, Message: fmt.Sprintf("directory %s not found", binaryDirectory)})
+		}
+	}
+	return problems
+}
+
+func checkComposeFile(dir string) []Problem {
+	for _, path := range composeFiles {
+		data, problem := readFile(dir, path)
+		if problem != nil {
+			if problem.Code == ProblemMissingFile {
+				continue
+			}
+			return []Problem{*problem}
+		}
+		var composeFile struct {
+			Services map[string]any `yaml:"services"`
+		}
+		if err := yaml.Unmarshal(data, &composeFile); err != nil {
+			return []Problem{{Code: ProblemInvalidFile, Path: path, Message: fmt.Sprintf("failed to parse %s: %s", path, err)}}
+		}
+		if len(composeFile.Services) == 0 {
+			return []Problem{{Code: ProblemInvalidFile, Path: path, Message: fmt.Sprintf("%s defines no services", path)}}
+		}
+		return nil
+	}
+	return []Problem{{Code: ProblemMissingFile, Path: composeFiles[0], Message: fmt.Sprintf("no compose file found, expected one of %s", strings.Join(composeFiles, ", "))}}
+}
@@ -0,0 +1,232 @@
+package validation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/credentials"
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/repo"
+	"github.com/ansonallard/deployment-service/cmd/internal/version"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("deployment-service.validation")
+
+const (
+	// cloneDepth is how many commits Validate clones at first. The clone is
+	// deepened cloneDepthGrowth times over until its history reaches the last
+	// release tag, up to maxCloneDepth commits.
+	cloneDepth       = 50
+	cloneDepthGrowth = 10
+	maxCloneDepth    = 5000
+)
+
+type ProblemCode string
+
+const (
+	ProblemInvalidCredential ProblemCode = "invalidCredential"
+	ProblemCloneFailed       ProblemCode = "cloneFailed"
+	// ProblemBranchNotFound is also reported for an empty repository
+	ProblemBranchNotFound       ProblemCode = "branchNotFound"
+	ProblemMissingFile          ProblemCode = "missingFile"
+	ProblemInvalidFile          ProblemCode = "invalidFile"
+	ProblemUnconventionalCommit ProblemCode = "unconventionalCommit"
+)
+
+// Problem is something in the repo that would fail the service's releases.
+type Problem struct {
+	Code ProblemCode `json:"code"`
+	// Path is the file in the repo the problem is with
+	Path string `json:"path,omitempty"`
+	// Commit is the commit the problem is with
+	Commit  string `json:"commit,omitempty"`
+	Message string `json:"message"`
+}
+
+type Report struct {
+	Valid bool `json:"valid"`
+	// Commit is the head of the branch that was checked
+	Commit   string    `json:"commit,omitempty"`
+	Problems []Problem `json:"problems"`
+}
+
+func (r *Report) add(problem Problem) {
+	r.Problems = append(r.Problems, problem)
+	r.Valid = false
+}
+
+// Summary joins the messages of every problem, for an error.
+func (r *Report) Summary() string {
+	messages := make([]string, 0, len(r.Problems))
+	for _, problem := range r.Problems {
+		messages = append(messages, problem.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+type Validator interface {
+	// Validate clones service's branch to a temporary directory and checks
+	// that it has what releasing the service needs. Problems with the repo
+	// are reported in the Report; an error means the check itself failed.
+	Validate(ctx context.Context, service *model.Service) (*Report, error)
+	// CheckClone is Validate for a clone of service's branch already at dir.
+	CheckClone(ctx context.Context, service *model.Service, dir string) (*Report, error)
+}
+
+type Config struct {
+	GitClient   repo.GitClient
+	Credentials credentials.Store
+	Versioner   version.Versioner
+}
+
+type validator struct {
+	gitClient   repo.GitClient
+	credentials credentials.Store
+	versioner   version.Versioner
+}
+
+func NewValidator(config Config) (Validator, error) {
+	if config.GitClient == nil {
+		return nil, fmt.Errorf("gitClient not provided")
+	}
+	if config.Credentials == nil {
+		return nil, fmt.Errorf("credentials not provided")
+	}
+	if config.Versioner == nil {
+		return nil, fmt.Errorf("versioner not provided")
+	}
+	return &validator{
+		gitClient:   config.GitClient,
+		credentials: config.Credentials,
+		versioner:   config.Versioner,
+	}, nil
+}
+
+func (v *validator) Validate(ctx context.Context, service *model.Service) (*Report, error) {
+	ctx, span := tracer.Start(ctx, "validation.validate",
+		trace.WithAttributes(
+			attribute.String("service.name", service.Name.Name),
+			attribute.String("git.url", service.GitSSHUrl),
+			attribute.String("git.branch", service.GitBranchName),
+		),
+	)
+	defer span.End()
+
+	report := &Report{Valid: true, Problems: []Problem{}}
+	defer func() { span.SetAttributes(attribute.Int("validation.problems", len(report.Problems))) }()
+
+	gitAuth, err := v.credentials.AuthMethod(service.GitCredential, service.GitSSHUrl)
+	if err != nil {
+		report.add(Problem{Code: ProblemInvalidCredential, Message: err.Error()})
+		return report, nil
+	}
+
+	dir, err := os.MkdirTemp("", "deployment-service-validate-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Shallow, and deepened by the commit check until it reaches the last tag
+	gitRepo, err := v.gitClient.Clone(ctx, dir, &git.CloneOptions{
+		URL:           service.GitSSHUrl,
+		ReferenceName: plumbing.ReferenceName(service.GitBranchName),
+		SingleBranch:  true,
+		Depth:         cloneDepth,
+		Auth:          gitAuth,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, git.NoMatchingRefSpecError{}):
+			report.add(Problem{Code: ProblemBranchNotFound, Message: fmt.Sprintf("branch %s not found", service.GitBranchName)})
+		case errors.Is(err, transport.ErrEmptyRemoteRepository):
+			report.add(Problem{Code: ProblemBranchNotFound, Message: "repository is empty"})
+		default:
+			report.add(Problem{Code: ProblemCloneFailed, Message: fmt.Sprintf("failed to clone repo: %s", err)})
+		}
+		return report, nil
+	}
+	deepen := func(depth int) error {
+		return gitRepo.Fetch(ctx, &git.FetchOptions{
+			RemoteName: git.DefaultRemoteName,
+			Depth:      depth,
+			Tags:       git.TagFollowing,
+			Auth:       gitAuth,
+		})
+	}
+	return v.checkClone(ctx, service, gitRepo, dir, deepen, report)
+}
+
+func (v *validator) CheckClone(ctx context.Context, service *model.Service, dir string) (*Report, error) {
+	ctx, span := tracer.Start(ctx, "validation.check_clone",
+		trace.WithAttributes(attribute.String("service.name", service.Name.Name)),
+	)
+	defer span.End()
+
+	report := &Report{Valid: true, Problems: []Problem{}}
+	defer func() { span.SetAttributes(attribute.Int("validation.problems", len(report.Problems))) }()
+
+	gitRepo, err := v.gitClient.Open(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	return v.checkClone(ctx, service, gitRepo, dir, nil, report)
+}
+
+// checkClone adds the problems with the clone at dir to report. deepen
+// fetches history back to depth commits when the clone is shallow, and is nil
+// otherwise.
+func (v *validator) checkClone(ctx context.Context, service *model.Service, gitRepo repo.Repository, dir string, deepen func(depth int) error, report *Report) (*Report, error) {
+	head, err := gitRepo.Head(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get HEAD: %w", err)
+	}
+	report.Commit = head.Hash().String()
+
+	for _, problem := range checkFiles(ctx, dir, service.Configuration) {
+		report.add(problem)
+	}
+
+	commits, err := v.unconventionalCommits(ctx, dir, deepen)
+	if err != nil {
+		return nil, err
+	}
+	for _, commit := range commits {
+		report.add(Problem{
+			Code:    ProblemUnconventionalCommit,
+			Commit:  commit.Hash.String(),
+			Message: fmt.Sprintf("commit %s %q isn't a conventional commit", commit.Hash.String()[:7], strings.Split(commit.Message, "\n")[0]),
+		})
+	}
+	return report, nil
+}
+
+// unconventionalCommits returns the unconventional commits since the last
+// release tag, deepening a shallow clone until its history reaches the tag.
+// Past maxCloneDepth commits, only the commits fetched are checked.
+func (v *validator) unconventionalCommits(ctx context.Context, dir string, deepen func(depth int) error) ([]*object.Commit, error) {
+	for depth := cloneDepth; ; depth *= cloneDepthGrowth {
+		commits, err := v.versioner.UnconventionalCommits(ctx, dir)
+		if !errors.Is(err, version.ErrIncompleteHistory) {
+			return commits, err
+		}
+		if deepen == nil || depth >= maxCloneDepth {
+			zerolog.Ctx(ctx).Warn().Int("depth", depth).Msg("Checked commits without reaching the last release tag")
+			return commits, nil
+		}
+		if err := deepen(depth * cloneDepthGrowth); err != nil {
+			return nil, fmt.Errorf("failed to fetch more history: %w", err)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package validation
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/model"
+	"github.com/ansonallard/deployment-service/cmd/internal/repo"
+	"github.com/ansonallard/deployment-service/cmd/internal/version"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+type noCredentials struct{}
+
+func (noCredentials) AuthMethod(credentialName, gitURL string) (transport.AuthMethod, error) {
+	return nil, nil
+}
+
+// depthRecordingClient records the depth of every clone and fetch.
+type depthRecordingClient struct {
+	repo.GitClient
+	depths []int
+}
+
+func (c *depthRecordingClient) Clone(ctx context.Context, path string, opts *git.CloneOptions) (repo.Repository, error) {
+	c.depths = append(c.depths, opts.Depth)
+	r, err := c.GitClient.Clone(ctx, path, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &depthRecordingRepository{Repository: r, client: c}, nil
+}
+
+type depthRecordingRepository struct {
+	repo.Repository
+	client *depthRecordingClient
+}
+
+func (r *depthRecordingRepository) Fetch(ctx context.Context, opts *git.FetchOptions) error {
+	r.client.depths = append(r.client.depths, opts.Depth)
+	return r.Repository.Fetch(ctx, opts)
+}
+
+// newRemote creates a repo on disk with released commits, the last tagged
+// 1.0.0, followed by unreleased ones, and returns its file:// URL. Cloning it
+// runs git-upload-pack, which unlike go-git's server supports shallow clones.
+func newRemote(t *testing.T, released []string, unreleased []string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "version.txt"), []byte("0.0.0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("version.txt"); err != nil {
+		t.Fatal(err)
+	}
+	signature := &object.Signature{Name: "CI", Email: "ci@example.com", When: time.Now()}
+	commit := func(message string) plumbing.Hash {
+		hash, err := wt.Commit(message, &git.CommitOptions{Author: signature, AllowEmptyCommits: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	var last plumbing.Hash
+	for _, message := range released {
+		last = commit(message)
+	}
+	if _, err := r.CreateTag("1.0.0", last, &git.CreateTagOptions{Tagger: signature, Message: "Release 1.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range unreleased {
+		commit(message)
+	}
+	return "file://" + dir
+}
+
+func newTestValidator(t *testing.T) (Validator, *depthRecordingClient) {
+	t.Helper()
+	client := &depthRecordingClient{GitClient: repo.NewGitClient()}
+	versioner, err := version.NewVersioner(version.VersionerConfig{GitClient: client})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewValidator(Config{GitClient: client, Credentials: noCredentials{}, Versioner: versioner})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, client
+}
+
+func newTestService(url string) *model.Service {
+	return &model.Service{
+		Name:          model.Name{Name: "service"},
+		GitSSHUrl:     url,
+		GitBranchName: "refs/heads/master",
+		Configuration: model.ServiceConfiguration{Go: &model.GoConfiguration{}},
+	}
+}
+
+func unconventionalCommits(report *Report) []string {
+	var messages []string
+	for _, problem := range report.Problems {
+		if problem.Code == ProblemUnconventionalCommit {
+			messages = append(messages, problem.Message)
+		}
+	}
+	return messages
+}
+
+func TestValidateFlagsUnconventionalCommits(t *testing.T) {
+	url := newRemote(t, []string{"feat: first", "not checked, it's released"}, []string{"fix: bug", "update stuff"})
+	v, client := newTestValidator(t)
+
+	report, err := v.Validate(context.Background(), newTestService(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Valid || len(unconventionalCommits(report)) != 1 {
+		t.Fatalf("expected only the unreleased unconventional commit to be flagged, got %+v", report.Problems)
+	}
+	if len(client.depths) != 1 || client.depths[0] != cloneDepth {
+		t.Fatalf("expected one clone %d commits deep, got depths %v", cloneDepth, client.depths)
+	}
+}
+
+func TestValidateDeepensCloneToLastTag(t *testing.T) {
+	// The unconventional commit is further back than the first clone reaches
+	unreleased := []string{"update stuff"}
+	for i := range cloneDepth + 10 {
+		unreleased = append(unreleased, fmt.Sprintf("fix: bug %d", i))
+	}
+	url := newRemote(t, []string{"feat: first"}, unreleased)
+	v, client := newTestValidator(t)
+
+	report, err := v.Validate(context.Background(), newTestService(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messages := unconventionalCommits(report); len(messages) != 1 {
+		t.Fatalf("expected the commit past the first clone to be flagged, got %+v", report.Problems)
+	}
+	if want := []int{cloneDepth, cloneDepth * cloneDepthGrowth}; fmt.Sprint(client.depths) != fmt.Sprint(want) {
+		t.Fatalf("expected a clone and one deepening with depths %v, got %v", want, client.depths)
+	}
+}
+
+func TestValidateAcceptsConventionalHistory(t *testing.T) {
+	url := newRemote(t, []string{"feat: first"}, []string{"fix: bug", "feat(api)!: breaking"})
+	v, _ := newTestValidator(t)
+
+	report, err := v.Validate(context.Background(), newTestService(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid {
+		t.Fatalf("expected a valid report, got %+v", report.Problems)
+	}
+}
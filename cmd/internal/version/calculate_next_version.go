@@ -2,13 +2,14 @@ package version
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
 	"strings"
 
 	"github.com/Masterminds/semver/v3"
-	git "github.com/go-git/go-git/v5"
+	"github.com/ansonallard/deployment-service/cmd/internal/repo"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
@@ -23,17 +24,44 @@ var tracer = otel.Tracer("deployment-service.version")
 
 var baseSemVerVersion = semver.New(0, 0, 1, "", "")
 
+var (
+	commitRegex = regexp.MustCompile(`^(fix|feat|chore|docs|ci|)(!?)|Initial Commit|Revert`)
+	semverRegex = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)$`)
+	// conventionalCommitRegex is what UnconventionalCommits holds commits to.
+	// commitRegex matches any message, since its type may be empty. Merges and
+	// reverts made by git itself are let through too.
+	conventionalCommitRegex = regexp.MustCompile(`^((fix|feat|chore|docs|ci|refactor|test|perf|build|style)(\([^)]*\))?!?: |Merge |Revert "|Initial [Cc]ommit)`)
+)
+
+// ErrIncompleteHistory is returned by UnconventionalCommits when a shallow
+// clone's history ends before the last release tag.
+var ErrIncompleteHistory = errors.New("history ends before the last release tag")
+
 type Versioner interface {
 	CalculateNextVersion(ctx context.Context, repoPath string) (*semver.Version, error)
+	// UnconventionalCommits returns the commits since the last release tag
+	// that aren't conventional commits. On a shallow clone whose history ends
+	// first, it returns the commits found with ErrIncompleteHistory.
+	UnconventionalCommits(ctx context.Context, repoPath string) ([]*object.Commit, error)
+}
+
+type VersionerConfig struct {
+	GitClient repo.GitClient
 }
 
 // Versioner holds state for calculating next semantic version
 type versioner struct {
+	gitClient repo.GitClient
 }
 
 // New creates a new Versioner for a given repo path
-func NewVersioner() Versioner {
-	return &versioner{}
+func NewVersioner(config VersionerConfig) (Versioner, error) {
+	if config.GitClient == nil {
+		return nil, fmt.Errorf("gitClient not provided")
+	}
+	return &versioner{
+		gitClient: config.GitClient,
+	}, nil
 }
 
 // CalculateNextVersion walks commit history, checks conventional commits,
@@ -48,24 +76,11 @@ func (v *versioner) CalculateNextVersion(ctx context.Context, repoPath string) (
 	log.Info().Msg("calculating next version")
 	var isMajor, isMinor, isPatch bool
 
-	repo, err := git.PlainOpen(repoPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open repo: %w", err)
-	}
-
-	ref, err := repo.Head()
+	cIter, semverTags, err := v.history(ctx, repoPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get HEAD: %w", err)
-	}
-
-	cIter, err := repo.Log(&git.LogOptions{From: ref.Hash()})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get log: %w", err)
+		return nil, err
 	}
 
-	commitRegex := regexp.MustCompile(`^(fix|feat|chore|docs|ci|)(!?)|Initial Commit|Revert`)
-	semverRegex := regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)$`)
-
 	var latestTag string
 
 	err = cIter.ForEach(func(c *object.Commit) error {
@@ -73,18 +88,7 @@ func (v *versioner) CalculateNextVersion(ctx context.Context, repoPath string) (
 		log.Info().Interface("stderr", os.Stderr).Interface("SHA", c.Hash.String()).Interface("SUMMARY", strings.Split(summary, "\n")[0]).Msg("current commit")
 
 		// Check if this commit has a tag
-		tags, _ := repo.Tags()
-		_ = tags.ForEach(func(ref *plumbing.Reference) error {
-			obj, err := repo.TagObject(ref.Hash())
-			if err == nil && obj.Target == c.Hash {
-				tagName := strings.TrimPrefix(ref.Name().Short(), "tags/")
-				if semverRegex.MatchString(tagName) {
-					latestTag = tagName
-					return storer.ErrStop
-				}
-			}
-			return nil
-		})
+		latestTag = semverTags[c.Hash]
 
 		if latestTag != "" {
 			return storer.ErrStop
@@ -134,3 +138,72 @@ func (v *versioner) CalculateNextVersion(ctx context.Context, repoPath string) (
 
 	return &newVersion, nil
 }
+
+func (v *versioner) UnconventionalCommits(ctx context.Context, repoPath string) ([]*object.Commit, error) {
+	ctx, span := tracer.Start(ctx, "version.unconventional_commits",
+		trace.WithAttributes(attribute.String("repo_path", repoPath)),
+	)
+	defer span.End()
+
+	cIter, semverTags, err := v.history(ctx, repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []*object.Commit
+	err = cIter.ForEach(func(c *object.Commit) error {
+		if semverTags[c.Hash] != "" {
+			return storer.ErrStop
+		}
+		if !conventionalCommitRegex.MatchString(c.Message) {
+			commits = append(commits, c)
+		}
+		return nil
+	})
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		// The parent of the last commit fetched is missing
+		return commits, ErrIncompleteHistory
+	}
+	if err != nil && err != storer.ErrStop {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	return commits, nil
+}
+
+// history returns the commits from HEAD back, and the release tags keyed by
+// the commit they point at.
+func (v *versioner) history(ctx context.Context, repoPath string) (object.CommitIter, map[plumbing.Hash]string, error) {
+	gitRepo, err := v.gitClient.Open(ctx, repoPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open repo: %w", err)
+	}
+
+	ref, err := gitRepo.Head(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get HEAD: %w", err)
+	}
+
+	cIter, err := gitRepo.Log(ctx, ref.Hash())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get log: %w", err)
+	}
+
+	tags, err := gitRepo.Tags(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get tags: %w", err)
+	}
+
+	// Only annotated release tags count, keyed by the commit they point at
+	semverTags := make(map[plumbing.Hash]string)
+	for _, tag := range tags {
+		if !tag.Annotated || !semverRegex.MatchString(tag.Name) {
+			continue
+		}
+		if _, ok := semverTags[tag.Target]; !ok {
+			semverTags[tag.Target] = tag.Name
+		}
+	}
+	return cIter, semverTags, nil
+}
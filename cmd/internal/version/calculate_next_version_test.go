@@ -0,0 +1,135 @@
+package version
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ansonallard/deployment-service/cmd/internal/repo"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestCalculateNextVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		// released are the commits before the release tag, unreleased the
+		// ones after it
+		released   []string
+		unreleased []string
+		tag        string
+		want       string
+	}{
+		{name: "untagged repo starts at the base version", unreleased: []string{"feat: first"}, want: "0.0.1"},
+		{name: "fix is a patch", released: []string{"feat: first"}, tag: "1.2.3", unreleased: []string{"fix: bug"}, want: "1.2.4"},
+		{name: "chore is a patch", released: []string{"feat: first"}, tag: "1.2.3", unreleased: []string{"chore: tidy"}, want: "1.2.4"},
+		{name: "feat is a minor", released: []string{"feat: first"}, tag: "1.2.3", unreleased: []string{"fix: bug", "feat: thing"}, want: "1.3.0"},
+		{name: "breaking change is a major", released: []string{"feat: first"}, tag: "1.2.3", unreleased: []string{"feat!: thing", "fix: bug"}, want: "2.0.0"},
+		{name: "commits before the tag don't count", released: []string{"feat!: first"}, tag: "1.2.3", unreleased: []string{"fix: bug"}, want: "1.2.4"},
+		{name: "non-semver tags are ignored", released: []string{"feat: first"}, tag: "latest", unreleased: []string{"fix: bug"}, want: "0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			client := repo.NewInMemoryGitClient()
+			path := cloneWithHistory(t, client, tt.released, tt.tag, tt.unreleased)
+
+			versioner, err := NewVersioner(VersionerConfig{GitClient: client})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := versioner.CalculateNextVersion(ctx, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestUnconventionalCommits(t *testing.T) {
+	tests := []struct {
+		message string
+		flagged bool
+	}{
+		{message: "feat: thing"},
+		{message: "fix(api): bug"},
+		{message: "refactor!: rename"},
+		{message: "ci: Release version 1.2.3"},
+		{message: "Merge pull request #1 from owner/release/1.2.3"},
+		{message: "Revert \"feat: thing\""},
+		{message: "update the readme", flagged: true},
+		{message: "feature: thing", flagged: true},
+		{message: "fix:missing space", flagged: true},
+		{message: "WIP fix: thing", flagged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			ctx := context.Background()
+			client := repo.NewInMemoryGitClient()
+			// The commit before the tag is never checked
+			path := cloneWithHistory(t, client, []string{"not conventional"}, "1.0.0", []string{tt.message})
+
+			versioner, err := NewVersioner(VersionerConfig{GitClient: client})
+			if err != nil {
+				t.Fatal(err)
+			}
+			commits, err := versioner.UnconventionalCommits(ctx, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if flagged := len(commits) == 1 && commits[0].Message == tt.message; flagged != tt.flagged || len(commits) > 1 {
+				t.Fatalf("expected flagged to be %t, got %d commits", tt.flagged, len(commits))
+			}
+		})
+	}
+}
+
+// cloneWithHistory pushes released to a new remote, tags its last commit
+// with tag when set, pushes unreleased on top and returns the path of a clone.
+func cloneWithHistory(t *testing.T, client *repo.InMemoryGitClient, released []string, tag string, unreleased []string) string {
+	t.Helper()
+	ctx := context.Background()
+	url, err := client.CreateRemote("service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last plumbing.Hash
+	for _, message := range released {
+		if last, err = client.PushCommit(ctx, url, "main", message, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tag != "" {
+		tagger := t.TempDir()
+		tagRepo, err := client.Clone(ctx, tagger, &git.CloneOptions{URL: url})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tagRepo.CreateTag(ctx, tag, last, repo.TagOptions{
+			Tagger:  &object.Signature{Name: "CI", Email: "ci@example.com", When: time.Now()},
+			Message: "Release " + tag,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tagRepo.Push(ctx, &git.PushOptions{RemoteName: "origin", RefSpecs: []config.RefSpec{"refs/tags/*:refs/tags/*"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, message := range unreleased {
+		if _, err := client.PushCommit(ctx, url, "main", message, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "repo")
+	if _, err := client.Clone(ctx, path, &git.CloneOptions{URL: url, Tags: git.AllTags}); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}